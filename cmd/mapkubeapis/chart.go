@@ -0,0 +1,162 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/chartrepo"
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapkubeapis"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// ChartOptions contains the options for Chart operation
+type ChartOptions struct {
+	BumpVersion  bool
+	Chart        string
+	ChartVersion string
+	DryRun       bool
+	Git          GitOptions
+	KubeVersion  string
+	MapFile      string
+	OutputDir    string
+}
+
+func newChartCmd(out io.Writer) *cobra.Command {
+	chartOptions := ChartOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "chart [flags] CHART_DIR | CHART_ARCHIVE | oci://REF:VERSION",
+		Short: "Map deprecated or removed Kubernetes APIs in the templates of a chart",
+		Long: "Map deprecated or removed Kubernetes APIs in the templates/ and crds/ directories of a chart " +
+			"directory, rewriting the apiVersion of the templates in place, or only reporting them with --dry-run. " +
+			"A packaged chart archive (.tgz) is mapped to a new archive written to --output-dir, optionally with a new chart version. " +
+			"A chart stored in an OCI registry, e.g. oci://ghcr.io/org/charts/mychart:1.2.0, is pulled, mapped and pushed " +
+			"back with the new chart version set by --chart-version or --bump-version as tag. " +
+			"With --git-repo, the chart directory is that of a clone of the Git repository, and the mapped templates are " +
+			"committed to a new branch, optionally pushed, ready for a pull request. " +
+			"The templates are not rendered, so resources whose apiVersion or kind is set by a template action are not found. " +
+			"Exits with code 2 if deprecated or removed APIs remain in the templates.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return withExitCode(ExitCodeUsage, errors.New("the chart directory or archive must be passed"))
+			}
+			if chartOptions.BumpVersion && chartOptions.ChartVersion != "" {
+				return withExitCode(ExitCodeUsage, errors.New("--bump-version cannot be used with --chart-version"))
+			}
+			if chartOptions.Git.Repo != "" && (chartOptions.BumpVersion || chartOptions.ChartVersion != "" || chartrepo.IsOCIReference(args[0])) {
+				return withExitCode(ExitCodeUsage, errors.New("--git-repo can only be used with a chart directory"))
+			}
+			return validateGitFlags(chartOptions.Git)
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chartOptions.Chart = args[0]
+			chartOptions.DryRun = settings.DryRun
+			chartOptions.MapFile = settings.MapFile
+			return Chart(cmd.Context(), out, chartOptions, settings.KubeConfig())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&chartOptions.KubeVersion, "kube-version", "", "Kubernetes version to map the templates for, e.g. v1.25; that of the cluster if not set")
+	flags.StringVarP(&chartOptions.OutputDir, "output-dir", "d", ".", "directory to write the mapped chart archive to, for a chart archive")
+	flags.StringVar(&chartOptions.ChartVersion, "chart-version", "", "version of the mapped chart archive, that of the chart if not set")
+	flags.BoolVar(&chartOptions.BumpVersion, "bump-version", false, "increment the patch version of the mapped chart archive")
+	addGitFlags(flags, &chartOptions.Git)
+
+	return cmd
+}
+
+// Chart maps the deprecated or removed APIs in the templates of a chart directory or archive,
+// and writes the findings
+func Chart(ctx context.Context, out io.Writer, chartOptions ChartOptions, kubeConfig common.KubeConfig) error {
+	kubeVersion, err := targetKubeVersion(ctx, chartOptions.KubeVersion, kubeConfig)
+	if err != nil {
+		return err
+	}
+	mapper := mapkubeapis.New(
+		mapkubeapis.WithDryRun(chartOptions.DryRun),
+		mapkubeapis.WithMappingProvider(settings.MappingProvider(chartOptions.MapFile, kubeConfig)),
+	)
+	archiveOptions := common.ChartArchiveOptions{
+		OutDir:      chartOptions.OutputDir,
+		Version:     chartOptions.ChartVersion,
+		BumpVersion: chartOptions.BumpVersion,
+	}
+	var result *mapkubeapis.ChartResult
+	if chartOptions.Git.Repo != "" {
+		result, err = runInGitRepo(ctx, chartOptions.Git, chartOptions.DryRun, kubeVersion, chartOptions.Chart, func(chartDir string) (*common.ChartResult, error) {
+			return mapper.MapChart(ctx, chartDir, kubeVersion)
+		})
+	} else if chartrepo.IsOCIReference(chartOptions.Chart) {
+		if !chartOptions.DryRun && chartOptions.ChartVersion == "" && !chartOptions.BumpVersion {
+			return withExitCode(ExitCodeUsage, errors.New("--chart-version or --bump-version must be set to push the mapped chart to an OCI registry"))
+		}
+		result, err = mapper.MapOCIChart(ctx, chartOptions.Chart, kubeVersion, archiveOptions)
+	} else if info, statErr := os.Stat(chartOptions.Chart); statErr == nil && !info.IsDir() {
+		result, err = mapper.MapChartArchive(ctx, chartOptions.Chart, kubeVersion, archiveOptions)
+	} else if chartOptions.ChartVersion != "" || chartOptions.BumpVersion {
+		return withExitCode(ExitCodeUsage, errors.New("--chart-version and --bump-version can only be used with a chart archive or OCI reference"))
+	} else {
+		result, err = mapper.MapChart(ctx, chartOptions.Chart, kubeVersion)
+	}
+	if err != nil {
+		return err
+	}
+	if result.Archive != "" {
+		log.Printf("Mapped chart archive written to: %s\n", result.Archive)
+	}
+
+	var remaining int
+	for _, finding := range result.Findings {
+		if (finding.Action == common.ActionMapped && chartOptions.DryRun) || len(finding.Warnings) > 0 {
+			remaining++
+		}
+	}
+	if len(result.Findings) == 0 {
+		fmt.Fprintf(out, "%s: no deprecated or removed APIs\n", chartOptions.Chart)
+		return nil
+	}
+	fmt.Fprintf(out, "%s: deprecated or removed APIs found\n", chartOptions.Chart)
+	for _, finding := range result.Findings {
+		newAPIVersion := "none"
+		if finding.NewAPI != "" {
+			if gvk, err := mapping.ParseAPI(finding.NewAPI); err == nil {
+				newAPIVersion = gvk.GroupVersion().String()
+			}
+		}
+		fmt.Fprintf(out, "  %s:%d: %s -> %s: %s\n", finding.File, finding.Line, resourceName(finding.Resource), newAPIVersion, finding.Action)
+		for _, warning := range finding.Warnings {
+			fmt.Fprintf(out, "    warning: %s\n", warning)
+		}
+	}
+	if remaining > 0 {
+		return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+	"github.com/helm/helm-mapkubeapis/pkg/mapkubeapis"
+)
+
+func newCheckCmd(out io.Writer) *cobra.Command {
+	var kubeVersion string
+
+	cmd := &cobra.Command{
+		Use:   "check [flags] RELEASE [RELEASE...]",
+		Short: "Check releases for deprecated or removed Kubernetes APIs",
+		Long: "Check releases for deprecated or removed Kubernetes APIs without modifying release storage. " +
+			"Pass '-' instead of release names to check the manifest stream of standard input, " +
+			"e.g. helm get manifest my-release | helm mapkubeapis check -, without access to release storage. " +
+			"Exits with code 2 if deprecated or removed APIs are found in any of the releases.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return withExitCode(ExitCodeUsage, errors.New("at least one release name must be passed"))
+			}
+			for _, arg := range args {
+				if arg == "-" && len(args) > 1 {
+					return withExitCode(ExitCodeUsage, errors.New("no release name may be passed with '-'"))
+				}
+			}
+			if kubeVersion != "" && args[0] != "-" {
+				return withExitCode(ExitCodeUsage, errors.New("--kube-version can only be used with '-'"))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if args[0] == "-" {
+				if len(settings.Contexts) > 0 || settings.AllContexts {
+					return withExitCode(ExitCodeUsage, errors.New("'-' cannot be used with --contexts or --all-contexts"))
+				}
+				return runCheckManifests(cmd.Context(), out, os.Stdin, kubeVersion, settings.KubeConfig())
+			}
+			return runClusters(cmd.Context(), out, func(ctx context.Context, out io.Writer, kubeConfig common.KubeConfig) error {
+				return runCheck(ctx, out, args, kubeConfig)
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&kubeVersion, "kube-version", "", "Kubernetes version to check the manifest stream of '-' for, e.g. v1.25; that of the cluster if not set")
+
+	return cmd
+}
+
+// runCheckManifests checks the manifest stream read from r, e.g. the output of helm get
+// manifest, for deprecated or removed APIs, without access to release storage
+func runCheckManifests(ctx context.Context, out io.Writer, r io.Reader, kubeVersion string, kubeConfig common.KubeConfig) error {
+	target, err := targetKubeVersion(ctx, kubeVersion, kubeConfig)
+	if err != nil {
+		return err
+	}
+	mapper := mapkubeapis.New(
+		mapkubeapis.WithKubeConfig(kubeConfig),
+		mapkubeapis.WithMappingProvider(settings.MappingProvider(settings.MapFile, kubeConfig)),
+	)
+	result, err := mapper.MapManifestStream(ctx, r, io.Discard, target)
+	if err != nil {
+		return err
+	}
+	if len(result.MappedAPIs) == 0 {
+		fmt.Fprintln(out, "-: no deprecated or removed APIs")
+		return nil
+	}
+	fmt.Fprintln(out, "-: deprecated or removed APIs found")
+	printFindings(out, result.MappedAPIs, result.Findings)
+	return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+}
+
+func runCheck(ctx context.Context, out io.Writer, releaseNames []string, kubeConfig common.KubeConfig) error {
+	provider := settings.MappingProvider(settings.MapFile, kubeConfig)
+
+	results := make([]*mapkubeapis.Result, len(releaseNames))
+	errs := make([]error, len(releaseNames))
+	ctxErr := forEach(ctx, settings.Concurrency, len(releaseNames), func(i int) {
+		// Each release has its own mapper, whose logger names the release, as the messages of
+		// the releases checked concurrently are interleaved
+		mapper := mapkubeapis.New(
+			mapkubeapis.WithKubeConfig(kubeConfig),
+			mapkubeapis.WithLogger(common.PrefixLogger(nil, releasePrefix(settings.Namespace, releaseNames[i]))),
+			mapkubeapis.WithMappingProvider(provider),
+			mapkubeapis.WithNamespace(settings.Namespace),
+			mapkubeapis.WithReleaseTimeout(settings.ReleaseTimeout),
+			mapkubeapis.WithStorageDriver(settings.StorageDriver),
+		)
+		results[i], errs[i] = mapper.CheckRelease(ctx, releaseNames[i])
+	})
+
+	var found, failed int
+	var lastErr error
+	for i, releaseName := range releaseNames {
+		result, err := results[i], errs[i]
+		if result == nil && err == nil {
+			// The check of the release was not started as the run was interrupted
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to check release '%s': %s\n", releaseID(settings.Namespace, releaseName), err)
+			fmt.Fprintf(out, "%s: check failed\n", releaseID(settings.Namespace, releaseName))
+			failed++
+			lastErr = err
+			continue
+		}
+		if len(result.MappedAPIs) == 0 {
+			fmt.Fprintf(out, "%s: no deprecated or removed APIs\n", releaseID(result.Namespace, releaseName))
+			continue
+		}
+		found++
+		fmt.Fprintf(out, "%s: deprecated or removed APIs found\n", releaseID(result.Namespace, releaseName))
+		printFindings(out, result.MappedAPIs, result.Findings)
+	}
+
+	switch {
+	case ctxErr != nil:
+		return errors.Wrap(ctxErr, "check interrupted")
+	case failed == len(releaseNames):
+		return lastErr
+	case failed > 0:
+		return withExitCode(ExitCodePartialFailure, errors.Errorf("failed to check %d of %d releases", failed, len(releaseNames)))
+	case found > 0:
+		return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+	}
+	return nil
+}
+
+// printFindings writes the deprecated or removed APIs found, and the resources using them
+func printFindings(out io.Writer, mappedAPIs []common.MappedAPI, findings common.Findings) {
+	for _, api := range mappedAPIs {
+		fmt.Fprintf(out, "  %d x %s -> %s\n", api.Count, common.FlattenAPI(api.DeprecatedAPI), common.FlattenAPI(api.NewAPI))
+	}
+	for _, finding := range findings {
+		if finding.Recreate {
+			fmt.Fprintf(out, "  %s: %s, requires recreation\n", resourceName(finding.Resource), finding.Action)
+		} else {
+			fmt.Fprintf(out, "  %s: %s\n", resourceName(finding.Resource), finding.Action)
+		}
+		for _, warning := range finding.Warnings {
+			fmt.Fprintf(out, "    warning: %s\n", warning)
+		}
+	}
+}
+
+// releaseID returns the namespace and name of a release for display, as releases of the same
+// name may exist in several namespaces, or its name if the namespace is not known
+func releaseID(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// resourceName returns the kind, name and API version of a resource for display
+func resourceName(resource convert.Resource) string {
+	name := resource.Kind
+	if resource.Name != "" {
+		name += "/" + resource.Name
+	}
+	return fmt.Sprintf("%s (%s)", name, resource.APIVersion)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/report"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+const ingressManifest = `---
+# Source: web/templates/ingress.yaml
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  backend:
+    serviceName: web
+    servicePort: 80
+`
+
+func TestReleasesOfTheSameNameInSeveralNamespaces(t *testing.T) {
+	settings = &EnvSettings{Concurrency: 1, MapFile: "embedded", StorageDriver: "memory"}
+	kubeConfig := common.KubeConfig{KubeVersion: "v1.25.0"}
+	for _, namespace := range []string{"ns-a", "ns-b"} {
+		cfg, err := v3.GetActionConfig(namespace, "memory", kubeConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel := &release.Release{
+			Name:      "web",
+			Namespace: namespace,
+			Version:   1,
+			Info:      &release.Info{Status: release.StatusDeployed},
+			Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "web", Version: "1.0.0"}},
+			Manifest:  ingressManifest,
+		}
+		if err := cfg.Releases.Create(rel); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	for _, namespace := range []string{"ns-a", "ns-b"} {
+		settings.Namespace = namespace
+		var out bytes.Buffer
+		err := runCheck(context.Background(), &out, []string{"web"}, kubeConfig)
+		if exitCode(err) != ExitCodeDeprecatedAPIsFound {
+			t.Fatalf("check of %s/web: expected exit code %d, got %v", namespace, ExitCodeDeprecatedAPIsFound, err)
+		}
+		if want := namespace + "/web: deprecated or removed APIs found\n"; !strings.HasPrefix(out.String(), want) {
+			t.Errorf("check output %q does not start with %q", out.String(), want)
+		}
+		if want := "Check release 'web' in namespace '" + namespace + "'"; !strings.Contains(logs.String(), want) {
+			t.Errorf("logs %q do not contain %q", logs.String(), want)
+		}
+	}
+
+	cfg, err := v3.GetActionConfigAllNamespaces("memory", kubeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases, err := cfg.Releases.ListReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := checkReleases(context.Background(), releases, settings.MappingProvider(settings.MapFile, kubeConfig), kubeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := writeReport(&out, &report.Report{Releases: results}, report.FormatMarkdown, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"## Namespace `ns-a`\n\n### web (revision 1): pending", "## Namespace `ns-b`\n\n### web (revision 1): pending"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report %q does not contain %q", out.String(), want)
+		}
+	}
+}
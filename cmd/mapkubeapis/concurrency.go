@@ -0,0 +1,63 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// forEach calls fn with the index of each of the n items of a bulk run, with up to concurrency
+// calls running at a time. Callers store the outcome of each item at its index, so that the
+// output of the run is in the order of the items whatever the order the calls complete in.
+// No call is started once the context is canceled, in which case forEach returns the error
+// of the context after the running calls returned.
+func forEach(ctx context.Context, concurrency, n int, fn func(i int)) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// releasePrefix returns the prefix of the messages logged for a release of a bulk run, the
+// namespace and name of the release, or its name if the namespace is not known
+func releasePrefix(namespace, name string) string {
+	if namespace == "" {
+		return name + ": "
+	}
+	return namespace + "/" + name + ": "
+}
@@ -0,0 +1,95 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/pkg/errors"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+// clusterRun runs a command against the cluster of the kube config settings, writing its
+// output to out
+type clusterRun func(ctx context.Context, out io.Writer, kubeConfig common.KubeConfig) error
+
+// runClusters runs the command against the cluster of each kubeconfig context of a
+// multi-cluster run, with up to --context-concurrency clusters at a time, or once against the
+// cluster of the kube config settings otherwise. The output of each cluster is written to out
+// in a section of its context, in the order of the contexts.
+//
+// The run exits with ExitCodePartialFailure if the command failed for some of the clusters,
+// and with ExitCodeDeprecatedAPIsFound if deprecated or removed APIs were found in any of
+// the clusters.
+func runClusters(ctx context.Context, out io.Writer, run clusterRun) error {
+	contexts, err := settings.KubeContexts()
+	if err != nil {
+		return err
+	}
+	if len(contexts) == 0 {
+		return run(ctx, out, settings.KubeConfig())
+	}
+
+	outputs := make([]bytes.Buffer, len(contexts))
+	errs := make([]error, len(contexts))
+	started := make([]bool, len(contexts))
+	ctxErr := forEach(ctx, settings.ContextConcurrency, len(contexts), func(i int) {
+		started[i] = true
+		log.Printf("Run against the cluster of context '%s'.\n", contexts[i])
+		kubeConfig := settings.KubeConfig()
+		kubeConfig.Context = contexts[i]
+		errs[i] = run(ctx, &outputs[i], kubeConfig)
+	})
+
+	var found, failed int
+	for i, kubeContext := range contexts {
+		if !started[i] {
+			// The cluster was not processed as the run was interrupted
+			continue
+		}
+		fmt.Fprintf(out, "==> Context: %s\n", kubeContext)
+		out.Write(outputs[i].Bytes())
+		if err := errs[i]; err != nil && err.Error() != "" {
+			fmt.Fprintf(out, "Error: %s\n", err)
+		}
+		fmt.Fprintln(out)
+		switch exitCode(errs[i]) {
+		case ExitCodeOK:
+		case ExitCodeDeprecatedAPIsFound:
+			found++
+		default:
+			failed++
+		}
+	}
+
+	switch {
+	case ctxErr != nil:
+		return errors.Wrap(ctxErr, "multi-cluster run interrupted")
+	case failed == len(contexts):
+		return errors.Errorf("failed for all %d clusters", failed)
+	case failed > 0:
+		return withExitCode(ExitCodePartialFailure, errors.Errorf("failed for %d of %d clusters", failed, len(contexts)))
+	case found > 0:
+		return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/daemon"
+	"github.com/helm/helm-mapkubeapis/pkg/health"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	"github.com/helm/helm-mapkubeapis/pkg/report"
+	"github.com/helm/helm-mapkubeapis/pkg/schedule"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+// DaemonOptions contains the options for Daemon operation
+type DaemonOptions struct {
+	AllNamespaces           bool
+	Force                   bool
+	HealthAddress           string
+	LeaderElect             bool
+	LeaderElectionNamespace string
+	Map                     bool
+	MapFileReloadInterval   time.Duration
+	MetricsAddress          string
+	ReportFile              string
+	ReportFormat            string
+	Schedule                string
+	StateFile               string
+}
+
+func newDaemonCmd(out io.Writer) *cobra.Command {
+	var daemonOptions DaemonOptions
+
+	cmd := &cobra.Command{
+		Use:   "daemon --schedule SCHEDULE [flags]",
+		Short: "Check, and optionally map, releases on a schedule",
+		Long: "Run until interrupted, checking the releases for deprecated or removed Kubernetes APIs at the times of a cron schedule, " +
+			"and mapping them with --map. The results of the last run are exposed as Prometheus metrics, and written to a report " +
+			"with --report-file. With --state-file, the state of the releases is kept between the runs and across restarts, " +
+			"so that the releases newly found or resolved by a run are logged, and a run missed while the daemon was stopped is " +
+			"run on start.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("daemon does not accept arguments"))
+			}
+			if len(settings.Contexts) > 0 || settings.AllContexts {
+				return withExitCode(ExitCodeUsage, errors.New("daemon cannot be used with --contexts or --all-contexts"))
+			}
+			if daemonOptions.ReportFile != "" {
+				if err := report.ValidateFormat(daemonOptions.ReportFormat); err != nil {
+					return withExitCode(ExitCodeUsage, err)
+				}
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sched, err := schedule.Parse(daemonOptions.Schedule)
+			if err != nil {
+				return withExitCode(ExitCodeUsage, err)
+			}
+			return Daemon(cmd.Context(), sched, daemonOptions, settings.KubeConfig())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVarP(&daemonOptions.AllNamespaces, "all-namespaces", "A", false, "check the releases across all namespaces")
+	flags.BoolVar(&daemonOptions.Force, "force", false, "with --map, map the releases even if they are managed by a GitOps controller")
+	flags.StringVar(&daemonOptions.HealthAddress, "health-address", defaultHealthAddress, "address to serve the /healthz and /readyz probes at; not served if empty")
+	flags.BoolVar(&daemonOptions.LeaderElect, "leader-elect", false, "elect a leader among the replicas with a Lease, so that only the leader runs")
+	flags.StringVar(&daemonOptions.LeaderElectionNamespace, "leader-election-namespace", "", "namespace of the Lease of the leader election; that of the kubeconfig context, or of the pod, if not set")
+	flags.BoolVar(&daemonOptions.Map, "map", false, "map the releases found with deprecated or removed APIs, instead of only checking them")
+	flags.DurationVar(&daemonOptions.MapFileReloadInterval, "mapfile-reload-interval", defaultMapFileReloadInterval, "interval at which the mapping file is checked for changes and reloaded; not reloaded if 0")
+	flags.StringVar(&daemonOptions.MetricsAddress, "metrics-address", ":9090", "address to serve the Prometheus metrics at, on /metrics; not served if empty")
+	flags.StringVar(&daemonOptions.ReportFile, "report-file", "", "file to write an upgrade readiness report of each run to, replacing that of the previous run")
+	flags.StringVar(&daemonOptions.ReportFormat, "report-format", report.FormatMarkdown, "format of the report, one of: markdown, html")
+	flags.StringVar(&daemonOptions.Schedule, "schedule", "", "cron schedule of the runs, e.g. \"0 3 * * *\" or @daily")
+	flags.StringVar(&daemonOptions.StateFile, "state-file", "", "file to keep the state of the releases in between the runs and across restarts")
+	cmd.MarkFlagRequired("schedule")
+
+	return cmd
+}
+
+// Daemon runs the check, and optionally the mapping, of the releases at the times of the
+// schedule until the context is done, once elected leader if the leader election is enabled
+func Daemon(ctx context.Context, sched *schedule.Schedule, daemonOptions DaemonOptions, kubeConfig common.KubeConfig) error {
+	elected, err := newElection(daemonOptions.LeaderElect, daemonOptions.LeaderElectionNamespace, daemonLeaseName, kubeConfig)
+	if err != nil {
+		return err
+	}
+	provider := settings.ReloadingMappingProvider(ctx, settings.MapFile, kubeConfig, daemonOptions.MapFileReloadInterval)
+	metrics := daemon.NewMetrics()
+	stopHealth, err := serveHealth(ctx, daemonOptions.HealthAddress, health.New())
+	if err != nil {
+		return err
+	}
+	defer stopHealth()
+
+	if daemonOptions.MetricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		server := &http.Server{
+			Addr:              daemonOptions.MetricsAddress,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Warning: failed to serve the metrics: %s\n", err)
+			}
+		}()
+		defer server.Close()
+		log.Printf("Serving the metrics at %s/metrics.\n", daemonOptions.MetricsAddress)
+	}
+
+	return runElected(ctx, elected, func(ctx context.Context) error {
+		metrics.SetLeader(true)
+		defer metrics.SetLeader(false)
+		return daemonLoop(ctx, sched, metrics, provider, daemonOptions, kubeConfig)
+	})
+}
+
+// daemonLoop runs the check, and optionally the mapping, of the releases at the times of the
+// schedule until the context is done
+func daemonLoop(ctx context.Context, sched *schedule.Schedule, metrics *daemon.Metrics, provider mapping.MappingProvider, daemonOptions DaemonOptions, kubeConfig common.KubeConfig) error {
+	state, err := daemon.LoadState(daemonOptions.StateFile)
+	if err != nil {
+		return err
+	}
+	metrics.SetState(state)
+
+	// The first run is on start if the daemon never ran, or if a run was missed
+	next := time.Now()
+	if !state.LastRunTime.IsZero() {
+		if missed := sched.Next(state.LastRunTime); missed.After(next) {
+			next = missed
+		}
+	}
+	for {
+		if next.IsZero() {
+			return errors.Errorf("schedule '%s' has no next run time", sched)
+		}
+		metrics.SetNextRunTime(next)
+		log.Printf("Next run at %s.\n", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		start := time.Now()
+		daemonRun(ctx, state, provider, daemonOptions, kubeConfig)
+		if ctx.Err() != nil {
+			// The run was interrupted, its partial results are not recorded
+			return nil
+		}
+		state.LastRunTime = start
+		state.LastRunDuration = time.Since(start)
+		metrics.ObserveRun(state)
+		if err := state.Save(daemonOptions.StateFile); err != nil {
+			log.Printf("Warning: %s\n", err)
+		}
+		if daemonOptions.ReportFile != "" && state.LastError == "" {
+			rpt := &report.Report{
+				Title:       reportTitle,
+				GeneratedAt: start,
+				Releases:    state.Results(),
+			}
+			if err := writeReport(nil, rpt, daemonOptions.ReportFormat, daemonOptions.ReportFile); err != nil {
+				log.Printf("Warning: %s\n", err)
+			}
+		}
+		next = sched.Next(time.Now())
+	}
+}
+
+// daemonRun checks the releases, maps those found with deprecated or removed APIs if set, and
+// records the results in the state
+func daemonRun(ctx context.Context, state *daemon.State, provider mapping.MappingProvider, daemonOptions DaemonOptions, kubeConfig common.KubeConfig) {
+	start := time.Now()
+	log.Println("Run started.")
+	// The mapping file is loaded once for the releases of the run, even if it is reloaded meanwhile
+	provider = mapping.NewCachedProvider(provider)
+	results, err := daemonCheck(ctx, provider, daemonOptions, kubeConfig)
+	if err != nil {
+		log.Printf("Run failed: %s\n", err)
+		state.LastError = err.Error()
+		return
+	}
+	if daemonOptions.Map {
+		daemonMap(ctx, results, provider, daemonOptions, kubeConfig)
+	}
+	state.LastError = ""
+	changes := state.Update(results, start)
+	for _, id := range changes.Found {
+		log.Printf("Deprecated or removed APIs found in release '%s'.\n", id)
+	}
+	for _, id := range changes.Resolved {
+		log.Printf("Deprecated or removed APIs of release '%s' resolved.\n", id)
+	}
+
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	var summary []string
+	for _, status := range []string{report.StatusClean, report.StatusPending, report.StatusMapped, report.StatusFailed} {
+		summary = append(summary, fmt.Sprintf("%d %s", counts[status], status))
+	}
+	log.Printf("Run finished, %d releases checked: %s.\n", len(results), strings.Join(summary, ", "))
+}
+
+// daemonCheck checks the latest version of each release in the scope of the daemon
+func daemonCheck(ctx context.Context, provider mapping.MappingProvider, daemonOptions DaemonOptions, kubeConfig common.KubeConfig) ([]report.Release, error) {
+	releases, err := v3.ListReleases(ctx, settings.Namespace, daemonOptions.AllNamespaces, settings.StorageDriver, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	return checkReleases(ctx, releases, provider, kubeConfig)
+}
+
+// daemonMap maps the releases checked with deprecated or removed APIs, with up to --concurrency
+// releases at a time, and updates their results
+func daemonMap(ctx context.Context, results []report.Release, provider mapping.MappingProvider, daemonOptions DaemonOptions, kubeConfig common.KubeConfig) {
+	var pending []int
+	for i := range results {
+		if results[i].Status == report.StatusPending {
+			pending = append(pending, i)
+		}
+	}
+	forEach(ctx, settings.Concurrency, len(pending), func(i int) {
+		result := &results[pending[i]]
+		mapOptions := MapOptions{
+			DryRun:           settings.DryRun,
+			Force:            daemonOptions.Force,
+			MapFile:          settings.MapFile,
+			MappingProvider:  provider,
+			ReleaseName:      result.Name,
+			ReleaseNamespace: result.Namespace,
+			ReleaseTimeout:   settings.ReleaseTimeout,
+			StorageDriver:    settings.StorageDriver,
+		}
+		mapped, err := Map(ctx, mapOptions, kubeConfig)
+		switch {
+		case err != nil:
+			log.Printf("Failed to map release '%s': %s\n", releaseID(result.Namespace, result.Name), err)
+			result.Status = report.StatusFailed
+			result.Error = err.Error()
+		case mapped.Mapped:
+			result.Status = report.StatusMapped
+			result.Revision = mapped.Revision
+			result.MappedAPIs = mapped.MappedAPIs
+		}
+	})
+}
@@ -0,0 +1,47 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/election"
+)
+
+// Names of the Leases of the leader elections of the long-running modes
+const (
+	daemonLeaseName   = "mapkubeapis-daemon"
+	operatorLeaseName = "mapkubeapis-operator"
+)
+
+// newElection returns the leader election of the Lease of the name, or nil if the leader
+// election is not enabled
+func newElection(leaderElect bool, namespace, name string, kubeConfig common.KubeConfig) (*election.Election, error) {
+	if !leaderElect {
+		return nil, nil
+	}
+	return election.New(kubeConfig, namespace, name, nil)
+}
+
+// runElected runs the function once elected leader, or at once if the leader election is nil
+func runElected(ctx context.Context, elected *election.Election, run func(ctx context.Context) error) error {
+	if elected == nil {
+		return run(ctx)
+	}
+	return elected.Run(ctx, run)
+}
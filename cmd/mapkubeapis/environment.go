@@ -17,16 +17,81 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	"github.com/helm/helm-mapkubeapis/pkg/validate"
 )
 
 // EnvSettings defined settings
 type EnvSettings struct {
-	DryRun         bool
-	KubeConfigFile string
-	KubeContext    string
-	MapFile        string
-	Namespace      string
+	AllowEmptyRelease  bool
+	CheckLiveObjects   bool
+	AllContexts        bool
+	Concurrency        int
+	Contexts           []string
+	ContextConcurrency int
+	CRDMappings        bool
+	DryRun             bool
+	FromFile           string
+	FromStorageObject  string
+	HelmVersion        int
+	KubeConfigFile     string
+	KubeContext        string
+	KubeAsUser         string
+	KubeAsGroups       []string
+	KubeAPIServer      string
+	KubeToken          string
+	KubeCAFile         string
+	KubeCertFile       string
+	KubeKeyFile        string
+	KubeInsecure       bool
+	KubeTLSServerName  string
+	KubeQPS            float32
+	KubeVersion        string
+	KubeBurst          int
+	Retries            int
+	RetryBackoff       time.Duration
+	Timeout            time.Duration
+	ReleaseTimeout     time.Duration
+	RequestTimeout     time.Duration
+	Force              bool
+	HelmReleasePatch   string
+	Lock               bool
+	MapFile            string
+	Namespace          string
+	NotifyURL          string
+	NotifyFormat       string
+	PolicyAction       string
+	PolicyDir          string
+	PostHook           string
+	PreHook            string
+	PSPReportFile      string
+	ReportFile         string
+	ReportFormat       string
+	RequireNewAPI      bool
+	SchemaLocation     string
+	ServerDryRun       bool
+	StorageDriver      string
+	StorageOwner       string
+	StorageSelector    string
+	ValidateSchema     bool
+
+	CommentRemoved                 bool
+	CSRSignerName                  string
+	IngressClassMap                map[string]string
+	IngressClassName               bool
+	IngressPathType                string
+	WebhookAdmissionReviewVersions []string
+	WebhookSideEffects             string
 }
 
 // New returns default env settings
@@ -43,8 +108,152 @@ func (s *EnvSettings) AddBaseFlags(fs *pflag.FlagSet) {
 // AddFlags binds flags to the given flagset.
 func (s *EnvSettings) AddFlags(fs *pflag.FlagSet) {
 	s.AddBaseFlags(fs)
-	fs.StringVar(&s.KubeConfigFile, "kubeconfig", "", "path to the kubeconfig file")
+	fs.StringVar(&s.KubeConfigFile, "kubeconfig", "", "path to the kubeconfig file, the files of KUBECONFIG merged if not set")
 	fs.StringVar(&s.KubeContext, "kube-context", s.KubeContext, "name of the kubeconfig context to use")
-	fs.StringVar(&s.MapFile, "mapfile", s.MapFile, "path to the API mapping file")
+	fs.StringVar(&s.KubeAsUser, "kube-as-user", "", "username to impersonate for the requests to the Kubernetes API server")
+	fs.StringArrayVar(&s.KubeAsGroups, "kube-as-group", nil, "group to impersonate for the requests to the Kubernetes API server, can be repeated")
+	fs.StringVar(&s.KubeAPIServer, "kube-apiserver", "", "address and port of the Kubernetes API server, overriding that of the kubeconfig")
+	fs.StringVar(&s.KubeToken, "kube-token", "", "bearer token used to authenticate to the Kubernetes API server, preferably set with HELM_KUBETOKEN")
+	fs.StringVar(&s.KubeCAFile, "kube-ca-file", "", "certificate authority file of the Kubernetes API server connection")
+	fs.StringVar(&s.KubeCertFile, "kube-client-cert", "", "client certificate file used to authenticate to the Kubernetes API server")
+	fs.StringVar(&s.KubeKeyFile, "kube-client-key", "", "key file of the client certificate set by --kube-client-cert")
+	fs.BoolVar(&s.KubeInsecure, "kube-insecure-skip-tls-verify", false, "do not verify the certificate of the Kubernetes API server, making the connection insecure")
+	fs.StringVar(&s.KubeTLSServerName, "kube-tls-server-name", "", "server name used to verify the certificate of the Kubernetes API server, the host of its address if not set")
+	fs.Float32Var(&s.KubeQPS, "kube-qps", common.DefaultQPS, "maximum number of queries per second to the Kubernetes API server")
+	fs.IntVar(&s.KubeBurst, "kube-burst", common.DefaultBurst, "maximum burst of queries to the Kubernetes API server")
+	fs.IntVar(&s.Concurrency, "concurrency", 1, "number of releases processed at a time by the commands processing many releases")
+	fs.StringSliceVar(&s.Contexts, "contexts", nil, "kubeconfig contexts of the clusters to run the command against, e.g. prod-eu,prod-us")
+	fs.BoolVar(&s.AllContexts, "all-contexts", false, "run the command against the clusters of all the kubeconfig contexts")
+	fs.IntVar(&s.ContextConcurrency, "context-concurrency", 1, "number of clusters processed at a time with --contexts or --all-contexts")
+	fs.IntVar(&s.Retries, "retries", 3, "number of times requests to the Kubernetes API server failing with a transient error are retried")
+	fs.DurationVar(&s.RetryBackoff, "retry-backoff", common.DefaultRetryBackoff, "delay before the first retry of a request, doubled on each retry")
+	fs.DurationVar(&s.Timeout, "timeout", 0, "time after which the run is stopped, after the release being written if any, e.g. 10m; no timeout if zero")
+	fs.DurationVar(&s.ReleaseTimeout, "release-timeout", 0, "time after which the check or mapping of a release fails, unless its update started; no timeout if zero")
+	fs.DurationVar(&s.RequestTimeout, "request-timeout", 0, "time after which a request to the Kubernetes API server fails; no timeout if zero")
+	fs.StringVar(&s.MapFile, "mapfile", s.MapFile, "path, http(s):// URL or oci:// reference of the API mapping file, or \"embedded\" for the built-in one")
+	fs.BoolVar(&s.CRDMappings, "crd-mappings", false, "also map the custom resource versions which are deprecated or no longer served by the CRDs of the cluster")
 	fs.StringVar(&s.Namespace, "namespace", s.Namespace, "namespace scope of the release")
+	fs.StringVar(&s.StorageDriver, "storage", "", "Helm storage driver of the releases, one of: secret, configmap, memory, sql; that of HELM_DRIVER if not set")
+	fs.StringVar(&s.StorageOwner, "storage-owner", "", "value of the owner label of the Secrets or ConfigMaps storing the releases, for releases stored by tools other than Helm; helm if not set")
+	fs.IntVar(&s.HelmVersion, "helm-version", 0, "major version of the Helm managing the releases, 3 or 4; that of the Helm client of HELM_BIN or the PATH if not set")
+	fs.StringVar(&s.StorageSelector, "storage-selector", "", "label selector the Secrets or ConfigMaps storing the releases must also match, e.g. app.kubernetes.io/managed-by=my-operator")
+	fs.StringVar(&s.NotifyURL, "notify-url", s.NotifyURL, "webhook URL to post the run summary to when the run finishes")
+	fs.StringVar(&s.NotifyFormat, "notify-format", "json", "payload format of the webhook notification, one of: json, slack")
+	fs.BoolVar(&s.ValidateSchema, "validate-schemas", false, "validate the manifests with their APIs mapped against the JSON schemas of the Kubernetes version, without cluster access")
+	fs.StringVar(&s.SchemaLocation, "schema-location", validate.DefaultSchemaLocation, "URL or path template of the JSON schemas used by --validate-schemas")
+
+	defaults := convert.DefaultSettings()
+	fs.BoolVar(&s.CommentRemoved, "comment-removed", false, "keep the resources using APIs without replacement in the manifest as commented-out documents, instead of deleting them")
+	fs.StringVar(&s.CSRSignerName, "csr-signer-name", defaults.CSRSignerName, "signerName set on certificate signing requests mapped to v1 which do not declare a signer allowed by v1")
+	fs.BoolVar(&s.IngressClassName, "ingress-class-name", false, "move the kubernetes.io/ingress.class annotation of ingresses mapped to v1 to spec.ingressClassName")
+	fs.StringVar(&s.IngressPathType, "ingress-path-type", defaults.IngressPathType, "pathType set on the paths of ingresses mapped to v1 which do not declare it, one of: Exact, Prefix, ImplementationSpecific")
+	fs.StringToStringVar(&s.IngressClassMap, "ingress-class-map", nil, "ingress class annotation values mapped to the ingressClassName set, e.g. nginx=nginx-internal; implies --ingress-class-name")
+	fs.StringVar(&s.WebhookSideEffects, "webhook-side-effects", defaults.WebhookSideEffects, "sideEffects set on admission webhooks mapped to v1 which do not declare it or declare Unknown, one of: None, NoneOnDryRun; those declaring Some are set to NoneOnDryRun")
+	fs.StringSliceVar(&s.WebhookAdmissionReviewVersions, "webhook-admission-review-versions", defaults.WebhookAdmissionReviewVersions, "admissionReviewVersions set on admission webhooks mapped to v1 which do not declare them")
+}
+
+// KubeConfig returns the settings of the Kubernetes clients. The clients created with the
+// settings share a rate limiter, so that the releases processed concurrently are rate limited
+// together by --kube-qps and --kube-burst.
+func (s *EnvSettings) KubeConfig() common.KubeConfig {
+	return common.KubeConfig{
+		Context:               s.KubeContext,
+		File:                  s.KubeConfigFile,
+		AsUser:                s.KubeAsUser,
+		AsGroups:              s.KubeAsGroups,
+		APIServer:             s.KubeAPIServer,
+		Token:                 s.KubeToken,
+		CAFile:                s.KubeCAFile,
+		CertFile:              s.KubeCertFile,
+		KeyFile:               s.KubeKeyFile,
+		TLSServerName:         s.KubeTLSServerName,
+		InsecureSkipTLSVerify: s.KubeInsecure,
+		QPS:                   s.KubeQPS,
+		Burst:                 s.KubeBurst,
+		Retries:               s.Retries,
+		RetryBackoff:          s.RetryBackoff,
+		RequestTimeout:        s.RequestTimeout,
+		StorageOwner:          s.StorageOwner,
+		StorageSelector:       s.StorageSelector,
+		HelmVersion:           s.HelmVersion,
+		RateLimiter:           common.NewRateLimiter(s.KubeQPS, s.KubeBurst),
+	}
+}
+
+// KubeContexts returns the kubeconfig contexts of a multi-cluster run, i.e. those of --contexts
+// or all the contexts of the kubeconfig with --all-contexts, sorted by name, or nil otherwise
+func (s *EnvSettings) KubeContexts() ([]string, error) {
+	if !s.AllContexts {
+		return s.Contexts, nil
+	}
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = s.KubeConfigFile
+	config, err := loadingRules.Load()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load the kubeconfig contexts")
+	}
+	var contexts []string
+	for name := range config.Contexts {
+		contexts = append(contexts, name)
+	}
+	if len(contexts) == 0 {
+		return nil, errors.New("no contexts found in the kubeconfig")
+	}
+	sort.Strings(contexts)
+	return contexts, nil
+}
+
+// kubeContextNamespace returns the namespace of the kube context of the kubeconfig of
+// KUBECONFIG, or "default" if it has none, as Helm does when --namespace is not passed
+func kubeContextNamespace(kubeContext string) string {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	namespace, _, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).Namespace()
+	if err != nil || namespace == "" {
+		return "default"
+	}
+	return namespace
+}
+
+// MappingProvider returns the provider of the API mappings of the mapping file, which also
+// provides the mappings of the CRDs of the cluster if --crd-mappings is set. The mappings are
+// loaded once, so that all the releases of the run are mapped with the same mappings.
+func (s *EnvSettings) MappingProvider(mapFile string, kubeConfig common.KubeConfig) mapping.MappingProvider {
+	var provider mapping.MappingProvider = mapping.NewProvider(mapFile)
+	if s.CRDMappings {
+		provider = &common.CRDProvider{Provider: provider, KubeConfig: kubeConfig}
+	}
+	return mapping.NewCachedProvider(provider)
+}
+
+// defaultMapFileReloadInterval is the default interval at which the long-running modes check the
+// mapping file for changes
+const defaultMapFileReloadInterval = time.Minute
+
+// ReloadingMappingProvider returns the provider of the API mappings of the mapping file of a
+// long-running mode, as MappingProvider, which is reloaded at the interval until the context is
+// done. It is not reloaded if the interval is 0.
+func (s *EnvSettings) ReloadingMappingProvider(ctx context.Context, mapFile string, kubeConfig common.KubeConfig, interval time.Duration) mapping.MappingProvider {
+	var provider mapping.MappingProvider = mapping.NewProvider(mapFile)
+	if s.CRDMappings {
+		provider = &common.CRDProvider{Provider: provider, KubeConfig: kubeConfig}
+	}
+	reloading := mapping.NewReloadingProvider(provider)
+	if interval > 0 {
+		go reloading.Watch(ctx, interval)
+	}
+	return reloading
+}
+
+// ConversionSettings returns the settings of the built-in conversions
+func (s *EnvSettings) ConversionSettings() convert.Settings {
+	return convert.Settings{
+		CommentRemoved:                 s.CommentRemoved,
+		CSRSignerName:                  s.CSRSignerName,
+		IngressClassName:               s.IngressClassName || len(s.IngressClassMap) > 0,
+		IngressClassNames:              s.IngressClassMap,
+		IngressPathType:                s.IngressPathType,
+		WebhookAdmissionReviewVersions: s.WebhookAdmissionReviewVersions,
+		WebhookSideEffects:             s.WebhookSideEffects,
+	}
 }
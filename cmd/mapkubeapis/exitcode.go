@@ -0,0 +1,75 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+)
+
+// Exit codes returned by the plugin
+const (
+	// ExitCodeOK is returned when the run completed and there was nothing left to do
+	ExitCodeOK = 0
+
+	// ExitCodeError is returned when the run failed with an unexpected error
+	ExitCodeError = 1
+
+	// ExitCodeDeprecatedAPIsFound is returned in check mode when deprecated or removed APIs were found
+	ExitCodeDeprecatedAPIsFound = 2
+
+	// ExitCodePartialFailure is returned when some of the releases of a bulk run failed
+	ExitCodePartialFailure = 3
+
+	// ExitCodeUsage is returned when the command is invoked with invalid arguments or flags
+	ExitCodeUsage = 4
+)
+
+// exitError is an error which carries the exit code the plugin should exit with.
+// The wrapped error is nil when the exit code does not signal a failure, e.g. when
+// deprecated APIs were found in check mode.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return ""
+	}
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// withExitCode returns an error which makes the plugin exit with the given code
+func withExitCode(code int, err error) error {
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code for an error returned by a command
+func exitCode(err error) int {
+	if err == nil {
+		return ExitCodeOK
+	}
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return ExitCodeError
+}
@@ -0,0 +1,103 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+func newExplainCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "explain [flags] [GROUP/]VERSION/KIND | KIND",
+		Short: "Explain the mapping of a deprecated or removed Kubernetes API",
+		Long: "Look up a deprecated or removed Kubernetes API in the map file and print its replacement, " +
+			"the Kubernetes version it was deprecated and removed in, and any notes or links. " +
+			"If only a kind is passed, the mappings of all its API versions are printed.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return withExitCode(ExitCodeUsage, errors.New("exactly one API must be passed"))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Explain(out, args[0], settings.MapFile)
+		},
+	}
+
+	return cmd
+}
+
+// Explain prints the mappings of a deprecated or removed API in the map file
+func Explain(out io.Writer, api, mapFile string) error {
+	var apiVersion, kind string
+	if i := strings.LastIndex(api, "/"); i >= 0 {
+		apiVersion, kind = api[:i], api[i+1:]
+	} else {
+		kind = api
+	}
+
+	mapMetadata, err := mapping.NewProvider(mapFile).Mappings(context.Background())
+	if err != nil {
+		return err
+	}
+	mappings := mapMetadata.Lookup(apiVersion, kind)
+	if len(mappings) == 0 {
+		return errors.Errorf("no mapping found for API '%s' in mapping file: %s", api, mapFile)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	for i, m := range mappings {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		newAPI := "none, the API is removed without replacement"
+		if m.NewAPI != "" {
+			newAPI = common.FlattenAPI(m.NewAPI)
+		}
+		fmt.Fprintf(w, "API:\t%s\n", common.FlattenAPI(m.DeprecatedAPI))
+		fmt.Fprintf(w, "Replacement:\t%s\n", newAPI)
+		fmt.Fprintf(w, "Deprecated in:\t%s\n", valueOrNone(m.DeprecatedInVersion))
+		fmt.Fprintf(w, "Removed in:\t%s\n", valueOrNone(m.RemovedInVersion))
+		if m.Notes != "" {
+			fmt.Fprintf(w, "Notes:\t%s\n", m.Notes)
+		}
+		if m.Link != "" {
+			fmt.Fprintf(w, "Link:\t%s\n", m.Link)
+		}
+	}
+	return w.Flush()
+}
+
+func valueOrNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
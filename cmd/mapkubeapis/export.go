@@ -0,0 +1,157 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/storage"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+// ExportOptions contains the options for Export operation
+type ExportOptions struct {
+	Output           string
+	ReleaseName      string
+	ReleaseNamespace string
+	StorageDriver    string
+}
+
+func newExportCmd(out io.Writer) *cobra.Command {
+	exportOptions := ExportOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "export [flags] RELEASE",
+		Short: "Export the release records of a release to a file",
+		Long: "Export all the versions of a release, as stored by Helm, to a file as a JSON list of release records, " +
+			"or to standard output. The file can be mapped with --from-file, e.g. for review, and loaded by simulate.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return withExitCode(ExitCodeUsage, errors.New("one release name must be passed"))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(settings.Contexts) > 0 || settings.AllContexts {
+				return withExitCode(ExitCodeUsage, errors.New("export cannot be used with --contexts or --all-contexts"))
+			}
+			exportOptions.ReleaseName = args[0]
+			exportOptions.ReleaseNamespace = settings.Namespace
+			exportOptions.StorageDriver = settings.StorageDriver
+			return Export(cmd.Context(), out, exportOptions, settings.KubeConfig())
+		},
+	}
+
+	cmd.Flags().StringVarP(&exportOptions.Output, "output", "o", "-", "file to export the release records to, or '-' for standard output")
+
+	return cmd
+}
+
+// Export writes all the versions of a release to the file of the options, or out, as a JSON
+// list of release records. The file is only readable by its owner, as release records include
+// the values of the release.
+func Export(ctx context.Context, out io.Writer, exportOptions ExportOptions, kubeConfig common.KubeConfig) error {
+	history, err := v3.GetReleaseHistory(ctx, exportOptions.ReleaseName, exportOptions.ReleaseNamespace, exportOptions.StorageDriver, kubeConfig)
+	if err != nil {
+		return err
+	}
+	if exportOptions.Output == "-" || exportOptions.Output == "" {
+		return writeReleaseList(out, history)
+	}
+	f, err := os.OpenFile(exportOptions.Output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create export file: %s", exportOptions.Output)
+	}
+	defer f.Close()
+	if err := writeReleaseList(f, history); err != nil {
+		return errors.Wrapf(err, "failed to write export file: %s", exportOptions.Output)
+	}
+	log.Printf("Release '%s' exported to %s.\n", exportOptions.ReleaseName, exportOptions.Output)
+	return f.Close()
+}
+
+// runMapFromFile maps the latest version of the release of a file written by export in memory,
+// through the same pipeline as Map, and writes the release records once mapped to out. Release
+// storage is not accessed, so the options acting on release storage cannot be used.
+func runMapFromFile(ctx context.Context, out io.Writer, file string) error {
+	switch {
+	case len(settings.Contexts) > 0 || settings.AllContexts:
+		return withExitCode(ExitCodeUsage, errors.New("--from-file cannot be used with --contexts or --all-contexts"))
+	case settings.FromStorageObject != "":
+		return withExitCode(ExitCodeUsage, errors.New("--from-file cannot be used with --from-storage-object"))
+	case settings.Lock || settings.CheckLiveObjects || settings.ServerDryRun || settings.HelmReleasePatch != "":
+		return withExitCode(ExitCodeUsage, errors.New("--lock, --check-live-objects, --server-dry-run and --helmrelease-patch cannot be used with --from-file"))
+	}
+
+	releases, err := loadFixtures([]string{file})
+	if err != nil {
+		return err
+	}
+	latest := latestReleases(releases, "", nil)
+	if len(latest) != 1 {
+		return withExitCode(ExitCodeUsage, errors.Errorf("the file must hold the versions of one release, found %d releases", len(latest)))
+	}
+	rel := latest[0]
+	memory, err := newMemoryStorage(releases)
+	if err != nil {
+		return err
+	}
+	memory.SetNamespace(rel.Namespace)
+
+	kubeConfig := settings.KubeConfig()
+	if settings.KubeVersion != "" {
+		if kubeConfig.KubeVersion, err = targetKubeVersion(ctx, settings.KubeVersion, kubeConfig); err != nil {
+			return err
+		}
+	}
+	mapOptions := MapOptions{
+		AllowEmptyRelease: settings.AllowEmptyRelease,
+		DryRun:            settings.DryRun,
+		MapFile:           settings.MapFile,
+		PolicyAction:      settings.PolicyAction,
+		PolicyDir:         settings.PolicyDir,
+		PostHook:          settings.PostHook,
+		PreHook:           settings.PreHook,
+		ReleaseName:       rel.Name,
+		ReleaseNamespace:  rel.Namespace,
+		RequireNewAPI:     settings.RequireNewAPI,
+		SchemaLocation:    settings.SchemaLocation,
+		StorageDriver:     "memory",
+		ValidateSchema:    settings.ValidateSchema,
+		Storage:           storage.Init(memory),
+	}
+	result, err := Map(ctx, mapOptions, kubeConfig)
+	writeMapReport(mapOptions, result, err)
+	writePSPReport(result)
+	if err != nil {
+		return err
+	}
+	if err := writeReleaseRecords(out, memory); err != nil {
+		return err
+	}
+	return mapResultError(result, nil, mapOptions.DryRun)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/gitrepo"
+)
+
+// GitOptions contains the options for mapping the files of a Git repository clone, and
+// committing them on a new branch
+type GitOptions struct {
+	Branch string
+	Dir    string
+	Push   bool
+	Repo   string
+}
+
+// addGitFlags adds the flags of the Git options to the flag set
+func addGitFlags(flags *pflag.FlagSet, gitOptions *GitOptions) {
+	flags.StringVar(&gitOptions.Repo, "git-repo", "", "URL of a Git repository to clone and map, the path passed being relative to the repository")
+	flags.StringVar(&gitOptions.Branch, "git-branch", "", "branch to commit the mapped files to, created from the default branch; mapkubeapis-KUBE_VERSION if not set")
+	flags.StringVar(&gitOptions.Dir, "git-dir", "", "directory to clone the Git repository to, a new temporary directory if not set")
+	flags.BoolVar(&gitOptions.Push, "git-push", false, "push the branch to the origin of the Git repository")
+}
+
+// validateGitFlags returns a usage error if Git flags are set without --git-repo
+func validateGitFlags(gitOptions GitOptions) error {
+	if gitOptions.Repo == "" && (gitOptions.Branch != "" || gitOptions.Dir != "" || gitOptions.Push) {
+		return withExitCode(ExitCodeUsage, errors.New("--git-branch, --git-dir and --git-push can only be used with --git-repo"))
+	}
+	return nil
+}
+
+// runInGitRepo clones the Git repository of the options, runs mapFn on the path relative to
+// the clone on a new branch, and commits the files mapped, which mapFn returns relative to the
+// path, unless in dry-run mode. The branch is pushed if requested. Without Git repository,
+// mapFn is run on the path as is.
+func runInGitRepo(ctx context.Context, gitOptions GitOptions, dryRun bool, kubeVersion, relPath string, mapFn func(path string) (*common.ChartResult, error)) (*common.ChartResult, error) {
+	if gitOptions.Repo == "" {
+		return mapFn(relPath)
+	}
+	if !filepath.IsLocal(relPath) {
+		return nil, withExitCode(ExitCodeUsage, errors.Errorf("the path must be within the Git repository with --git-repo: %s", relPath))
+	}
+
+	dir := gitOptions.Dir
+	if dir == "" {
+		// The temporary clone is removed once the files are mapped, so the commit must be pushed
+		if !dryRun && !gitOptions.Push {
+			return nil, withExitCode(ExitCodeUsage, errors.New("--git-repo requires --git-push or --git-dir, as the temporary clone of the repository is removed once mapped"))
+		}
+		var err error
+		if dir, err = os.MkdirTemp("", "mapkubeapis-git-"); err != nil {
+			return nil, errors.Wrap(err, "failed to create Git clone directory")
+		}
+		defer os.RemoveAll(dir)
+	}
+	repo, err := gitrepo.Clone(ctx, gitOptions.Repo, dir)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Git repository %s cloned to %s.\n", gitOptions.Repo, dir)
+
+	branch := gitOptions.Branch
+	if branch == "" {
+		branch = "mapkubeapis-" + kubeVersion
+	}
+	if !dryRun {
+		if err := repo.CreateBranch(ctx, branch); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := mapFn(filepath.Join(dir, relPath))
+	if err != nil {
+		return nil, err
+	}
+	if dryRun || len(result.Files) == 0 {
+		return result, nil
+	}
+
+	files := make([]string, len(result.Files))
+	for i, file := range result.Files {
+		files[i] = path.Join(filepath.ToSlash(relPath), file)
+	}
+	message := fmt.Sprintf("Map deprecated or removed Kubernetes APIs for Kubernetes %s\n\nMapped files:\n- %s\n",
+		kubeVersion, strings.Join(files, "\n- "))
+	if err := repo.Commit(ctx, message, files); err != nil {
+		return nil, err
+	}
+	log.Printf("Mapped files committed to branch %s of %s.\n", branch, dir)
+
+	if gitOptions.Push {
+		if err := repo.Push(ctx, branch); err != nil {
+			return nil, err
+		}
+		log.Printf("Branch %s pushed to %s.\n", branch, gitOptions.Repo)
+	}
+	return result, nil
+}
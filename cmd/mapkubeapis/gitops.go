@@ -0,0 +1,182 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+	"github.com/helm/helm-mapkubeapis/pkg/mapkubeapis"
+)
+
+// GitOpsOptions contains the options for GitOps operation
+type GitOpsOptions struct {
+	DryRun      bool
+	Git         GitOptions
+	KubeVersion string
+	MapFile     string
+	Patch       string
+	ReleaseName string
+	Source      string
+}
+
+func newGitOpsCmd(out io.Writer) *cobra.Command {
+	gitOpsOptions := GitOpsOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "gitops --source DIR [flags] RELEASE",
+		Short: "Map deprecated or removed Kubernetes APIs of a release in its Git source",
+		Long: "Check a release for deprecated or removed Kubernetes APIs, and map them in the manifests and templates " +
+			"of the source directory rendering the release, such as the checkout of the Git repository synced by Argo CD " +
+			"or Flux, instead of writing a new release version, so that the remediation is committed to the source of truth " +
+			"and not reverted by the next sync. The files are rewritten in place, or the changes are written as a patch " +
+			"with --patch. With --git-repo, the source directory is that of a clone of the Git repository, and the mapped " +
+			"files are committed to a new branch, optionally pushed, ready for a pull request. Release storage is not modified. " +
+			"Exits with code 2 if deprecated or removed APIs of the release remain in the source.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return withExitCode(ExitCodeUsage, errors.New("the release name must be passed"))
+			}
+			if gitOpsOptions.Git.Repo != "" && gitOpsOptions.Patch != "" {
+				return withExitCode(ExitCodeUsage, errors.New("--patch cannot be used with --git-repo"))
+			}
+			return validateGitFlags(gitOpsOptions.Git)
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			gitOpsOptions.ReleaseName = args[0]
+			gitOpsOptions.DryRun = settings.DryRun
+			gitOpsOptions.MapFile = settings.MapFile
+			return GitOps(cmd.Context(), out, gitOpsOptions, settings.KubeConfig())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&gitOpsOptions.Source, "source", "", "directory of the manifests or chart rendering the release, e.g. a Git repository checkout")
+	flags.StringVar(&gitOpsOptions.Patch, "patch", "", "file to write the changes to as a patch instead of rewriting the source, or '-' for standard output")
+	flags.StringVar(&gitOpsOptions.KubeVersion, "kube-version", "", "Kubernetes version to map the release for, e.g. v1.25; that of the cluster if not set")
+	addGitFlags(flags, &gitOpsOptions.Git)
+	cmd.MarkFlagRequired("source")
+
+	return cmd
+}
+
+// GitOps checks the release for deprecated or removed APIs and maps them in its source
+// directory, rewriting the source or writing the changes as a patch
+func GitOps(ctx context.Context, out io.Writer, gitOpsOptions GitOpsOptions, kubeConfig common.KubeConfig) error {
+	kubeVersion, err := targetKubeVersion(ctx, gitOpsOptions.KubeVersion, kubeConfig)
+	if err != nil {
+		return err
+	}
+	kubeConfig.KubeVersion = kubeVersion
+	provider := settings.MappingProvider(gitOpsOptions.MapFile, kubeConfig)
+
+	checked, err := mapkubeapis.New(
+		mapkubeapis.WithKubeConfig(kubeConfig),
+		mapkubeapis.WithMappingProvider(provider),
+		mapkubeapis.WithNamespace(settings.Namespace),
+		mapkubeapis.WithReleaseTimeout(settings.ReleaseTimeout),
+		mapkubeapis.WithStorageDriver(settings.StorageDriver),
+	).CheckRelease(ctx, gitOpsOptions.ReleaseName)
+	if err != nil {
+		return err
+	}
+	name := releaseID(checked.Namespace, gitOpsOptions.ReleaseName)
+	if len(checked.MappedAPIs) == 0 {
+		fmt.Fprintf(out, "%s: no deprecated or removed APIs\n", name)
+		return nil
+	}
+
+	patchOnly := gitOpsOptions.Patch != ""
+	result, err := runInGitRepo(ctx, gitOpsOptions.Git, gitOpsOptions.DryRun, kubeVersion, gitOpsOptions.Source, func(source string) (*common.ChartResult, error) {
+		return common.MapSource(ctx, source, provider, kubeVersion, gitOpsOptions.DryRun || patchOnly, nil)
+	})
+	if err != nil {
+		return err
+	}
+	if patchOnly && !gitOpsOptions.DryRun {
+		if err := writePatch(out, gitOpsOptions.Patch, result.Patch()); err != nil {
+			return err
+		}
+	}
+
+	w := out
+	if gitOpsOptions.Patch == "-" {
+		// The findings must not be mixed with the patch written to standard output
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "%s: deprecated or removed APIs found\n", name)
+	var remaining int
+	for _, finding := range result.Findings {
+		if (finding.Action == common.ActionMapped && gitOpsOptions.DryRun) || len(finding.Warnings) > 0 {
+			remaining++
+		}
+		fmt.Fprintf(w, "  %s:%d: %s: %s\n", finding.File, finding.Line, resourceName(finding.Resource), finding.Action)
+		for _, warning := range finding.Warnings {
+			fmt.Fprintf(w, "    warning: %s\n", warning)
+		}
+	}
+	for _, resource := range unmatchedResources(checked.Findings, result.Findings) {
+		remaining++
+		fmt.Fprintf(w, "  %s: not found in the source\n", resourceName(resource))
+	}
+
+	if remaining > 0 {
+		return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+	}
+	return nil
+}
+
+// unmatchedResources returns the resources of the release requiring mapping whose API and
+// kind are not found in the source, e.g. as they are set by template actions
+func unmatchedResources(releaseFindings common.Findings, sourceFindings []common.ChartFinding) []convert.Resource {
+	found := map[string]bool{}
+	for _, finding := range sourceFindings {
+		found[finding.DeprecatedAPI] = true
+	}
+	var resources []convert.Resource
+	for _, finding := range releaseFindings {
+		if finding.Action == common.ActionSkipped || found[finding.DeprecatedAPI] {
+			continue
+		}
+		resources = append(resources, finding.Resource)
+	}
+	return resources
+}
+
+// writePatch writes the patch to the file, or to out if the file is '-'
+func writePatch(out io.Writer, file, patch string) error {
+	if file == "-" {
+		_, err := io.WriteString(out, patch)
+		return err
+	}
+	if err := os.WriteFile(file, []byte(patch), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write patch file: %s", file)
+	}
+	log.Printf("Patch written to %s.\n", file)
+	return nil
+}
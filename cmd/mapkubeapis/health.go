@@ -0,0 +1,75 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/helm/helm-mapkubeapis/pkg/health"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// defaultHealthAddress is the default address of the probes of the long-running modes
+const defaultHealthAddress = ":8081"
+
+// serveHealth serves the liveness and readiness probes at the address until the returned stop
+// function is called. The probes report the component as stopping once the context is done,
+// while it completes its work in progress. Nothing is served if the address is empty.
+func serveHealth(ctx context.Context, address string, probes *health.Probes) (func(), error) {
+	if address == "" {
+		return func() {}, nil
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to serve the health probes")
+	}
+	mux := http.NewServeMux()
+	probes.Register(mux)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Warning: failed to serve the health probes: %s\n", err)
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			probes.SetStopping()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		server.Close()
+	}, nil
+}
+
+// mappingsCheck returns the readiness check of the mappings of the provider, which succeeds once
+// the mapping file is loaded
+func mappingsCheck(provider mapping.MappingProvider) health.Check {
+	return func(ctx context.Context) error {
+		_, err := provider.Mappings(ctx)
+		return err
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/helmfile"
+	"github.com/helm/helm-mapkubeapis/pkg/mapkubeapis"
+)
+
+// HelmfileOptions contains the options for Helmfile operation
+type HelmfileOptions struct {
+	Check bool
+	File  string
+}
+
+func newHelmfileCmd(out io.Writer) *cobra.Command {
+	helmfileOptions := HelmfileOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "helmfile [flags]",
+		Short: "Map deprecated or removed Kubernetes APIs of the releases of a helmfile",
+		Long: "Map, or only check with --check, the deprecated or removed Kubernetes APIs of the releases declared in a " +
+			"helmfile state file and the state files it includes, each in its namespace and kube context. " +
+			"State files using Go template syntax must be rendered first: pass '-' as the file to read the output of " +
+			"helmfile build from standard input. " +
+			"Exits with code 2 if deprecated or removed APIs are found but not mapped in any of the releases.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("helmfile does not accept release names"))
+			}
+			if len(settings.Contexts) > 0 || settings.AllContexts {
+				return withExitCode(ExitCodeUsage, errors.New("helmfile cannot be used with --contexts or --all-contexts, the kube contexts are those of the releases"))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Helmfile(cmd.Context(), out, helmfileOptions)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&helmfileOptions.File, "file", "f", "helmfile.yaml", "helmfile state file, or '-' to read it from standard input")
+	flags.BoolVar(&helmfileOptions.Check, "check", false, "check the releases without modifying release storage")
+
+	return cmd
+}
+
+// Helmfile maps, or checks, the releases declared in a helmfile state file
+func Helmfile(ctx context.Context, out io.Writer, helmfileOptions HelmfileOptions) error {
+	var releases []helmfile.Release
+	var err error
+	if helmfileOptions.File == "-" {
+		releases, err = helmfile.Read(os.Stdin)
+	} else {
+		releases, err = helmfile.Load(helmfileOptions.File)
+	}
+	if err != nil {
+		return err
+	}
+	if len(releases) == 0 {
+		fmt.Fprintln(out, "No releases declared in the helmfile.")
+		return nil
+	}
+
+	results := make([]*mapkubeapis.Result, len(releases))
+	errs := make([]error, len(releases))
+	ctxErr := forEach(ctx, settings.Concurrency, len(releases), func(i int) {
+		results[i], errs[i] = runHelmfileRelease(ctx, releases[i], helmfileOptions.Check)
+	})
+
+	var found, failed int
+	var lastErr error
+	for i, release := range releases {
+		result, err := results[i], errs[i]
+		if result == nil && err == nil {
+			// The release was not processed as the run was interrupted
+			continue
+		}
+		name := helmfileReleaseID(release)
+		if err != nil {
+			log.Printf("Failed to process release '%s': %s\n", name, err)
+			fmt.Fprintf(out, "%s: failed\n", name)
+			failed++
+			lastErr = err
+			continue
+		}
+		switch {
+		case result.Mapped:
+			fmt.Fprintf(out, "%s: deprecated or removed APIs mapped, revision %d\n", name, result.Revision)
+		case len(result.MappedAPIs) > 0:
+			found++
+			fmt.Fprintf(out, "%s: deprecated or removed APIs found, not mapped\n", name)
+			printFindings(out, result.MappedAPIs, result.Findings)
+		default:
+			fmt.Fprintf(out, "%s: no deprecated or removed APIs\n", name)
+		}
+	}
+
+	switch {
+	case ctxErr != nil:
+		return errors.Wrap(ctxErr, "helmfile run interrupted")
+	case failed == len(releases):
+		return lastErr
+	case failed > 0:
+		return withExitCode(ExitCodePartialFailure, errors.Errorf("failed to process %d of %d releases", failed, len(releases)))
+	case found > 0:
+		return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+	}
+	return nil
+}
+
+// runHelmfileRelease maps, or checks, a release of a helmfile in its namespace and kube
+// context, those of the flags if the helmfile does not set them
+func runHelmfileRelease(ctx context.Context, release helmfile.Release, check bool) (*mapkubeapis.Result, error) {
+	kubeConfig := settings.KubeConfig()
+	if release.KubeContext != "" {
+		kubeConfig.Context = release.KubeContext
+	}
+	mapOptions := settingsMapOptions(release.Name)
+	if release.Namespace != "" {
+		mapOptions.ReleaseNamespace = release.Namespace
+	}
+	if !check {
+		return Map(ctx, mapOptions, kubeConfig)
+	}
+	return mapkubeapis.New(
+		mapkubeapis.WithKubeConfig(kubeConfig),
+		mapkubeapis.WithMappingProvider(settings.MappingProvider(mapOptions.MapFile, kubeConfig)),
+		mapkubeapis.WithNamespace(mapOptions.ReleaseNamespace),
+		mapkubeapis.WithReleaseTimeout(mapOptions.ReleaseTimeout),
+		mapkubeapis.WithStorageDriver(mapOptions.StorageDriver),
+	).CheckRelease(ctx, release.Name)
+}
+
+// helmfileReleaseID returns the kube context, namespace and name of a release of a helmfile
+// for display
+func helmfileReleaseID(release helmfile.Release) string {
+	id := releaseID(release.Namespace, release.Name)
+	if release.KubeContext != "" {
+		id = release.KubeContext + ":" + id
+	}
+	return id
+}
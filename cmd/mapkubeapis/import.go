@@ -0,0 +1,112 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapkubeapis"
+)
+
+// ImportOptions contains the options for Import operation
+type ImportOptions struct {
+	DryRun           bool
+	File             string
+	Lock             bool
+	MapFile          string
+	ReleaseNamespace string
+	StorageDriver    string
+}
+
+func newImportCmd(out io.Writer) *cobra.Command {
+	importOptions := ImportOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "import -f FILE [flags]",
+		Short: "Import a release mapped from a file back into release storage",
+		Long: "Import the release records of a release exported with export and mapped with --from-file back into " +
+			"release storage: the latest release version is superseded and a new version with the manifest of the " +
+			"mapped version is added, as when the release is mapped in release storage. The import fails if the " +
+			"release advanced or was modified since it was exported.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("import does not accept arguments, pass the release records with -f"))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(settings.Contexts) > 0 || settings.AllContexts {
+				return withExitCode(ExitCodeUsage, errors.New("import cannot be used with --contexts or --all-contexts"))
+			}
+			importOptions.DryRun = settings.DryRun
+			importOptions.MapFile = settings.MapFile
+			importOptions.ReleaseNamespace = settings.Namespace
+			importOptions.StorageDriver = settings.StorageDriver
+			return Import(cmd.Context(), out, importOptions, settings.KubeConfig())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&importOptions.File, "filename", "f", "", "file of the release records mapped with --from-file")
+	flags.BoolVar(&importOptions.Lock, "lock", false, "hold a Lease in the release namespace while the release is imported, so that concurrent runs fail instead of updating it simultaneously")
+	cmd.MarkFlagRequired("filename")
+
+	return cmd
+}
+
+// Import writes the mapped version of the release records of a file back to release storage,
+// see mapkubeapis.Mapper.ImportMappedRelease
+func Import(ctx context.Context, out io.Writer, importOptions ImportOptions, kubeConfig common.KubeConfig) error {
+	records, err := loadFixtures([]string{importOptions.File})
+	if err != nil {
+		return err
+	}
+	if importOptions.DryRun {
+		log.Println("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		log.Println("Run without --dry-run to take the actions described below:")
+		log.Println()
+	}
+	mapper := mapkubeapis.New(
+		mapkubeapis.WithDryRun(importOptions.DryRun),
+		mapkubeapis.WithKubeConfig(kubeConfig),
+		mapkubeapis.WithLock(importOptions.Lock),
+		mapkubeapis.WithMappingProvider(settings.MappingProvider(importOptions.MapFile, kubeConfig)),
+		mapkubeapis.WithNamespace(importOptions.ReleaseNamespace),
+		mapkubeapis.WithReleaseTimeout(settings.ReleaseTimeout),
+		mapkubeapis.WithStorageDriver(importOptions.StorageDriver),
+	)
+	result, err := mapper.ImportMappedRelease(ctx, records)
+	if err != nil {
+		return err
+	}
+	if result.Mapped {
+		fmt.Fprintf(out, "%s: imported, revision %d\n", releaseID(result.Namespace, result.Name), result.Revision)
+		return nil
+	}
+	fmt.Fprintf(out, "%s: can be imported\n", releaseID(result.Namespace, result.Name))
+	return nil
+}
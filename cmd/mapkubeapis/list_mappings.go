@@ -0,0 +1,128 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/mod/semver"
+	"sigs.k8s.io/yaml"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+const (
+	// outputTable prints the mappings as a table
+	outputTable = "table"
+
+	// outputYAML prints the mappings as a map file
+	outputYAML = "yaml"
+)
+
+// ListMappingsOptions contains the options for ListMappings operation
+type ListMappingsOptions struct {
+	KubeVersion string
+	MapFile     string
+	Output      string
+}
+
+func newListMappingsCmd(out io.Writer) *cobra.Command {
+	listOptions := ListMappingsOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "list-mappings [flags]",
+		Short: "List the mappings applied for a Kubernetes version",
+		Long: "List the effective mappings of the map file which would be applied for a Kubernetes version. " +
+			"The version of the Kubernetes server is used if no version is passed.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("list-mappings does not accept arguments"))
+			}
+			if listOptions.Output != outputTable && listOptions.Output != outputYAML {
+				return withExitCode(ExitCodeUsage, errors.Errorf("unsupported output format '%s', must be one of: %s, %s", listOptions.Output, outputTable, outputYAML))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			listOptions.MapFile = settings.MapFile
+			kubeConfig := settings.KubeConfig()
+			return ListMappings(cmd.Context(), out, listOptions, kubeConfig)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&listOptions.KubeVersion, "kube-version", "", "Kubernetes version to list the mappings for, e.g. v1.22")
+	flags.StringVarP(&listOptions.Output, "output", "o", outputTable, "output format, one of: table, yaml")
+
+	return cmd
+}
+
+// ListMappings prints the mappings of the map file which apply to the Kubernetes version
+func ListMappings(ctx context.Context, out io.Writer, listOptions ListMappingsOptions, kubeConfig common.KubeConfig) error {
+	kubeVersion := listOptions.KubeVersion
+	if kubeVersion == "" {
+		var err error
+		if kubeVersion, err = common.GetKubernetesServerVersion(ctx, kubeConfig); err != nil {
+			return err
+		}
+	} else if !strings.HasPrefix(kubeVersion, "v") {
+		kubeVersion = "v" + kubeVersion
+	}
+	if !semver.IsValid(kubeVersion) {
+		return withExitCode(ExitCodeUsage, errors.Errorf("invalid Kubernetes version '%s'", listOptions.KubeVersion))
+	}
+
+	mapMetadata, err := mapping.NewProvider(listOptions.MapFile).Mappings(context.Background())
+	if err != nil {
+		return err
+	}
+	effective, err := mapMetadata.Effective(kubeVersion)
+	if err != nil {
+		return err
+	}
+
+	if listOptions.Output == outputYAML {
+		b, err := yaml.Marshal(effective)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(b)
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEPRECATED API\tNEW API\tDEPRECATED IN\tREMOVED IN")
+	for _, m := range effective.Mappings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", common.FlattenAPI(m.DeprecatedAPI), valueOrNone(common.FlattenAPI(m.NewAPI)),
+			valueOrNone(m.DeprecatedInVersion), valueOrNone(m.RemovedInVersion))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\n%d of %d mappings apply to Kubernetes %s.\n", len(effective.Mappings), len(mapMetadata.Mappings), kubeVersion)
+	return nil
+}
@@ -17,48 +17,152 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+	"github.com/helm/helm-mapkubeapis/pkg/flux"
+	"github.com/helm/helm-mapkubeapis/pkg/hook"
+	"github.com/helm/helm-mapkubeapis/pkg/mapkubeapis"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	"github.com/helm/helm-mapkubeapis/pkg/notify"
+	"github.com/helm/helm-mapkubeapis/pkg/policy"
+	"github.com/helm/helm-mapkubeapis/pkg/psp"
+	"github.com/helm/helm-mapkubeapis/pkg/report"
 	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+	v4 "github.com/helm/helm-mapkubeapis/pkg/v4"
+	"github.com/helm/helm-mapkubeapis/pkg/validate"
 )
 
 // MapOptions contains the options for Map operation
 type MapOptions struct {
-	DryRun           bool
-	MapFile          string
-	ReleaseName      string
-	ReleaseNamespace string
+	Lock              bool
+	AllowEmptyRelease bool
+	CheckLiveObjects  bool
+	DryRun            bool
+	Force             bool
+	MapFile           string
+	PolicyAction      string
+	PolicyDir         string
+	PostHook          string
+	PreHook           string
+	ReleaseName       string
+	ReleaseNamespace  string
+	ReleaseTimeout    time.Duration
+	RequireNewAPI     bool
+	SchemaLocation    string
+	ServerDryRun      bool
+	StorageDriver     string
+	ValidateSchema    bool
+
+	// Storage is the release storage of the release, the Helm release storage of the cluster
+	// if nil
+	Storage common.ReleaseStorage
+
+	// MappingProvider is the provider of the API mappings, that of MapFile if nil
+	MappingProvider mapping.MappingProvider
 }
 
 var (
 	settings *EnvSettings
+
+	// cancelTimeout releases the context bounded by the overall timeout, if any
+	cancelTimeout context.CancelFunc = func() {}
 )
 
 func newMapCmd(out io.Writer, args []string) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:          "mapkubeapis [flags] RELEASE",
-		Short:        "Map release deprecated or removed Kubernetes APIs in-place",
-		Long:         "Map release deprecated or removed Kubernetes APIs in-place",
-		SilenceUsage: true,
+		Use:   "mapkubeapis [flags] [--] RELEASE",
+		Short: "Map release deprecated or removed Kubernetes APIs in-place",
+		Long: "Map release deprecated or removed Kubernetes APIs in-place. A release named like a subcommand, e.g. check or scan, " +
+			"must be passed after --, e.g. mapkubeapis -- check, as its name is otherwise taken as the subcommand.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
 		Args: func(cmd *cobra.Command, args []string) error {
+			if settings.FromFile != "" {
+				if len(args) > 0 {
+					return withExitCode(ExitCodeUsage, errors.New("no release name may be passed with --from-file"))
+				}
+				return nil
+			}
+			if settings.FromStorageObject != "" {
+				if len(args) > 0 {
+					return withExitCode(ExitCodeUsage, errors.New("no release name may be passed with --from-storage-object"))
+				}
+				return nil
+			}
 			if len(args) == 0 {
 				cmd.Help()
-				os.Exit(1)
+				os.Exit(ExitCodeUsage)
 			} else if len(args) > 1 {
-				return errors.New("only one release name may be passed at a time")
+				return withExitCode(ExitCodeUsage, errors.New("only one release name may be passed at a time"))
 			}
 			return nil
 		},
 
-		RunE: runMap,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			switch settings.WebhookSideEffects {
+			case "None", "NoneOnDryRun":
+			default:
+				return withExitCode(ExitCodeUsage, fmt.Errorf("invalid webhook side effects '%s', must be one of: None, NoneOnDryRun", settings.WebhookSideEffects))
+			}
+			switch settings.IngressPathType {
+			case "Exact", "Prefix", "ImplementationSpecific":
+			default:
+				return withExitCode(ExitCodeUsage, fmt.Errorf("invalid ingress path type '%s', must be one of: Exact, Prefix, ImplementationSpecific", settings.IngressPathType))
+			}
+			if err := v3.ValidateStorageDriver(settings.StorageDriver); err != nil {
+				return withExitCode(ExitCodeUsage, err)
+			}
+			if err := v3.ValidateStorageLabels(settings.StorageOwner, settings.StorageSelector); err != nil {
+				return withExitCode(ExitCodeUsage, err)
+			}
+			if err := v4.ValidateHelmVersion(settings.HelmVersion); err != nil {
+				return withExitCode(ExitCodeUsage, err)
+			}
+			if settings.HelmVersion == 0 {
+				settings.HelmVersion = v4.DetectHelmVersion()
+			}
+			if settings.KubeContext != "" && common.InCluster(common.KubeConfig{File: settings.KubeConfigFile}) {
+				return withExitCode(ExitCodeUsage, fmt.Errorf("--kube-context cannot be used with the in-cluster configuration, no kubeconfig file is set"))
+			}
+			if settings.Concurrency < 1 {
+				return withExitCode(ExitCodeUsage, fmt.Errorf("invalid concurrency %d, must be at least 1", settings.Concurrency))
+			}
+			if len(settings.Contexts) > 0 || settings.AllContexts {
+				switch {
+				case len(settings.Contexts) > 0 && settings.AllContexts:
+					return withExitCode(ExitCodeUsage, fmt.Errorf("--contexts and --all-contexts cannot be used together"))
+				case settings.KubeContext != "":
+					return withExitCode(ExitCodeUsage, fmt.Errorf("--kube-context cannot be used with --contexts or --all-contexts"))
+				case settings.ContextConcurrency < 1:
+					return withExitCode(ExitCodeUsage, fmt.Errorf("invalid context concurrency %d, must be at least 1", settings.ContextConcurrency))
+				}
+			}
+			cmd.SetContext(convert.WithSettings(cmd.Context(), settings.ConversionSettings()))
+			if settings.Timeout > 0 {
+				ctx, cancel := context.WithTimeout(cmd.Context(), settings.Timeout)
+				cmd.SetContext(ctx)
+				cancelTimeout = cancel
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runMap(cmd.Context(), out, args)
+		},
 	}
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return withExitCode(ExitCodeUsage, err)
+	})
 
 	flags := cmd.PersistentFlags()
 	flags.Parse(args)
@@ -80,34 +184,261 @@ func newMapCmd(out io.Writer, args []string) *cobra.Command {
 		settings.KubeContext = ctx
 	}
 
+	// As for Helm, HELM_NAMESPACE is the default of --namespace. The Helm plugin framework
+	// always sets it, to the namespace of the kube context if Helm's --namespace flag is not
+	// passed, in which case it is ignored so that the namespace of the release is found.
+	if ns := os.Getenv("HELM_NAMESPACE"); ns != "" {
+		if os.Getenv("HELM_PLUGIN_DIR") == "" || ns != kubeContextNamespace(settings.KubeContext) {
+			settings.Namespace = ns
+		}
+	}
+
 	// Note that the plugin's --kubeconfig flag is set by the Helm plugin framework to
 	// the KUBECONFIG environment variable instead of being passed into the plugin.
 
 	settings.AddFlags(flags)
 
+	cmd.Flags().StringVar(&settings.ReportFile, "report-file", "", "file to write an upgrade readiness report of the run to")
+	cmd.Flags().StringVar(&settings.ReportFormat, "report-format", report.FormatMarkdown, "format of the report, one of: markdown, html")
+	cmd.Flags().BoolVar(&settings.AllowEmptyRelease, "allow-empty-release", false, "map the release even if all its resources are removed, as their APIs have no replacement")
+	cmd.Flags().BoolVar(&settings.CheckLiveObjects, "check-live-objects", false, "warn about the live objects of the resources removed from the release, as their API has no replacement, which Helm orphans")
+	cmd.Flags().BoolVar(&settings.Force, "force", false, "map the release even if it is managed by a GitOps controller, e.g. Argo CD, which reverts the release to its source")
+	cmd.Flags().StringVar(&settings.HelmReleasePatch, "helmrelease-patch", "", "file to write the patch of the Flux HelmRelease of the release to, or '-' for standard output, adding a post-renderer which maps the deprecated or removed APIs at the source")
+	cmd.Flags().BoolVar(&settings.Lock, "lock", false, "hold a Lease in the release namespace while the release is mapped, so that concurrent runs fail instead of mapping it simultaneously")
+	cmd.Flags().StringVar(&settings.PreHook, "pre-hook", "", "command run before the release is updated, with the change summary on stdin; a non-zero exit aborts the update")
+	cmd.Flags().StringVar(&settings.PolicyDir, "policy-dir", "", "directory of Rego policies the release with its APIs mapped is evaluated against before it is updated")
+	cmd.Flags().StringVar(&settings.PolicyAction, "policy-action", policy.ActionAbort, "action if a policy denies the change, one of: abort, dry-run")
+	cmd.Flags().StringVar(&settings.PSPReportFile, "psp-report", "", "file to write a report of the PodSecurityPolicy resources removed from the release to, with suggested Pod Security Admission namespace labels")
+	cmd.Flags().BoolVar(&settings.RequireNewAPI, "require-new-api", false, "fail if the supported API of a deprecated or removed API is not served by the cluster, instead of leaving it unmapped")
+	cmd.Flags().BoolVar(&settings.ServerDryRun, "server-dry-run", false, "validate the release with its APIs mapped by applying its resources to the cluster in server-side dry-run mode before it is updated")
+	cmd.Flags().StringVar(&settings.FromStorageObject, "from-storage-object", "", "name of the Secret or ConfigMap of a release version whose release payload is mapped in place, for the repair of releases Helm fails to load, e.g. sh.helm.release.v1.my-release.v7")
+	cmd.Flags().StringVar(&settings.FromFile, "from-file", "", "file of the release records of a release written by export, whose latest version is mapped without accessing release storage; the release records once mapped are written to standard output")
+	cmd.Flags().StringVar(&settings.KubeVersion, "kube-version", "", "Kubernetes version to map the release of --from-file for, e.g. v1.25; that of the cluster if not set")
+	cmd.Flags().StringVar(&settings.PostHook, "post-hook", "", "command run after the release is updated, with the change summary on stdin")
+
+	cmd.AddCommand(newChartCmd(out))
+	cmd.AddCommand(newCheckCmd(out))
+	cmd.AddCommand(newDaemonCmd(out))
+	cmd.AddCommand(newExplainCmd(out))
+	cmd.AddCommand(newExportCmd(out))
+	cmd.AddCommand(newGitOpsCmd(out))
+	cmd.AddCommand(newHelmfileCmd(out))
+	cmd.AddCommand(newImportCmd(out))
+	cmd.AddCommand(newListMappingsCmd(out))
+	cmd.AddCommand(newMapManifestsCmd(out))
+	cmd.AddCommand(newMapPayloadCmd(out))
+	cmd.AddCommand(newOperatorCmd(out))
+	cmd.AddCommand(newVersionCmd(out))
+	cmd.AddCommand(newReportCmd(out))
+	cmd.AddCommand(newScanCmd(out))
+	cmd.AddCommand(newScanRepoCmd(out))
+	cmd.AddCommand(newServeCmd(out))
+	cmd.AddCommand(newSimulateCmd(out))
+	cmd.AddCommand(newStoredVersionsCmd(out))
+	cmd.AddCommand(newUpdateMapFileCmd(out))
+	cmd.AddCommand(newV2MapCmd(out))
+	cmd.AddCommand(newVerifyCmd(out))
+	cmd.AddCommand(newWebhookCmd(out))
+
 	return cmd
 }
 
-func runMap(cmd *cobra.Command, args []string) error {
-	releaseName := args[0]
-	mapOptions := MapOptions{
-		DryRun:           settings.DryRun,
-		MapFile:          settings.MapFile,
-		ReleaseName:      releaseName,
-		ReleaseNamespace: settings.Namespace,
+func runMap(ctx context.Context, out io.Writer, args []string) error {
+	if settings.FromFile != "" {
+		return runMapFromFile(ctx, out, settings.FromFile)
+	}
+	if settings.KubeVersion != "" {
+		return withExitCode(ExitCodeUsage, errors.New("--kube-version can only be used with --from-file"))
+	}
+	if settings.FromStorageObject != "" {
+		return runMapStorageObject(ctx, settings.FromStorageObject)
 	}
-	kubeConfig := common.KubeConfig{
-		Context: settings.KubeContext,
-		File:    settings.KubeConfigFile,
+	mapOptions := settingsMapOptions(args[0])
+	kubeConfig := settings.KubeConfig()
+	if settings.ReportFile != "" {
+		if err := report.ValidateFormat(settings.ReportFormat); err != nil {
+			return withExitCode(ExitCodeUsage, err)
+		}
+	}
+	if err := policy.ValidateAction(settings.PolicyAction); err != nil {
+		return withExitCode(ExitCodeUsage, err)
+	}
+	if len(settings.Contexts) > 0 || settings.AllContexts {
+		if settings.ReportFile != "" || settings.NotifyURL != "" || settings.PSPReportFile != "" || settings.HelmReleasePatch != "" {
+			return withExitCode(ExitCodeUsage, errors.New("--report-file, --notify-url, --psp-report and --helmrelease-patch cannot be used with --contexts or --all-contexts"))
+		}
+		return runClusters(ctx, out, func(ctx context.Context, out io.Writer, kubeConfig common.KubeConfig) error {
+			result, err := Map(ctx, mapOptions, kubeConfig)
+			if err == nil {
+				switch {
+				case result.Mapped:
+					fmt.Fprintf(out, "%s: deprecated or removed APIs mapped, revision %d\n", result.Name, result.Revision)
+				case len(result.MappedAPIs) > 0:
+					fmt.Fprintf(out, "%s: deprecated or removed APIs found, not mapped\n", result.Name)
+				default:
+					fmt.Fprintf(out, "%s: no deprecated or removed APIs\n", result.Name)
+				}
+			}
+			return mapResultError(result, err, mapOptions.DryRun)
+		})
+	}
+	if settings.NotifyURL == "" {
+		result, err := Map(ctx, mapOptions, kubeConfig)
+		writeMapReport(mapOptions, result, err)
+		writePSPReport(result)
+		writeHelmReleasePatch(out, result)
+		return mapResultError(result, err, mapOptions.DryRun)
 	}
 
-	return Map(mapOptions, kubeConfig)
+	if err := notify.ValidateFormat(settings.NotifyFormat); err != nil {
+		return withExitCode(ExitCodeUsage, err)
+	}
+	summary := notify.Summary{
+		Release:   mapOptions.ReleaseName,
+		Namespace: mapOptions.ReleaseNamespace,
+		DryRun:    mapOptions.DryRun,
+		Status:    notify.StatusSucceeded,
+		StartTime: time.Now(),
+	}
+	result, err := Map(ctx, mapOptions, kubeConfig)
+	summary.EndTime = time.Now()
+	writeMapReport(mapOptions, result, err)
+	writePSPReport(result)
+	writeHelmReleasePatch(out, result)
+	if err != nil {
+		summary.Status = notify.StatusFailed
+		summary.Error = err.Error()
+	} else {
+		summary.Namespace = result.Namespace
+		summary.MappedAPIs = result.MappedAPIs
+	}
+	if notifyErr := notify.Send(settings.NotifyURL, settings.NotifyFormat, summary); notifyErr != nil {
+		log.Printf("Warning: %s\n", notifyErr)
+	}
+	return mapResultError(result, err, mapOptions.DryRun)
+}
+
+// settingsMapOptions returns the options of mapping the release set by the flags
+func settingsMapOptions(releaseName string) MapOptions {
+	return MapOptions{
+		Lock:              settings.Lock,
+		AllowEmptyRelease: settings.AllowEmptyRelease,
+		CheckLiveObjects:  settings.CheckLiveObjects,
+		DryRun:            settings.DryRun,
+		Force:             settings.Force,
+		MapFile:           settings.MapFile,
+		PolicyAction:      settings.PolicyAction,
+		PolicyDir:         settings.PolicyDir,
+		PostHook:          settings.PostHook,
+		PreHook:           settings.PreHook,
+		ReleaseName:       releaseName,
+		ReleaseNamespace:  settings.Namespace,
+		ReleaseTimeout:    settings.ReleaseTimeout,
+		RequireNewAPI:     settings.RequireNewAPI,
+		SchemaLocation:    settings.SchemaLocation,
+		ServerDryRun:      settings.ServerDryRun,
+		StorageDriver:     settings.StorageDriver,
+		ValidateSchema:    settings.ValidateSchema,
+	}
+}
+
+// writeMapReport writes the upgrade readiness report of a map run if a report file is set
+func writeMapReport(mapOptions MapOptions, result *mapkubeapis.Result, err error) {
+	if settings.ReportFile == "" {
+		return
+	}
+	reportRelease := report.Release{
+		Name:      mapOptions.ReleaseName,
+		Namespace: mapOptions.ReleaseNamespace,
+		Status:    report.StatusClean,
+	}
+	switch {
+	case err != nil:
+		reportRelease.Status = report.StatusFailed
+		reportRelease.Error = err.Error()
+	default:
+		reportRelease.Namespace = result.Namespace
+		reportRelease.Revision = result.Revision
+		reportRelease.MappedAPIs = result.MappedAPIs
+		if result.Mapped {
+			reportRelease.Status = report.StatusMapped
+		} else if len(result.MappedAPIs) > 0 {
+			reportRelease.Status = report.StatusPending
+		}
+	}
+	rpt := &report.Report{
+		Title:       reportTitle,
+		GeneratedAt: time.Now(),
+		Releases:    []report.Release{reportRelease},
+	}
+	if reportErr := writeReport(nil, rpt, settings.ReportFormat, settings.ReportFile); reportErr != nil {
+		log.Printf("Warning: %s\n", reportErr)
+	}
+}
+
+// writePSPReport writes the report of the PodSecurityPolicy resources removed from the release
+// if a PSP report file is set
+func writePSPReport(result *mapkubeapis.Result) {
+	if settings.PSPReportFile == "" || result == nil {
+		return
+	}
+	guidance := psp.Analyze(result.Removed)
+	if len(guidance) == 0 {
+		return
+	}
+	rpt := &psp.Report{Release: result.Name, Namespace: result.Namespace, Guidance: guidance}
+	f, err := os.Create(settings.PSPReportFile)
+	if err != nil {
+		log.Printf("Warning: failed to create PodSecurityPolicy report: %s\n", err)
+		return
+	}
+	defer f.Close()
+	if err := rpt.Write(f); err != nil {
+		log.Printf("Warning: failed to write PodSecurityPolicy report: %s\n", err)
+		return
+	}
+	log.Printf("PodSecurityPolicy report written to: %s\n", settings.PSPReportFile)
+}
+
+// writeHelmReleasePatch writes the patch of the Flux HelmRelease of the release, mapping its
+// deprecated or removed APIs at the source, if a HelmRelease patch file is set
+func writeHelmReleasePatch(out io.Writer, result *mapkubeapis.Result) {
+	if settings.HelmReleasePatch == "" || result == nil || len(result.MappedAPIs) == 0 {
+		return
+	}
+	if result.ManagedBy == nil || result.ManagedBy.Controller != common.ControllerFlux {
+		log.Printf("Warning: release '%s' is not managed by a Flux HelmRelease, no HelmRelease patch is written.\n", result.Name)
+		return
+	}
+	patch, err := flux.HelmReleasePatch(result.ManagedBy, result.Findings, result.Manifest)
+	if err != nil {
+		log.Printf("Warning: failed to create HelmRelease patch: %s\n", err)
+		return
+	}
+	if err := writePatch(out, settings.HelmReleasePatch, string(patch)); err != nil {
+		log.Printf("Warning: %s\n", err)
+	}
+}
+
+// mapResultError returns the error which sets the exit code for the result of a map run.
+// A run which found deprecated or removed APIs but did not map them, as the update was skipped
+// by a policy or as the release is managed by a GitOps controller, exits with
+// ExitCodeDeprecatedAPIsFound. A dry run exits with code 0 whatever it found, as the check
+// command is the one gating on the findings.
+func mapResultError(result *mapkubeapis.Result, err error, dryRun bool) error {
+	if err != nil || dryRun {
+		return err
+	}
+	if !result.Mapped && len(result.MappedAPIs) > 0 {
+		return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+	}
+	return nil
 }
 
 // Map checks for Kubernetes deprecated or removed APIs in the manifest of the last deployed release version
 // and maps those API versions to supported versions. It then adds a new release version with
 // the updated APIs and supersedes the version with the unsupported APIs.
-func Map(mapOptions MapOptions, kubeConfig common.KubeConfig) error {
+func Map(ctx context.Context, mapOptions MapOptions, kubeConfig common.KubeConfig) (*mapkubeapis.Result, error) {
 	if mapOptions.DryRun {
 		log.Println("NOTE: This is in dry-run mode, the following actions will not be executed.")
 		log.Println("Run without --dry-run to take the actions described below:")
@@ -116,19 +447,71 @@ func Map(mapOptions MapOptions, kubeConfig common.KubeConfig) error {
 
 	log.Printf("Release '%s' will be checked for deprecated or removed Kubernetes APIs and will be updated if necessary to supported API versions.\n", mapOptions.ReleaseName)
 
-	options := common.MapOptions{
-		DryRun:           mapOptions.DryRun,
-		KubeConfig:       kubeConfig,
-		MapFile:          mapOptions.MapFile,
-		ReleaseName:      mapOptions.ReleaseName,
-		ReleaseNamespace: mapOptions.ReleaseNamespace,
+	provider := mapOptions.MappingProvider
+	if provider == nil {
+		provider = settings.MappingProvider(mapOptions.MapFile, kubeConfig)
 	}
-
-	if err := v3.MapReleaseWithUnSupportedAPIs(options); err != nil {
-		return err
+	opts := []mapkubeapis.Option{
+		mapkubeapis.WithAllowEmptyRelease(mapOptions.AllowEmptyRelease),
+		mapkubeapis.WithCheckLiveObjects(mapOptions.CheckLiveObjects),
+		mapkubeapis.WithDryRun(mapOptions.DryRun),
+		mapkubeapis.WithForce(mapOptions.Force),
+		mapkubeapis.WithKubeConfig(kubeConfig),
+		mapkubeapis.WithLock(mapOptions.Lock),
+		mapkubeapis.WithMappingProvider(provider),
+		mapkubeapis.WithNamespace(mapOptions.ReleaseNamespace),
+		mapkubeapis.WithReleaseTimeout(mapOptions.ReleaseTimeout),
+		mapkubeapis.WithRequireNewAPI(mapOptions.RequireNewAPI),
+		mapkubeapis.WithStorageDriver(mapOptions.StorageDriver),
+	}
+	if mapOptions.Storage != nil {
+		opts = append(opts, mapkubeapis.WithStorage(mapOptions.Storage))
+	}
+	var validators []common.Hook
+	if mapOptions.ValidateSchema {
+		kubeVersion, err := common.GetKubernetesServerVersion(ctx, kubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		validator, err := validate.NewSchemaValidator(mapOptions.SchemaLocation, kubeVersion)
+		if err != nil {
+			return nil, err
+		}
+		validators = append(validators, validator.Hook())
+	}
+	if mapOptions.ServerDryRun {
+		validators = append(validators, validate.ServerDryRun(kubeConfig))
+	}
+	if len(validators) > 0 {
+		opts = append(opts, mapkubeapis.WithValidator(hook.Chain(validators...)))
+	}
+	var preMapHooks []common.Hook
+	if mapOptions.PolicyDir != "" {
+		preMapHooks = append(preMapHooks, policy.Gate(mapOptions.PolicyDir, mapOptions.PolicyAction))
+	}
+	if mapOptions.PreHook != "" {
+		preMapHooks = append(preMapHooks, hook.Command(hook.PreMap, mapOptions.PreHook))
+	}
+	if len(preMapHooks) > 0 {
+		opts = append(opts, mapkubeapis.WithPreMapHook(hook.Chain(preMapHooks...)))
+	}
+	if mapOptions.PostHook != "" {
+		opts = append(opts, mapkubeapis.WithPostMapHook(hook.Command(hook.PostMap, mapOptions.PostHook)))
+	}
+	mapper := mapkubeapis.New(opts...)
+	result, err := mapper.MapRelease(ctx, mapOptions.ReleaseName)
+	if err != nil {
+		return nil, err
 	}
 
 	log.Printf("Map of release '%s' deprecated or removed APIs to supported versions, completed successfully.\n", mapOptions.ReleaseName)
+	if len(result.Findings) > 0 {
+		log.Printf("Resources mapped: %d, removed: %d, skipped: %d.\n", result.Findings.Count(common.ActionMapped),
+			result.Findings.Count(common.ActionRemoved), result.Findings.Count(common.ActionSkipped))
+	}
+	for _, g := range psp.Analyze(result.Removed) {
+		log.Printf("Warning: PodSecurityPolicy '%s' was removed, its closest Pod Security Standard is '%s'. Protections it enforced are lost unless Pod Security Admission is configured.\n", g.Name, g.Level)
+	}
 
-	return nil
+	return result, nil
 }
@@ -17,13 +17,40 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
+
+	// Register the auth provider plugins of kubeconfig users, e.g. oidc, as Helm does. Exec
+	// credential plugins are supported by client-go itself.
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
 )
 
 func main() {
+	// On SIGINT or SIGTERM, the context is canceled so that the run stops after the release
+	// being written, instead of leaving it superseded but not replaced. A second signal
+	// terminates the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		if ctx.Err() == context.Canceled {
+			stop()
+			log.Println("Interrupted, stopping after the release being written. Interrupt again to terminate immediately.")
+		}
+	}()
+
 	mapCmd := newMapCmd(os.Stdout, os.Args[1:])
 
-	if err := mapCmd.Execute(); err != nil {
-		os.Exit(1)
+	err := mapCmd.ExecuteContext(ctx)
+	cancelTimeout()
+	stop()
+	if err != nil {
+		if msg := err.Error(); msg != "" {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", msg)
+		}
+		os.Exit(exitCode(err))
 	}
 }
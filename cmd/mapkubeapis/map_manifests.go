@@ -0,0 +1,200 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/mod/semver"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapkubeapis"
+	"github.com/helm/helm-mapkubeapis/pkg/validate"
+)
+
+// MapManifestsOptions contains the options for MapManifests operation
+type MapManifestsOptions struct {
+	DryRun         bool
+	File           string
+	KubeVersion    string
+	KustomizeDir   string
+	MapFile        string
+	SchemaLocation string
+	ValidateSchema bool
+}
+
+func newMapManifestsCmd(out io.Writer) *cobra.Command {
+	mapManifestsOptions := MapManifestsOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "map -f FILE | --kustomize-dir DIR [flags]",
+		Short: "Map deprecated or removed Kubernetes APIs in manifests",
+		Long: "Map deprecated or removed Kubernetes APIs in a multi-document YAML manifest file, and write the " +
+			"result to standard output. Pass '-' as the file to read the manifests from standard input, " +
+			"e.g. helm template ... | helm mapkubeapis map -f - | kubectl apply -f -, or kustomize build ... | helm mapkubeapis map -f -. " +
+			"With --kustomize-dir, the manifests are built from the kustomization directory, as kustomize build does. " +
+			"Helm release storage is not accessed.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("map does not accept arguments, pass the manifests with -f"))
+			}
+			if (mapManifestsOptions.File == "") == (mapManifestsOptions.KustomizeDir == "") {
+				return withExitCode(ExitCodeUsage, errors.New("exactly one of -f or --kustomize-dir must be set"))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mapManifestsOptions.DryRun = settings.DryRun
+			mapManifestsOptions.MapFile = settings.MapFile
+			mapManifestsOptions.SchemaLocation = settings.SchemaLocation
+			mapManifestsOptions.ValidateSchema = settings.ValidateSchema
+			kubeConfig := settings.KubeConfig()
+			return MapManifests(cmd.Context(), out, mapManifestsOptions, kubeConfig)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&mapManifestsOptions.File, "filename", "f", "", "manifest file to map, or '-' to read from standard input")
+	flags.StringVar(&mapManifestsOptions.KubeVersion, "kube-version", "", "Kubernetes version to map the manifests for, e.g. v1.25")
+	flags.StringVar(&mapManifestsOptions.KustomizeDir, "kustomize-dir", "", "kustomization directory to build the manifests to map from, instead of -f")
+
+	return cmd
+}
+
+// MapManifests maps the deprecated or removed APIs in a manifest file, or standard input,
+// and writes the mapped manifests. In dry-run mode the manifests are written unchanged.
+func MapManifests(ctx context.Context, out io.Writer, mapManifestsOptions MapManifestsOptions, kubeConfig common.KubeConfig) error {
+	kubeVersion, err := targetKubeVersion(ctx, mapManifestsOptions.KubeVersion, kubeConfig)
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if mapManifestsOptions.KustomizeDir != "" {
+		manifests, err := kustomizeBuild(mapManifestsOptions.KustomizeDir)
+		if err != nil {
+			return err
+		}
+		in = bytes.NewReader(manifests)
+	} else if mapManifestsOptions.File != "-" {
+		f, err := os.Open(mapManifestsOptions.File)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open manifest file: %s", mapManifestsOptions.File)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var validator *validate.SchemaValidator
+	if mapManifestsOptions.ValidateSchema {
+		var err error
+		if validator, err = validate.NewSchemaValidator(mapManifestsOptions.SchemaLocation, kubeVersion); err != nil {
+			return err
+		}
+	}
+
+	mapper := mapkubeapis.New(mapkubeapis.WithMapFile(mapManifestsOptions.MapFile))
+	if validator == nil {
+		// Without validation, the manifests are written as they are mapped, one document at a
+		// time, so that huge manifests are not held in memory
+		var result *mapkubeapis.ManifestResult
+		var err error
+		if mapManifestsOptions.DryRun {
+			result, err = mapper.MapManifestStream(ctx, io.TeeReader(in, out), io.Discard, kubeVersion)
+		} else {
+			result, err = mapper.MapManifestStream(ctx, in, out, kubeVersion)
+		}
+		if err != nil {
+			return err
+		}
+		if mapManifestsOptions.DryRun && len(result.MappedAPIs) > 0 {
+			return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+		}
+		return nil
+	}
+
+	if !mapManifestsOptions.DryRun {
+		result, err := mapper.MapManifests(ctx, in, kubeVersion)
+		if err != nil {
+			return err
+		}
+		if err := validator.Validate(result.Manifest); err != nil {
+			return err
+		}
+		_, err = io.WriteString(out, result.Manifest)
+		return err
+	}
+
+	var manifest strings.Builder
+	result, err := mapper.MapManifests(ctx, io.TeeReader(in, &manifest), kubeVersion)
+	if err != nil {
+		return err
+	}
+	if err := validator.Validate(result.Manifest); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(out, manifest.String()); err != nil {
+		return err
+	}
+	if len(result.MappedAPIs) > 0 {
+		return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+	}
+	return nil
+}
+
+// kustomizeBuild returns the manifests built from the kustomization directory, as kustomize
+// build does with its default options
+func kustomizeBuild(dir string) ([]byte, error) {
+	resources, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(filesys.MakeFsOnDisk(), dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to build kustomization: %s", dir)
+	}
+	manifests, err := resources.AsYaml()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode the manifests of kustomization: %s", dir)
+	}
+	return manifests, nil
+}
+
+// targetKubeVersion returns the Kubernetes version to map manifests for, i.e. the version
+// passed, or the version of the Kubernetes server if none is passed
+func targetKubeVersion(ctx context.Context, kubeVersion string, kubeConfig common.KubeConfig) (string, error) {
+	target := kubeVersion
+	if target == "" {
+		var err error
+		if target, err = common.GetKubernetesServerVersion(ctx, kubeConfig); err != nil {
+			return "", err
+		}
+	} else if !strings.HasPrefix(target, "v") {
+		target = "v" + target
+	}
+	if !semver.IsValid(target) {
+		return "", withExitCode(ExitCodeUsage, errors.Errorf("invalid Kubernetes version '%s'", kubeVersion))
+	}
+	return target, nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+// MapPayloadOptions contains the options for MapPayload operation
+type MapPayloadOptions struct {
+	DryRun      bool
+	File        string
+	KubeVersion string
+	MapFile     string
+	PayloadOnly bool
+}
+
+func newMapPayloadCmd(out io.Writer) *cobra.Command {
+	mapPayloadOptions := MapPayloadOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "map-payload -f FILE [flags]",
+		Short: "Map deprecated or removed Kubernetes APIs in exported release storage objects",
+		Long: "Map deprecated or removed Kubernetes APIs in the release payloads of Helm release Secrets or ConfigMaps " +
+			"exported as YAML or JSON, e.g. with kubectl get secret -o yaml or from a backup, and write the objects " +
+			"with their payloads re-encoded to standard output. Pass '-' as the file to read the objects from standard input. " +
+			"Neither the cluster nor Helm release storage is accessed if --kube-version is set.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("map-payload does not accept arguments, pass the objects with -f"))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			mapPayloadOptions.DryRun = settings.DryRun
+			mapPayloadOptions.MapFile = settings.MapFile
+			kubeConfig := settings.KubeConfig()
+			return MapPayload(cmd.Context(), out, mapPayloadOptions, kubeConfig)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&mapPayloadOptions.File, "filename", "f", "", "file of the exported Secret or ConfigMap, or list of them, or '-' to read from standard input")
+	flags.StringVar(&mapPayloadOptions.KubeVersion, "kube-version", "", "Kubernetes version to map the releases for, e.g. v1.25; that of the cluster if not set")
+	flags.BoolVar(&mapPayloadOptions.PayloadOnly, "payload-only", false, "write the release payloads, gzipped and base64-encoded as stored by Helm, one per line instead of the objects")
+	cmd.MarkFlagRequired("filename")
+
+	return cmd
+}
+
+// MapPayload maps the deprecated or removed APIs in the release payloads of the Secrets or
+// ConfigMaps of a file, or standard input, and writes the objects with the payloads re-encoded.
+// In dry-run mode the objects are written unchanged.
+func MapPayload(ctx context.Context, out io.Writer, mapPayloadOptions MapPayloadOptions, kubeConfig common.KubeConfig) error {
+	kubeVersion, err := targetKubeVersion(ctx, mapPayloadOptions.KubeVersion, kubeConfig)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if mapPayloadOptions.File == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(mapPayloadOptions.File)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to read release storage objects: %s", mapPayloadOptions.File)
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return errors.Wrap(err, "failed to decode release storage objects")
+	}
+	objects := []map[string]interface{}{doc}
+	if kind, _ := doc["kind"].(string); strings.HasSuffix(kind, "List") {
+		items, _ := doc["items"].([]interface{})
+		objects = nil
+		for _, item := range items {
+			if object, ok := item.(map[string]interface{}); ok {
+				objects = append(objects, object)
+			}
+		}
+	}
+
+	provider := mapping.NewProvider(mapPayloadOptions.MapFile)
+	var payloads []string
+	var found int
+	for _, object := range objects {
+		name := storageObjectName(object)
+		payload, err := getStoragePayload(object)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get the release payload of '%s'", name)
+		}
+		mappedPayload, result, err := v3.MapReleasePayload(ctx, payload, provider, kubeVersion, nil)
+		if err != nil {
+			return errors.Wrapf(err, "failed to map the release payload of '%s'", name)
+		}
+		if !result.Mapped || mapPayloadOptions.DryRun {
+			payloads = append(payloads, payload)
+			if result.Mapped {
+				found++
+			}
+			continue
+		}
+		annotations, err := v3.MappingAnnotations(result.Manifest, result.Findings)
+		if err != nil {
+			return err
+		}
+		setStoragePayload(object, mappedPayload, annotations)
+		payloads = append(payloads, mappedPayload)
+	}
+
+	if mapPayloadOptions.PayloadOnly {
+		for _, payload := range payloads {
+			if _, err := fmt.Fprintln(out, strings.TrimSpace(payload)); err != nil {
+				return err
+			}
+		}
+	} else if mapPayloadOptions.DryRun {
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	} else {
+		mapped, err := yaml.Marshal(doc)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode release storage objects")
+		}
+		if _, err := out.Write(mapped); err != nil {
+			return err
+		}
+	}
+	if found > 0 {
+		return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+	}
+	return nil
+}
+
+// storageObjectName returns the kind and name of a release storage object for display
+func storageObjectName(object map[string]interface{}) string {
+	kind, _ := object["kind"].(string)
+	metadata, _ := object["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	return kind + "/" + name
+}
+
+// getStoragePayload returns the release payload of a Secret or ConfigMap storing a release
+// version. The data of a Secret is base64-encoded, over the encoding of the payload.
+func getStoragePayload(object map[string]interface{}) (string, error) {
+	data, _ := object["data"].(map[string]interface{})
+	value, ok := data["release"].(string)
+	if !ok {
+		return "", errors.New("no release payload found in its data")
+	}
+	switch kind, _ := object["kind"].(string); kind {
+	case "Secret":
+		payload, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to decode the Secret data")
+		}
+		return string(payload), nil
+	case "ConfigMap":
+		return value, nil
+	default:
+		return "", errors.Errorf("unsupported kind '%s', must be Secret or ConfigMap", kind)
+	}
+}
+
+// setStoragePayload replaces the release payload of a Secret or ConfigMap storing a release
+// version, and adds the annotations
+func setStoragePayload(object map[string]interface{}, payload string, annotations map[string]string) {
+	data := object["data"].(map[string]interface{})
+	if kind, _ := object["kind"].(string); kind == "Secret" {
+		data["release"] = base64.StdEncoding.EncodeToString([]byte(payload))
+	} else {
+		data["release"] = payload
+	}
+	metadata, ok := object["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		object["metadata"] = metadata
+	}
+	objectAnnotations, ok := metadata["annotations"].(map[string]interface{})
+	if !ok {
+		objectAnnotations = map[string]interface{}{}
+		metadata["annotations"] = objectAnnotations
+	}
+	for key, value := range annotations {
+		objectAnnotations[key] = value
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/pkg/errors"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapkubeapis"
+)
+
+// runMapStorageObject maps the release payload of the storage object in place, see
+// mapkubeapis.Mapper.MapStorageObject. The options which act on the release, rather than on a
+// release version, cannot be used.
+func runMapStorageObject(ctx context.Context, objectName string) error {
+	switch {
+	case len(settings.Contexts) > 0 || settings.AllContexts:
+		return withExitCode(ExitCodeUsage, errors.New("--from-storage-object cannot be used with --contexts or --all-contexts"))
+	case settings.Lock || settings.PreHook != "" || settings.PostHook != "" || settings.PolicyDir != "" || settings.ValidateSchema || settings.ServerDryRun:
+		return withExitCode(ExitCodeUsage, errors.New("--lock, --pre-hook, --post-hook, --policy-dir, --validate-schemas and --server-dry-run cannot be used with --from-storage-object"))
+	case settings.ReportFile != "" || settings.NotifyURL != "" || settings.PSPReportFile != "" || settings.HelmReleasePatch != "":
+		return withExitCode(ExitCodeUsage, errors.New("--report-file, --notify-url, --psp-report and --helmrelease-patch cannot be used with --from-storage-object"))
+	}
+	if settings.DryRun {
+		log.Println("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		log.Println("Run without --dry-run to take the actions described below:")
+		log.Println()
+	}
+
+	kubeConfig := settings.KubeConfig()
+	mapper := mapkubeapis.New(
+		mapkubeapis.WithAllowEmptyRelease(settings.AllowEmptyRelease),
+		mapkubeapis.WithDryRun(settings.DryRun),
+		mapkubeapis.WithKubeConfig(kubeConfig),
+		mapkubeapis.WithMappingProvider(settings.MappingProvider(settings.MapFile, kubeConfig)),
+		mapkubeapis.WithNamespace(settings.Namespace),
+		mapkubeapis.WithReleaseTimeout(settings.ReleaseTimeout),
+		mapkubeapis.WithRequireNewAPI(settings.RequireNewAPI),
+		mapkubeapis.WithStorageDriver(settings.StorageDriver),
+	)
+	result, err := mapper.MapStorageObject(ctx, objectName)
+	if err != nil {
+		return err
+	}
+	if result.Mapped {
+		log.Printf("Map of the release payload of '%s' deprecated or removed APIs to supported versions, completed successfully.\n", objectName)
+	}
+	return mapResultError(result, nil, settings.DryRun)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/health"
+	"github.com/helm/helm-mapkubeapis/pkg/operator"
+)
+
+// OperatorOptions contains the options for Operator operation
+type OperatorOptions struct {
+	HealthAddress           string
+	LeaderElect             bool
+	LeaderElectionNamespace string
+	MapFileReloadInterval   time.Duration
+	OperatorNamespace       string
+	WatchNamespace          string
+	Workers                 int
+}
+
+func newOperatorCmd(out io.Writer) *cobra.Command {
+	var operatorOptions OperatorOptions
+
+	cmd := &cobra.Command{
+		Use:   "operator [flags]",
+		Short: "Run the controller of the MapKubeAPIsJob resources",
+		Long: "Run the controller of the MapKubeAPIsJob custom resources, e.g. in-cluster. " +
+			"Each job checks or maps the releases it selects once, or on its schedule, and records the result in its status. " +
+			"The controller runs until it is interrupted. The MapKubeAPIsJob CustomResourceDefinition must be installed, see config/operator.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("operator does not accept arguments"))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if operatorOptions.Workers < 1 {
+				return withExitCode(ExitCodeUsage, fmt.Errorf("invalid workers %d, must be at least 1", operatorOptions.Workers))
+			}
+			if len(settings.Contexts) > 0 || settings.AllContexts {
+				return withExitCode(ExitCodeUsage, errors.New("operator cannot be used with --contexts or --all-contexts"))
+			}
+			kubeConfig := settings.KubeConfig()
+			elected, err := newElection(operatorOptions.LeaderElect, operatorOptions.LeaderElectionNamespace, operatorLeaseName, kubeConfig)
+			if err != nil {
+				return err
+			}
+			operatorNamespace := operatorOptions.OperatorNamespace
+			if operatorNamespace == "" {
+				if operatorNamespace, _, err = common.RESTClientGetter(kubeConfig).ToRawKubeConfigLoader().Namespace(); err != nil {
+					return errors.Wrap(err, "failed to get the namespace of the operator")
+				}
+			}
+			controller, err := operator.NewController(kubeConfig, operator.Options{
+				Namespace:         operatorOptions.WatchNamespace,
+				OperatorNamespace: operatorNamespace,
+				MapFile:           settings.MapFile,
+				MappingProvider:   settings.ReloadingMappingProvider(cmd.Context(), settings.MapFile, kubeConfig, operatorOptions.MapFileReloadInterval),
+				StorageDriver:     settings.StorageDriver,
+			})
+			if err != nil {
+				return err
+			}
+			probes := health.New()
+			probes.AddReadinessCheck("controller", func(ctx context.Context) error {
+				// The replicas waiting for the leadership are ready to take over
+				if elected != nil && !elected.IsLeader() {
+					return nil
+				}
+				return controller.Ready(ctx)
+			})
+			stopHealth, err := serveHealth(cmd.Context(), operatorOptions.HealthAddress, probes)
+			if err != nil {
+				return err
+			}
+			defer stopHealth()
+			return runElected(cmd.Context(), elected, func(ctx context.Context) error {
+				return controller.Run(ctx, operatorOptions.Workers)
+			})
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&operatorOptions.HealthAddress, "health-address", defaultHealthAddress, "address to serve the /healthz and /readyz probes at; not served if empty")
+	flags.BoolVar(&operatorOptions.LeaderElect, "leader-elect", false, "elect a leader among the replicas with a Lease, so that only the leader runs the jobs")
+	flags.StringVar(&operatorOptions.LeaderElectionNamespace, "leader-election-namespace", "", "namespace of the Lease of the leader election; that of the kubeconfig context, or of the pod, if not set")
+	flags.DurationVar(&operatorOptions.MapFileReloadInterval, "mapfile-reload-interval", defaultMapFileReloadInterval, "interval at which the mapping file is checked for changes and reloaded; not reloaded if 0")
+	flags.StringVar(&operatorOptions.OperatorNamespace, "operator-namespace", "", "namespace whose jobs can select the releases of other namespaces; that of the kubeconfig context, or of the pod, if not set")
+	flags.StringVar(&operatorOptions.WatchNamespace, "watch-namespace", "", "namespace of the MapKubeAPIsJob resources run, all namespaces if not set")
+	flags.IntVar(&operatorOptions.Workers, "workers", 1, "number of jobs run concurrently")
+
+	return cmd
+}
@@ -0,0 +1,171 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/report"
+)
+
+// ReportOptions contains the options for Report operation
+type ReportOptions struct {
+	AllNamespaces bool
+	Format        string
+	MapFile       string
+	Namespace     string
+	OutputFile    string
+	StorageDriver string
+}
+
+const reportTitle = "Kubernetes API upgrade readiness report"
+
+func newReportCmd(out io.Writer) *cobra.Command {
+	reportOptions := ReportOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "report [flags]",
+		Short: "Generate an upgrade readiness report of releases",
+		Long: "Generate an upgrade readiness report summarizing per namespace and per release the deprecated or removed " +
+			"Kubernetes APIs, the replacements pending and the releases requiring manual action. Release storage is not modified.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("report does not accept release names"))
+			}
+			return report.ValidateFormat(reportOptions.Format)
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reportOptions.MapFile = settings.MapFile
+			reportOptions.Namespace = settings.Namespace
+			reportOptions.StorageDriver = settings.StorageDriver
+			contexts, err := settings.KubeContexts()
+			if err != nil {
+				return err
+			}
+			if len(contexts) > 0 {
+				return ReportClusters(cmd.Context(), out, reportOptions, contexts)
+			}
+			kubeConfig := settings.KubeConfig()
+			return Report(cmd.Context(), out, reportOptions, kubeConfig)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVarP(&reportOptions.AllNamespaces, "all-namespaces", "A", false, "report on releases across all namespaces")
+	flags.StringVar(&reportOptions.Format, "format", report.FormatMarkdown, "format of the report, one of: markdown, html")
+	flags.StringVarP(&reportOptions.OutputFile, "output", "o", "", "file to write the report to instead of standard output")
+
+	return cmd
+}
+
+// Report evaluates the latest version of each release against the map file and writes an
+// upgrade readiness report of the findings
+func Report(ctx context.Context, out io.Writer, reportOptions ReportOptions, kubeConfig common.KubeConfig) error {
+	releases, err := listReleases(ctx, reportOptions.Namespace, reportOptions.AllNamespaces, reportOptions.StorageDriver, kubeConfig)
+	if err != nil {
+		return err
+	}
+	results, err := checkReleases(ctx, releases, settings.MappingProvider(reportOptions.MapFile, kubeConfig), kubeConfig)
+	if err != nil {
+		return err
+	}
+	rpt := &report.Report{
+		Title:       reportTitle,
+		GeneratedAt: time.Now(),
+		Releases:    results,
+	}
+	return writeReport(out, rpt, reportOptions.Format, reportOptions.OutputFile)
+}
+
+// ReportClusters evaluates the latest version of each release of the clusters of the kubeconfig
+// contexts against the map file, with up to --context-concurrency clusters at a time, and
+// writes an upgrade readiness report of the findings with a section per cluster
+func ReportClusters(ctx context.Context, out io.Writer, reportOptions ReportOptions, contexts []string) error {
+	clusters := make([]report.Cluster, len(contexts))
+	results := make([][]report.Release, len(contexts))
+	err := forEach(ctx, settings.ContextConcurrency, len(contexts), func(i int) {
+		clusters[i].Name = contexts[i]
+		log.Printf("Check the releases of the cluster of context '%s'.\n", contexts[i])
+		kubeConfig := settings.KubeConfig()
+		kubeConfig.Context = contexts[i]
+		releases, err := listReleases(ctx, reportOptions.Namespace, reportOptions.AllNamespaces, reportOptions.StorageDriver, kubeConfig)
+		if err == nil {
+			results[i], err = checkReleases(ctx, releases, settings.MappingProvider(reportOptions.MapFile, kubeConfig), kubeConfig)
+		}
+		if err != nil {
+			log.Printf("Failed to check the releases of the cluster of context '%s': %s\n", contexts[i], err)
+			clusters[i].Error = err.Error()
+			return
+		}
+		for j := range results[i] {
+			results[i][j].Context = contexts[i]
+		}
+	})
+	if err != nil {
+		return errors.Wrap(err, "report interrupted")
+	}
+
+	rpt := &report.Report{
+		Title:       reportTitle,
+		GeneratedAt: time.Now(),
+		Clusters:    clusters,
+	}
+	var failed int
+	for i := range contexts {
+		if clusters[i].Error != "" {
+			failed++
+		}
+		rpt.Releases = append(rpt.Releases, results[i]...)
+	}
+	if err := writeReport(out, rpt, reportOptions.Format, reportOptions.OutputFile); err != nil {
+		return err
+	}
+	switch {
+	case failed == len(contexts):
+		return errors.Errorf("failed to check the releases of all %d clusters", failed)
+	case failed > 0:
+		return withExitCode(ExitCodePartialFailure, errors.Errorf("failed to check the releases of %d of %d clusters", failed, len(contexts)))
+	}
+	return nil
+}
+
+// writeReport writes the report to the output file, or to out if no file is set
+func writeReport(out io.Writer, rpt *report.Report, format, outputFile string) error {
+	if outputFile == "" {
+		return rpt.Write(out, format)
+	}
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to create report file")
+	}
+	defer f.Close()
+	if err := rpt.Write(f, format); err != nil {
+		return errors.Wrap(err, "failed to write report")
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	"github.com/helm/helm-mapkubeapis/pkg/report"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+// ScanOptions contains the options for Scan operation
+type ScanOptions struct {
+	AllNamespaces bool
+	MapFile       string
+	Namespace     string
+	StorageDriver string
+	UseMetrics    bool
+}
+
+func newScanCmd(out io.Writer) *cobra.Command {
+	var allNamespaces, useMetrics bool
+
+	cmd := &cobra.Command{
+		Use:   "scan [flags]",
+		Short: "List releases which contain deprecated or removed Kubernetes APIs",
+		Long: "List releases which contain deprecated or removed Kubernetes APIs. " +
+			"The latest version of each release is evaluated against the map file, release storage is not modified.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("scan does not accept release names"))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scanOptions := ScanOptions{
+				AllNamespaces: allNamespaces,
+				MapFile:       settings.MapFile,
+				Namespace:     settings.Namespace,
+				StorageDriver: settings.StorageDriver,
+				UseMetrics:    useMetrics,
+			}
+			return runClusters(cmd.Context(), out, func(ctx context.Context, out io.Writer, kubeConfig common.KubeConfig) error {
+				return Scan(ctx, out, scanOptions, kubeConfig)
+			})
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVarP(&allNamespaces, "all-namespaces", "A", false, "scan releases across all namespaces")
+	flags.BoolVar(&useMetrics, "use-metrics", false, "flag and list first the releases whose deprecated APIs are being requested according to the API server metrics")
+
+	return cmd
+}
+
+// Scan evaluates the latest version of each release against the map file and prints the
+// releases which contain deprecated or removed APIs
+func Scan(ctx context.Context, out io.Writer, scanOptions ScanOptions, kubeConfig common.KubeConfig) error {
+	releases, err := listReleases(ctx, scanOptions.Namespace, scanOptions.AllNamespaces, scanOptions.StorageDriver, kubeConfig)
+	if err != nil {
+		return err
+	}
+	results, err := checkReleases(ctx, releases, settings.MappingProvider(scanOptions.MapFile, kubeConfig), kubeConfig)
+	if err != nil {
+		return err
+	}
+	if scanOptions.UseMetrics {
+		prioritizeRequestedReleases(results, kubeConfig)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	header := "NAMESPACE\tNAME\tREVISION\tDEPRECATED API\tNEW API\tCOUNT"
+	if scanOptions.UseMetrics {
+		header += "\tREQUESTED"
+	}
+	fmt.Fprintln(w, header)
+	for _, result := range results {
+		for _, api := range result.MappedAPIs {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%d", result.Namespace, result.Name, result.Revision,
+				common.FlattenAPI(api.DeprecatedAPI), common.FlattenAPI(api.NewAPI), api.Count)
+			if scanOptions.UseMetrics {
+				fmt.Fprintf(w, "\t%t", api.Requested)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	w.Flush()
+
+	summary := &report.Report{Releases: results}
+	fmt.Fprintf(out, "\n%d of %d releases contain deprecated or removed APIs.\n", summary.Count(report.StatusPending), len(results))
+
+	if failed := summary.Count(report.StatusFailed); failed > 0 {
+		return withExitCode(ExitCodePartialFailure, errors.Errorf("failed to check %d of %d releases", failed, len(results)))
+	}
+	return nil
+}
+
+// listReleases returns the latest version of the releases in the namespace, or in all
+// namespaces, and fails if there are none, as the releases may be stored with another
+// storage driver than the one used
+func listReleases(ctx context.Context, namespace string, allNamespaces bool, storageDriver string, kubeConfig common.KubeConfig) ([]*release.Release, error) {
+	releases, err := v3.ListReleases(ctx, namespace, allNamespaces, storageDriver, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(releases) == 0 {
+		scope := "the namespace"
+		if allNamespaces {
+			scope = "any namespace"
+		}
+		return nil, errors.Errorf("no releases found in %s with the '%s' storage driver, set --storage if they are stored with another driver",
+			scope, v3.StorageDriver(storageDriver))
+	}
+	return releases, nil
+}
+
+// prioritizeRequestedReleases flags the deprecated APIs found in the releases which are being
+// requested from the API server, and sorts the releases with requested APIs first
+func prioritizeRequestedReleases(results []report.Release, kubeConfig common.KubeConfig) {
+	requested, err := common.GetRequestedDeprecatedAPIs(kubeConfig)
+	if err != nil {
+		log.Printf("Warning: %s\n", err)
+		return
+	}
+	isRequested := func(result report.Release) bool {
+		for _, api := range result.MappedAPIs {
+			if api.Requested {
+				return true
+			}
+		}
+		return false
+	}
+	for i := range results {
+		for j := range results[i].MappedAPIs {
+			results[i].MappedAPIs[j].Requested = requested.IsRequested(results[i].MappedAPIs[j].DeprecatedAPI)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return isRequested(results[i]) && !isRequested(results[j])
+	})
+}
+
+// checkReleases evaluates the releases against the mappings of the provider without modifying
+// release storage, with up to --concurrency releases evaluated at a time. The results are in the
+// order of the releases. The mappings, the Kubernetes server version and the served APIs are
+// looked up once for all the releases, which all fail if the lookup fails. It fails if the
+// context is canceled.
+func checkReleases(ctx context.Context, releases []*release.Release, provider mapping.MappingProvider, kubeConfig common.KubeConfig) ([]report.Release, error) {
+	results := make([]report.Release, len(releases))
+	var clusterMapper *common.ClusterMapper
+	var lookupErr error
+	if len(releases) > 0 {
+		clusterMapper, lookupErr = common.NewClusterMapper(ctx, provider, kubeConfig)
+	}
+	err := forEach(ctx, settings.Concurrency, len(releases), func(i int) {
+		rel := releases[i]
+		result := report.Release{
+			Name:      rel.Name,
+			Namespace: rel.Namespace,
+			Revision:  rel.Version,
+			Status:    report.StatusClean,
+		}
+		logger := common.PrefixLogger(nil, releasePrefix(rel.Namespace, rel.Name))
+		logger.Printf("Check release '%s' in namespace '%s' for deprecated or removed APIs...\n", rel.Name, rel.Namespace)
+		var manifestResult *common.ManifestResult
+		err := lookupErr
+		if err == nil {
+			manifestResult, err = clusterMapper.MapManifest(ctx, rel.Manifest, false, logger)
+		}
+		switch {
+		case err != nil:
+			logger.Printf("Failed to check release '%s' in namespace '%s': %s\n", rel.Name, rel.Namespace, err)
+			result.Status = report.StatusFailed
+			result.Error = err.Error()
+		case len(manifestResult.MappedAPIs) > 0:
+			result.Status = report.StatusPending
+			result.MappedAPIs = manifestResult.MappedAPIs
+		}
+		results[i] = result
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "check of releases interrupted")
+	}
+	return results, nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/helm/helm-mapkubeapis/pkg/chartrepo"
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// ScanRepoOptions contains the options for ScanRepo operation
+type ScanRepoOptions struct {
+	Filter      chartrepo.Filter
+	KubeVersion string
+	MapFile     string
+	Output      string
+	Password    string
+	RepoURL     string
+	Username    string
+}
+
+func newScanRepoCmd(out io.Writer) *cobra.Command {
+	scanRepoOptions := ScanRepoOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "scan-repo [flags] REPO_URL",
+		Short: "Scan the charts of a chart repository for deprecated or removed Kubernetes APIs",
+		Long: "Download the charts of a chart repository, optionally filtered by name and version, and report the " +
+			"deprecated or removed Kubernetes APIs in their templates. The repository URL may be that of its index.yaml. " +
+			"Exits with code 2 if deprecated or removed APIs are found in any of the charts.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return withExitCode(ExitCodeUsage, errors.New("the chart repository URL must be passed"))
+			}
+			if scanRepoOptions.Output != outputTable && scanRepoOptions.Output != outputYAML {
+				return withExitCode(ExitCodeUsage, errors.Errorf("unsupported output format '%s', must be one of: %s, %s", scanRepoOptions.Output, outputTable, outputYAML))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scanRepoOptions.RepoURL = args[0]
+			scanRepoOptions.MapFile = settings.MapFile
+			return ScanRepo(cmd.Context(), out, scanRepoOptions, settings.KubeConfig())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringArrayVar(&scanRepoOptions.Filter.Charts, "chart", nil, "name of a chart to scan, all the charts if not set; can be repeated")
+	flags.StringVar(&scanRepoOptions.Filter.Version, "version", "", "semantic version constraint of the chart versions to scan, e.g. '>=1.2.0'")
+	flags.BoolVar(&scanRepoOptions.Filter.Latest, "latest", false, "scan only the latest version of each chart")
+	flags.StringVar(&scanRepoOptions.KubeVersion, "kube-version", "", "Kubernetes version to scan the charts for, e.g. v1.25; that of the cluster if not set")
+	flags.StringVar(&scanRepoOptions.Username, "username", "", "chart repository username")
+	flags.StringVar(&scanRepoOptions.Password, "password", "", "chart repository password")
+	flags.StringVarP(&scanRepoOptions.Output, "output", "o", outputTable, "output format, one of: table, yaml")
+
+	return cmd
+}
+
+// ScanRepo scans the chart versions of a chart repository selected by the filter, and
+// writes a report of the deprecated or removed APIs of their templates
+func ScanRepo(ctx context.Context, out io.Writer, scanRepoOptions ScanRepoOptions, kubeConfig common.KubeConfig) error {
+	kubeVersion, err := targetKubeVersion(ctx, scanRepoOptions.KubeVersion, kubeConfig)
+	if err != nil {
+		return err
+	}
+	chartRepo := chartrepo.New(scanRepoOptions.RepoURL, scanRepoOptions.Username, scanRepoOptions.Password)
+	charts, err := chartRepo.ChartVersions(scanRepoOptions.Filter)
+	if err != nil {
+		return err
+	}
+	provider := settings.MappingProvider(scanRepoOptions.MapFile, kubeConfig)
+	if _, err := provider.Mappings(ctx); err != nil {
+		return err
+	}
+
+	reports := make([]chartrepo.ChartReport, len(charts))
+	ctxErr := forEach(ctx, settings.Concurrency, len(charts), func(i int) {
+		reports[i] = chartRepo.Scan(ctx, charts[i], provider, kubeVersion, nil)
+	})
+	if ctxErr != nil {
+		return errors.Wrap(ctxErr, "scan interrupted")
+	}
+
+	var found, failed int
+	for _, report := range reports {
+		if report.Error != "" {
+			log.Printf("Failed to scan chart %s-%s: %s\n", report.Name, report.Version, report.Error)
+			failed++
+		} else if report.Pending() > 0 {
+			found++
+		}
+	}
+
+	if scanRepoOptions.Output == outputYAML {
+		b, err := yaml.Marshal(reports)
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+	} else {
+		writeRepoReport(out, reports)
+		fmt.Fprintf(out, "\n%d of %d chart versions contain deprecated or removed APIs for Kubernetes %s.\n", found, len(reports), kubeVersion)
+	}
+
+	switch {
+	case failed > 0 && failed == len(reports):
+		return errors.Errorf("failed to scan all %d chart versions", failed)
+	case failed > 0:
+		return withExitCode(ExitCodePartialFailure, errors.Errorf("failed to scan %d of %d chart versions", failed, len(reports)))
+	case found > 0:
+		return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+	}
+	return nil
+}
+
+// writeRepoReport writes the findings of the chart versions which require a change of their
+// templates as a table
+func writeRepoReport(out io.Writer, reports []chartrepo.ChartReport) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHART\tVERSION\tTEMPLATE\tDEPRECATED API\tNEW API\tACTION")
+	for _, report := range reports {
+		for _, finding := range report.Findings {
+			if finding.Action != common.ActionMapped && len(finding.Warnings) == 0 {
+				continue
+			}
+			newAPIVersion := "none"
+			if gvk, err := mapping.ParseAPI(finding.NewAPI); err == nil && finding.NewAPI != "" {
+				newAPIVersion = gvk.GroupVersion().String()
+			}
+			action := "replace apiVersion"
+			if finding.Action != common.ActionMapped {
+				action = "manual"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s:%d\t%s\t%s\t%s\n", report.Name, report.Version, finding.File, finding.Line,
+				resourceName(finding.Resource), newAPIVersion, action)
+		}
+	}
+	w.Flush()
+}
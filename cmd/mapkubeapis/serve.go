@@ -0,0 +1,146 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/health"
+	"github.com/helm/helm-mapkubeapis/pkg/server"
+)
+
+// ServeOptions contains the options for Serve operation
+type ServeOptions struct {
+	Address               string
+	HealthAddress         string
+	InsecureNoAuth        bool
+	MapFileReloadInterval time.Duration
+	TLSCertFile           string
+	TLSKeyFile            string
+	TokenFile             string
+}
+
+func newServeCmd(out io.Writer) *cobra.Command {
+	var serveOptions ServeOptions
+
+	cmd := &cobra.Command{
+		Use:   "serve [flags]",
+		Short: "Serve an HTTP API checking and mapping releases or manifests",
+		Long: "Serve an HTTP API checking and mapping releases or manifests on request: POST " + server.CheckPath + " checks " +
+			"the releases selected, or a manifest, for deprecated or removed Kubernetes APIs without modifying release storage, " +
+			"and POST " + server.MapPath + " maps them. The server runs until it is interrupted. The requests must be authenticated " +
+			"with the bearer token of --token-file, unless --insecure-no-auth is set. Set --tls-cert-file and --tls-key-file to serve HTTPS.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("serve does not accept arguments"))
+			}
+			if len(settings.Contexts) > 0 || settings.AllContexts {
+				return withExitCode(ExitCodeUsage, errors.New("serve cannot be used with --contexts or --all-contexts"))
+			}
+			if (serveOptions.TLSCertFile == "") != (serveOptions.TLSKeyFile == "") {
+				return withExitCode(ExitCodeUsage, errors.New("--tls-cert-file and --tls-key-file must be set together"))
+			}
+			if serveOptions.TokenFile == "" && !serveOptions.InsecureNoAuth {
+				return withExitCode(ExitCodeUsage, errors.New("--token-file is required to authenticate the requests, set --insecure-no-auth to serve the API without authentication"))
+			}
+			if serveOptions.TokenFile != "" && serveOptions.InsecureNoAuth {
+				return withExitCode(ExitCodeUsage, errors.New("--token-file cannot be used with --insecure-no-auth"))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Serve(cmd.Context(), serveOptions, settings.KubeConfig())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&serveOptions.Address, "address", ":8080", "address to serve the API at")
+	flags.StringVar(&serveOptions.HealthAddress, "health-address", defaultHealthAddress, "address to serve the /healthz and /readyz probes at; not served if empty")
+	flags.BoolVar(&serveOptions.InsecureNoAuth, "insecure-no-auth", false, "serve the API without authentication, so that any client which can reach it can map the releases")
+	flags.DurationVar(&serveOptions.MapFileReloadInterval, "mapfile-reload-interval", defaultMapFileReloadInterval, "interval at which the mapping file is checked for changes and reloaded; not reloaded if 0")
+	flags.StringVar(&serveOptions.TLSCertFile, "tls-cert-file", "", "file of the TLS certificate served, HTTP is served if not set")
+	flags.StringVar(&serveOptions.TLSKeyFile, "tls-key-file", "", "file of the private key of the TLS certificate")
+	flags.StringVar(&serveOptions.TokenFile, "token-file", "", "file of the bearer token the requests must be authenticated with; required unless --insecure-no-auth is set")
+
+	return cmd
+}
+
+// Serve serves the HTTP API until the context is done
+func Serve(ctx context.Context, serveOptions ServeOptions, kubeConfig common.KubeConfig) error {
+	var token string
+	if serveOptions.TokenFile != "" {
+		data, err := os.ReadFile(serveOptions.TokenFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read token file")
+		}
+		if token = strings.TrimSpace(string(data)); token == "" {
+			return errors.Errorf("token file '%s' is empty", serveOptions.TokenFile)
+		}
+	}
+	provider := settings.ReloadingMappingProvider(ctx, settings.MapFile, kubeConfig, serveOptions.MapFileReloadInterval)
+	probes := health.New()
+	probes.AddReadinessCheck("mappings", mappingsCheck(provider))
+	stopHealth, err := serveHealth(ctx, serveOptions.HealthAddress, probes)
+	if err != nil {
+		return err
+	}
+	defer stopHealth()
+	handler := server.New(server.Options{
+		KubeConfig:      kubeConfig,
+		MappingProvider: provider,
+		StorageDriver:   settings.StorageDriver,
+		ReleaseTimeout:  settings.ReleaseTimeout,
+		Token:           token,
+	})
+	httpServer := &http.Server{
+		Addr:              serveOptions.Address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if serveOptions.TLSCertFile != "" {
+			errs <- httpServer.ListenAndServeTLS(serveOptions.TLSCertFile, serveOptions.TLSKeyFile)
+		} else {
+			errs <- httpServer.ListenAndServe()
+		}
+	}()
+	log.Printf("Serving the API at %s.\n", serveOptions.Address)
+	select {
+	case err := <-errs:
+		return errors.Wrap(err, "failed to serve the API")
+	case <-ctx.Done():
+	}
+	// The requests being served complete, e.g. the update of the release being mapped
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return httpServer.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,295 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"sigs.k8s.io/yaml"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/policy"
+)
+
+// SimulateOptions contains the options for Simulate operation
+type SimulateOptions struct {
+	AllowEmptyRelease bool
+	DryRun            bool
+	Files             []string
+	KubeVersion       string
+	MapFile           string
+	Output            string
+	PolicyAction      string
+	PolicyDir         string
+	PostHook          string
+	PreHook           string
+	ReleaseNames      []string
+	ReleaseNamespace  string
+	SchemaLocation    string
+	ValidateSchema    bool
+}
+
+func newSimulateCmd(out io.Writer) *cobra.Command {
+	simulateOptions := SimulateOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "simulate -f FILE --kube-version VERSION [flags] [RELEASE...]",
+		Short: "Map releases loaded from fixture files, without a cluster",
+		Long: "Load releases from fixture files into Helm's in-memory release storage and map the latest version of " +
+			"each release, or of the releases passed, for the Kubernetes version, through the same pipeline as the " +
+			"mapping of releases stored in a cluster: validation, policies and hooks included. A fixture file holds " +
+			"a release record, or a list of them, in JSON or YAML, as written by Helm release storage. " +
+			"Neither a cluster nor Helm release storage is accessed.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(settings.Contexts) > 0 || settings.AllContexts || settings.CRDMappings || settings.NotifyURL != "" {
+				return withExitCode(ExitCodeUsage, errors.New("--contexts, --all-contexts, --crd-mappings and --notify-url cannot be used with simulate, no cluster is accessed"))
+			}
+			simulateOptions.DryRun = settings.DryRun
+			simulateOptions.MapFile = settings.MapFile
+			simulateOptions.ReleaseNames = args
+			simulateOptions.ReleaseNamespace = settings.Namespace
+			simulateOptions.SchemaLocation = settings.SchemaLocation
+			simulateOptions.ValidateSchema = settings.ValidateSchema
+			return Simulate(cmd.Context(), out, simulateOptions, settings.KubeConfig())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringArrayVarP(&simulateOptions.Files, "filename", "f", nil, "fixture file of release records, can be repeated")
+	flags.StringVar(&simulateOptions.KubeVersion, "kube-version", "", "Kubernetes version to map the releases for, e.g. v1.25")
+	flags.StringVar(&simulateOptions.Output, "output", "", "file to write all the release records to once mapped, as a JSON list")
+	flags.BoolVar(&simulateOptions.AllowEmptyRelease, "allow-empty-release", false, "map the releases even if all their resources are removed, as their APIs have no replacement")
+	flags.StringVar(&simulateOptions.PreHook, "pre-hook", "", "command run before a release is updated, with the change summary on stdin; a non-zero exit aborts the update")
+	flags.StringVar(&simulateOptions.PostHook, "post-hook", "", "command run after a release is updated, with the change summary on stdin")
+	flags.StringVar(&simulateOptions.PolicyDir, "policy-dir", "", "directory of Rego policies a release with its APIs mapped is evaluated against before it is updated")
+	flags.StringVar(&simulateOptions.PolicyAction, "policy-action", policy.ActionAbort, "action if a policy denies the change, one of: abort, dry-run")
+	cmd.MarkFlagRequired("filename")
+	cmd.MarkFlagRequired("kube-version")
+
+	return cmd
+}
+
+// Simulate loads the release records of the fixture files into in-memory release storage, and
+// maps the latest version of each release as Map does for releases stored in a cluster. The
+// releases are mapped for the Kubernetes version of the options, without cluster access.
+func Simulate(ctx context.Context, out io.Writer, simulateOptions SimulateOptions, kubeConfig common.KubeConfig) error {
+	if err := policy.ValidateAction(simulateOptions.PolicyAction); err != nil {
+		return withExitCode(ExitCodeUsage, err)
+	}
+	kubeVersion, err := targetKubeVersion(ctx, simulateOptions.KubeVersion, kubeConfig)
+	if err != nil {
+		return err
+	}
+	kubeConfig.KubeVersion = kubeVersion
+
+	releases, err := loadFixtures(simulateOptions.Files)
+	if err != nil {
+		return err
+	}
+	memory, err := newMemoryStorage(releases)
+	if err != nil {
+		return err
+	}
+	toMap := latestReleases(releases, simulateOptions.ReleaseNamespace, simulateOptions.ReleaseNames)
+	if len(toMap) == 0 {
+		return errors.New("no release to simulate found in the fixture files")
+	}
+
+	var found, failed int
+	var lastErr error
+	for _, rel := range toMap {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrap(err, "simulation interrupted")
+		}
+		memory.SetNamespace(rel.Namespace)
+		mapOptions := MapOptions{
+			AllowEmptyRelease: simulateOptions.AllowEmptyRelease,
+			DryRun:            simulateOptions.DryRun,
+			MapFile:           simulateOptions.MapFile,
+			PolicyAction:      simulateOptions.PolicyAction,
+			PolicyDir:         simulateOptions.PolicyDir,
+			PostHook:          simulateOptions.PostHook,
+			PreHook:           simulateOptions.PreHook,
+			ReleaseName:       rel.Name,
+			ReleaseNamespace:  rel.Namespace,
+			SchemaLocation:    simulateOptions.SchemaLocation,
+			StorageDriver:     "memory",
+			ValidateSchema:    simulateOptions.ValidateSchema,
+			Storage:           storage.Init(memory),
+		}
+		result, err := Map(ctx, mapOptions, kubeConfig)
+		switch {
+		case err != nil:
+			log.Printf("Failed to map release '%s': %s\n", releaseID(rel.Namespace, rel.Name), err)
+			fmt.Fprintf(out, "%s: mapping failed\n", releaseID(rel.Namespace, rel.Name))
+			failed++
+			lastErr = err
+		case result.Mapped:
+			fmt.Fprintf(out, "%s: deprecated or removed APIs mapped, revision %d\n", releaseID(rel.Namespace, rel.Name), result.Revision)
+		case len(result.MappedAPIs) > 0:
+			fmt.Fprintf(out, "%s: deprecated or removed APIs found, not mapped\n", releaseID(rel.Namespace, rel.Name))
+			found++
+		default:
+			fmt.Fprintf(out, "%s: no deprecated or removed APIs\n", releaseID(rel.Namespace, rel.Name))
+		}
+	}
+
+	if simulateOptions.Output != "" {
+		var buf bytes.Buffer
+		if err := writeReleaseRecords(&buf, memory); err != nil {
+			return err
+		}
+		if err := os.WriteFile(simulateOptions.Output, buf.Bytes(), 0644); err != nil {
+			return errors.Wrapf(err, "failed to write the simulated releases: %s", simulateOptions.Output)
+		}
+	}
+
+	switch {
+	case failed == len(toMap):
+		return lastErr
+	case failed > 0:
+		return withExitCode(ExitCodePartialFailure, errors.Errorf("failed to map %d of %d releases", failed, len(toMap)))
+	case found > 0 && !simulateOptions.DryRun:
+		return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+	}
+	return nil
+}
+
+// loadFixtures returns the release records of the fixture files. A file holds a release record
+// or a list of them, in JSON or YAML. The namespace of the releases without one is "default".
+func loadFixtures(files []string) ([]*release.Release, error) {
+	var releases []*release.Release
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read fixture file: %s", file)
+		}
+		data, err = yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode fixture file: %s", file)
+		}
+		var fileReleases []*release.Release
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			err = json.Unmarshal(data, &fileReleases)
+		} else {
+			var rel release.Release
+			err = json.Unmarshal(data, &rel)
+			fileReleases = append(fileReleases, &rel)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode fixture file: %s", file)
+		}
+		for _, rel := range fileReleases {
+			if rel == nil || rel.Name == "" || rel.Version < 1 || rel.Info == nil {
+				return nil, errors.Errorf("invalid release record in fixture file %s, its name, version and info must be set", file)
+			}
+			if rel.Namespace == "" {
+				rel.Namespace = "default"
+			}
+			releases = append(releases, rel)
+		}
+	}
+	return releases, nil
+}
+
+// latestReleases returns the latest version of each release of the namespace, or all namespaces
+// if empty, and of the names if any, sorted by namespace and name
+func latestReleases(releases []*release.Release, namespace string, names []string) []*release.Release {
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+	latest := map[string]*release.Release{}
+	for _, rel := range releases {
+		if namespace != "" && rel.Namespace != namespace {
+			continue
+		}
+		if len(names) > 0 && !selected[rel.Name] {
+			continue
+		}
+		id := releaseID(rel.Namespace, rel.Name)
+		if current, ok := latest[id]; !ok || rel.Version > current.Version {
+			latest[id] = rel
+		}
+	}
+	var sorted []*release.Release
+	for _, rel := range latest {
+		sorted = append(sorted, rel)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return releaseID(sorted[i].Namespace, sorted[i].Name) < releaseID(sorted[j].Namespace, sorted[j].Name)
+	})
+	return sorted
+}
+
+// newMemoryStorage returns Helm's in-memory release storage driver with the release records
+// loaded
+func newMemoryStorage(releases []*release.Release) (*driver.Memory, error) {
+	memory := driver.NewMemory()
+	for _, rel := range releases {
+		if err := memory.Create(fmt.Sprintf("sh.helm.release.v1.%s.v%d", rel.Name, rel.Version), rel); err != nil {
+			return nil, errors.Wrapf(err, "failed to load release version '%s.v%d'", rel.Name, rel.Version)
+		}
+	}
+	return memory, nil
+}
+
+// writeReleaseRecords writes all the release records of the in-memory release storage as a
+// JSON list, sorted by namespace, name and version
+func writeReleaseRecords(w io.Writer, memory *driver.Memory) error {
+	memory.SetNamespace("")
+	releases, err := memory.List(func(*release.Release) bool { return true })
+	if err != nil {
+		return errors.Wrap(err, "failed to list the releases")
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		a, b := releases[i], releases[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	return writeReleaseList(w, releases)
+}
+
+// writeReleaseList writes release records as a JSON list
+func writeReleaseList(w io.Writer, releases []*release.Release) error {
+	data, err := json.MarshalIndent(releases, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the releases")
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
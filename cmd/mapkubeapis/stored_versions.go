@@ -0,0 +1,115 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+// StoredVersionsOptions contains the options for StoredVersions operation
+type StoredVersionsOptions struct {
+	CRDNames []string
+	DryRun   bool
+	Migrate  bool
+}
+
+func newStoredVersionsCmd(out io.Writer) *cobra.Command {
+	var migrate bool
+
+	cmd := &cobra.Command{
+		Use:   "stored-versions [flags] [CRD...]",
+		Short: "Report CRDs whose stored versions include deprecated versions",
+		Long: "Report the CustomResourceDefinitions whose status.storedVersions include versions other than their storage version, " +
+			"e.g. after mapping custom resources to a new version. These versions cannot be removed from the CRDs until their custom resources are migrated. " +
+			"The CRDs passed, or all CRDs of the cluster if none are passed, are checked. " +
+			"With --migrate, the custom resources are rewritten in the storage version and the stored versions are set to the storage version only. " +
+			"Exits with code 2 if stale stored versions are found and not migrated.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			storedVersionsOptions := StoredVersionsOptions{
+				CRDNames: args,
+				DryRun:   settings.DryRun,
+				Migrate:  migrate,
+			}
+			return runClusters(cmd.Context(), out, func(ctx context.Context, out io.Writer, kubeConfig common.KubeConfig) error {
+				return StoredVersions(ctx, out, storedVersionsOptions, kubeConfig)
+			})
+		},
+	}
+
+	cmd.Flags().BoolVar(&migrate, "migrate", false, "rewrite the custom resources in the storage version and remove the other stored versions of the CRDs")
+
+	return cmd
+}
+
+// StoredVersions reports the CRDs whose stored versions include versions other than their
+// storage version, and migrates them if requested
+func StoredVersions(ctx context.Context, out io.Writer, storedVersionsOptions StoredVersionsOptions, kubeConfig common.KubeConfig) error {
+	stale, err := common.FindStaleStoredVersions(ctx, kubeConfig, storedVersionsOptions.CRDNames)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTORAGE VERSION\tSTORED VERSIONS")
+	for _, storedVersions := range stale {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", storedVersions.CRD, storedVersions.StorageVersion, strings.Join(storedVersions.StoredVersions, ","))
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\n%d custom resource definitions have stored versions other than their storage version.\n", len(stale))
+	if len(stale) == 0 {
+		return nil
+	}
+	if !storedVersionsOptions.Migrate {
+		return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+	}
+
+	var failed int
+	for _, storedVersions := range stale {
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), "migration interrupted")
+		}
+		if storedVersionsOptions.DryRun {
+			log.Printf("Custom resources of '%s' would be migrated to storage version %s, and stored versions %s removed.\n",
+				storedVersions.CRD, storedVersions.StorageVersion, strings.Join(storedVersions.Stale(), ","))
+			continue
+		}
+		if err := common.MigrateStoredVersions(ctx, kubeConfig, storedVersions.CRD, nil); err != nil {
+			log.Printf("Failed to migrate the stored versions of '%s': %s\n", storedVersions.CRD, err)
+			failed++
+		}
+	}
+	switch {
+	case failed > 0 && failed == len(stale):
+		return errors.New("failed to migrate any of the custom resource definitions")
+	case failed > 0:
+		return withExitCode(ExitCodePartialFailure, errors.Errorf("failed to migrate %d of %d custom resource definitions", failed, len(stale)))
+	}
+	return nil
+}
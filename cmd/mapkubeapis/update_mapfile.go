@@ -0,0 +1,210 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+const (
+	// defaultMapFileURL is the URL of the mapping file of the latest release of the project
+	defaultMapFileURL = "https://github.com/helm/helm-mapkubeapis/releases/latest/download/Map.yaml"
+
+	// defaultChecksumsURL is the URL of the checksums of the artifacts of the latest release of
+	// the project
+	defaultChecksumsURL = "https://github.com/helm/helm-mapkubeapis/releases/latest/download/checksums.txt"
+)
+
+// UpdateMapFileOptions contains the options for UpdateMapFile operation
+type UpdateMapFileOptions struct {
+	ChecksumURL string
+	DryRun      bool
+	MapFile     string
+	URL         string
+}
+
+func newUpdateMapFileCmd(out io.Writer) *cobra.Command {
+	updateOptions := UpdateMapFileOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "update-mapfile [flags]",
+		Short: "Update the mapping file to the latest published one",
+		Long: "Download the mapping file of the latest release of the plugin, or that of --url, verify it, and install it as the mapping file of --mapfile, " +
+			"by default that of the plugin, so that new Kubernetes deprecations are picked up without reinstalling the plugin. " +
+			"The mapping file downloaded is verified against the checksum of --checksum-url, by default that of the release, and validated before it replaces the installed one.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("update-mapfile does not accept arguments"))
+			}
+			if settings.MapFile == mapping.EmbeddedSource || strings.Contains(settings.MapFile, "://") {
+				return withExitCode(ExitCodeUsage, errors.Errorf("--mapfile must be the path of the mapping file to install, not '%s'", settings.MapFile))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// The checksums of the release only apply to the mapping file of the release
+			if cmd.Flags().Changed("url") && !cmd.Flags().Changed("checksum-url") {
+				updateOptions.ChecksumURL = ""
+			}
+			updateOptions.DryRun = settings.DryRun
+			updateOptions.MapFile = settings.MapFile
+			return UpdateMapFile(cmd.Context(), out, updateOptions)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&updateOptions.ChecksumURL, "checksum-url", defaultChecksumsURL, "URL of the checksums file, in the sha256sum format, the mapping file is verified against; not verified if --url is set without it")
+	flags.StringVar(&updateOptions.URL, "url", defaultMapFileURL, "URL of the mapping file to install")
+
+	return cmd
+}
+
+// UpdateMapFile downloads the mapping file, verifies its checksum and validates it, and replaces
+// the installed mapping file with it unless it is up to date or in dry-run mode
+func UpdateMapFile(ctx context.Context, out io.Writer, updateOptions UpdateMapFileOptions) error {
+	data, err := download(ctx, updateOptions.URL)
+	if err != nil {
+		return errors.Wrap(err, "failed to download mapping file")
+	}
+	if updateOptions.ChecksumURL != "" {
+		if err := verifyChecksum(ctx, data, path.Base(updateOptions.URL), updateOptions.ChecksumURL); err != nil {
+			return err
+		}
+	}
+	mapMetadata, err := mapping.LoadMapdata(data, updateOptions.URL)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load mapping file: %s", updateOptions.URL)
+	}
+	if err := mapMetadata.Validate(); err != nil {
+		return errors.Wrapf(err, "mapping file %s is invalid", updateOptions.URL)
+	}
+
+	installed := "(none)"
+	if current, err := ioutil.ReadFile(updateOptions.MapFile); err == nil {
+		if bytes.Equal(current, data) {
+			fmt.Fprintf(out, "Mapping file %s is up to date, version %s.\n", updateOptions.MapFile, versionOrNone(mapMetadata.Version))
+			return nil
+		}
+		if currentMetadata, err := mapping.LoadMapdata(current, updateOptions.MapFile); err == nil {
+			installed = versionOrNone(currentMetadata.Version)
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to read mapping file: %s", updateOptions.MapFile)
+	}
+	if updateOptions.DryRun {
+		fmt.Fprintf(out, "Mapping file %s would be updated from %s: version %s -> %s, %d mappings.\n",
+			updateOptions.MapFile, updateOptions.URL, installed, versionOrNone(mapMetadata.Version), len(mapMetadata.Mappings))
+		return nil
+	}
+	if err := installMapFile(updateOptions.MapFile, data); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Mapping file %s updated from %s: version %s -> %s, %d mappings.\n",
+		updateOptions.MapFile, updateOptions.URL, installed, versionOrNone(mapMetadata.Version), len(mapMetadata.Mappings))
+	return nil
+}
+
+// download returns the content served at the URL
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("%s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// verifyChecksum verifies the data against the SHA-256 checksum of the file name listed in the
+// checksums file at the URL, in the sha256sum format
+func verifyChecksum(ctx context.Context, data []byte, name, checksumURL string) error {
+	checksums, err := download(ctx, checksumURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to download checksums")
+	}
+	sum := sha256.Sum256(data)
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		if !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+			return errors.Errorf("checksum of mapping file %s does not match that of %s", name, checksumURL)
+		}
+		return nil
+	}
+	return errors.Errorf("failed to find the checksum of mapping file %s in %s", name, checksumURL)
+}
+
+// installMapFile replaces the mapping file with the data, through a temporary file renamed over
+// it, so that a process reading the mapping file never reads a partial one
+func installMapFile(mapFile string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(mapFile), 0755); err != nil {
+		return errors.Wrapf(err, "failed to install mapping file: %s", mapFile)
+	}
+	f, err := os.CreateTemp(filepath.Dir(mapFile), filepath.Base(mapFile)+".*")
+	if err != nil {
+		return errors.Wrapf(err, "failed to install mapping file: %s", mapFile)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), mapFile)
+	}
+	return errors.Wrapf(err, "failed to install mapping file: %s", mapFile)
+}
+
+// versionOrNone returns the version of a mapping file, or (none) if it is not versioned
+func versionOrNone(version string) string {
+	if version == "" {
+		return "(none)"
+	}
+	return version
+}
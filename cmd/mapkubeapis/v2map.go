@@ -0,0 +1,116 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	v2 "github.com/helm/helm-mapkubeapis/pkg/v2"
+)
+
+// V2MapOptions contains the options for V2Map operation
+type V2MapOptions struct {
+	AllowEmptyRelease bool
+	DryRun            bool
+	MapFile           string
+	ReleaseName       string
+	ReleaseStorage    string
+	ReleaseTimeout    time.Duration
+	TillerNamespace   string
+}
+
+func newV2MapCmd(out io.Writer) *cobra.Command {
+	v2MapOptions := V2MapOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "v2map [flags] RELEASE",
+		Short: "Map Helm 2 release deprecated or removed Kubernetes APIs in-place",
+		Long: "Map the deprecated or removed Kubernetes APIs of a Helm 2 release in-place, in the ConfigMaps or Secrets " +
+			"Tiller stores the releases in. The Tiller namespace is that of TILLER_NAMESPACE, or kube-system, if --tiller-ns is not set.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return withExitCode(ExitCodeUsage, errors.New("one release name must be passed"))
+			}
+			return v2.ValidateStorage(v2MapOptions.ReleaseStorage)
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v2MapOptions.AllowEmptyRelease = settings.AllowEmptyRelease
+			v2MapOptions.DryRun = settings.DryRun
+			v2MapOptions.MapFile = settings.MapFile
+			v2MapOptions.ReleaseName = args[0]
+			v2MapOptions.ReleaseTimeout = settings.ReleaseTimeout
+			return runClusters(cmd.Context(), out, func(ctx context.Context, out io.Writer, kubeConfig common.KubeConfig) error {
+				result, err := V2Map(ctx, v2MapOptions, kubeConfig)
+				return mapResultError(result, err, v2MapOptions.DryRun)
+			})
+		},
+	}
+
+	tillerNamespace := os.Getenv("TILLER_NAMESPACE")
+	if tillerNamespace == "" {
+		tillerNamespace = v2.DefaultTillerNamespace
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&v2MapOptions.TillerNamespace, "tiller-ns", tillerNamespace, "namespace of Tiller, whose ConfigMaps or Secrets store the Helm 2 releases")
+	flags.StringVar(&v2MapOptions.ReleaseStorage, "release-storage", v2.StorageConfigMaps, "objects Tiller stores the Helm 2 releases in, one of: configmaps, secrets")
+
+	return cmd
+}
+
+// V2Map checks for Kubernetes deprecated or removed APIs in the manifest of the latest version
+// of the Helm 2 release, and maps those API versions to supported versions. It then adds a new
+// release version with the updated APIs and supersedes the version with the unsupported APIs.
+func V2Map(ctx context.Context, v2MapOptions V2MapOptions, kubeConfig common.KubeConfig) (*common.ReleaseResult, error) {
+	if v2MapOptions.DryRun {
+		log.Println("NOTE: This is in dry-run mode, the following actions will not be executed.")
+		log.Println("Run without --dry-run to take the actions described below:")
+		log.Println()
+	}
+
+	log.Printf("Helm 2 release '%s' will be checked for deprecated or removed Kubernetes APIs and will be updated if necessary to supported API versions.\n", v2MapOptions.ReleaseName)
+
+	mapOptions := common.MapOptions{
+		AllowEmptyRelease: v2MapOptions.AllowEmptyRelease,
+		DryRun:            v2MapOptions.DryRun,
+		KubeConfig:        kubeConfig,
+		MappingProvider:   settings.MappingProvider(v2MapOptions.MapFile, kubeConfig),
+		ReleaseName:       v2MapOptions.ReleaseName,
+		Timeout:           v2MapOptions.ReleaseTimeout,
+	}
+	storageOptions := v2.StorageOptions{
+		TillerNamespace: v2MapOptions.TillerNamespace,
+		Storage:         v2MapOptions.ReleaseStorage,
+	}
+	result, err := v2.MapReleaseWithUnSupportedAPIs(ctx, mapOptions, storageOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Map of Helm 2 release '%s' deprecated or removed APIs to supported versions, completed successfully.\n", v2MapOptions.ReleaseName)
+	return result, nil
+}
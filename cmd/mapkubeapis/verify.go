@@ -0,0 +1,155 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/mod/semver"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+// VerifyOptions contains the options for Verify operation
+type VerifyOptions struct {
+	AllNamespaces bool
+	KubeVersion   string
+	MapFile       string
+	Namespace     string
+	ReleaseNames  []string
+	StorageDriver string
+}
+
+func newVerifyCmd(out io.Writer) *cobra.Command {
+	var allNamespaces bool
+	var against string
+
+	cmd := &cobra.Command{
+		Use:   "verify --against VERSION [flags] [RELEASE...]",
+		Short: "Verify releases are ready for a Kubernetes version",
+		Long: "Verify releases are ready for a Kubernetes version by checking them for APIs which are removed in that version. " +
+			"The releases passed, or all releases of the namespace if none are passed, are evaluated. " +
+			"Exits with code 2 if any of the releases contain APIs removed in the version.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kubeVersion := against
+			if kubeVersion != "" && !strings.HasPrefix(kubeVersion, "v") {
+				kubeVersion = "v" + kubeVersion
+			}
+			if !semver.IsValid(kubeVersion) {
+				return withExitCode(ExitCodeUsage, errors.Errorf("invalid Kubernetes version '%s' passed to --against", against))
+			}
+			verifyOptions := VerifyOptions{
+				AllNamespaces: allNamespaces,
+				KubeVersion:   kubeVersion,
+				MapFile:       settings.MapFile,
+				Namespace:     settings.Namespace,
+				ReleaseNames:  args,
+				StorageDriver: settings.StorageDriver,
+			}
+			return runClusters(cmd.Context(), out, func(ctx context.Context, out io.Writer, kubeConfig common.KubeConfig) error {
+				return Verify(ctx, out, verifyOptions, kubeConfig)
+			})
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&against, "against", "", "Kubernetes version to verify the releases against, e.g. v1.30")
+	flags.BoolVarP(&allNamespaces, "all-namespaces", "A", false, "verify releases across all namespaces when no release is passed")
+	cmd.MarkFlagRequired("against")
+
+	return cmd
+}
+
+// Verify checks the latest version of releases for APIs which are removed in the Kubernetes version
+func Verify(ctx context.Context, out io.Writer, verifyOptions VerifyOptions, kubeConfig common.KubeConfig) error {
+	var releases []*release.Release
+	var failed int
+	if len(verifyOptions.ReleaseNames) == 0 {
+		var err error
+		releases, err = listReleases(ctx, verifyOptions.Namespace, verifyOptions.AllNamespaces, verifyOptions.StorageDriver, kubeConfig)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, releaseName := range verifyOptions.ReleaseNames {
+			rel, err := v3.GetLatestRelease(ctx, releaseName, verifyOptions.Namespace, verifyOptions.StorageDriver, kubeConfig)
+			if err != nil {
+				log.Printf("Failed to get release '%s': %s\n", releaseID(verifyOptions.Namespace, releaseName), err)
+				failed++
+				continue
+			}
+			releases = append(releases, rel)
+		}
+	}
+
+	provider := mapping.NewCachedProvider(mapping.NewProvider(verifyOptions.MapFile))
+	results := make([][]common.MappedAPI, len(releases))
+	errs := make([]error, len(releases))
+	if err := forEach(ctx, settings.Concurrency, len(releases), func(i int) {
+		results[i], errs[i] = common.FindManifestRemovedAPIs(ctx, releases[i].Manifest, provider, verifyOptions.KubeVersion)
+	}); err != nil {
+		return errors.Wrap(err, "verification interrupted")
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tREVISION\tREMOVED API\tREMOVED IN\tNEW API\tCOUNT")
+	var notReady int
+	for i, rel := range releases {
+		removedAPIs, err := results[i], errs[i]
+		if err != nil {
+			log.Printf("Failed to verify release '%s' in namespace '%s': %s\n", rel.Name, rel.Namespace, err)
+			failed++
+			continue
+		}
+		if len(removedAPIs) > 0 {
+			notReady++
+		}
+		for _, api := range removedAPIs {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%d\n", rel.Namespace, rel.Name, rel.Version,
+				common.FlattenAPI(api.DeprecatedAPI), api.RemovedInVersion, common.FlattenAPI(api.NewAPI), api.Count)
+		}
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\n%d of %d releases contain APIs removed in Kubernetes %s.\n", notReady, len(releases), verifyOptions.KubeVersion)
+
+	total := len(releases)
+	if len(verifyOptions.ReleaseNames) > 0 {
+		total = len(verifyOptions.ReleaseNames)
+	}
+	switch {
+	case failed > 0 && failed == total:
+		return errors.New("failed to verify any of the releases")
+	case failed > 0:
+		return withExitCode(ExitCodePartialFailure, errors.Errorf("failed to verify %d of %d releases", failed, total))
+	case notReady > 0:
+		return withExitCode(ExitCodeDeprecatedAPIsFound, nil)
+	}
+	return nil
+}
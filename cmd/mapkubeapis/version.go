@@ -0,0 +1,77 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// version is the version of the plugin, set at build time
+var version = "dev"
+
+func newVersionCmd(out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "version",
+		Short:         "Print the plugin version and the map file provenance",
+		Long:          "Print the plugin version and the version, source and checksum of the map file which is loaded.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("version does not accept arguments"))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Version(out, settings.MapFile)
+		},
+	}
+
+	return cmd
+}
+
+// Version prints the plugin version and the provenance of the map file
+func Version(out io.Writer, mapFile string) error {
+	fmt.Fprintf(out, "Version: %s\n", version)
+
+	provider := mapping.NewProvider(mapFile)
+	mapMetadata, err := provider.Mappings(context.Background())
+	if err != nil {
+		return err
+	}
+	source := mapMetadata.Source
+	if _, ok := provider.(*mapping.FileProvider); ok {
+		if abs, err := filepath.Abs(source); err == nil {
+			source = abs
+		}
+	}
+	fmt.Fprintf(out, "Map file:\n")
+	fmt.Fprintf(out, "  Source: %s\n", source)
+	fmt.Fprintf(out, "  Version: %s\n", valueOrNone(mapMetadata.Version))
+	fmt.Fprintf(out, "  Checksum: %s\n", mapMetadata.Checksum)
+	fmt.Fprintf(out, "  Mappings: %d\n", len(mapMetadata.Mappings))
+	return nil
+}
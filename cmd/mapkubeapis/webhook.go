@@ -0,0 +1,120 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/health"
+	"github.com/helm/helm-mapkubeapis/pkg/webhook"
+)
+
+// WebhookOptions contains the options for Webhook operation
+type WebhookOptions struct {
+	Address               string
+	HealthAddress         string
+	KubeVersion           string
+	MapFileReloadInterval time.Duration
+	TLSCertFile           string
+	TLSKeyFile            string
+}
+
+func newWebhookCmd(out io.Writer) *cobra.Command {
+	var webhookOptions WebhookOptions
+
+	cmd := &cobra.Command{
+		Use:   "webhook --tls-cert-file FILE --tls-key-file FILE [flags]",
+		Short: "Serve a mutating admission webhook mapping the release versions written by Helm",
+		Long: "Serve a mutating admission webhook, at " + webhook.Path + ", which maps the deprecated or removed Kubernetes APIs " +
+			"of the release versions Helm writes to its Secret or ConfigMap storage, so that releases installed or upgraded " +
+			"with deprecated APIs during a migration window are stored with their APIs mapped. The resources applied by Helm " +
+			"are not mutated. The webhook never denies a request, and runs until it is interrupted. See config/webhook for an " +
+			"example MutatingWebhookConfiguration.",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return withExitCode(ExitCodeUsage, errors.New("webhook does not accept arguments"))
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(settings.Contexts) > 0 || settings.AllContexts {
+				return withExitCode(ExitCodeUsage, errors.New("webhook cannot be used with --contexts or --all-contexts"))
+			}
+			return Webhook(cmd.Context(), webhookOptions, settings.KubeConfig())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&webhookOptions.Address, "address", ":8443", "address to serve the webhook at")
+	flags.StringVar(&webhookOptions.HealthAddress, "health-address", defaultHealthAddress, "address to serve the /healthz and /readyz probes at; not served if empty")
+	flags.StringVar(&webhookOptions.KubeVersion, "kube-version", "", "Kubernetes version to map the releases for, e.g. v1.25; that of the cluster if not set")
+	flags.DurationVar(&webhookOptions.MapFileReloadInterval, "mapfile-reload-interval", defaultMapFileReloadInterval, "interval at which the mapping file is checked for changes and reloaded; not reloaded if 0")
+	flags.StringVar(&webhookOptions.TLSCertFile, "tls-cert-file", "", "file of the TLS certificate served")
+	flags.StringVar(&webhookOptions.TLSKeyFile, "tls-key-file", "", "file of the private key of the TLS certificate")
+	cmd.MarkFlagRequired("tls-cert-file")
+	cmd.MarkFlagRequired("tls-key-file")
+
+	return cmd
+}
+
+// Webhook serves the mutating admission webhook until the context is done
+func Webhook(ctx context.Context, webhookOptions WebhookOptions, kubeConfig common.KubeConfig) error {
+	kubeVersion, err := targetKubeVersion(ctx, webhookOptions.KubeVersion, kubeConfig)
+	if err != nil {
+		return err
+	}
+	logger := common.LoggerOrDefault(nil)
+	provider := settings.ReloadingMappingProvider(ctx, settings.MapFile, kubeConfig, webhookOptions.MapFileReloadInterval)
+	probes := health.New()
+	probes.AddReadinessCheck("mappings", mappingsCheck(provider))
+	stopHealth, err := serveHealth(ctx, webhookOptions.HealthAddress, probes)
+	if err != nil {
+		return err
+	}
+	defer stopHealth()
+	mux := http.NewServeMux()
+	mux.Handle(webhook.Path, webhook.NewHandler(provider, kubeVersion, logger))
+	server := &http.Server{
+		Addr:              webhookOptions.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServeTLS(webhookOptions.TLSCertFile, webhookOptions.TLSKeyFile)
+	}()
+	logger.Printf("Serving the webhook at %s, mapping releases for Kubernetes %s.\n", webhookOptions.Address, kubeVersion)
+	select {
+	case err := <-errs:
+		return errors.Wrap(err, "failed to serve the webhook")
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,27 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config contains the default API mapping file, embedded in the binary
+package config
+
+import (
+	_ "embed"
+)
+
+// MapFile is the content of the default API mapping file, Map.yaml
+//
+//go:embed Map.yaml
+var MapFile []byte
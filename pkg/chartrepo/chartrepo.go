@@ -0,0 +1,233 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package chartrepo scans the charts published in Helm chart repositories for deprecated or
+// removed Kubernetes APIs, so that repository owners know which charts break on the next
+// Kubernetes version.
+package chartrepo
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// Repository is a Helm chart repository
+type Repository struct {
+	// URL of the repository, or of its index.yaml
+	URL string
+
+	// Username and Password are the basic authentication credentials of the repository
+	Username string
+	Password string
+
+	getters getter.Providers
+}
+
+// Filter selects the chart versions of a repository to scan
+type Filter struct {
+	// Charts are the names of the charts to scan, all the charts if empty
+	Charts []string
+
+	// Version is a semantic version constraint of the chart versions to scan, e.g. ">=1.2.0",
+	// all the versions if empty
+	Version string
+
+	// Latest selects only the latest version matching the constraint of each chart
+	Latest bool
+}
+
+// ChartVersion is a version of a chart of a repository
+type ChartVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	URL     string `json:"url"`
+}
+
+// ChartReport is the result of scanning a version of a chart
+type ChartReport struct {
+	ChartVersion
+
+	// Findings are the resources of the templates of the chart found using deprecated or
+	// removed APIs
+	Findings []common.ChartFinding `json:"findings,omitempty"`
+
+	// Error is the error scanning the chart, if any
+	Error string `json:"error,omitempty"`
+}
+
+// Pending returns the number of findings requiring a change of the templates
+func (r ChartReport) Pending() int {
+	var count int
+	for _, finding := range r.Findings {
+		if finding.Action == common.ActionMapped || len(finding.Warnings) > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// New returns the repository of the URL, which may be that of its index.yaml
+func New(repoURL, username, password string) *Repository {
+	return &Repository{
+		URL:      strings.TrimSuffix(strings.TrimSuffix(repoURL, "/index.yaml"), "/"),
+		Username: username,
+		Password: password,
+		getters:  getter.All(cli.New()),
+	}
+}
+
+// ChartVersions downloads the index of the repository and returns the chart versions selected
+// by the filter, sorted by name and from the latest version
+func (r *Repository) ChartVersions(filter Filter) ([]ChartVersion, error) {
+	var constraint *semver.Constraints
+	if filter.Version != "" {
+		var err error
+		if constraint, err = semver.NewConstraint(filter.Version); err != nil {
+			return nil, errors.Wrapf(err, "invalid chart version constraint: %s", filter.Version)
+		}
+	}
+	names := map[string]bool{}
+	for _, name := range filter.Charts {
+		names[name] = true
+	}
+
+	index, err := r.index()
+	if err != nil {
+		return nil, err
+	}
+	var versions []ChartVersion
+	for name, entries := range index.Entries {
+		if len(names) > 0 && !names[name] {
+			continue
+		}
+		for _, entry := range entries {
+			if constraint != nil {
+				version, err := semver.NewVersion(entry.Version)
+				if err != nil || !constraint.Check(version) {
+					continue
+				}
+			}
+			if len(entry.URLs) == 0 {
+				continue
+			}
+			chartURL, err := repo.ResolveReferenceURL(r.URL, entry.URLs[0])
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to resolve URL of chart %s-%s", name, entry.Version)
+			}
+			versions = append(versions, ChartVersion{Name: name, Version: entry.Version, URL: chartURL})
+			if filter.Latest {
+				break
+			}
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].Name < versions[j].Name })
+	return versions, nil
+}
+
+// index downloads the index of the repository to a temporary directory and loads it
+func (r *Repository) index() (*repo.IndexFile, error) {
+	cacheDir, err := os.MkdirTemp("", "mapkubeapis-repo-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary repository cache")
+	}
+	defer os.RemoveAll(cacheDir)
+
+	chartRepo, err := repo.NewChartRepository(&repo.Entry{
+		Name:     "mapkubeapis",
+		URL:      r.URL,
+		Username: r.Username,
+		Password: r.Password,
+	}, r.getters)
+	if err != nil {
+		return nil, err
+	}
+	chartRepo.CachePath = cacheDir
+	indexPath, err := chartRepo.DownloadIndexFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download the index of chart repository: %s", r.URL)
+	}
+	index, err := repo.LoadIndexFile(indexPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load the index of chart repository: %s", r.URL)
+	}
+	return index, nil
+}
+
+// Scan downloads the chart version and maps the deprecated or removed APIs of its templates for
+// the Kubernetes version, without writing the mapped chart. Failures are reported in the error
+// of the report, so that a repository scan goes on.
+func (r *Repository) Scan(ctx context.Context, chart ChartVersion, provider mapping.MappingProvider, kubeVersion string, logger common.Logger) ChartReport {
+	report := ChartReport{ChartVersion: chart}
+	archive, err := r.download(chart)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	defer os.RemoveAll(filepath.Dir(archive))
+
+	result, err := common.MapChartArchive(ctx, archive, provider, kubeVersion, common.ChartArchiveOptions{}, true, logger)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Findings = result.Findings
+	return report
+}
+
+// download downloads the archive of the chart version to a temporary directory and returns its
+// path
+func (r *Repository) download(chart ChartVersion) (string, error) {
+	u, err := url.Parse(chart.URL)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid chart URL: %s", chart.URL)
+	}
+	client, err := r.getters.ByScheme(u.Scheme)
+	if err != nil {
+		return "", err
+	}
+	// The credentials of the repository are only passed to the charts it hosts
+	var opts []getter.Option
+	if strings.HasPrefix(chart.URL, r.URL+"/") {
+		opts = append(opts, getter.WithBasicAuth(r.Username, r.Password))
+	}
+	data, err := client.Get(chart.URL, opts...)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to download chart: %s", chart.URL)
+	}
+	dir, err := os.MkdirTemp("", "mapkubeapis-chart-")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create temporary chart directory")
+	}
+	archive := filepath.Join(dir, "chart.tgz")
+	if err := os.WriteFile(archive, data.Bytes(), 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", errors.Wrapf(err, "failed to write chart: %s", chart.URL)
+	}
+	return archive, nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chartrepo
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/registry"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// OCIScheme is the URL scheme of the charts stored in OCI registries
+const OCIScheme = "oci://"
+
+// IsOCIReference returns true if the chart reference is that of a chart stored in an OCI
+// registry, e.g. oci://ghcr.io/org/charts/mychart:1.2.0
+func IsOCIReference(ref string) bool {
+	return strings.HasPrefix(ref, OCIScheme)
+}
+
+// MapOCIChart pulls the chart of the reference from its OCI registry, maps the deprecated or
+// removed APIs of its templates like common.MapChartArchive, and pushes the mapped chart to
+// the same repository with its version as tag. The reference must have the version of the
+// chart as tag. The mapped chart must have a new version, set by the options, so that the
+// published tag is not overwritten. The registry credentials of Helm are used, as set by
+// helm registry login. Nothing is pushed in dry-run mode, or if no template was rewritten.
+func MapOCIChart(ctx context.Context, ref string, provider mapping.MappingProvider, kubeVersion string, opts common.ChartArchiveOptions, dryRun bool, logger common.Logger) (*common.ChartResult, error) {
+	logger = common.LoggerOrDefault(logger)
+	repository, tag := splitOCIReference(strings.TrimPrefix(ref, OCIScheme))
+	if tag == "" {
+		return nil, errors.Errorf("the OCI chart reference must have the chart version as tag: %s", ref)
+	}
+	if opts.Version == "" && !opts.BumpVersion && !dryRun {
+		return nil, errors.Errorf("a new chart version must be set to push the mapped chart of: %s", ref)
+	}
+
+	client, err := registry.NewClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create registry client")
+	}
+	pulled, err := client.Pull(repository + ":" + tag)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to pull chart: %s", ref)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "mapkubeapis-oci-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary chart directory")
+	}
+	defer os.RemoveAll(tmpDir)
+	archive := filepath.Join(tmpDir, "chart.tgz")
+	if err := os.WriteFile(archive, pulled.Chart.Data, 0600); err != nil {
+		return nil, errors.Wrapf(err, "failed to write chart: %s", ref)
+	}
+
+	opts.OutDir = filepath.Join(tmpDir, "mapped")
+	result, err := common.MapChartArchive(ctx, archive, provider, kubeVersion, opts, dryRun, logger)
+	if err != nil {
+		return nil, err
+	}
+	if result.Archive == "" {
+		return result, nil
+	}
+	data, err := os.ReadFile(result.Archive)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read mapped chart of: %s", ref)
+	}
+	mapped, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load mapped chart of: %s", ref)
+	}
+	pushed, err := client.Push(data, repository+":"+mapped.Metadata.Version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to push mapped chart of: %s", ref)
+	}
+	result.Archive = ""
+	result.Reference = OCIScheme + pushed.Ref
+	logger.Printf("Mapped chart pushed to: %s\n", result.Reference)
+	return result, nil
+}
+
+// splitOCIReference splits an OCI reference without scheme into its repository and tag, the
+// tag being empty if not set
+func splitOCIReference(ref string) (string, string) {
+	i := strings.LastIndex(ref, ":")
+	if i < 0 || strings.Contains(ref[i:], "/") {
+		return ref, ""
+	}
+	return ref[:i], ref[i+1:]
+}
@@ -0,0 +1,451 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	mmsemver "github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// chartDirs are the directories of a chart containing templates or manifests
+var chartDirs = []string{"templates", "crds"}
+
+// chartFileExtensions are the extensions of the chart files scanned for deprecated APIs
+var chartFileExtensions = map[string]bool{".yaml": true, ".yml": true, ".tpl": true}
+
+// topLevelField matches a top-level field of a template with a literal value, e.g. the line
+// apiVersion: extensions/v1beta1, with the value as submatch
+var topLevelField = regexp.MustCompile(`^(apiVersion|kind):[ \t]*("[^"{}]*"|'[^'{}]*'|[^\s"'{}#]+)[ \t]*(#.*)?\r?$`)
+
+// ChartFinding is a deprecated or removed API found in a template of a chart
+type ChartFinding struct {
+	Finding
+
+	// File is the path of the template, relative to the chart directory
+	File string `json:"file"`
+
+	// Line is the number of the apiVersion line of the resource in the template
+	Line int `json:"line"`
+}
+
+// ChartResult is the result of mapping the templates of a chart
+type ChartResult struct {
+	// Findings are the resources of the templates found using deprecated or removed APIs
+	Findings []ChartFinding `json:"findings,omitempty"`
+
+	// Files are the templates rewritten, or which would be rewritten in dry-run mode,
+	// relative to the chart directory
+	Files []string `json:"files,omitempty"`
+
+	// Changes are the original and mapped content of the files, in the order of Files
+	Changes []FileChange `json:"-"`
+
+	// Archive is the path of the chart archive written when mapping a chart archive, empty if
+	// none was written
+	Archive string `json:"archive,omitempty"`
+
+	// Reference is the reference of the mapped chart pushed to an OCI registry, empty if none
+	// was pushed
+	Reference string `json:"reference,omitempty"`
+}
+
+// FileChange is the original and mapped content of a file rewritten
+type FileChange struct {
+	File     string
+	Original string
+	Mapped   string
+}
+
+// ChartArchiveOptions are the options of mapping a chart archive
+type ChartArchiveOptions struct {
+	// OutDir is the directory the mapped chart archive is written to
+	OutDir string
+
+	// Version is the version of the mapped chart, that of the archive if not set
+	Version string
+
+	// BumpVersion increments the patch version of the mapped chart if Version is not set
+	BumpVersion bool
+}
+
+// MapChart maps the deprecated or removed APIs in the templates and CRDs of a chart directory
+// to supported APIs for the target Kubernetes version, rewriting the templates in place unless
+// dryRun is set. The templates are not rendered: the top-level apiVersion and kind lines with
+// literal values of each document are matched, and the lines holding Go template actions are
+// ignored, so that an apiVersion chosen by a template condition is matched for each branch.
+// Resources whose mapping converts more than their API, or whose API has no replacement, are
+// reported with the action skipped, as their templates must be updated manually.
+func MapChart(ctx context.Context, chartDir string, provider mapping.MappingProvider, kubeVersion string, dryRun bool, logger Logger) (*ChartResult, error) {
+	if _, err := os.Stat(filepath.Join(chartDir, "Chart.yaml")); err != nil {
+		return nil, errors.Wrapf(err, "failed to find the Chart.yaml of chart directory: %s", chartDir)
+	}
+	result, err := mapSourceDirs(ctx, chartDir, chartDirs, provider, kubeVersion, dryRun, logger)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to map the templates of chart directory: %s", chartDir)
+	}
+	return result, nil
+}
+
+// MapSource maps the deprecated or removed APIs in the manifests and templates of a source
+// directory, such as the directory of a Git repository rendering a release with Argo CD or
+// Flux, like MapChart. All the YAML and template files of the directory and its subdirectories
+// are mapped, except those of hidden directories such as .git.
+func MapSource(ctx context.Context, dir string, provider mapping.MappingProvider, kubeVersion string, dryRun bool, logger Logger) (*ChartResult, error) {
+	result, err := mapSourceDirs(ctx, dir, []string{"."}, provider, kubeVersion, dryRun, logger)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to map the manifests of source directory: %s", dir)
+	}
+	return result, nil
+}
+
+// mapSourceDirs maps the deprecated or removed APIs of the files of the directories, relative
+// to the base directory
+func mapSourceDirs(ctx context.Context, baseDir string, dirs []string, provider mapping.MappingProvider, kubeVersion string, dryRun bool, logger Logger) (*ChartResult, error) {
+	logger = LoggerOrDefault(logger)
+	if !semver.IsValid(kubeVersion) {
+		return nil, errors.Errorf("Invalid Kubernetes version: %s", kubeVersion)
+	}
+	mapMetadata, err := provider.Mappings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ChartResult{}
+	for _, dir := range dirs {
+		root := filepath.Join(baseDir, dir)
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if path == root && errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if entry.IsDir() {
+				if path != root && strings.HasPrefix(entry.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !chartFileExtensions[filepath.Ext(path)] {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(baseDir, path)
+			if err != nil {
+				return err
+			}
+			return mapChartFile(path, filepath.ToSlash(rel), mapMetadata, kubeVersion, dryRun, result, logger)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// MapChartArchive maps the deprecated or removed APIs in the templates and CRDs of a packaged
+// chart archive (.tgz), like MapChart, and writes the mapped chart to a new archive named after
+// the chart and its version in the output directory, as helm package does. The archive is not
+// written in dry-run mode, or if no template was rewritten.
+func MapChartArchive(ctx context.Context, archive string, provider mapping.MappingProvider, kubeVersion string, opts ChartArchiveOptions, dryRun bool, logger Logger) (*ChartResult, error) {
+	tmpDir, err := os.MkdirTemp("", "mapkubeapis-chart-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary chart directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := chartutil.ExpandFile(tmpDir, archive); err != nil {
+		return nil, errors.Wrapf(err, "failed to expand chart archive: %s", archive)
+	}
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil || len(entries) != 1 {
+		return nil, errors.Errorf("failed to expand chart archive: %s", archive)
+	}
+	chartDir := filepath.Join(tmpDir, entries[0].Name())
+
+	result, err := MapChart(ctx, chartDir, provider, kubeVersion, dryRun, logger)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Files) == 0 || dryRun {
+		return result, nil
+	}
+
+	mapped, err := loader.LoadDir(chartDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load mapped chart of archive: %s", archive)
+	}
+	switch {
+	case opts.Version != "":
+		mapped.Metadata.Version = opts.Version
+	case opts.BumpVersion:
+		version, err := mmsemver.NewVersion(mapped.Metadata.Version)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to bump chart version: %s", mapped.Metadata.Version)
+		}
+		mapped.Metadata.Version = version.IncPatch().String()
+	}
+	if result.Archive, err = chartutil.Save(mapped, opts.OutDir); err != nil {
+		return nil, errors.Wrapf(err, "failed to write mapped chart archive of: %s", archive)
+	}
+	return result, nil
+}
+
+// mapChartFile maps the deprecated or removed APIs of a template file of a chart and adds the
+// findings to the result
+func mapChartFile(path, name string, mapMetadata *mapping.Metadata, kubeVersion string, dryRun bool, result *ChartResult, logger Logger) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	docs := convert.Split(string(content))
+	var rewritten bool
+	lineOffset := 0
+	for i, doc := range docs {
+		lines := strings.SplitAfter(doc, "\n")
+		kind, apiVersions := templateAPIs(lines)
+		for _, index := range apiVersions {
+			apiVersion := fieldValue(lines[index])
+			finding, ok, err := chartFinding(apiVersion, kind, mapMetadata, kubeVersion)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if finding.Action == ActionMapped {
+				gvk, err := mapping.ParseAPI(finding.NewAPI)
+				if err != nil {
+					return err
+				}
+				lines[index] = strings.Replace(lines[index], apiVersion, gvk.GroupVersion().String(), 1)
+				rewritten = true
+			}
+			result.Findings = append(result.Findings, ChartFinding{Finding: finding, File: name, Line: lineOffset + index + 1})
+		}
+		docs[i] = strings.Join(lines, "")
+		lineOffset += strings.Count(doc, "\n")
+	}
+	if !rewritten {
+		return nil
+	}
+	result.Files = append(result.Files, name)
+	result.Changes = append(result.Changes, FileChange{File: name, Original: string(content), Mapped: convert.Join(docs)})
+	if dryRun {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(convert.Join(docs)), info.Mode().Perm()); err != nil {
+		return err
+	}
+	logger.Printf("Mapped the deprecated or removed APIs of file: %s\n", name)
+	return nil
+}
+
+// templateAPIs returns the literal kind of a document of a template, and the indexes of its
+// top-level apiVersion lines with literal values. A document may have several apiVersion
+// lines, one for each branch of a template condition.
+func templateAPIs(lines []string) (string, []int) {
+	var kind string
+	var apiVersions []int
+	for i, line := range lines {
+		match := topLevelField.FindStringSubmatch(strings.TrimSuffix(line, "\n"))
+		if match == nil {
+			continue
+		}
+		if match[1] == "kind" {
+			kind = strings.Trim(match[2], `"'`)
+		} else {
+			apiVersions = append(apiVersions, i)
+		}
+	}
+	if kind == "" {
+		return "", nil
+	}
+	return kind, apiVersions
+}
+
+// fieldValue returns the literal value of a top-level field line, without quotes
+func fieldValue(line string) string {
+	match := topLevelField.FindStringSubmatch(strings.TrimSuffix(line, "\n"))
+	if match == nil {
+		return ""
+	}
+	return strings.Trim(match[2], `"'`)
+}
+
+// chartFinding returns the finding for a resource of a template using the API, and false if
+// the API is not deprecated or removed. The action is mapped if the apiVersion of the template
+// can be replaced, and skipped if the API does not require mapping in the Kubernetes version
+// or the template must be updated manually, in which case the finding has a warning.
+func chartFinding(apiVersion, kind string, mapMetadata *mapping.Metadata, kubeVersion string) (Finding, bool, error) {
+	api := "apiVersion: " + apiVersion + "\nkind: " + kind + "\n"
+	var found *mapping.Mapping
+	for _, m := range mapMetadata.Mappings {
+		if m.DeprecatedAPI != api {
+			continue
+		}
+		applies, err := m.AppliesTo(kubeVersion)
+		if err != nil {
+			return Finding{}, false, err
+		}
+		if applies {
+			found = m
+			break
+		}
+		if found == nil {
+			found = m
+		}
+	}
+	if found == nil {
+		return Finding{}, false, nil
+	}
+
+	finding := Finding{
+		Resource:      convert.Resource{APIVersion: apiVersion, Kind: kind},
+		DeprecatedAPI: api,
+		NewAPI:        found.NewAPI,
+		Action:        ActionSkipped,
+	}
+	if applies, _ := found.AppliesTo(kubeVersion); !applies {
+		return finding, true, nil
+	}
+	resolved, err := resolveMapping(found, mapMetadata, kubeVersion, nil)
+	if err != nil {
+		return Finding{}, false, err
+	}
+	finding.NewAPI = resolved.NewAPI
+	if resolved.NewAPI == "" {
+		finding.Warnings = append(finding.Warnings, "the API has no replacement, the template must be removed manually")
+		return finding, true, nil
+	}
+	gvk, err := mapping.ParseAPI(resolved.NewAPI)
+	if err != nil {
+		return Finding{}, false, err
+	}
+	switch {
+	case gvk.Kind != kind:
+		finding.Warnings = append(finding.Warnings, "the kind of the supported API differs, the template must be updated manually")
+	case convert.Converts(resolved):
+		finding.Warnings = append(finding.Warnings, "the resource is converted beyond its API, the template must be updated manually")
+	default:
+		finding.Action = ActionMapped
+	}
+	return finding, true, nil
+}
+
+// patchContext is the number of unchanged lines around the changes of a patch
+const patchContext = 3
+
+// Patch returns the changes of the files as a unified diff with paths relative to the
+// directory mapped, which can be applied with git apply or patch -p1
+func (r *ChartResult) Patch() string {
+	var b strings.Builder
+	for _, change := range r.Changes {
+		writeFilePatch(&b, change)
+	}
+	return b.String()
+}
+
+// writeFilePatch writes the unified diff of a file change. The mapping replaces lines without
+// adding or removing any, so that the lines of both versions are compared by index.
+func writeFilePatch(b *strings.Builder, change FileChange) {
+	original := patchLines(change.Original)
+	mapped := patchLines(change.Mapped)
+	if len(original) != len(mapped) {
+		return
+	}
+	fmt.Fprintf(b, "--- a/%s\n+++ b/%s\n", change.File, change.File)
+	for i := 0; i < len(original); {
+		if original[i] == mapped[i] {
+			i++
+			continue
+		}
+		// A hunk spans the changes closer to each other than twice the context
+		end := i + 1
+		for j := end; j < len(original) && j-end < 2*patchContext; j++ {
+			if original[j] != mapped[j] {
+				end = j + 1
+			}
+		}
+		start := i - patchContext
+		if start < 0 {
+			start = 0
+		}
+		stop := end + patchContext
+		if stop > len(original) {
+			stop = len(original)
+		}
+		fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@\n", start+1, stop-start, start+1, stop-start)
+		for k := start; k < stop; {
+			if original[k] == mapped[k] {
+				writePatchLine(b, " ", original[k])
+				k++
+				continue
+			}
+			run := k
+			for run < stop && original[run] != mapped[run] {
+				run++
+			}
+			for _, line := range original[k:run] {
+				writePatchLine(b, "-", line)
+			}
+			for _, line := range mapped[k:run] {
+				writePatchLine(b, "+", line)
+			}
+			k = run
+		}
+		i = stop
+	}
+}
+
+// patchLines splits the content of a file into its lines, keeping their line breaks
+func patchLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// writePatchLine writes a line of a hunk, marking the last line of a file without line break
+func writePatchLine(b *strings.Builder, prefix, line string) {
+	b.WriteString(prefix)
+	b.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		b.WriteString("\n\\ No newline at end of file\n")
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/cli"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// DefaultQPS is the default maximum number of queries per second to the Kubernetes API
+	// server, higher than the client-go default of 5 so that bulk runs reading many release
+	// versions are not throttled
+	DefaultQPS float32 = 50
+
+	// DefaultBurst is the default maximum burst of queries to the Kubernetes API server
+	DefaultBurst = 100
+)
+
+// HelmSettings returns the Helm env settings with the kube config settings, whose Kubernetes
+// clients are rate limited by the QPS and burst, and whose requests time out after the request
+// timeout of the kube config settings
+func HelmSettings(kubeConfig KubeConfig) *cli.EnvSettings {
+	settings := cli.New()
+	// Helm sets the kubeconfig file to KUBECONFIG, which fails if it is a list of files. The
+	// file is left empty instead, so that the client-go loading rules merge the files of the list.
+	settings.KubeConfig = kubeConfig.File
+	settings.KubeContext = kubeConfig.Context
+	if kubeConfig.AsUser != "" {
+		settings.KubeAsUser = kubeConfig.AsUser
+	}
+	if len(kubeConfig.AsGroups) > 0 {
+		settings.KubeAsGroups = kubeConfig.AsGroups
+	}
+	if kubeConfig.APIServer != "" {
+		settings.KubeAPIServer = kubeConfig.APIServer
+	}
+	if kubeConfig.Token != "" {
+		settings.KubeToken = kubeConfig.Token
+	}
+	if kubeConfig.CAFile != "" {
+		settings.KubeCaFile = kubeConfig.CAFile
+	}
+	if kubeConfig.InsecureSkipTLSVerify {
+		settings.KubeInsecureSkipTLSVerify = true
+	}
+	if kubeConfig.TLSServerName != "" {
+		settings.KubeTLSServerName = kubeConfig.TLSServerName
+	}
+	settings.BurstLimit = kubeConfig.burst()
+	if configFlags, ok := settings.RESTClientGetter().(*genericclioptions.ConfigFlags); ok {
+		// Helm has no client certificate settings
+		if kubeConfig.CertFile != "" {
+			configFlags.CertFile = &kubeConfig.CertFile
+		}
+		if kubeConfig.KeyFile != "" {
+			configFlags.KeyFile = &kubeConfig.KeyFile
+		}
+		configFlags.WrapConfigFn = func(config *rest.Config) *rest.Config {
+			config.QPS = kubeConfig.qps()
+			config.Burst = kubeConfig.burst()
+			if kubeConfig.RateLimiter != nil {
+				config.RateLimiter = kubeConfig.RateLimiter
+			}
+			if kubeConfig.RequestTimeout > 0 {
+				config.Timeout = kubeConfig.RequestTimeout
+			}
+			return config
+		}
+	}
+	return settings
+}
+
+// InCluster reports whether the Kubernetes clients of the kube config settings use the
+// in-cluster configuration of the pod service account, which client-go falls back to when
+// running in a pod with no kubeconfig file set nor found at the default location, and no API
+// server set. The release namespace is then the namespace of the service account, unless set.
+func InCluster(kubeConfig KubeConfig) bool {
+	if kubeConfig.File != "" || os.Getenv(clientcmd.RecommendedConfigPathEnvVar) != "" {
+		return false
+	}
+	if kubeConfig.APIServer != "" || os.Getenv("HELM_KUBEAPISERVER") != "" {
+		return false
+	}
+	if _, err := os.Stat(clientcmd.RecommendedHomeFile); err == nil {
+		return false
+	}
+	_, err := rest.InClusterConfig()
+	return err == nil
+}
+
+// NewRateLimiter returns the rate limiter of the QPS and burst to share between Kubernetes
+// clients, with the defaults of DefaultQPS and DefaultBurst if zero
+func NewRateLimiter(qps float32, burst int) flowcontrol.RateLimiter {
+	kubeConfig := KubeConfig{QPS: qps, Burst: burst}
+	return flowcontrol.NewTokenBucketRateLimiter(kubeConfig.qps(), kubeConfig.burst())
+}
+
+// RESTClientGetter returns the getter of the Kubernetes clients of the kube config settings
+func RESTClientGetter(kubeConfig KubeConfig) genericclioptions.RESTClientGetter {
+	return HelmSettings(kubeConfig).RESTClientGetter()
+}
+
+// ClientSet returns the Kubernetes client set of the kube config settings
+func ClientSet(kubeConfig KubeConfig) (kubernetes.Interface, error) {
+	restConfig, err := RESTClientGetter(kubeConfig).ToRESTConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "kubernetes cluster unreachable")
+	}
+	clientSet, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "kubernetes cluster unreachable")
+	}
+	return clientSet, nil
+}
+
+// qps returns the QPS of the Kubernetes clients
+func (k KubeConfig) qps() float32 {
+	if k.QPS > 0 {
+		return k.QPS
+	}
+	return DefaultQPS
+}
+
+// burst returns the burst of the Kubernetes clients
+func (k KubeConfig) burst() int {
+	if k.Burst > 0 {
+		return k.Burst
+	}
+	return DefaultBurst
+}
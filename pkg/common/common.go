@@ -17,92 +17,744 @@ limitations under the License.
 package common
 
 import (
+	"context"
+	"io"
 	"log"
 	"strings"
+	"time"
 
-	utils "github.com/maorfr/helm-plugin-utils/pkg"
 	"github.com/pkg/errors"
 	"golang.org/x/mod/semver"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/util/flowcontrol"
 
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
 	"github.com/helm/helm-mapkubeapis/pkg/mapping"
 )
 
 // KubeConfig are the Kubernetes configuration settings
 type KubeConfig struct {
 	Context string
-	File    string
+
+	// File is the path of the kubeconfig file. If empty, the files of the colon-separated
+	// (semicolon-separated on Windows) list of the KUBECONFIG environment variable are merged
+	// as by kubectl, or ~/.kube/config is used if it is not set.
+	File string
+
+	// AsUser and AsGroups are the user and groups impersonated by the Kubernetes clients,
+	// those of the HELM_KUBEASUSER and HELM_KUBEASGROUPS environment variables if empty
+	AsUser   string
+	AsGroups []string
+
+	// APIServer and Token are the address of the Kubernetes API server and the bearer token
+	// authenticating to it, which override those of the kubeconfig file; those of the
+	// HELM_KUBEAPISERVER and HELM_KUBETOKEN environment variables if empty
+	APIServer string
+	Token     string
+
+	// CAFile, InsecureSkipTLSVerify and TLSServerName are the TLS settings of the connection
+	// to the Kubernetes API server, which override those of the kubeconfig file; those of the
+	// HELM_KUBECAFILE, HELM_KUBEINSECURE_SKIP_TLS_VERIFY and HELM_KUBETLS_SERVER_NAME
+	// environment variables if not set
+	CAFile                string
+	InsecureSkipTLSVerify bool
+	TLSServerName         string
+
+	// CertFile and KeyFile are the client certificate and key authenticating to the
+	// Kubernetes API server, which override those of the kubeconfig file
+	CertFile string
+	KeyFile  string
+
+	// QPS is the maximum number of queries per second to the Kubernetes API server, the
+	// default of DefaultQPS if zero
+	QPS float32
+
+	// Burst is the maximum burst of queries to the Kubernetes API server, the default of
+	// DefaultBurst if zero
+	Burst int
+
+	// Retries is the number of times requests failing with a transient error are retried,
+	// see Retry
+	Retries int
+
+	// RetryBackoff is the delay before the first retry, doubled on each retry, the default of
+	// DefaultRetryBackoff if zero
+	RetryBackoff time.Duration
+
+	// RequestTimeout is the timeout of each request to the Kubernetes API server, no timeout
+	// if zero
+	RequestTimeout time.Duration
+
+	// StorageOwner is the value of the owner label of the Secrets or ConfigMaps storing the
+	// releases, for releases stored by tools which set another owner than Helm; "helm" if empty
+	StorageOwner string
+
+	// StorageSelector is a label selector the Secrets or ConfigMaps storing the releases must
+	// also match, e.g. the labels set by the tool storing them
+	StorageSelector string
+
+	// HelmVersion is the major version of the Helm managing the releases, 3 or 4. The Helm 4
+	// release fields are preserved when releases are written if 4, see package v4.
+	HelmVersion int
+
+	// KubeVersion is the Kubernetes version the releases are mapped for instead of that of the
+	// cluster, e.g. v1.25.0, in which case the cluster is not queried for its version nor the
+	// APIs it serves
+	KubeVersion string
+
+	// RateLimiter is shared by the Kubernetes clients, so that concurrent clients are rate
+	// limited together. Each client is rate limited by QPS and Burst if nil, see NewRateLimiter.
+	RateLimiter flowcontrol.RateLimiter
 }
 
 // MapOptions are the options for mapping deprecated APIs in a release
 type MapOptions struct {
-	DryRun           bool
+	// AllowEmptyRelease allows mapping a release when all its resources are removed, as
+	// their APIs have no replacement
+	AllowEmptyRelease bool
+
+	// CheckLiveObjects warns about the live objects of the resources removed from the release
+	// manifest, which are orphaned by Helm
+	CheckLiveObjects bool
+
+	DryRun bool
+
+	// Force maps a release reconciled by a GitOps controller, e.g. Argo CD, which is skipped
+	// otherwise as the controller reverts the release to its source
+	Force bool
+
 	KubeConfig       KubeConfig
-	MapFile          string
+	Logger           Logger
+	MappingProvider  mapping.MappingProvider
+	PostMapHook      Hook
+	PreMapHook       Hook
 	ReleaseName      string
 	ReleaseNamespace string
+
+	// Lock holds a coordination.k8s.io Lease in the release namespace while the release is
+	// mapped, so that concurrent runs do not map the same release simultaneously
+	Lock bool
+
+	// Storage is the release storage, the Helm release storage of the namespace if nil
+	Storage ReleaseStorage
+
+	// StorageDriver is the Helm storage driver of the release storage if Storage is nil, one
+	// of: secret, configmap, memory, sql; the driver of HELM_DRIVER if empty
+	StorageDriver string
+
+	// RequireNewAPI fails the mapping if a supported API is not served by the cluster, instead
+	// of leaving the deprecated API unmapped
+	RequireNewAPI bool
+
+	// Timeout bounds the check and mapping of the release, no timeout if zero. Once the update
+	// of the release started, it is completed or reverted whatever the timeout.
+	Timeout time.Duration
+
+	// Validate is run with the change summary of a release before it is updated, also in
+	// dry-run mode. If it returns an error, the release is not updated.
+	Validate Hook
+}
+
+// Hook is run with the change summary of a release before or after it is updated. A pre-map
+// hook which returns an error aborts the update of the release, unless the error wraps
+// ErrSkipUpdate in which case the release is left unchanged as in dry-run mode. The context is
+// that of the mapping of the release.
+type Hook func(ctx context.Context, result *ReleaseResult) error
+
+// ErrSkipUpdate is wrapped by the error of a pre-map hook to skip the update of the release
+// without failing
+var ErrSkipUpdate = errors.New("update skipped")
+
+// Logger is the interface used to log the progress of checking and mapping releases.
+// It is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// LoggerOrDefault returns the logger, or the standard logger of the log package if it is nil
+func LoggerOrDefault(logger Logger) Logger {
+	if logger == nil {
+		return log.Default()
+	}
+	return logger
+}
+
+// PrefixLogger returns a logger prefixing the messages of the logger, the standard logger of the
+// log package if nil, with the prefix, e.g. the release they relate to when several releases
+// are processed concurrently
+func PrefixLogger(logger Logger, prefix string) Logger {
+	return &prefixLogger{logger: LoggerOrDefault(logger), prefix: prefix}
+}
+
+// prefixLogger is a logger prefixing its messages, see PrefixLogger
+type prefixLogger struct {
+	logger Logger
+	prefix string
+}
+
+func (l *prefixLogger) Printf(format string, v ...interface{}) {
+	l.logger.Printf("%s"+format, append([]interface{}{l.prefix}, v...)...)
+}
+
+// MappedAPI describes a deprecated or removed API found in a manifest and the
+// supported API it was mapped to
+type MappedAPI struct {
+	DeprecatedAPI       string `json:"deprecatedAPI"`
+	NewAPI              string `json:"newAPI"`
+	DeprecatedInVersion string `json:"deprecatedInVersion,omitempty"`
+	RemovedInVersion    string `json:"removedInVersion,omitempty"`
+	Count               int    `json:"count"`
+	Requested           bool   `json:"requested,omitempty"`
+}
+
+// Actions taken on the resources using a deprecated or removed API
+const (
+	// ActionMapped is the action of mapping the API of the resource to the supported API
+	ActionMapped = "mapped"
+
+	// ActionRemoved is the action of removing the resource, as its API has no replacement
+	ActionRemoved = "removed"
+
+	// ActionSkipped is the action of leaving the resource unchanged, as its API is not
+	// deprecated or removed in the Kubernetes version
+	ActionSkipped = "skipped"
+)
+
+// Finding describes a resource found using a deprecated or removed API and the action taken
+type Finding struct {
+	// Resource using the deprecated or removed API
+	Resource convert.Resource `json:"resource"`
+
+	// DeprecatedAPI is the deprecated or removed API of the resource
+	DeprecatedAPI string `json:"deprecatedAPI"`
+
+	// NewAPI is the supported API the resource is mapped to, empty if it has no replacement
+	NewAPI string `json:"newAPI,omitempty"`
+
+	// Action taken on the resource, one of: mapped, removed, skipped
+	Action string `json:"action"`
+
+	// Warnings about the changes of behavior of the resource caused by its conversion
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Recreate is true if the conversion implies a change of an immutable field of the live
+	// resource, so that the resource must be recreated on the next upgrade of the release
+	Recreate bool `json:"recreate,omitempty"`
+}
+
+// Findings are the resources found using deprecated or removed APIs
+type Findings []Finding
+
+// Count returns the number of findings with the given action
+func (f Findings) Count(action string) int {
+	var count int
+	for _, finding := range f {
+		if finding.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// ReleaseResult is the result of checking or mapping a release
+type ReleaseResult struct {
+	// Name of the release
+	Name string `json:"name"`
+
+	// Namespace of the release
+	Namespace string `json:"namespace"`
+
+	// Revision is the latest release version, which is the new version if the release was mapped
+	Revision int `json:"revision"`
+
+	// Mapped is true if a new release version with the APIs mapped was created
+	Mapped bool `json:"mapped"`
+
+	// MappedAPIs are the deprecated or removed APIs found, which were or would be mapped
+	MappedAPIs []MappedAPI `json:"mappedAPIs,omitempty"`
+
+	// Findings are the resources found using deprecated or removed APIs, including the
+	// resources skipped as their API does not require mapping in the Kubernetes version
+	Findings Findings `json:"findings,omitempty"`
+
+	// ManagedBy is the GitOps controller found reconciling the release, nil if none
+	ManagedBy *ManagedBy `json:"managedBy,omitempty"`
+
+	// Manifest is the release manifest with the APIs mapped
+	Manifest string `json:"-"`
+
+	// Removed are the decoded resources removed from the release manifest, as their API has
+	// no replacement
+	Removed []map[string]interface{} `json:"-"`
 }
 
 // UpgradeDescription is description of why release was upgraded
 const UpgradeDescription = "Kubernetes deprecated API upgrade - DO NOT rollback from this version"
 
-// ReplaceManifestUnSupportedAPIs returns a release manifest with deprecated or removed
-// Kubernetes APIs updated to supported APIs
-func ReplaceManifestUnSupportedAPIs(origManifest, mapFile string, kubeConfig KubeConfig) (string, error) {
-	var modifiedManifest = origManifest
-	var err error
-	var mapMetadata *mapping.Metadata
+// ReplaceManifestUnSupportedAPIs returns the result of mapping a release manifest, with
+// deprecated or removed Kubernetes APIs updated to supported APIs for the Kubernetes server version.
+// APIs whose supported API is not served by the cluster are left unmapped, or fail the mapping
+// if requireNewAPI is true.
+func ReplaceManifestUnSupportedAPIs(ctx context.Context, origManifest string, provider mapping.MappingProvider, kubeConfig KubeConfig, requireNewAPI bool, logger Logger) (*ManifestResult, error) {
+	clusterMapper, err := NewClusterMapper(ctx, provider, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	return clusterMapper.MapManifest(ctx, origManifest, requireNewAPI, logger)
+}
+
+// ClusterMapper maps the manifests of the releases of a cluster like
+// ReplaceManifestUnSupportedAPIs, with the mappings, the Kubernetes server version and the
+// served APIs looked up once for all the manifests. It is safe for concurrent use.
+type ClusterMapper struct {
+	mapMetadata *mapping.Metadata
+	kubeVersion string
+	served      *ServedAPIs
+}
 
+// NewClusterMapper returns the mapper of the manifests of the cluster of the kube config
+func NewClusterMapper(ctx context.Context, provider mapping.MappingProvider, kubeConfig KubeConfig) (*ClusterMapper, error) {
 	// Load the mapping data
-	if mapMetadata, err = mapping.LoadMapfile(mapFile); err != nil {
-		return "", errors.Wrapf(err, "Failed to load mapping file: %s", mapFile)
+	mapMetadata, err := provider.Mappings(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	// get the Kubernetes server version
-	kubeVersionStr, err := getKubernetesServerVersion(kubeConfig)
+	kubeVersionStr, err := GetKubernetesServerVersion(ctx, kubeConfig)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	if !semver.IsValid(kubeVersionStr) {
-		return "", errors.Errorf("Failed to get Kubernetes server version")
+
+	var served *ServedAPIs
+	if kubeConfig.KubeVersion == "" {
+		if served, err = GetServedAPIs(kubeConfig); err != nil {
+			return nil, err
+		}
 	}
+	return &ClusterMapper{mapMetadata: mapMetadata, kubeVersion: kubeVersionStr, served: served}, nil
+}
 
-	// Check for deprecated or removed APIs and map accordingly to supported versions
-	for _, mapping := range mapMetadata.Mappings {
-		deprecatedAPI := mapping.DeprecatedAPI
-		supportedAPI := mapping.NewAPI
-		var apiVersionStr string
-		if mapping.DeprecatedInVersion != "" {
-			apiVersionStr = mapping.DeprecatedInVersion
-		} else {
-			apiVersionStr = mapping.RemovedInVersion
+// MapManifest returns the result of mapping a release manifest, see
+// ReplaceManifestUnSupportedAPIs
+func (c *ClusterMapper) MapManifest(ctx context.Context, origManifest string, requireNewAPI bool, logger Logger) (*ManifestResult, error) {
+	return mapManifest(ctx, origManifest, c.mapMetadata, c.kubeVersion, c.served, requireNewAPI, logger)
+}
+
+// mapManifest returns the result of mapping the deprecated or removed APIs in the manifest
+// which apply to the Kubernetes version to supported APIs. If the served APIs are set, APIs
+// whose supported API is not served are left unmapped, or fail the mapping if requireNewAPI
+// is true.
+func mapManifest(ctx context.Context, origManifest string, mapMetadata *mapping.Metadata, kubeVersionStr string, served *ServedAPIs, requireNewAPI bool, logger Logger) (*ManifestResult, error) {
+	var modifiedManifest strings.Builder
+	modifiedManifest.Grow(len(origManifest))
+	docs := convert.NewDocumentReader(strings.NewReader(origManifest))
+	result, err := mapDocuments(ctx, docs, &modifiedManifest, mapMetadata, kubeVersionStr, served, requireNewAPI, logger)
+	if err != nil {
+		return nil, err
+	}
+	result.Manifest = modifiedManifest.String()
+	return result, nil
+}
+
+// mapDocuments maps the deprecated or removed APIs of the documents read from the reader, and
+// writes each document with its APIs mapped before the next one is read, so that memory is
+// proportional to the largest document instead of the whole manifest. The manifest of the
+// result is empty. It fails if the context is canceled.
+func mapDocuments(ctx context.Context, docs *convert.DocumentReader, w io.Writer, mapMetadata *mapping.Metadata, kubeVersionStr string, served *ServedAPIs, requireNewAPI bool, logger Logger) (*ManifestResult, error) {
+	mapper, err := newManifestMapper(mapMetadata, kubeVersionStr, served, requireNewAPI)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-		if !semver.IsValid(apiVersionStr) {
-			return "", errors.Errorf("Failed to get the deprecated or removed Kubernetes version for API: %s", strings.ReplaceAll(deprecatedAPI, "\n", " "))
+		doc, err := docs.Next()
+		if err == io.EOF {
+			break
 		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read manifests")
+		}
+		if doc, err = mapper.mapDocument(ctx, doc); err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(w, doc); err != nil {
+			return nil, errors.Wrap(err, "failed to write manifests")
+		}
+	}
+	return mapper.result(LoggerOrDefault(logger)), nil
+}
 
-		if count := strings.Count(modifiedManifest, deprecatedAPI); count > 0 {
-			if semver.Compare(apiVersionStr, kubeVersionStr) > 0 {
-				log.Printf("The following API does not require mapping as the "+
-					"API is not deprecated or removed in Kubernetes '%s':\n\"%s\"\n", apiVersionStr,
-					deprecatedAPI)
+// manifestMapper maps the deprecated or removed APIs of the documents of a manifest, and
+// accumulates the outcome of each mapping over the documents
+type manifestMapper struct {
+	mapMetadata   *mapping.Metadata
+	kubeVersion   string
+	served        *ServedAPIs
+	requireNewAPI bool
+	applies       []bool
+	outcomes      []mappingOutcome
+
+	// The mappings are indexed by the first line of their deprecated API, usually its
+	// apiVersion, shared by several mappings, so that a document is searched once for the
+	// line instead of once for each mapping. found holds whether a document contains each
+	// line, 0 if it was not searched yet, 1 if it does and -1 if it does not.
+	lines     []string
+	lineIndex []int
+	found     []int8
+}
+
+// mappingOutcome is the outcome of a mapping of the map file over the documents of a manifest
+type mappingOutcome struct {
+	// mapping collapsing the chain of mappings starting with the mapping, nil if none is served
+	mapping  *mapping.Mapping
+	resolved bool
+
+	// found is the number of instances of the deprecated API found, converted the number of
+	// instances converted
+	found     int
+	converted int
+
+	findings Findings
+	warnings []convert.Warning
+	removed  []map[string]interface{}
+
+	// applier of the mapping, prepared once for all the documents
+	applier *convert.Applier
+}
+
+func newManifestMapper(mapMetadata *mapping.Metadata, kubeVersion string, served *ServedAPIs, requireNewAPI bool) (*manifestMapper, error) {
+	applies := make([]bool, len(mapMetadata.Mappings))
+	lineIndex := make([]int, len(mapMetadata.Mappings))
+	var lines []string
+	indexes := map[string]int{}
+	for i, m := range mapMetadata.Mappings {
+		var err error
+		if applies[i], err = m.AppliesTo(kubeVersion); err != nil {
+			return nil, err
+		}
+		line := m.DeprecatedAPI
+		if end := strings.IndexByte(line, '\n'); end >= 0 {
+			line = line[:end]
+		}
+		index, ok := indexes[line]
+		if !ok {
+			index = len(lines)
+			indexes[line] = index
+			lines = append(lines, line)
+		}
+		lineIndex[i] = index
+	}
+	return &manifestMapper{
+		mapMetadata:   mapMetadata,
+		kubeVersion:   kubeVersion,
+		served:        served,
+		requireNewAPI: requireNewAPI,
+		applies:       applies,
+		outcomes:      make([]mappingOutcome, len(mapMetadata.Mappings)),
+		lines:         lines,
+		lineIndex:     lineIndex,
+		found:         make([]int8, len(lines)),
+	}, nil
+}
+
+// mapDocument returns the document with its deprecated or removed APIs mapped.
+// Mapping chains are collapsed, so that each resource is rewritten once to its final API.
+func (p *manifestMapper) mapDocument(ctx context.Context, doc string) (string, error) {
+	p.resetFound()
+	for i, chained := range p.mapMetadata.Mappings {
+		if !p.contains(doc, i) {
+			continue
+		}
+		count := convert.Match(doc, chained)
+		if count == 0 {
+			continue
+		}
+		outcome := &p.outcomes[i]
+		outcome.found += count
+		if !p.applies[i] {
+			outcome.findings = appendFindings(outcome.findings, doc, chained, ActionSkipped)
+			continue
+		}
+		if !outcome.resolved {
+			var err error
+			if outcome.mapping, err = resolveMapping(chained, p.mapMetadata, p.kubeVersion, p.served); err != nil {
+				return "", err
+			}
+			outcome.resolved = true
+			if outcome.mapping != nil {
+				if outcome.applier, err = convert.NewApplier(ctx, outcome.mapping); err != nil {
+					return "", errors.Wrapf(err, "failed to convert API: %s", FlattenAPI(chained.DeprecatedAPI))
+				}
+			}
+		}
+		if outcome.mapping == nil && p.requireNewAPI {
+			return "", errors.Errorf("the supported API of %s is not served by the cluster: %s", FlattenAPI(chained.DeprecatedAPI), FlattenAPI(chained.NewAPI))
+		}
+		if outcome.mapping == nil {
+			outcome.findings = appendFindings(outcome.findings, doc, chained, ActionSkipped)
+			continue
+		}
+
+		action := ActionMapped
+		if outcome.mapping.NewAPI == "" {
+			action = ActionRemoved
+			objects, err := removedObjects(doc, outcome.mapping)
+			if err != nil {
+				return "", err
+			}
+			outcome.removed = append(outcome.removed, objects...)
+		}
+		docFindings := len(outcome.findings)
+		outcome.findings = appendFindings(outcome.findings, doc, outcome.mapping, action)
+		var conversion convert.Conversion
+		var err error
+		if doc, conversion, err = outcome.applier.Apply(doc); err != nil {
+			return "", errors.Wrapf(err, "failed to convert API: %s", FlattenAPI(chained.DeprecatedAPI))
+		}
+		// The document was rewritten, so it may now contain lines it did not contain
+		p.resetFound()
+		for _, warning := range conversion.Warnings {
+			addWarning(outcome.findings[docFindings:], warning)
+		}
+		outcome.warnings = append(outcome.warnings, conversion.Warnings...)
+		outcome.converted += conversion.Count
+	}
+	return doc, nil
+}
+
+// contains returns whether the document may contain the deprecated API of the i-th mapping,
+// i.e. it contains the first line of the deprecated API
+func (p *manifestMapper) contains(doc string, i int) bool {
+	index := p.lineIndex[i]
+	if p.found[index] == 0 {
+		p.found[index] = -1
+		if strings.Contains(doc, p.lines[index]) {
+			p.found[index] = 1
+		}
+	}
+	return p.found[index] > 0
+}
+
+// resetFound forgets the lines found in the document being mapped
+func (p *manifestMapper) resetFound() {
+	for i := range p.found {
+		p.found[i] = 0
+	}
+}
+
+// result logs the outcome of each mapping over the documents mapped, and returns the result
+// of mapping the manifest, in the order of the mappings of the map file
+func (p *manifestMapper) result(logger Logger) *ManifestResult {
+	result := &ManifestResult{}
+	for i, chained := range p.mapMetadata.Mappings {
+		outcome := p.outcomes[i]
+		result.Findings = append(result.Findings, outcome.findings...)
+		switch {
+		case outcome.found == 0:
+			continue
+		case !p.applies[i]:
+			logger.Printf("The following API does not require mapping as the "+
+				"API is not deprecated or removed in Kubernetes '%s':\n\"%s\"\n", p.kubeVersion,
+				chained.DeprecatedAPI)
+			continue
+		case outcome.mapping == nil:
+			logger.Printf("Warning: the supported API is not served by the cluster, the following API is not mapped:\n\"%s\"\n", chained.DeprecatedAPI)
+			continue
+		}
+
+		supportedAPI := outcome.mapping.NewAPI
+		if supportedAPI == "" {
+			logger.Printf("Found %d instances of removed Kubernetes API:\n\"%s\"\nThe API has no supported equivalent, the resources are removed.\n", outcome.found, chained.DeprecatedAPI)
+		} else {
+			logger.Printf("Found %d instances of deprecated or removed Kubernetes API:\n\"%s\"\nSupported API equivalent:\n\"%s\"\n", outcome.found, chained.DeprecatedAPI, supportedAPI)
+		}
+		for _, warning := range outcome.warnings {
+			if warning.Recreate {
+				logger.Printf("Warning: %s '%s' must be recreated on the next upgrade: %s\n", warning.Resource.Kind, warning.Resource.Name, warning.Message)
 			} else {
-				log.Printf("Found %d instances of deprecated or removed Kubernetes API:\n\"%s\"\nSupported API equivalent:\n\"%s\"\n", count, deprecatedAPI, supportedAPI)
-				modifiedManifest = strings.ReplaceAll(modifiedManifest, deprecatedAPI, supportedAPI)
+				logger.Printf("Warning: %s '%s': %s\n", warning.Resource.Kind, warning.Resource.Name, warning.Message)
 			}
 		}
+		result.Removed = append(result.Removed, outcome.removed...)
+		result.MappedAPIs = append(result.MappedAPIs, MappedAPI{
+			DeprecatedAPI:       chained.DeprecatedAPI,
+			NewAPI:              supportedAPI,
+			DeprecatedInVersion: outcome.mapping.DeprecatedInVersion,
+			RemovedInVersion:    outcome.mapping.RemovedInVersion,
+			Count:               outcome.converted,
+		})
 	}
+	return result
+}
 
-	return modifiedManifest, nil
+// removedObjects returns the decoded resources of the manifest using the deprecated API of
+// the mapping, which are removed as the API has no replacement
+func removedObjects(manifest string, m *mapping.Mapping) ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+	for _, doc := range convert.Split(manifest) {
+		if convert.Match(doc, m) == 0 {
+			continue
+		}
+		docObjects, err := convert.Objects(doc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode removed resource of API: %s", FlattenAPI(m.DeprecatedAPI))
+		}
+		objects = append(objects, docObjects...)
+	}
+	return objects, nil
 }
 
-func getKubernetesServerVersion(kubeConfig KubeConfig) (string, error) {
-	clientSet := utils.GetClientSetWithKubeConfig(kubeConfig.File, kubeConfig.Context)
-	if clientSet == nil {
-		return "", errors.Errorf("kubernetes cluster unreachable")
+// resolveMapping returns the mapping collapsing the chain of mappings which apply to the
+// Kubernetes version starting with the mapping, e.g. extensions/v1beta1 Ingress mapped to
+// networking.k8s.io/v1beta1 and then to networking.k8s.io/v1, so that the resources are mapped
+// to the final API of the chain whatever the order of the mappings. If the served APIs are set,
+// the chain stops at the last API served by the cluster, and nil is returned if none is served.
+func resolveMapping(m *mapping.Mapping, mapMetadata *mapping.Metadata, kubeVersion string, served *ServedAPIs) (*mapping.Mapping, error) {
+	chain := []*mapping.Mapping{m}
+	visited := map[string]bool{m.DeprecatedAPI: true}
+	for api := m.NewAPI; api != ""; {
+		next := nextMapping(api, mapMetadata, kubeVersion)
+		if next == nil {
+			break
+		}
+		if visited[next.DeprecatedAPI] {
+			return nil, errors.Errorf("the mappings of API %s form a cycle", FlattenAPI(m.DeprecatedAPI))
+		}
+		visited[next.DeprecatedAPI] = true
+		chain = append(chain, next)
+		api = next.NewAPI
+	}
+
+	end := len(chain)
+	if served != nil {
+		for ; end > 0; end-- {
+			api := chain[end-1].NewAPI
+			if api == "" {
+				break
+			}
+			ok, err := served.IsServed(api)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				break
+			}
+		}
+		if end == 0 {
+			return nil, nil
+		}
 	}
-	kubeVersion, err := clientSet.ServerVersion()
+	return mapping.Collapse(chain[:end])
+}
+
+// nextMapping returns the mapping of an API which applies to the Kubernetes version, or nil
+func nextMapping(api string, mapMetadata *mapping.Metadata, kubeVersion string) *mapping.Mapping {
+	for _, m := range mapMetadata.Mappings {
+		if m.DeprecatedAPI != api {
+			continue
+		}
+		if applies, err := m.AppliesTo(kubeVersion); err == nil && applies {
+			return m
+		}
+	}
+	return nil
+}
+
+// appendFindings appends the findings for the resources of the manifest using the deprecated
+// API of the mapping
+func appendFindings(findings Findings, manifest string, m *mapping.Mapping, action string) Findings {
+	for _, resource := range convert.Resources(manifest, m) {
+		findings = append(findings, Finding{
+			Resource:      resource,
+			DeprecatedAPI: m.DeprecatedAPI,
+			NewAPI:        m.NewAPI,
+			Action:        action,
+		})
+	}
+	return findings
+}
+
+// addWarning adds a conversion warning to the finding of its resource. The findings identify
+// resources by their deprecated API, the warnings by their new API.
+func addWarning(findings Findings, warning convert.Warning) {
+	for i := range findings {
+		resource := findings[i].Resource
+		if resource.Kind == warning.Resource.Kind && resource.Name == warning.Resource.Name && resource.Namespace == warning.Resource.Namespace {
+			findings[i].Warnings = append(findings[i].Warnings, warning.Message)
+			findings[i].Recreate = findings[i].Recreate || warning.Recreate
+			return
+		}
+	}
+}
+
+// FindManifestRemovedAPIs returns the APIs in a release manifest which are removed in the
+// given Kubernetes version, instead of the version of the Kubernetes server
+func FindManifestRemovedAPIs(ctx context.Context, manifest string, provider mapping.MappingProvider, kubeVersion string) ([]MappedAPI, error) {
+	var removedAPIs []MappedAPI
+
+	mapMetadata, err := provider.Mappings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !semver.IsValid(kubeVersion) {
+		return nil, errors.Errorf("Invalid Kubernetes version: %s", kubeVersion)
+	}
+
+	for _, mapping := range mapMetadata.Mappings {
+		if mapping.RemovedInVersion == "" {
+			continue
+		}
+		if !semver.IsValid(mapping.RemovedInVersion) {
+			return nil, errors.Errorf("Failed to get the removed Kubernetes version for API: %s", FlattenAPI(mapping.DeprecatedAPI))
+		}
+		if semver.Compare(mapping.RemovedInVersion, kubeVersion) > 0 {
+			continue
+		}
+		if count := convert.Match(manifest, mapping); count > 0 {
+			removedAPIs = append(removedAPIs, MappedAPI{
+				DeprecatedAPI:       mapping.DeprecatedAPI,
+				NewAPI:              mapping.NewAPI,
+				DeprecatedInVersion: mapping.DeprecatedInVersion,
+				RemovedInVersion:    mapping.RemovedInVersion,
+				Count:               count,
+			})
+		}
+	}
+
+	return removedAPIs, nil
+}
+
+// FlattenAPI returns a single line representation of a mapping API string
+func FlattenAPI(api string) string {
+	return strings.Join(strings.Fields(api), " ")
+}
+
+// GetKubernetesServerVersion returns the version of the Kubernetes server, or the Kubernetes
+// version of the kube config settings if set
+func GetKubernetesServerVersion(ctx context.Context, kubeConfig KubeConfig) (string, error) {
+	if kubeConfig.KubeVersion != "" {
+		if !semver.IsValid(kubeConfig.KubeVersion) {
+			return "", errors.Errorf("Invalid Kubernetes version: %s", kubeConfig.KubeVersion)
+		}
+		return kubeConfig.KubeVersion, nil
+	}
+	clientSet, err := ClientSet(kubeConfig)
+	if err != nil {
+		return "", err
+	}
+	var kubeVersion *version.Info
+	err = Retry(ctx, kubeConfig, func() error {
+		kubeVersion, err = clientSet.Discovery().ServerVersion()
+		return err
+	})
 	if err != nil {
 		return "", errors.Wrap(err, "kubernetes cluster unreachable")
 	}
+	if !semver.IsValid(kubeVersion.GitVersion) {
+		return "", errors.Errorf("Failed to get Kubernetes server version")
+	}
 	return kubeVersion.GitVersion, nil
 }
@@ -0,0 +1,211 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// benchmarkManifestSize is the size of the manifest of the benchmarks, that of a large release
+const benchmarkManifestSize = 5 << 20
+
+// benchmarkDocuments are the documents of a chart rendered with deprecated and supported APIs,
+// repeated with distinct names in the manifest of the benchmarks
+var benchmarkDocuments = []string{`# Source: web/templates/deployment.yaml
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web-%[1]d
+  labels:
+    app.kubernetes.io/name: web
+    app.kubernetes.io/instance: web-%[1]d
+spec:
+  replicas: 2
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: web
+        app.kubernetes.io/instance: web-%[1]d
+    spec:
+      containers:
+      - name: web
+        image: "nginx:1.25"
+        ports:
+        - name: http
+          containerPort: 80
+        resources:
+          limits:
+            cpu: 500m
+            memory: 128Mi
+`, `# Source: web/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: web-%[1]d
+spec:
+  type: ClusterIP
+  ports:
+  - port: 80
+    targetPort: http
+    name: http
+  selector:
+    app.kubernetes.io/instance: web-%[1]d
+`, `# Source: web/templates/ingress.yaml
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web-%[1]d
+  annotations:
+    kubernetes.io/ingress.class: nginx
+spec:
+  tls:
+  - hosts:
+    - web-%[1]d.example.com
+    secretName: web-%[1]d-tls
+  rules:
+  - host: web-%[1]d.example.com
+    http:
+      paths:
+      - path: /
+        backend:
+          serviceName: web-%[1]d
+          servicePort: http
+`, `# Source: web/templates/cronjob.yaml
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: web-%[1]d-backup
+spec:
+  schedule: "0 3 * * *"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          restartPolicy: OnFailure
+          containers:
+          - name: backup
+            image: busybox
+            args: ["sh", "-c", "date"]
+`, `# Source: web/templates/pdb.yaml
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: web-%[1]d
+spec:
+  minAvailable: 1
+  selector:
+    matchLabels:
+      app.kubernetes.io/instance: web-%[1]d
+`, `# Source: web/templates/hpa.yaml
+apiVersion: autoscaling/v2beta1
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web-%[1]d
+spec:
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: web-%[1]d
+  minReplicas: 2
+  maxReplicas: 10
+  metrics:
+  - type: Resource
+    resource:
+      name: cpu
+      targetAverageUtilization: 80
+`, `# Source: web/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web-%[1]d
+data:
+  nginx.conf: |
+    server {
+      listen 80;
+      location / {
+        root /usr/share/nginx/html;
+      }
+    }
+`}
+
+// benchmarkManifest returns a manifest of about benchmarkManifestSize bytes
+func benchmarkManifest() string {
+	var b strings.Builder
+	for i := 0; b.Len() < benchmarkManifestSize; i++ {
+		for _, doc := range benchmarkDocuments {
+			b.WriteString("---\n")
+			fmt.Fprintf(&b, doc, i)
+		}
+	}
+	return b.String()
+}
+
+// benchmarkMappings returns the mappings of the embedded mapping file
+func benchmarkMappings(b *testing.B) *mapping.Metadata {
+	mapMetadata, err := (&mapping.EmbeddedProvider{}).Mappings(context.Background())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return mapMetadata
+}
+
+// BenchmarkMapManifest benchmarks the decoding, mapping and encoding of a large manifest
+func BenchmarkMapManifest(b *testing.B) {
+	manifest := benchmarkManifest()
+	mapMetadata := benchmarkMappings(b)
+	logger := log.New(io.Discard, "", 0)
+	b.SetBytes(int64(len(manifest)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := mapManifest(context.Background(), manifest, mapMetadata, "v1.25.0", nil, false, logger); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSplitManifest benchmarks the decoding of a large manifest into its documents
+func BenchmarkSplitManifest(b *testing.B) {
+	manifest := benchmarkManifest()
+	b.SetBytes(int64(len(manifest)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		convert.Split(manifest)
+	}
+}
+
+// BenchmarkObjects benchmarks the decoding of the resources of a large manifest
+func BenchmarkObjects(b *testing.B) {
+	manifest := benchmarkManifest()
+	b.SetBytes(int64(len(manifest)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := convert.Objects(manifest); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	batchv1 "k8s.io/api/batch/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// renderChart returns the manifest of the chart rendered as by helm template
+func renderChart(t *testing.T, path string) string {
+	t.Helper()
+	chrt, err := loader.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	install := action.NewInstall(new(action.Configuration))
+	install.ClientOnly = true
+	install.DryRun = true
+	install.Replace = true
+	install.ReleaseName = "nightly"
+	install.Namespace = "default"
+	rel, err := install.Run(chrt, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rel.Manifest
+}
+
+func TestMapManifestCronJob(t *testing.T) {
+	manifest := renderChart(t, "testdata/cronjob")
+	mapMetadata, err := (&mapping.EmbeddedProvider{}).Mappings(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := mapManifest(context.Background(), manifest, mapMetadata, "v1.25.0", nil, false, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.MappedAPIs) != 1 || result.MappedAPIs[0].Count != 1 ||
+		FlattenAPI(result.MappedAPIs[0].DeprecatedAPI) != "apiVersion: batch/v1beta1 kind: CronJob" || FlattenAPI(result.MappedAPIs[0].NewAPI) != "apiVersion: batch/v1 kind: CronJob" {
+		t.Fatalf("expected 1 batch/v1beta1 CronJob mapped to batch/v1, got %+v", result.MappedAPIs)
+	}
+
+	// batch/v1 CronJob has the fields of batch/v1beta1 CronJob, so only the apiVersion changes
+	// as the schedule has no time zone
+	var got, want map[string]interface{}
+	if err := yaml.Unmarshal([]byte(result.Manifest), &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte(strings.Replace(manifest, "apiVersion: batch/v1beta1\n", "apiVersion: batch/v1\n", 1)), &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mapped manifest:\n%s\nexpected:\n%s", result.Manifest, manifest)
+	}
+
+	var cronJob batchv1.CronJob
+	if err := yaml.UnmarshalStrict([]byte(result.Manifest), &cronJob); err != nil {
+		t.Fatalf("mapped CronJob is not a valid batch/v1 CronJob: %s", err)
+	}
+	spec := cronJob.Spec
+	switch {
+	case cronJob.APIVersion != "batch/v1" || cronJob.Name != "nightly-cronjob":
+		t.Errorf("unexpected CronJob %s %s", cronJob.APIVersion, cronJob.Name)
+	case spec.Schedule != "*/5 * * * *" || spec.ConcurrencyPolicy != batchv1.ForbidConcurrent:
+		t.Errorf("unexpected schedule %q or concurrency policy %q", spec.Schedule, spec.ConcurrencyPolicy)
+	case spec.StartingDeadlineSeconds == nil || *spec.StartingDeadlineSeconds != 120:
+		t.Errorf("unexpected starting deadline %v", spec.StartingDeadlineSeconds)
+	case spec.SuccessfulJobsHistoryLimit == nil || *spec.SuccessfulJobsHistoryLimit != 3 || spec.FailedJobsHistoryLimit == nil || *spec.FailedJobsHistoryLimit != 1:
+		t.Errorf("unexpected history limits %v, %v", spec.SuccessfulJobsHistoryLimit, spec.FailedJobsHistoryLimit)
+	case spec.JobTemplate.Spec.BackoffLimit == nil || *spec.JobTemplate.Spec.BackoffLimit != 2:
+		t.Errorf("unexpected backoff limit %v", spec.JobTemplate.Spec.BackoffLimit)
+	case len(spec.JobTemplate.Spec.Template.Spec.Containers) != 1 || spec.JobTemplate.Spec.Template.Spec.Containers[0].Image != "busybox:1.36":
+		t.Errorf("unexpected containers %+v", spec.JobTemplate.Spec.Template.Spec.Containers)
+	}
+}
+
+func TestMapManifestPrefixLogger(t *testing.T) {
+	manifest := renderChart(t, "testdata/cronjob")
+	mapMetadata, err := (&mapping.EmbeddedProvider{}).Mappings(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	logger := PrefixLogger(log.New(&out, "", 0), "default/nightly: ")
+	if _, err := mapManifest(context.Background(), manifest, mapMetadata, "v1.25.0", nil, false, logger); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "default/nightly: Found 1 instances of deprecated or removed Kubernetes API:") {
+		t.Errorf("unexpected messages:\n%s", out.String())
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// ConfigMapProvider provides the mapping data of a key of a ConfigMap
+type ConfigMapProvider struct {
+	KubeConfig KubeConfig
+	Namespace  string
+	Name       string
+	Key        string
+}
+
+// Source returns the source of the mapping data, configmap://<namespace>/<name>/<key>
+func (p *ConfigMapProvider) Source() string {
+	return fmt.Sprintf("configmap://%s/%s/%s", p.Namespace, p.Name, p.Key)
+}
+
+// Mappings loads the mapping data of the ConfigMap key
+func (p *ConfigMapProvider) Mappings(ctx context.Context) (*mapping.Metadata, error) {
+	configMap, err := p.configMap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := configMap.Data[p.Key]
+	if !ok {
+		return nil, errors.Errorf("Failed to find mapping file key '%s' in ConfigMap '%s/%s'", p.Key, p.Namespace, p.Name)
+	}
+	mapMetadata, err := mapping.LoadMapdata([]byte(data), p.Source())
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to load mapping file: %s", p.Source())
+	}
+	return mapMetadata, nil
+}
+
+// Revision returns the resource version of the ConfigMap
+func (p *ConfigMapProvider) Revision(ctx context.Context) (string, error) {
+	configMap, err := p.configMap(ctx)
+	if err != nil {
+		return "", err
+	}
+	return configMap.ResourceVersion, nil
+}
+
+// configMap gets the ConfigMap of the mapping data
+func (p *ConfigMapProvider) configMap(ctx context.Context) (*corev1.ConfigMap, error) {
+	clientSet, err := ClientSet(p.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	configMap, err := clientSet.CoreV1().ConfigMaps(p.Namespace).Get(ctx, p.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get mapping file ConfigMap '%s/%s'", p.Namespace, p.Name)
+	}
+	return configMap, nil
+}
@@ -0,0 +1,144 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensions "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// crdDeprecatedInVersion is the Kubernetes version set on the mappings of custom resources. The
+// deprecation of a custom resource version does not depend on the Kubernetes version, so the
+// mappings apply from apiextensions.k8s.io/v1, which serves the CRD versions.
+const crdDeprecatedInVersion = "v1.16"
+
+// CRDProvider provides the mappings of a provider, and the mappings of the custom resource
+// versions which are deprecated or no longer served by the CRDs of the cluster. The mappings
+// of the provider take precedence over the mappings of the CRDs.
+type CRDProvider struct {
+	Provider   mapping.MappingProvider
+	KubeConfig KubeConfig
+}
+
+// Mappings returns the mappings of the provider and of the CRDs
+func (p *CRDProvider) Mappings(ctx context.Context) (*mapping.Metadata, error) {
+	mapMetadata, err := p.Provider.Mappings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	crdMappings, err := CRDMappings(ctx, p.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	metadata := *mapMetadata
+	metadata.Mappings = append([]*mapping.Mapping(nil), mapMetadata.Mappings...)
+	for _, m := range crdMappings {
+		gvk, err := mapping.ParseAPI(m.DeprecatedAPI)
+		if err != nil {
+			return nil, err
+		}
+		if len(mapMetadata.Lookup(gvk.GroupVersion().String(), gvk.Kind)) > 0 {
+			continue
+		}
+		metadata.Mappings = append(metadata.Mappings, m)
+	}
+	return &metadata, nil
+}
+
+// CRDMappings returns the mappings of the custom resource versions which are deprecated or no
+// longer served by the CRDs of the cluster. A version is mapped to the storage version of its
+// CRD, or to the first version which is served and not deprecated if the storage version is
+// itself deprecated or not served.
+func CRDMappings(ctx context.Context, kubeConfig KubeConfig) ([]*mapping.Mapping, error) {
+	client, err := apiextensionsClient(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	crds, err := client.ApiextensionsV1().CustomResourceDefinitions().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list the custom resource definitions")
+	}
+
+	var mappings []*mapping.Mapping
+	for _, crd := range crds.Items {
+		target := crdTargetVersion(crd.Spec.Versions)
+		if target == "" {
+			continue
+		}
+		group, kind := crd.Spec.Group, crd.Spec.Names.Kind
+		for _, version := range crd.Spec.Versions {
+			if version.Name == target || (version.Served && !version.Deprecated) {
+				continue
+			}
+			notes := fmt.Sprintf("Version %s of the custom resource %s is no longer served by its CustomResourceDefinition %s.", version.Name, kind, crd.Name)
+			if version.Served {
+				notes = fmt.Sprintf("Version %s of the custom resource %s is deprecated by its CustomResourceDefinition %s.", version.Name, kind, crd.Name)
+				if version.DeprecationWarning != nil {
+					notes = *version.DeprecationWarning
+				}
+			}
+			mappings = append(mappings, &mapping.Mapping{
+				DeprecatedAPI:       crdAPI(group, version.Name, kind),
+				NewAPI:              crdAPI(group, target, kind),
+				DeprecatedInVersion: crdDeprecatedInVersion,
+				Notes:               notes,
+			})
+		}
+	}
+	return mappings, nil
+}
+
+// apiextensionsClient returns the client of the CRDs of the cluster
+func apiextensionsClient(kubeConfig KubeConfig) (apiextensions.Interface, error) {
+	restConfig, err := RESTClientGetter(kubeConfig).ToRESTConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Kubernetes client configuration")
+	}
+	client, err := apiextensions.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Kubernetes client")
+	}
+	return client, nil
+}
+
+// crdTargetVersion returns the version the deprecated versions of a CRD are mapped to, or an
+// empty string if all its versions are deprecated or not served
+func crdTargetVersion(versions []apiextensionsv1.CustomResourceDefinitionVersion) string {
+	for _, version := range versions {
+		if version.Storage && version.Served && !version.Deprecated {
+			return version.Name
+		}
+	}
+	for _, version := range versions {
+		if version.Served && !version.Deprecated {
+			return version.Name
+		}
+	}
+	return ""
+}
+
+// crdAPI returns the mapping API string of a custom resource version
+func crdAPI(group, version, kind string) string {
+	return fmt.Sprintf("apiVersion: %s/%s\nkind: %s\n", group, version, kind)
+}
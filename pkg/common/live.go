@@ -0,0 +1,125 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+)
+
+// FindLiveObjects returns the resources among the objects which still exist in the cluster,
+// under any API version serving their kind. Namespaced resources without namespace are looked
+// up in the namespace.
+func FindLiveObjects(kubeConfig KubeConfig, objects []map[string]interface{}, namespace string) ([]convert.Resource, error) {
+	if len(objects) == 0 {
+		return nil, nil
+	}
+	client, resourceLists, err := dynamicClient(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var live []convert.Resource
+	for _, obj := range objects {
+		resource := convert.ObjectResource(obj)
+		if resource.Name == "" {
+			continue
+		}
+		liveObj, err := getLiveObject(client, resourceLists, resource, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if liveObj != nil {
+			live = append(live, resource)
+		}
+	}
+	return live, nil
+}
+
+// dynamicClient returns the dynamic client of the cluster and its discovered resources
+func dynamicClient(kubeConfig KubeConfig) (dynamic.Interface, []*metav1.APIResourceList, error) {
+	getter := RESTClientGetter(kubeConfig)
+	restConfig, err := getter.ToRESTConfig()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get Kubernetes client configuration")
+	}
+	discoveryClient, err := getter.ToDiscoveryClient()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create Kubernetes discovery client")
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create Kubernetes client")
+	}
+	// Discovery fails partially if an aggregated API is unavailable, the other APIs are used
+	resourceLists, err := discoveryClient.ServerPreferredResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, nil, errors.Wrap(err, "failed to discover the resources of the cluster")
+	}
+	return client, resourceLists, nil
+}
+
+// getLiveObject returns the live object of a resource under the first of the discovered
+// resources of its kind it exists under, or nil if it does not exist in the cluster
+func getLiveObject(client dynamic.Interface, resourceLists []*metav1.APIResourceList, resource convert.Resource, namespace string) (*unstructured.Unstructured, error) {
+	for _, list := range resourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, apiResource := range list.APIResources {
+			if apiResource.Kind != resource.Kind || !hasVerb(apiResource, "get") {
+				continue
+			}
+			var ri dynamic.ResourceInterface = client.Resource(gv.WithResource(apiResource.Name))
+			if apiResource.Namespaced {
+				ns := resource.Namespace
+				if ns == "" {
+					ns = namespace
+				}
+				ri = client.Resource(gv.WithResource(apiResource.Name)).Namespace(ns)
+			}
+			obj, err := ri.Get(context.Background(), resource.Name, metav1.GetOptions{})
+			switch {
+			case err == nil:
+				return obj, nil
+			case !apierrors.IsNotFound(err):
+				return nil, errors.Wrapf(err, "failed to get %s '%s'", resource.Kind, resource.Name)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// hasVerb returns true if the API resource supports the verb
+func hasVerb(resource metav1.APIResource, verb string) bool {
+	for _, v := range resource.Verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
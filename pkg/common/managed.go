@@ -0,0 +1,223 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+)
+
+// GitOps controllers reconciling releases
+const (
+	ControllerArgoCD = "Argo CD"
+	ControllerFlux   = "Flux"
+)
+
+const (
+	// ArgoCDTrackingIDAnnotation is the annotation Argo CD tracks the objects of an
+	// Application by with annotation tracking, of value <application>:<group>/<kind>:<namespace>/<name>
+	ArgoCDTrackingIDAnnotation = "argocd.argoproj.io/tracking-id"
+
+	// ArgoCDInstanceLabel is the label Argo CD is commonly configured to track the objects of
+	// an Application by with label tracking, of value the name of the Application. The
+	// default tracking label, app.kubernetes.io/instance, is not used as Helm charts set it
+	// to the release name.
+	ArgoCDInstanceLabel = "argocd.argoproj.io/instance"
+
+	// FluxNameLabel is the label the Flux helm-controller sets on the objects of a release to
+	// the name of its HelmRelease
+	FluxNameLabel = "helm.toolkit.fluxcd.io/name"
+
+	// FluxNamespaceLabel is the label the Flux helm-controller sets on the objects of a
+	// release to the namespace of its HelmRelease
+	FluxNamespaceLabel = "helm.toolkit.fluxcd.io/namespace"
+
+	// fluxGroup is the API group of the Flux HelmRelease
+	fluxGroup = "helm.toolkit.fluxcd.io"
+)
+
+// ManagedBy describes the GitOps controller reconciling a release, which reverts the changes
+// made to the release outside of its source
+type ManagedBy struct {
+	// Controller is the name of the GitOps controller, one of: Argo CD, Flux
+	Controller string `json:"controller"`
+
+	// APIVersion of the object of the controller the release is reconciled from, empty if
+	// not known
+	APIVersion string `json:"apiVersion,omitempty"`
+
+	// Kind of the object of the controller the release is reconciled from, e.g. Application
+	Kind string `json:"kind"`
+
+	// Name of the object of the controller the release is reconciled from
+	Name string `json:"name"`
+
+	// Namespace of the object of the controller the release is reconciled from, empty if
+	// not known
+	Namespace string `json:"namespace,omitempty"`
+}
+
+func (m *ManagedBy) String() string {
+	if m.Namespace == "" {
+		return fmt.Sprintf("%s %s '%s'", m.Controller, m.Kind, m.Name)
+	}
+	return fmt.Sprintf("%s %s '%s/%s'", m.Controller, m.Kind, m.Namespace, m.Name)
+}
+
+// FindManagedBy returns the GitOps controller reconciling a release, or nil if none is found.
+// It is found from the labels and annotations of the storage object of the release, if any,
+// the Flux HelmReleases of the cluster, and the labels and annotations of the live objects of
+// the resources of the release manifest. Namespaced resources without namespace are looked up
+// in the namespace of the release.
+func FindManagedBy(ctx context.Context, kubeConfig KubeConfig, storageObject metav1.Object, releaseName, namespace, manifest string) (*ManagedBy, error) {
+	if storageObject != nil {
+		if managedBy := objectManagedBy(storageObject); managedBy != nil {
+			return managedBy, nil
+		}
+	}
+	client, resourceLists, err := dynamicClient(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	helmReleases, hasHelmReleases := discoveredResource(resourceLists, fluxGroup, "HelmRelease")
+	if hasHelmReleases {
+		managedBy, err := findHelmRelease(ctx, client, helmReleases, releaseName, namespace)
+		if err != nil || managedBy != nil {
+			return managedBy, err
+		}
+	}
+
+	objects, err := convert.Objects(manifest)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objects {
+		resource := convert.ObjectResource(obj)
+		if resource.Name == "" {
+			continue
+		}
+		live, err := getLiveObject(client, resourceLists, resource, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if live == nil {
+			continue
+		}
+		if managedBy := objectManagedBy(live); managedBy != nil {
+			if managedBy.Controller == ControllerFlux && hasHelmReleases {
+				managedBy.APIVersion = helmReleases.GroupVersion().String()
+			}
+			return managedBy, nil
+		}
+	}
+	return nil, nil
+}
+
+// objectManagedBy returns the GitOps controller tracking an object from its labels and
+// annotations, or nil if it is not tracked
+func objectManagedBy(obj metav1.Object) *ManagedBy {
+	if name := obj.GetLabels()[FluxNameLabel]; name != "" {
+		return &ManagedBy{Controller: ControllerFlux, Kind: "HelmRelease", Name: name, Namespace: obj.GetLabels()[FluxNamespaceLabel]}
+	}
+	if app := argoCDApplication(obj); app != "" {
+		managedBy := &ManagedBy{Controller: ControllerArgoCD, Kind: "Application", Name: app}
+		if i := strings.Index(app, "_"); i > 0 {
+			managedBy.Namespace, managedBy.Name = app[:i], app[i+1:]
+		}
+		return managedBy
+	}
+	return nil
+}
+
+// argoCDApplication returns the name of the Argo CD Application tracking an object, or an
+// empty string if it is not tracked by Argo CD. The namespace of an Application outside of the
+// Argo CD namespace is separated from its name by an underscore in the tracking ID.
+func argoCDApplication(obj metav1.Object) string {
+	if id := obj.GetAnnotations()[ArgoCDTrackingIDAnnotation]; id != "" {
+		return strings.SplitN(id, ":", 2)[0]
+	}
+	return obj.GetLabels()[ArgoCDInstanceLabel]
+}
+
+// findHelmRelease returns the Flux HelmRelease of the release, or nil if none is found or the
+// HelmReleases cannot be listed
+func findHelmRelease(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, releaseName, namespace string) (*ManagedBy, error) {
+	list, err := client.Resource(gvr).List(ctx, metav1.ListOptions{})
+	switch {
+	case apierrors.IsForbidden(err) || apierrors.IsNotFound(err):
+		return nil, nil
+	case err != nil:
+		return nil, errors.Wrap(err, "failed to list the Flux HelmReleases")
+	}
+	for _, item := range list.Items {
+		name, storageNamespace := helmReleaseStorage(item)
+		if name == releaseName && storageNamespace == namespace {
+			return &ManagedBy{
+				Controller: ControllerFlux,
+				APIVersion: item.GetAPIVersion(),
+				Kind:       item.GetKind(),
+				Name:       item.GetName(),
+				Namespace:  item.GetNamespace(),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// helmReleaseStorage returns the name and storage namespace of the release of a Flux
+// HelmRelease, as defaulted by the helm-controller
+func helmReleaseStorage(helmRelease unstructured.Unstructured) (string, string) {
+	releaseName, _, _ := unstructured.NestedString(helmRelease.Object, "spec", "releaseName")
+	if releaseName == "" {
+		releaseName = helmRelease.GetName()
+		if targetNamespace, _, _ := unstructured.NestedString(helmRelease.Object, "spec", "targetNamespace"); targetNamespace != "" {
+			releaseName = targetNamespace + "-" + releaseName
+		}
+	}
+	storageNamespace, _, _ := unstructured.NestedString(helmRelease.Object, "spec", "storageNamespace")
+	if storageNamespace == "" {
+		storageNamespace = helmRelease.GetNamespace()
+	}
+	return releaseName, storageNamespace
+}
+
+// discoveredResource returns the resource of the preferred version of a kind among the
+// discovered resources, and false if the kind is not served
+func discoveredResource(resourceLists []*metav1.APIResourceList, group, kind string) (schema.GroupVersionResource, bool) {
+	for _, list := range resourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil || gv.Group != group {
+			continue
+		}
+		for _, apiResource := range list.APIResources {
+			if apiResource.Kind == kind && hasVerb(apiResource, "list") {
+				return gv.WithResource(apiResource.Name), true
+			}
+		}
+	}
+	return schema.GroupVersionResource{}, false
+}
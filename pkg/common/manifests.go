@@ -0,0 +1,73 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// ManifestResult is the result of mapping a manifest stream
+type ManifestResult struct {
+	// Manifest is the manifest stream with the deprecated or removed APIs mapped
+	Manifest string `json:"manifest"`
+
+	// MappedAPIs are the deprecated or removed APIs found, which were mapped
+	MappedAPIs []MappedAPI `json:"mappedAPIs,omitempty"`
+
+	// Findings are the resources found using deprecated or removed APIs, including the
+	// resources skipped as their API does not require mapping in the Kubernetes version
+	Findings Findings `json:"findings,omitempty"`
+
+	// Removed are the decoded resources removed from the manifest, as their API has no replacement
+	Removed []map[string]interface{} `json:"-"`
+}
+
+// MapManifests maps the deprecated or removed APIs in a multi-document YAML manifest stream
+// to supported APIs for the target Kubernetes version. Unlike mapping a release, neither Helm
+// release storage nor a Kubernetes cluster is accessed.
+func MapManifests(ctx context.Context, r io.Reader, provider mapping.MappingProvider, kubeVersion string, logger Logger) (*ManifestResult, error) {
+	var manifest strings.Builder
+	result, err := MapManifestStream(ctx, r, &manifest, provider, kubeVersion, logger)
+	if err != nil {
+		return nil, err
+	}
+	result.Manifest = manifest.String()
+	return result, nil
+}
+
+// MapManifestStream is like MapManifests, but writes the manifest stream with its APIs mapped
+// to w one document at a time as it is read, so that memory is proportional to the largest
+// document of the stream. The manifest of the result is empty. If it fails, the documents
+// mapped before the failure were already written.
+func MapManifestStream(ctx context.Context, r io.Reader, w io.Writer, provider mapping.MappingProvider, kubeVersion string, logger Logger) (*ManifestResult, error) {
+	if !semver.IsValid(kubeVersion) {
+		return nil, errors.Errorf("Invalid Kubernetes version: %s", kubeVersion)
+	}
+	mapMetadata, err := provider.Mappings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mapDocuments(ctx, convert.NewDocumentReader(r), w, mapMetadata, kubeVersion, nil, false, logger)
+}
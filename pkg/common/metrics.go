@@ -0,0 +1,103 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// deprecatedAPIsMetric is the API server metric which reports the deprecated APIs being requested
+const deprecatedAPIsMetric = "apiserver_requested_deprecated_apis"
+
+// RequestedDeprecatedAPIs is the set of deprecated APIs being requested from the Kubernetes API server
+type RequestedDeprecatedAPIs map[schema.GroupVersionKind]bool
+
+// IsRequested returns true if the API of a mapping string is being requested
+func (r RequestedDeprecatedAPIs) IsRequested(api string) bool {
+	gvk, err := mapping.ParseAPI(api)
+	if err != nil {
+		return false
+	}
+	return r[gvk]
+}
+
+// GetRequestedDeprecatedAPIs queries the Kubernetes API server metrics for the deprecated APIs
+// which have been requested since the API server started
+func GetRequestedDeprecatedAPIs(kubeConfig KubeConfig) (RequestedDeprecatedAPIs, error) {
+	clientSet, err := ClientSet(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := clientSet.Discovery().RESTClient().Get().AbsPath("/metrics").DoRaw(context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Kubernetes API server metrics")
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse Kubernetes API server metrics")
+	}
+
+	requested := RequestedDeprecatedAPIs{}
+	kindsByGroupVersion := map[schema.GroupVersion]map[string]string{}
+	family, ok := families[deprecatedAPIsMetric]
+	if !ok {
+		return requested, nil
+	}
+	for _, metric := range family.GetMetric() {
+		if metric.GetGauge().GetValue() == 0 {
+			continue
+		}
+		labels := map[string]string{}
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["subresource"] != "" {
+			continue
+		}
+		gv := schema.GroupVersion{Group: labels["group"], Version: labels["version"]}
+		if _, ok := kindsByGroupVersion[gv]; !ok {
+			kindsByGroupVersion[gv] = resourceKinds(gv, clientSet.Discovery())
+		}
+		if kind, ok := kindsByGroupVersion[gv][labels["resource"]]; ok {
+			requested[gv.WithKind(kind)] = true
+		}
+	}
+	return requested, nil
+}
+
+// resourceKinds returns the kinds of the resources served for a group version, keyed by resource name
+func resourceKinds(gv schema.GroupVersion, client discovery.DiscoveryInterface) map[string]string {
+	kinds := map[string]string{}
+	resources, err := client.ServerResourcesForGroupVersion(gv.String())
+	if err != nil {
+		// A deprecated API which is no longer served cannot be requested
+		return kinds
+	}
+	for _, resource := range resources.APIResources {
+		kinds[resource.Name] = resource.Kind
+	}
+	return kinds
+}
@@ -0,0 +1,196 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// DefaultRetryBackoff is the default delay before the first retry of a request failing with
+// a transient error
+const DefaultRetryBackoff = time.Second
+
+// IsTransient returns true if an error of a request to the Kubernetes API server is transient,
+// i.e. the request may succeed if it is retried: timeouts, throttling, unavailable servers,
+// internal errors such as etcd leader changes, and reset connections
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) || apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err) {
+		return true
+	}
+	if utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err) || utilnet.IsConnectionRefused(err) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return strings.Contains(err.Error(), "etcdserver: leader changed")
+}
+
+// Retry calls the function, and retries it with an exponential backoff while it fails with a
+// transient error, up to the number of retries of the kube config settings. The delay suggested
+// by the API server, e.g. on throttling, is honored if it is longer than the backoff. The
+// retries stop when the context is done, with the error of the last call.
+func Retry(ctx context.Context, kubeConfig KubeConfig, fn func() error) error {
+	backoff := kubeConfig.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if attempt >= kubeConfig.Retries || !IsTransient(err) {
+			return err
+		}
+		delay := backoff
+		if seconds, ok := apierrors.SuggestsClientDelay(err); ok && time.Duration(seconds)*time.Second > delay {
+			delay = time.Duration(seconds) * time.Second
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// RetryStorage returns the release storage with its operations retried on transient errors
+// according to the kube config settings, until the context is done. As writes are not
+// idempotent, a write failing with a transient error may have been applied by the server: it is
+// only retried if the release version read back from storage shows it was not applied.
+func RetryStorage(ctx context.Context, storage ReleaseStorage, kubeConfig KubeConfig) ReleaseStorage {
+	if kubeConfig.Retries <= 0 {
+		return storage
+	}
+	return &retryStorage{ctx: ctx, storage: storage, kubeConfig: kubeConfig}
+}
+
+// retryStorage is a release storage retrying the operations of a storage on transient errors
+type retryStorage struct {
+	ctx        context.Context
+	storage    ReleaseStorage
+	kubeConfig KubeConfig
+}
+
+// Get gets a release version, retried on transient errors
+func (s *retryStorage) Get(name string, version int) (rls *release.Release, err error) {
+	err = Retry(s.ctx, s.kubeConfig, func() error {
+		rls, err = s.storage.Get(name, version)
+		return err
+	})
+	return rls, err
+}
+
+// Last gets the latest release version, retried on transient errors
+func (s *retryStorage) Last(name string) (rls *release.Release, err error) {
+	err = Retry(s.ctx, s.kubeConfig, func() error {
+		rls, err = s.storage.Last(name)
+		return err
+	})
+	return rls, err
+}
+
+// ListReleases lists the release versions, retried on transient errors
+func (s *retryStorage) ListReleases() (releases []*release.Release, err error) {
+	err = Retry(s.ctx, s.kubeConfig, func() error {
+		releases, err = s.storage.ListReleases()
+		return err
+	})
+	return releases, err
+}
+
+// Update updates a release version. On a transient error, the update may have been applied by
+// the server, so it is only retried if the release version read back from storage differs from
+// the release version updated, and succeeds otherwise.
+func (s *retryStorage) Update(rls *release.Release) error {
+	retried := false
+	return Retry(s.ctx, s.kubeConfig, func() error {
+		if retried && s.stored(rls) {
+			return nil
+		}
+		retried = true
+		return s.storage.Update(rls)
+	})
+}
+
+// Create adds a release version. On a transient error, the release version may have been created
+// by the server, so it is only retried if the release version is not found in storage. A retry
+// failing because the release version exists succeeds if the release version stored is the
+// release version created.
+func (s *retryStorage) Create(rls *release.Release) error {
+	retried := false
+	return Retry(s.ctx, s.kubeConfig, func() error {
+		if retried && s.stored(rls) {
+			return nil
+		}
+		err := s.storage.Create(rls)
+		if retried && errors.Is(err, driver.ErrReleaseExists) && s.stored(rls) {
+			return nil
+		}
+		retried = true
+		return err
+	})
+}
+
+// Delete deletes a release version. On a transient error, the release version may have been
+// deleted by the server, so a retry failing because the release version is not found succeeds.
+func (s *retryStorage) Delete(name string, version int) (rls *release.Release, err error) {
+	retried := false
+	err = Retry(s.ctx, s.kubeConfig, func() error {
+		rls, err = s.storage.Delete(name, version)
+		if retried && errors.Is(err, driver.ErrReleaseNotFound) {
+			return nil
+		}
+		retried = true
+		return err
+	})
+	return rls, err
+}
+
+// stored returns true if the release version read from storage is the release version written,
+// i.e. with the same status and manifest. A failure to read the release version is treated as
+// the release version not being stored, so that the write is retried.
+func (s *retryStorage) stored(rls *release.Release) bool {
+	stored, err := s.storage.Get(rls.Name, rls.Version)
+	if err != nil || stored == nil {
+		return false
+	}
+	return stored.Manifest == rls.Manifest && statusOf(stored) == statusOf(rls)
+}
+
+// statusOf returns the status of a release version, empty if it has no info
+func statusOf(rls *release.Release) release.Status {
+	if rls.Info == nil {
+		return ""
+	}
+	return rls.Info.Status
+}
@@ -0,0 +1,92 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// timeoutStorage is a release storage whose writes are applied, but fail with a timeout the
+// first time, as when the response of the API server is lost
+type timeoutStorage struct {
+	*storage.Storage
+	writes int
+}
+
+func (s *timeoutStorage) Create(rls *release.Release) error {
+	s.writes++
+	if err := s.Storage.Create(rls); err != nil || s.writes > 1 {
+		return err
+	}
+	return apierrors.NewTimeoutError("request timed out", 0)
+}
+
+func (s *timeoutStorage) Update(rls *release.Release) error {
+	s.writes++
+	if err := s.Storage.Update(rls); err != nil || s.writes > 1 {
+		return err
+	}
+	return apierrors.NewTimeoutError("request timed out", 0)
+}
+
+func TestRetryStorageWriteApplied(t *testing.T) {
+	kubeConfig := KubeConfig{Retries: 3, RetryBackoff: time.Millisecond}
+	rls := &release.Release{Name: "web", Namespace: "default", Version: 1, Manifest: "kind: Service\n", Info: &release.Info{Status: release.StatusDeployed}}
+
+	s := &timeoutStorage{Storage: storage.Init(driver.NewMemory())}
+	if err := RetryStorage(context.Background(), s, kubeConfig).Create(rls); err != nil {
+		t.Fatalf("expected the create applied by the server to succeed, got: %s", err)
+	}
+	if s.writes != 1 {
+		t.Errorf("expected the create applied by the server not to be retried, got %d writes", s.writes)
+	}
+
+	s.writes = 0
+	superseded := *rls
+	superseded.Info = &release.Info{Status: release.StatusSuperseded}
+	if err := RetryStorage(context.Background(), s, kubeConfig).Update(&superseded); err != nil {
+		t.Fatalf("expected the update applied by the server to succeed, got: %s", err)
+	}
+	if s.writes != 1 {
+		t.Errorf("expected the update applied by the server not to be retried, got %d writes", s.writes)
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	transient := apierrors.NewServiceUnavailable("unavailable")
+	start := time.Now()
+	err := Retry(ctx, KubeConfig{Retries: 3, RetryBackoff: time.Hour}, func() error {
+		calls++
+		return transient
+	})
+	if err != transient {
+		t.Errorf("expected the error of the last call, got: %v", err)
+	}
+	if calls != 1 || time.Since(start) > time.Minute {
+		t.Errorf("expected the retries to stop once the context is done, got %d calls", calls)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// ServedAPIs reports whether APIs are served by the Kubernetes cluster, using discovery. It is
+// safe for concurrent use.
+type ServedAPIs struct {
+	client discovery.DiscoveryInterface
+
+	mu    sync.Mutex
+	kinds map[schema.GroupVersion]map[string]bool
+}
+
+// GetServedAPIs returns the APIs served by the Kubernetes cluster. The APIs are discovered
+// per group version when they are first queried.
+func GetServedAPIs(kubeConfig KubeConfig) (*ServedAPIs, error) {
+	clientSet, err := ClientSet(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &ServedAPIs{client: clientSet.Discovery(), kinds: map[schema.GroupVersion]map[string]bool{}}, nil
+}
+
+// IsServed returns true if the API of a mapping string is served by the cluster
+func (s *ServedAPIs) IsServed(api string) (bool, error) {
+	gvk, err := mapping.ParseAPI(api)
+	if err != nil {
+		return false, err
+	}
+	gv := gvk.GroupVersion()
+	// The lock is held while a group version is discovered, so that it is discovered once
+	// when the manifests of several releases are mapped concurrently
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kinds, ok := s.kinds[gv]
+	if !ok {
+		kinds = map[string]bool{}
+		resources, err := s.client.ServerResourcesForGroupVersion(gv.String())
+		if err != nil && !apierrors.IsNotFound(err) {
+			return false, errors.Wrapf(err, "failed to discover the resources of API %s", gv)
+		}
+		if resources != nil {
+			for _, resource := range resources.APIResources {
+				kinds[resource.Kind] = true
+			}
+		}
+		s.kinds[gv] = kinds
+	}
+	return kinds[gvk.Kind], nil
+}
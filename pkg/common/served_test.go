@@ -0,0 +1,58 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"sync"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestServedAPIsConcurrent(t *testing.T) {
+	fake := &k8stesting.Fake{Resources: []*metav1.APIResourceList{{
+		GroupVersion: "batch/v1",
+		APIResources: []metav1.APIResource{{Name: "cronjobs", Kind: "CronJob"}},
+	}}}
+	served := &ServedAPIs{client: &fakediscovery.FakeDiscovery{Fake: fake}, kinds: map[schema.GroupVersion]map[string]bool{}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, api := range []string{"apiVersion: batch/v1\nkind: CronJob\n", "apiVersion: policy/v1\nkind: PodDisruptionBudget\n"} {
+				ok, err := served.IsServed(api)
+				if err != nil {
+					t.Error(err)
+				}
+				if want := api == "apiVersion: batch/v1\nkind: CronJob\n"; ok != want {
+					t.Errorf("expected served %t for %q, got %t", want, api, ok)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Each group version is discovered once
+	if len(fake.Actions()) != 2 {
+		t.Errorf("expected 2 discovery requests, got %d", len(fake.Actions()))
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"helm.sh/helm/v3/pkg/release"
+)
+
+// ReleaseStorage is the storage releases are checked and mapped in. It is satisfied by the
+// Helm release storage, *storage.Storage, which is used if no storage is set. Alternative
+// backends, e.g. custom secret layouts, external stores or test fakes, can be plugged in by
+// implementing it.
+type ReleaseStorage interface {
+	// Get returns a version of the release
+	Get(name string, version int) (*release.Release, error)
+
+	// Last returns the latest version of the release
+	Last(name string) (*release.Release, error)
+
+	// ListReleases returns all versions of all releases
+	ListReleases() ([]*release.Release, error)
+
+	// Update updates a release version
+	Update(rls *release.Release) error
+
+	// Create adds a new release version
+	Create(rls *release.Release) error
+
+	// Delete deletes a release version, and returns it
+	Delete(name string, version int) (*release.Release, error)
+}
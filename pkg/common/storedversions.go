@@ -0,0 +1,177 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// migrateListLimit is the number of custom resources listed per request when migrating them
+const migrateListLimit = 500
+
+// StoredVersions describes the versions a CRD has persisted its custom resources in
+type StoredVersions struct {
+	// CRD is the name of the CustomResourceDefinition
+	CRD string `json:"crd"`
+
+	// StorageVersion is the version the custom resources are currently persisted in
+	StorageVersion string `json:"storageVersion"`
+
+	// StoredVersions are the versions listed in status.storedVersions of the CRD
+	StoredVersions []string `json:"storedVersions"`
+}
+
+// Stale returns the stored versions other than the storage version. Custom resources may
+// still be persisted in these versions, so they cannot be removed from the CRD.
+func (s StoredVersions) Stale() []string {
+	var stale []string
+	for _, version := range s.StoredVersions {
+		if version != s.StorageVersion {
+			stale = append(stale, version)
+		}
+	}
+	return stale
+}
+
+// FindStaleStoredVersions returns the CRDs whose stored versions include versions other than
+// their storage version. All the CRDs of the cluster are checked if no names are passed.
+func FindStaleStoredVersions(ctx context.Context, kubeConfig KubeConfig, names []string) ([]StoredVersions, error) {
+	client, err := apiextensionsClient(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	var crds []apiextensionsv1.CustomResourceDefinition
+	if len(names) == 0 {
+		list, err := client.ApiextensionsV1().CustomResourceDefinitions().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list the custom resource definitions")
+		}
+		crds = list.Items
+	}
+	for _, name := range names {
+		crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get custom resource definition '%s'", name)
+		}
+		crds = append(crds, *crd)
+	}
+
+	var stale []StoredVersions
+	for _, crd := range crds {
+		storedVersions := StoredVersions{
+			CRD:            crd.Name,
+			StorageVersion: crdStorageVersion(&crd),
+			StoredVersions: crd.Status.StoredVersions,
+		}
+		if len(storedVersions.Stale()) > 0 {
+			stale = append(stale, storedVersions)
+		}
+	}
+	return stale, nil
+}
+
+// MigrateStoredVersions rewrites all the custom resources of a CRD so that they are persisted
+// in its storage version, then sets status.storedVersions of the CRD to the storage version
+// only, as kube-storage-version-migrator does. The custom resources are rewritten by updating
+// them unchanged; resources modified or deleted meanwhile are skipped, as they are already
+// persisted in the storage version or gone.
+func MigrateStoredVersions(ctx context.Context, kubeConfig KubeConfig, name string, logger Logger) error {
+	logger = LoggerOrDefault(logger)
+	client, err := apiextensionsClient(kubeConfig)
+	if err != nil {
+		return err
+	}
+	crd, err := client.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get custom resource definition '%s'", name)
+	}
+	storageVersion := crdStorageVersion(crd)
+	if storageVersion == "" {
+		return errors.Errorf("custom resource definition '%s' has no storage version", name)
+	}
+
+	restConfig, err := RESTClientGetter(kubeConfig).ToRESTConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to get Kubernetes client configuration")
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return errors.Wrap(err, "failed to create Kubernetes client")
+	}
+	resource := dynamicClient.Resource(schema.GroupVersionResource{
+		Group:    crd.Spec.Group,
+		Version:  storageVersion,
+		Resource: crd.Spec.Names.Plural,
+	})
+
+	var migrated int
+	opts := metav1.ListOptions{Limit: migrateListLimit}
+	for {
+		list, err := resource.List(ctx, opts)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list the custom resources of '%s'", name)
+		}
+		for i := range list.Items {
+			item := &list.Items[i]
+			var err error
+			if crd.Spec.Scope == apiextensionsv1.NamespaceScoped {
+				_, err = resource.Namespace(item.GetNamespace()).Update(ctx, item, metav1.UpdateOptions{})
+			} else {
+				_, err = resource.Update(ctx, item, metav1.UpdateOptions{})
+			}
+			if err != nil && !apierrors.IsConflict(err) && !apierrors.IsNotFound(err) {
+				return errors.Wrapf(err, "failed to migrate %s '%s'", crd.Spec.Names.Kind, item.GetName())
+			}
+			migrated++
+		}
+		if opts.Continue = list.GetContinue(); opts.Continue == "" {
+			break
+		}
+	}
+	logger.Printf("Migrated %d custom resources of '%s' to storage version %s.\n", migrated, name, storageVersion)
+
+	crd, err = client.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get custom resource definition '%s'", name)
+	}
+	if crdStorageVersion(crd) != storageVersion {
+		return errors.Errorf("storage version of custom resource definition '%s' changed during the migration", name)
+	}
+	crd.Status.StoredVersions = []string{storageVersion}
+	if _, err := client.ApiextensionsV1().CustomResourceDefinitions().UpdateStatus(ctx, crd, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrapf(err, "failed to update the stored versions of custom resource definition '%s'", name)
+	}
+	logger.Printf("Stored versions of '%s' set to %s.\n", name, storageVersion)
+	return nil
+}
+
+// crdStorageVersion returns the storage version of a CRD
+func crdStorageVersion(crd *apiextensionsv1.CustomResourceDefinition) string {
+	for _, version := range crd.Spec.Versions {
+		if version.Storage {
+			return version.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"reflect"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// celObjectVariable is the variable the resource is bound to in CEL expressions
+const celObjectVariable = "object"
+
+// celTransform is a compiled CEL transform of a mapping
+type celTransform struct {
+	keys    []string
+	program cel.Program
+}
+
+// celConverter returns a converter which sets the fields of the resource to the values of the
+// CEL expressions of the transforms. A field is deleted if its expression evaluates to null.
+func celConverter(transforms []mapping.Transform) (ObjectConverter, error) {
+	env, err := cel.NewEnv(cel.Variable(celObjectVariable, cel.DynType))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create CEL environment")
+	}
+	var compiled []celTransform
+	for _, transform := range transforms {
+		keys, err := fieldPath(transform.Field)
+		if err != nil {
+			return nil, err
+		}
+		ast, issues := env.Compile(transform.Expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, errors.Wrapf(issues.Err(), "failed to compile CEL expression of field '%s'", transform.Field)
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to compile CEL expression of field '%s'", transform.Field)
+		}
+		compiled = append(compiled, celTransform{keys: keys, program: program})
+	}
+
+	return func(obj map[string]interface{}) (map[string]interface{}, error) {
+		for i, transform := range compiled {
+			out, _, err := transform.program.Eval(map[string]interface{}{celObjectVariable: obj})
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to evaluate CEL expression of field '%s'", transforms[i].Field)
+			}
+			if out == types.NullValue {
+				deleteField(obj, transform.keys)
+				continue
+			}
+			native, err := out.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to convert the value of CEL expression of field '%s'", transforms[i].Field)
+			}
+			if err := setField(obj, transform.keys, native.(*structpb.Value).AsInterface()); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	}, nil
+}
@@ -0,0 +1,378 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package convert is the conversion engine which matches deprecated or removed Kubernetes
+// APIs in manifests and rewrites them to supported APIs, or removes the resources using APIs
+// without replacement. It has no dependency on Kubernetes clients or Helm, so it can be
+// reused by other tools such as chart linters.
+package convert
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// documentSeparator separates the documents of a multi-document YAML stream
+const documentSeparator = "---"
+
+// Conversion describes the conversion of a deprecated or removed API in a manifest
+type Conversion struct {
+	// Mapping of the API which was converted
+	Mapping *mapping.Mapping
+
+	// Count is the number of instances of the API found
+	Count int
+
+	// Removed is true if the resources using the API were removed, as it has no replacement
+	Removed bool
+
+	// Warnings about the changes of behavior of the resources caused by the conversion
+	Warnings []Warning
+}
+
+// Warning describes a change of behavior of a resource caused by its conversion
+type Warning struct {
+	// Resource whose behavior changed
+	Resource Resource `json:"resource"`
+
+	// Message describing the change of behavior
+	Message string `json:"message"`
+
+	// Recreate is true if the conversion implies a change of an immutable field of the live
+	// resource, so that the resource must be recreated on the next upgrade of the release
+	Recreate bool `json:"recreate,omitempty"`
+}
+
+// Resource identifies a resource of a manifest
+type Resource struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+// Resources returns the resources of the manifest which use the deprecated API of the mapping
+func Resources(manifest string, m *mapping.Mapping) []Resource {
+	var resources []Resource
+	for _, doc := range Split(manifest) {
+		if Match(doc, m) == 0 {
+			continue
+		}
+		if resource, ok := scanResource(doc); ok {
+			resources = append(resources, resource)
+			continue
+		}
+		var object struct {
+			APIVersion string `yaml:"apiVersion"`
+			Kind       string `yaml:"kind"`
+			Metadata   struct {
+				Name      string `yaml:"name"`
+				Namespace string `yaml:"namespace"`
+			} `yaml:"metadata"`
+		}
+		// A document which fails to parse is still reported, identified by the mapping API
+		if err := yamlv3.Unmarshal([]byte(doc), &object); err != nil || object.Kind == "" {
+			if gvk, err := mapping.ParseAPI(m.DeprecatedAPI); err == nil {
+				object.APIVersion, object.Kind = gvk.GroupVersion().String(), gvk.Kind
+			}
+		}
+		resources = append(resources, Resource{
+			APIVersion: object.APIVersion,
+			Kind:       object.Kind,
+			Name:       object.Metadata.Name,
+			Namespace:  object.Metadata.Namespace,
+		})
+	}
+	return resources
+}
+
+// ObjectResource returns the identity of a decoded resource
+func ObjectResource(obj map[string]interface{}) Resource {
+	var resource Resource
+	resource.APIVersion, _ = obj["apiVersion"].(string)
+	resource.Kind, _ = obj["kind"].(string)
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		resource.Name, _ = metadata["name"].(string)
+		resource.Namespace, _ = metadata["namespace"].(string)
+	}
+	return resource
+}
+
+// Objects returns the decoded resources of the manifest, skipping empty documents
+func Objects(manifest string) ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+	for _, doc := range Split(manifest) {
+		obj := map[string]interface{}{}
+		if err := yamlv3.Unmarshal([]byte(doc), &obj); err != nil {
+			return nil, errors.Wrap(err, "failed to decode resource")
+		}
+		if len(obj) > 0 {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+// Match returns the number of instances of the deprecated API of the mapping in the manifest
+func Match(manifest string, m *mapping.Mapping) int {
+	return strings.Count(manifest, m.DeprecatedAPI)
+}
+
+// Rewrite returns the manifest with the instances of the deprecated API of the mapping
+// replaced by the new API, and the number of instances replaced
+func Rewrite(manifest string, m *mapping.Mapping) (string, int) {
+	count := Match(manifest, m)
+	if count == 0 {
+		return manifest, 0
+	}
+	return strings.ReplaceAll(manifest, m.DeprecatedAPI, m.NewAPI), count
+}
+
+// Remove returns the manifest without the documents using the deprecated API of the mapping,
+// and the number of instances removed
+func Remove(manifest string, m *mapping.Mapping) (string, int) {
+	var count int
+	var kept []string
+	for _, doc := range Split(manifest) {
+		if n := Match(doc, m); n > 0 {
+			count += n
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	if count == 0 {
+		return manifest, 0
+	}
+	return Join(kept), count
+}
+
+// Comment returns the manifest with the documents using the deprecated API of the mapping
+// commented out, and the number of instances commented out. The separator and comment lines
+// heading the documents are kept, and a comment line explaining the removal is added.
+func Comment(manifest string, m *mapping.Mapping) (string, int) {
+	var count int
+	docs := Split(manifest)
+	for i, doc := range docs {
+		n := Match(doc, m)
+		if n == 0 {
+			continue
+		}
+		count += n
+		header, body := splitHeader(doc)
+		var b strings.Builder
+		b.WriteString(header)
+		b.WriteString("# Removed by helm-mapkubeapis, the API has no replacement: " + strings.Join(strings.Fields(m.DeprecatedAPI), " ") + "\n")
+		for _, line := range strings.SplitAfter(body, "\n") {
+			switch {
+			case line == "":
+			case strings.TrimSpace(line) == "":
+				b.WriteString("#\n")
+			default:
+				b.WriteString("# " + line)
+			}
+		}
+		if !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+		docs[i] = b.String()
+	}
+	if count == 0 {
+		return manifest, 0
+	}
+	return Join(docs), count
+}
+
+// Apply converts the deprecated API of the mapping in the manifest. The API is rewritten to
+// the new API, or the resources using it are removed, or commented out if the CommentRemoved
+// setting carried by the context is set, if the mapping has no new API. The
+// object converters of the mapping are then applied to the resources which were rewritten,
+// and the checks of the mapping return the warnings of the conversion. The object converters
+// running a script are canceled when the context is done.
+func Apply(ctx context.Context, manifest string, m *mapping.Mapping) (string, Conversion, error) {
+	applier, err := NewApplier(ctx, m)
+	if err != nil {
+		return "", Conversion{Mapping: m, Removed: m.NewAPI == ""}, err
+	}
+	return applier.Apply(manifest)
+}
+
+// Applier applies the conversion of the deprecated API of a mapping, see Apply. The object
+// converters, template and checks of the mapping are prepared once, so that an applier is
+// reused for the documents of a manifest instead of preparing them for each document.
+type Applier struct {
+	mapping    *mapping.Mapping
+	settings   Settings
+	converters []ObjectConverter
+	tmpl       *template.Template
+	checks     []ConversionCheck
+}
+
+// NewApplier returns the applier of the conversion of the deprecated API of the mapping, with
+// the settings of the built-in conversions carried by the context, see WithSettings. Its
+// object converters running a script are canceled when the context is done.
+func NewApplier(ctx context.Context, m *mapping.Mapping) (*Applier, error) {
+	applier := &Applier{mapping: m, settings: SettingsFromContext(ctx)}
+	if m.NewAPI == "" {
+		return applier, nil
+	}
+	var err error
+	if applier.converters, err = objectConverters(ctx, m, applier.settings); err != nil {
+		return nil, err
+	}
+	if applier.tmpl, err = parseTemplate(m); err != nil {
+		return nil, err
+	}
+	applier.checks = objectChecks(m)
+	return applier, nil
+}
+
+// Apply converts the deprecated API of the mapping of the applier in the manifest
+func (a *Applier) Apply(manifest string) (string, Conversion, error) {
+	m := a.mapping
+	conversion := Conversion{Mapping: m, Removed: m.NewAPI == ""}
+	if conversion.Removed {
+		if a.settings.CommentRemoved {
+			manifest, conversion.Count = Comment(manifest, m)
+		} else {
+			manifest, conversion.Count = Remove(manifest, m)
+		}
+		return manifest, conversion, nil
+	}
+
+	if len(a.converters) == 0 && a.tmpl == nil && len(a.checks) == 0 {
+		manifest, conversion.Count = Rewrite(manifest, m)
+		return manifest, conversion, nil
+	}
+
+	docs := Split(manifest)
+	for i, original := range docs {
+		doc, count := Rewrite(original, m)
+		if count == 0 {
+			continue
+		}
+		docConverters := a.converters
+		var originalObj map[string]interface{}
+		if a.tmpl != nil || len(a.checks) > 0 {
+			originalObj = map[string]interface{}{}
+			if err := yamlv3.Unmarshal([]byte(original), &originalObj); err != nil {
+				return "", conversion, errors.Wrap(err, "failed to decode resource")
+			}
+		}
+		if a.tmpl != nil {
+			docConverters = append(docConverters[:len(docConverters):len(docConverters)], templateConverter(a.tmpl, originalObj))
+		}
+		var obj map[string]interface{}
+		if len(docConverters) > 0 {
+			var err error
+			if docs[i], obj, err = convertObject(doc, docConverters); err != nil {
+				return "", conversion, err
+			}
+		} else {
+			docs[i] = doc
+			obj = map[string]interface{}{}
+			if err := yamlv3.Unmarshal([]byte(doc), &obj); err != nil {
+				return "", conversion, errors.Wrap(err, "failed to decode resource")
+			}
+		}
+		for _, check := range a.checks {
+			for _, warning := range check(originalObj, obj) {
+				warning.Resource = ObjectResource(obj)
+				conversion.Warnings = append(conversion.Warnings, warning)
+			}
+		}
+		conversion.Count += count
+	}
+	return Join(docs), conversion, nil
+}
+
+// Split splits a multi-document YAML stream into its documents. Each document after the first
+// starts with its separator line, so that Join(Split(manifest)) == manifest.
+func Split(manifest string) []string {
+	var docs []string
+	start := 0
+	for offset := 0; offset < len(manifest); {
+		end := strings.IndexByte(manifest[offset:], '\n')
+		if end < 0 {
+			end = len(manifest)
+		} else {
+			end += offset + 1
+		}
+		if offset > start && isSeparator(manifest[offset:end]) {
+			docs = append(docs, manifest[start:offset])
+			start = offset
+		}
+		offset = end
+	}
+	return append(docs, manifest[start:])
+}
+
+// DocumentReader reads the documents of a multi-document YAML stream one at a time, as split
+// by Split, so that a stream can be processed with memory proportional to its largest document
+type DocumentReader struct {
+	r    *bufio.Reader
+	next string
+	err  error
+}
+
+// NewDocumentReader returns the reader of the documents of the YAML stream
+func NewDocumentReader(r io.Reader) *DocumentReader {
+	return &DocumentReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next document of the stream, or io.EOF once all the documents were read.
+// Each document after the first starts with its separator line.
+func (d *DocumentReader) Next() (string, error) {
+	if d.err != nil {
+		return "", d.err
+	}
+	var b strings.Builder
+	b.WriteString(d.next)
+	d.next = ""
+	for {
+		line, err := d.r.ReadString('\n')
+		if b.Len() > 0 && isSeparator(line) {
+			d.next = line
+			return b.String(), nil
+		}
+		b.WriteString(line)
+		if err != nil {
+			d.err = err
+			if err == io.EOF && b.Len() > 0 {
+				return b.String(), nil
+			}
+			return "", err
+		}
+	}
+}
+
+// Join joins documents split by Split into a multi-document YAML stream
+func Join(docs []string) string {
+	return strings.Join(docs, "")
+}
+
+// isSeparator returns true if the line is a YAML document separator
+func isSeparator(line string) bool {
+	line = strings.TrimRight(line, " \t\r\n")
+	return line == documentSeparator || strings.HasPrefix(line, documentSeparator+" ")
+}
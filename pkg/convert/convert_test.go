@@ -0,0 +1,56 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// testMapping returns the mapping of the deprecated API of a kind to the new API
+func testMapping(kind, deprecatedAPIVersion, newAPIVersion string) *mapping.Mapping {
+	return &mapping.Mapping{
+		DeprecatedAPI: "apiVersion: " + deprecatedAPIVersion + "\nkind: " + kind + "\n",
+		NewAPI:        "apiVersion: " + newAPIVersion + "\nkind: " + kind + "\n",
+	}
+}
+
+// assertApply applies the mapping to the manifest with the settings, and checks that the
+// mapped manifest decodes to the same resource as want, and returns the conversion
+func assertApply(t *testing.T, m *mapping.Mapping, s Settings, manifest, want string) Conversion {
+	t.Helper()
+	mapped, conversion, err := Apply(WithSettings(context.Background(), s), manifest, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, expected map[string]interface{}
+	if err := yamlv3.Unmarshal([]byte(mapped), &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := yamlv3.Unmarshal([]byte(want), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(normalizeNumbers(got), normalizeNumbers(expected)) {
+		t.Errorf("mapped manifest:\n%s\nexpected:\n%s", mapped, want)
+	}
+	return conversion
+}
@@ -0,0 +1,211 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func init() {
+	Register(schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1beta1", Kind: "CustomResourceDefinition"}, convertCRD)
+}
+
+// convertCRD converts the spec of a CustomResourceDefinition mapped to apiextensions.k8s.io/v1,
+// which only supports per-version schemas, subresources and printer columns, and requires a
+// structural schema for each version
+func convertCRD(obj map[string]interface{}) (map[string]interface{}, error) {
+	if obj["apiVersion"] != "apiextensions.k8s.io/v1" {
+		return obj, nil
+	}
+	spec, ok := obj["spec"].(map[string]interface{})
+	if !ok {
+		return obj, nil
+	}
+
+	versions, _ := spec["versions"].([]interface{})
+	if name, ok := spec["version"]; ok {
+		if len(versions) == 0 {
+			versions = []interface{}{map[string]interface{}{"name": name, "served": true, "storage": true}}
+		}
+		delete(spec, "version")
+	}
+
+	// The top-level validation, subresources and printer columns apply to all the versions
+	validation, _ := spec["validation"].(map[string]interface{})
+	subresources, hasSubresources := spec["subresources"]
+	columns, hasColumns := spec["additionalPrinterColumns"]
+	delete(spec, "validation")
+	delete(spec, "subresources")
+	delete(spec, "additionalPrinterColumns")
+
+	// preserveUnknownFields defaults to true in v1beta1 and can only be false in v1, where
+	// unknown fields are preserved by the schema instead
+	preserveUnknownFields := spec["preserveUnknownFields"] != false
+	delete(spec, "preserveUnknownFields")
+
+	for _, version := range versions {
+		v, ok := version.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := v["schema"]; !ok && validation != nil {
+			v["schema"] = deepCopyValue(validation)
+		}
+		if _, ok := v["subresources"]; !ok && hasSubresources {
+			v["subresources"] = deepCopyValue(subresources)
+		}
+		if _, ok := v["additionalPrinterColumns"]; !ok && hasColumns {
+			v["additionalPrinterColumns"] = deepCopyValue(columns)
+		}
+		convertCRDPrinterColumns(v)
+		convertCRDSchema(v, preserveUnknownFields)
+	}
+	if versions != nil {
+		spec["versions"] = versions
+	}
+
+	if conversion, ok := spec["conversion"].(map[string]interface{}); ok {
+		convertCRDConversion(conversion)
+	}
+	return obj, nil
+}
+
+// convertCRDSchema sets the structural schema required by v1 on a CRD version. A version
+// without schema accepts any object.
+func convertCRDSchema(version map[string]interface{}, preserveUnknownFields bool) {
+	s, _ := version["schema"].(map[string]interface{})
+	if s == nil {
+		s = map[string]interface{}{}
+		version["schema"] = s
+	}
+	root, _ := s["openAPIV3Schema"].(map[string]interface{})
+	if root == nil {
+		root = map[string]interface{}{}
+		s["openAPIV3Schema"] = root
+		preserveUnknownFields = true
+	}
+	if _, ok := root["type"]; !ok {
+		root["type"] = "object"
+	}
+	structuralSchema(root, preserveUnknownFields)
+}
+
+// structuralSchema sets the type of the nodes of a CRD schema nested in its properties,
+// additionalProperties and items, which a structural schema requires: object if the node has
+// properties or additionalProperties, array if it has items. A node whose type cannot be
+// inferred accepts any value, as in v1beta1. If unknown fields are preserved, as by default
+// in v1beta1, they are preserved in every object node, as v1 prunes the unknown fields of the
+// nested objects otherwise.
+func structuralSchema(node map[string]interface{}, preserveUnknownFields bool) {
+	_, hasType := node["type"]
+	_, hasProperties := node["properties"]
+	_, hasAdditionalProperties := node["additionalProperties"].(map[string]interface{})
+	_, hasItems := node["items"]
+	switch {
+	case hasType || node["x-kubernetes-int-or-string"] == true:
+	case hasProperties || hasAdditionalProperties:
+		node["type"] = "object"
+	case hasItems:
+		node["type"] = "array"
+	default:
+		node["x-kubernetes-preserve-unknown-fields"] = true
+	}
+	if preserveUnknownFields && node["type"] == "object" {
+		node["x-kubernetes-preserve-unknown-fields"] = true
+	}
+
+	var children []interface{}
+	if properties, ok := node["properties"].(map[string]interface{}); ok {
+		for _, property := range properties {
+			children = append(children, property)
+		}
+	}
+	children = append(children, node["additionalProperties"])
+	if items, ok := node["items"].([]interface{}); ok {
+		children = append(children, items...)
+	} else {
+		children = append(children, node["items"])
+	}
+	for _, child := range children {
+		if c, ok := child.(map[string]interface{}); ok {
+			structuralSchema(c, preserveUnknownFields)
+		}
+	}
+}
+
+// convertCRDPrinterColumns renames the JSONPath field of the printer columns of a CRD version
+// to jsonPath
+func convertCRDPrinterColumns(version map[string]interface{}) {
+	columns, _ := version["additionalPrinterColumns"].([]interface{})
+	for _, column := range columns {
+		c, ok := column.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if path, ok := c["JSONPath"]; ok {
+			delete(c, "JSONPath")
+			c["jsonPath"] = path
+		}
+	}
+}
+
+// convertCRDConversion moves the webhook client config and conversion review versions of a
+// CRD conversion to the webhook object. The conversion review versions default to v1beta1,
+// as in v1beta1, since v1 requires them.
+func convertCRDConversion(conversion map[string]interface{}) {
+	clientConfig, hasClientConfig := conversion["webhookClientConfig"]
+	reviewVersions, hasReviewVersions := conversion["conversionReviewVersions"]
+	delete(conversion, "webhookClientConfig")
+	delete(conversion, "conversionReviewVersions")
+	if conversion["strategy"] != "Webhook" {
+		return
+	}
+	webhook, _ := conversion["webhook"].(map[string]interface{})
+	if webhook == nil {
+		webhook = map[string]interface{}{}
+		conversion["webhook"] = webhook
+	}
+	if _, ok := webhook["clientConfig"]; !ok && hasClientConfig {
+		webhook["clientConfig"] = clientConfig
+	}
+	if _, ok := webhook["conversionReviewVersions"]; !ok {
+		if !hasReviewVersions {
+			reviewVersions = []interface{}{"v1beta1"}
+		}
+		webhook["conversionReviewVersions"] = reviewVersions
+	}
+}
+
+// deepCopyValue returns a deep copy of a decoded value, so that it can be set on several fields
+// without them sharing objects
+func deepCopyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			c[key] = deepCopyValue(value)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, value := range v {
+			c[i] = deepCopyValue(value)
+		}
+		return c
+	}
+	return value
+}
@@ -0,0 +1,211 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"testing"
+)
+
+func TestConvertCRD(t *testing.T) {
+	m := testMapping("CustomResourceDefinition", "apiextensions.k8s.io/v1beta1", "apiextensions.k8s.io/v1")
+	tests := []struct {
+		name     string
+		manifest string
+		want     string
+	}{
+		{
+			name: "nested non-structural schema",
+			manifest: `apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: backups.example.com
+spec:
+  group: example.com
+  names:
+    kind: Backup
+    plural: backups
+  scope: Namespaced
+  version: v1
+  preserveUnknownFields: false
+  validation:
+    openAPIV3Schema:
+      properties:
+        spec:
+          properties:
+            schedule:
+              type: string
+            retention:
+              properties:
+                days:
+                  type: integer
+            targets:
+              items:
+                properties:
+                  name:
+                    type: string
+            labels:
+              additionalProperties:
+                type: string
+            port:
+              x-kubernetes-int-or-string: true
+            config: {}
+`,
+			want: `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: backups.example.com
+spec:
+  group: example.com
+  names:
+    kind: Backup
+    plural: backups
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            properties:
+              schedule:
+                type: string
+              retention:
+                type: object
+                properties:
+                  days:
+                    type: integer
+              targets:
+                type: array
+                items:
+                  type: object
+                  properties:
+                    name:
+                      type: string
+              labels:
+                type: object
+                additionalProperties:
+                  type: string
+              port:
+                x-kubernetes-int-or-string: true
+              config:
+                x-kubernetes-preserve-unknown-fields: true
+`,
+		},
+		{
+			name: "unknown fields preserved",
+			manifest: `apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: backups.example.com
+spec:
+  group: example.com
+  names:
+    kind: Backup
+    plural: backups
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+  validation:
+    openAPIV3Schema:
+      properties:
+        spec:
+          properties:
+            schedule:
+              type: string
+`,
+			want: `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: backups.example.com
+spec:
+  group: example.com
+  names:
+    kind: Backup
+    plural: backups
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        x-kubernetes-preserve-unknown-fields: true
+        properties:
+          spec:
+            type: object
+            x-kubernetes-preserve-unknown-fields: true
+            properties:
+              schedule:
+                type: string
+`,
+		},
+		{
+			name: "without schema",
+			manifest: `apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: backups.example.com
+spec:
+  group: example.com
+  names:
+    kind: Backup
+    plural: backups
+  scope: Namespaced
+  version: v1
+  additionalPrinterColumns:
+  - name: Schedule
+    type: string
+    JSONPath: .spec.schedule
+`,
+			want: `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: backups.example.com
+spec:
+  group: example.com
+  names:
+    kind: Backup
+    plural: backups
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    additionalPrinterColumns:
+    - name: Schedule
+      type: string
+      jsonPath: .spec.schedule
+    schema:
+      openAPIV3Schema:
+        type: object
+        x-kubernetes-preserve-unknown-fields: true
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertApply(t, m, DefaultSettings(), tt.manifest, tt.want)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func init() {
+	Register(schema.GroupVersionKind{Group: "batch", Version: "v1beta1", Kind: "CronJob"}, convertCronJob)
+}
+
+// convertCronJob converts the spec of a CronJob mapped to batch/v1. The spec of batch/v1 is the
+// same as batch/v1beta1, with the same defaults, but batch/v1 rejects the CRON_TZ or TZ time
+// zone prefix of the schedule, which is moved to spec.timeZone unless it is already set.
+func convertCronJob(obj map[string]interface{}) (map[string]interface{}, error) {
+	if obj["apiVersion"] != "batch/v1" {
+		return obj, nil
+	}
+	spec, ok := obj["spec"].(map[string]interface{})
+	if !ok {
+		return obj, nil
+	}
+	if _, ok := spec["timeZone"]; ok {
+		return obj, nil
+	}
+	schedule, _ := spec["schedule"].(string)
+	if timeZone, rest, ok := scheduleTimeZone(schedule); ok {
+		spec["schedule"] = rest
+		spec["timeZone"] = timeZone
+	}
+	return obj, nil
+}
+
+// scheduleTimeZone splits the CRON_TZ=<zone> or TZ=<zone> prefix of a cron schedule into the
+// time zone and the rest of the schedule
+func scheduleTimeZone(schedule string) (string, string, bool) {
+	schedule = strings.TrimSpace(schedule)
+	for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+		if !strings.HasPrefix(schedule, prefix) {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimPrefix(schedule, prefix), " ", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return "", "", false
+		}
+		return fields[0], strings.TrimSpace(fields[1]), true
+	}
+	return "", "", false
+}
@@ -0,0 +1,137 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"testing"
+)
+
+func TestConvertCronJob(t *testing.T) {
+	m := testMapping("CronJob", "batch/v1beta1", "batch/v1")
+	tests := []struct {
+		name     string
+		manifest string
+		want     string
+	}{
+		{
+			name: "without time zone",
+			manifest: `apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: nightly
+spec:
+  schedule: "0 2 * * *"
+  concurrencyPolicy: Forbid
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          restartPolicy: OnFailure
+`,
+			want: `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: nightly
+spec:
+  schedule: "0 2 * * *"
+  concurrencyPolicy: Forbid
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          restartPolicy: OnFailure
+`,
+		},
+		{
+			name: "CRON_TZ prefix",
+			manifest: `apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: nightly
+spec:
+  schedule: "CRON_TZ=Europe/Paris 0 2 * * *"
+`,
+			want: `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: nightly
+spec:
+  schedule: "0 2 * * *"
+  timeZone: Europe/Paris
+`,
+		},
+		{
+			name: "TZ prefix",
+			manifest: `apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: nightly
+spec:
+  schedule: "TZ=UTC  */5 * * * *"
+`,
+			want: `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: nightly
+spec:
+  schedule: "*/5 * * * *"
+  timeZone: UTC
+`,
+		},
+		{
+			name: "time zone already set",
+			manifest: `apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: nightly
+spec:
+  schedule: "TZ=UTC 0 2 * * *"
+  timeZone: Europe/Paris
+`,
+			want: `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: nightly
+spec:
+  schedule: "TZ=UTC 0 2 * * *"
+  timeZone: Europe/Paris
+`,
+		},
+		{
+			name: "prefix without schedule",
+			manifest: `apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: nightly
+spec:
+  schedule: "TZ=UTC"
+`,
+			want: `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: nightly
+spec:
+  schedule: "TZ=UTC"
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertApply(t, m, DefaultSettings(), tt.manifest, tt.want)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func init() {
+	registerSettingsConverter(schema.GroupVersionKind{Group: "certificates.k8s.io", Version: "v1beta1", Kind: "CertificateSigningRequest"}, convertCSR)
+}
+
+// convertCSR sets the signerName and usages of a CertificateSigningRequest mapped to
+// certificates.k8s.io/v1, which requires them
+func convertCSR(obj map[string]interface{}, s Settings) (map[string]interface{}, error) {
+	if obj["apiVersion"] != "certificates.k8s.io/v1" {
+		return obj, nil
+	}
+	spec, ok := obj["spec"].(map[string]interface{})
+	if !ok {
+		return obj, nil
+	}
+	// v1 does not allow the legacy-unknown signer, the v1beta1 default
+	switch spec["signerName"] {
+	case nil, "", "kubernetes.io/legacy-unknown":
+		spec["signerName"] = s.CSRSignerName
+	}
+	setDefault(spec, "usages", []interface{}{"digital signature", "key encipherment"})
+	return obj, nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"testing"
+)
+
+func TestConvertCSR(t *testing.T) {
+	m := testMapping("CertificateSigningRequest", "certificates.k8s.io/v1beta1", "certificates.k8s.io/v1")
+	customSigner := DefaultSettings()
+	customSigner.CSRSignerName = "example.com/internal-ca"
+
+	csr := func(apiVersion, spec string) string {
+		return "apiVersion: " + apiVersion + `
+kind: CertificateSigningRequest
+metadata:
+  name: client
+spec:
+  request: LS0tLS1CRUdJTi0tLS0t
+` + spec
+	}
+	tests := []struct {
+		name     string
+		settings Settings
+		spec     string
+		want     string
+	}{
+		{
+			name:     "default signer",
+			settings: DefaultSettings(),
+			want: `  signerName: kubernetes.io/kube-apiserver-client
+  usages:
+  - digital signature
+  - key encipherment
+`,
+		},
+		{
+			name:     "signer setting",
+			settings: customSigner,
+			want: `  signerName: example.com/internal-ca
+  usages:
+  - digital signature
+  - key encipherment
+`,
+		},
+		{
+			name:     "legacy-unknown signer",
+			settings: customSigner,
+			spec: `  signerName: kubernetes.io/legacy-unknown
+`,
+			want: `  signerName: example.com/internal-ca
+  usages:
+  - digital signature
+  - key encipherment
+`,
+		},
+		{
+			name:     "signer and usages kept",
+			settings: customSigner,
+			spec: `  signerName: kubernetes.io/kubelet-serving
+  usages:
+  - server auth
+`,
+			want: `  signerName: kubernetes.io/kubelet-serving
+  usages:
+  - server auth
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertApply(t, m, tt.settings, csr("certificates.k8s.io/v1beta1", tt.spec), csr("certificates.k8s.io/v1", tt.want))
+		})
+	}
+}
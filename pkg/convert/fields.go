@@ -0,0 +1,77 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// fieldPath returns the keys of a dot-separated field path, e.g. spec.defaultBackend
+func fieldPath(path string) ([]string, error) {
+	keys := strings.Split(strings.TrimPrefix(path, "."), ".")
+	for _, key := range keys {
+		if key == "" {
+			return nil, errors.Errorf("invalid field path '%s'", path)
+		}
+	}
+	return keys, nil
+}
+
+// getField returns the value of the field at the path of the object
+func getField(obj map[string]interface{}, keys []string) (interface{}, bool) {
+	var value interface{} = obj
+	for _, key := range keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setField sets the field at the path of the object, creating the parent objects as needed
+func setField(obj map[string]interface{}, keys []string, value interface{}) error {
+	m := obj
+	for i, key := range keys[:len(keys)-1] {
+		child, ok := m[key]
+		if !ok || child == nil {
+			child = map[string]interface{}{}
+			m[key] = child
+		}
+		if m, ok = child.(map[string]interface{}); !ok {
+			return errors.Errorf("field '%s' is not an object", strings.Join(keys[:i+1], "."))
+		}
+	}
+	m[keys[len(keys)-1]] = value
+	return nil
+}
+
+// deleteField deletes the field at the path of the object, if it exists
+func deleteField(obj map[string]interface{}, keys []string) {
+	parent, ok := getField(obj, keys[:len(keys)-1])
+	if !ok {
+		return
+	}
+	if m, ok := parent.(map[string]interface{}); ok {
+		delete(m, keys[len(keys)-1])
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// hpaTargetFields are the v2beta1 target fields of each metric source type, in the order the
+// conversion prefers them when several are set
+var hpaTargetFields = []struct {
+	source string
+	fields []string
+}{
+	{source: "resource", fields: []string{"targetAverageUtilization", "targetAverageValue"}},
+	{source: "pods", fields: []string{"targetAverageValue"}},
+	{source: "object", fields: []string{"averageValue", "targetValue"}},
+	{source: "external", fields: []string{"targetAverageValue", "targetValue"}},
+}
+
+func init() {
+	gvk := schema.GroupVersionKind{Group: "autoscaling", Version: "v2beta1", Kind: "HorizontalPodAutoscaler"}
+	Register(gvk, convertHPA)
+	RegisterConversionCheck(gvk, checkHPATargets)
+}
+
+// convertHPA converts the metrics of a HorizontalPodAutoscaler mapped from autoscaling/v2beta1
+// to autoscaling/v2beta2 or autoscaling/v2, which describe the metric and its target as
+// separate metric and target objects
+func convertHPA(obj map[string]interface{}) (map[string]interface{}, error) {
+	if obj["apiVersion"] != "autoscaling/v2" && obj["apiVersion"] != "autoscaling/v2beta2" {
+		return obj, nil
+	}
+	spec, ok := obj["spec"].(map[string]interface{})
+	if !ok {
+		return obj, nil
+	}
+	metrics, _ := spec["metrics"].([]interface{})
+	for _, metric := range metrics {
+		m, ok := metric.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if source, ok := m["resource"].(map[string]interface{}); ok {
+			convertHPAResourceMetric(source)
+		}
+		if source, ok := m["pods"].(map[string]interface{}); ok {
+			convertHPAMetric(source, "selector")
+			moveHPATarget(source, "targetAverageValue", "AverageValue", "averageValue")
+		}
+		if source, ok := m["object"].(map[string]interface{}); ok {
+			if target, ok := source["target"]; ok {
+				delete(source, "target")
+				source["describedObject"] = target
+			}
+			convertHPAMetric(source, "selector")
+			moveHPATarget(source, "averageValue", "AverageValue", "averageValue")
+			moveHPATarget(source, "targetValue", "Value", "value")
+		}
+		if source, ok := m["external"].(map[string]interface{}); ok {
+			convertHPAMetric(source, "metricSelector")
+			moveHPATarget(source, "targetAverageValue", "AverageValue", "averageValue")
+			moveHPATarget(source, "targetValue", "Value", "value")
+		}
+	}
+	return obj, nil
+}
+
+// convertHPAResourceMetric moves the target utilization or value of a resource metric to its
+// target object
+func convertHPAResourceMetric(source map[string]interface{}) {
+	moveHPATarget(source, "targetAverageUtilization", "Utilization", "averageUtilization")
+	moveHPATarget(source, "targetAverageValue", "AverageValue", "averageValue")
+}
+
+// convertHPAMetric moves the metricName and the selector field of a metric source to its
+// metric object
+func convertHPAMetric(source map[string]interface{}, selectorField string) {
+	name, hasName := source["metricName"]
+	selector, hasSelector := source[selectorField]
+	if !hasName && !hasSelector {
+		return
+	}
+	delete(source, "metricName")
+	delete(source, selectorField)
+	metric := map[string]interface{}{}
+	if hasName {
+		metric["name"] = name
+	}
+	if hasSelector {
+		metric["selector"] = selector
+	}
+	source["metric"] = metric
+}
+
+// moveHPATarget moves a v2beta1 target field of a metric source to the target object, with the
+// target type and field of autoscaling/v2. A target already set is not replaced.
+func moveHPATarget(source map[string]interface{}, field, targetType, targetField string) {
+	value, ok := source[field]
+	if !ok {
+		return
+	}
+	delete(source, field)
+	if _, ok := source["target"].(map[string]interface{}); ok {
+		return
+	}
+	source["target"] = map[string]interface{}{
+		"type":      targetType,
+		targetField: value,
+	}
+}
+
+// checkHPATargets warns about the metrics of a HorizontalPodAutoscaler mapped from
+// autoscaling/v2beta1 which set several targets, as the single target of an autoscaling/v2
+// metric only keeps the first one, e.g. the averageValue of an object metric also setting
+// targetValue
+func checkHPATargets(original, obj map[string]interface{}) []Warning {
+	if obj["apiVersion"] != "autoscaling/v2" && obj["apiVersion"] != "autoscaling/v2beta2" {
+		return nil
+	}
+	spec, _ := original["spec"].(map[string]interface{})
+	metrics, _ := spec["metrics"].([]interface{})
+	var warnings []Warning
+	for i, metric := range metrics {
+		m, ok := metric.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, target := range hpaTargetFields {
+			source, ok := m[target.source].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			var set []string
+			for _, field := range target.fields {
+				if _, ok := source[field]; ok {
+					set = append(set, field)
+				}
+			}
+			if len(set) < 2 {
+				continue
+			}
+			warnings = append(warnings, Warning{Message: fmt.Sprintf("the %s metric %d sets %s, but a metric of %s has a single target; only %s is kept",
+				target.source, i, strings.Join(set, " and "), obj["apiVersion"], set[0])})
+		}
+	}
+	return warnings
+}
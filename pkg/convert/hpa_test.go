@@ -0,0 +1,175 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConvertHPA(t *testing.T) {
+	m := testMapping("HorizontalPodAutoscaler", "autoscaling/v2beta1", "autoscaling/v2")
+	tests := []struct {
+		name     string
+		metric   string
+		want     string
+		warnings []string
+	}{
+		{
+			name: "resource utilization",
+			metric: `resource:
+      name: cpu
+      targetAverageUtilization: 80`,
+			want: `resource:
+      name: cpu
+      target:
+        type: Utilization
+        averageUtilization: 80`,
+		},
+		{
+			name: "resource value",
+			metric: `resource:
+      name: memory
+      targetAverageValue: 512Mi`,
+			want: `resource:
+      name: memory
+      target:
+        type: AverageValue
+        averageValue: 512Mi`,
+		},
+		{
+			name: "pods",
+			metric: `pods:
+      metricName: packets-per-second
+      selector:
+        matchLabels:
+          verb: GET
+      targetAverageValue: 1k`,
+			want: `pods:
+      metric:
+        name: packets-per-second
+        selector:
+          matchLabels:
+            verb: GET
+      target:
+        type: AverageValue
+        averageValue: 1k`,
+		},
+		{
+			name: "object",
+			metric: `object:
+      metricName: requests-per-second
+      target:
+        apiVersion: networking.k8s.io/v1
+        kind: Ingress
+        name: main-route
+      targetValue: 10k`,
+			want: `object:
+      metric:
+        name: requests-per-second
+      describedObject:
+        apiVersion: networking.k8s.io/v1
+        kind: Ingress
+        name: main-route
+      target:
+        type: Value
+        value: 10k`,
+		},
+		{
+			name: "external",
+			metric: `external:
+      metricName: queue_messages_ready
+      metricSelector:
+        matchLabels:
+          queue: worker_tasks
+      targetAverageValue: 30`,
+			want: `external:
+      metric:
+        name: queue_messages_ready
+        selector:
+          matchLabels:
+            queue: worker_tasks
+      target:
+        type: AverageValue
+        averageValue: 30`,
+		},
+		{
+			name: "object with both targets",
+			metric: `object:
+      metricName: requests-per-second
+      target:
+        kind: Service
+        name: web
+      averageValue: 100
+      targetValue: 10k`,
+			want: `object:
+      metric:
+        name: requests-per-second
+      describedObject:
+        kind: Service
+        name: web
+      target:
+        type: AverageValue
+        averageValue: 100`,
+			warnings: []string{"the object metric 0 sets averageValue and targetValue, but a metric of autoscaling/v2 has a single target; only averageValue is kept"},
+		},
+		{
+			name: "external with both targets",
+			metric: `external:
+      metricName: queue_messages_ready
+      targetAverageValue: 30
+      targetValue: 100`,
+			want: `external:
+      metric:
+        name: queue_messages_ready
+      target:
+        type: AverageValue
+        averageValue: 30`,
+			warnings: []string{"the external metric 0 sets targetAverageValue and targetValue"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metricType := map[string]string{"resource": "Resource", "pods": "Pods", "object": "Object", "external": "External"}[strings.SplitN(tt.metric, ":", 2)[0]]
+			hpa := func(apiVersion, metric string) string {
+				return "apiVersion: " + apiVersion + `
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+spec:
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: web
+  minReplicas: 1
+  maxReplicas: 10
+  metrics:
+  - type: ` + metricType + `
+    ` + metric + "\n"
+			}
+			conversion := assertApply(t, m, DefaultSettings(), hpa("autoscaling/v2beta1", tt.metric), hpa("autoscaling/v2", tt.want))
+			if len(conversion.Warnings) != len(tt.warnings) {
+				t.Fatalf("expected %d warnings, got %+v", len(tt.warnings), conversion.Warnings)
+			}
+			for i, want := range tt.warnings {
+				if !strings.Contains(conversion.Warnings[i].Message, want) {
+					t.Errorf("warning %q does not contain %q", conversion.Warnings[i].Message, want)
+				}
+			}
+		})
+	}
+}
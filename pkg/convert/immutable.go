@@ -0,0 +1,58 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func init() {
+	for _, gvk := range []schema.GroupVersionKind{
+		{Group: "extensions", Version: "v1beta1", Kind: "DaemonSet"},
+		{Group: "extensions", Version: "v1beta1", Kind: "Deployment"},
+		{Group: "extensions", Version: "v1beta1", Kind: "ReplicaSet"},
+		{Group: "apps", Version: "v1beta1", Kind: "Deployment"},
+		{Group: "apps", Version: "v1beta1", Kind: "StatefulSet"},
+		{Group: "apps", Version: "v1beta2", Kind: "DaemonSet"},
+		{Group: "apps", Version: "v1beta2", Kind: "Deployment"},
+		{Group: "apps", Version: "v1beta2", Kind: "ReplicaSet"},
+		{Group: "apps", Version: "v1beta2", Kind: "StatefulSet"},
+	} {
+		RegisterCheck(gvk, checkWorkloadSelector)
+	}
+}
+
+// checkWorkloadSelector flags a workload mapped to apps/v1 without selector. The beta APIs
+// defaulted the immutable selector to the labels of the pod template, while apps/v1 requires
+// the selector, so the next upgrade fails unless the chart sets the same selector.
+func checkWorkloadSelector(obj map[string]interface{}) []Warning {
+	if obj["apiVersion"] != "apps/v1" {
+		return nil
+	}
+	spec, ok := obj["spec"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if _, ok := spec["selector"]; ok {
+		return nil
+	}
+	return []Warning{{
+		Message: "apps/v1 requires spec.selector, which is immutable and was defaulted to all the pod template labels by the deprecated API; " +
+			"the next upgrade fails with a field is immutable error unless the chart sets the selector to all the pod template labels",
+		Recreate: true,
+	}}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"testing"
+)
+
+func TestCheckWorkloadSelector(t *testing.T) {
+	workload := func(apiVersion, kind, selector string) string {
+		return "apiVersion: " + apiVersion + `
+kind: ` + kind + `
+metadata:
+  name: web
+spec:
+` + selector + `  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: nginx
+`
+	}
+	selector := `  selector:
+    matchLabels:
+      app: web
+`
+	tests := []struct {
+		name          string
+		kind          string
+		deprecatedAPI string
+		selector      string
+		recreate      bool
+	}{
+		{name: "extensions Deployment without selector", kind: "Deployment", deprecatedAPI: "extensions/v1beta1", recreate: true},
+		{name: "extensions DaemonSet without selector", kind: "DaemonSet", deprecatedAPI: "extensions/v1beta1", recreate: true},
+		{name: "apps/v1beta1 StatefulSet without selector", kind: "StatefulSet", deprecatedAPI: "apps/v1beta1", recreate: true},
+		{name: "apps/v1beta2 ReplicaSet without selector", kind: "ReplicaSet", deprecatedAPI: "apps/v1beta2", recreate: true},
+		{name: "extensions Deployment with selector", kind: "Deployment", deprecatedAPI: "extensions/v1beta1", selector: selector},
+		{name: "apps/v1beta2 StatefulSet with selector", kind: "StatefulSet", deprecatedAPI: "apps/v1beta2", selector: selector},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMapping(tt.kind, tt.deprecatedAPI, "apps/v1")
+			conversion := assertApply(t, m, DefaultSettings(), workload(tt.deprecatedAPI, tt.kind, tt.selector), workload("apps/v1", tt.kind, tt.selector))
+			switch {
+			case !tt.recreate && len(conversion.Warnings) != 0:
+				t.Errorf("expected no warnings, got %+v", conversion.Warnings)
+			case tt.recreate && (len(conversion.Warnings) != 1 || !conversion.Warnings[0].Recreate):
+				t.Errorf("expected 1 warning requiring recreation, got %+v", conversion.Warnings)
+			case tt.recreate && (conversion.Warnings[0].Resource.Kind != tt.kind || conversion.Warnings[0].Resource.Name != "web"):
+				t.Errorf("unexpected resource %+v", conversion.Warnings[0].Resource)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// ingressV1 is the API version of Ingress which the Ingress conversion applies to
+	ingressV1 = "networking.k8s.io/v1"
+
+	// ingressClassAnnotation is the deprecated annotation setting the class of an Ingress
+	ingressClassAnnotation = "kubernetes.io/ingress.class"
+)
+
+func init() {
+	for _, gvk := range []schema.GroupVersionKind{
+		{Group: "extensions", Version: "v1beta1", Kind: "Ingress"},
+		{Group: "networking.k8s.io", Version: "v1beta1", Kind: "Ingress"},
+	} {
+		registerSettingsConverter(gvk, convertIngress)
+	}
+}
+
+// convertIngress converts the spec of an Ingress mapped to networking.k8s.io/v1: spec.backend
+// is renamed to spec.defaultBackend, and the serviceName and servicePort of the backends are
+// moved to service.name and service.port. Paths without pathType get the default pathType.
+// If enabled, the ingress class annotation is moved to
+// spec.ingressClassName.
+func convertIngress(obj map[string]interface{}, s Settings) (map[string]interface{}, error) {
+	if obj["apiVersion"] != ingressV1 {
+		return obj, nil
+	}
+	spec, ok := obj["spec"].(map[string]interface{})
+	if !ok {
+		return obj, nil
+	}
+	if backend, ok := spec["backend"]; ok {
+		delete(spec, "backend")
+		spec["defaultBackend"] = convertIngressBackend(backend)
+	}
+	for _, path := range ingressPaths(spec) {
+		if backend, ok := path["backend"]; ok {
+			path["backend"] = convertIngressBackend(backend)
+		}
+		if _, ok := path["pathType"]; !ok && s.IngressPathType != "" {
+			path["pathType"] = s.IngressPathType
+		}
+	}
+	if s.IngressClassName {
+		convertIngressClass(obj, spec, s.IngressClassNames)
+	}
+	return obj, nil
+}
+
+// convertIngressClass moves the ingress class annotation of an Ingress to spec.ingressClassName,
+// with the class name mapped by the class names. The annotation is kept if ingressClassName is
+// already set.
+func convertIngressClass(obj, spec map[string]interface{}, classNames map[string]string) {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	class, _ := annotations[ingressClassAnnotation].(string)
+	if class == "" {
+		return
+	}
+	if _, ok := spec["ingressClassName"]; ok {
+		return
+	}
+	if name, ok := classNames[class]; ok {
+		class = name
+	}
+	spec["ingressClassName"] = class
+	delete(annotations, ingressClassAnnotation)
+	if len(annotations) == 0 {
+		delete(metadata, "annotations")
+	}
+}
+
+// ingressPaths returns the HTTP paths of the rules of an Ingress spec
+func ingressPaths(spec map[string]interface{}) []map[string]interface{} {
+	var paths []map[string]interface{}
+	rules, _ := spec["rules"].([]interface{})
+	for _, rule := range rules {
+		r, ok := rule.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		http, ok := r["http"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rulePaths, _ := http["paths"].([]interface{})
+		for _, path := range rulePaths {
+			if p, ok := path.(map[string]interface{}); ok {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
+
+// convertIngressBackend converts a v1beta1 Ingress backend to v1. As in the Kubernetes API
+// conversion, a string servicePort is a port name and any other value a port number.
+func convertIngressBackend(backend interface{}) interface{} {
+	b, ok := backend.(map[string]interface{})
+	if !ok {
+		return backend
+	}
+	name, hasName := b["serviceName"]
+	port, hasPort := b["servicePort"]
+	if !hasName && !hasPort {
+		return b
+	}
+	delete(b, "serviceName")
+	delete(b, "servicePort")
+	service := map[string]interface{}{}
+	if hasName {
+		service["name"] = name
+	}
+	if hasPort {
+		if _, isName := port.(string); isName {
+			service["port"] = map[string]interface{}{"name": port}
+		} else {
+			service["port"] = map[string]interface{}{"number": port}
+		}
+	}
+	b["service"] = service
+	return b
+}
@@ -0,0 +1,246 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"testing"
+)
+
+func TestConvertIngress(t *testing.T) {
+	classSettings := DefaultSettings()
+	classSettings.IngressClassName = true
+	classSettings.IngressClassNames = map[string]string{"nginx": "nginx-internal"}
+	noPathType := DefaultSettings()
+	noPathType.IngressPathType = ""
+
+	tests := []struct {
+		name          string
+		deprecatedAPI string
+		settings      Settings
+		manifest      string
+		want          string
+	}{
+		{
+			name:          "backends",
+			deprecatedAPI: "extensions/v1beta1",
+			settings:      DefaultSettings(),
+			manifest: `apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  backend:
+    serviceName: default
+    servicePort: 80
+  rules:
+  - host: example.com
+    http:
+      paths:
+      - path: /
+        backend:
+          serviceName: web
+          servicePort: http
+      - path: /api
+        pathType: Prefix
+        backend:
+          serviceName: api
+          servicePort: 8080
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  defaultBackend:
+    service:
+      name: default
+      port:
+        number: 80
+  rules:
+  - host: example.com
+    http:
+      paths:
+      - path: /
+        pathType: ImplementationSpecific
+        backend:
+          service:
+            name: web
+            port:
+              name: http
+      - path: /api
+        pathType: Prefix
+        backend:
+          service:
+            name: api
+            port:
+              number: 8080
+`,
+		},
+		{
+			name:          "resource backend",
+			deprecatedAPI: "networking.k8s.io/v1beta1",
+			settings:      DefaultSettings(),
+			manifest: `apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  backend:
+    resource:
+      apiGroup: k8s.example.com
+      kind: StorageBucket
+      name: static-assets
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  defaultBackend:
+    resource:
+      apiGroup: k8s.example.com
+      kind: StorageBucket
+      name: static-assets
+`,
+		},
+		{
+			name:          "path type setting",
+			deprecatedAPI: "networking.k8s.io/v1beta1",
+			settings:      noPathType,
+			manifest: `apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  rules:
+  - http:
+      paths:
+      - path: /
+        backend:
+          serviceName: web
+          servicePort: 80
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  rules:
+  - http:
+      paths:
+      - path: /
+        backend:
+          service:
+            name: web
+            port:
+              number: 80
+`,
+		},
+		{
+			name:          "class annotation kept by default",
+			deprecatedAPI: "networking.k8s.io/v1beta1",
+			settings:      DefaultSettings(),
+			manifest: `apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: web
+  annotations:
+    kubernetes.io/ingress.class: nginx
+spec: {}
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+  annotations:
+    kubernetes.io/ingress.class: nginx
+spec: {}
+`,
+		},
+		{
+			name:          "class annotation mapped",
+			deprecatedAPI: "networking.k8s.io/v1beta1",
+			settings:      classSettings,
+			manifest: `apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: web
+  annotations:
+    kubernetes.io/ingress.class: nginx
+spec: {}
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  ingressClassName: nginx-internal
+`,
+		},
+		{
+			name:          "class annotation not mapped",
+			deprecatedAPI: "networking.k8s.io/v1beta1",
+			settings:      classSettings,
+			manifest: `apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: web
+  annotations:
+    kubernetes.io/ingress.class: traefik
+    team: web
+spec: {}
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+  annotations:
+    team: web
+spec:
+  ingressClassName: traefik
+`,
+		},
+		{
+			name:          "class name already set",
+			deprecatedAPI: "networking.k8s.io/v1beta1",
+			settings:      classSettings,
+			manifest: `apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: web
+  annotations:
+    kubernetes.io/ingress.class: nginx
+spec:
+  ingressClassName: public
+`,
+			want: `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+  annotations:
+    kubernetes.io/ingress.class: nginx
+spec:
+  ingressClassName: public
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertApply(t, testMapping("Ingress", tt.deprecatedAPI, "networking.k8s.io/v1"), tt.settings, tt.manifest, tt.want)
+		})
+	}
+}
@@ -0,0 +1,341 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// Patch operations
+const (
+	// PatchSet sets the value of the targeted fields
+	PatchSet = "set"
+
+	// PatchRename moves the value of the targeted fields to another path
+	PatchRename = "rename"
+
+	// PatchDelete deletes the targeted fields
+	PatchDelete = "delete"
+)
+
+// pathSegment is a segment of a JSONPath: a key, an index or a wildcard
+type pathSegment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseJSONPath parses the JSONPath subset supported by patches: the root $, child keys as
+// .key, ['key'] or ["key"], array indexes as [n], and wildcards as .* or [*]
+func parseJSONPath(path string) ([]pathSegment, error) {
+	invalid := errors.Errorf("invalid JSONPath '%s'", path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, invalid
+	}
+	var segments []pathSegment
+	for rest := path[1:]; rest != ""; {
+		switch {
+		case strings.HasPrefix(rest, "."):
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, invalid
+			}
+			if key == "*" {
+				segments = append(segments, pathSegment{wildcard: true})
+			} else {
+				segments = append(segments, pathSegment{key: key})
+			}
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "['"), strings.HasPrefix(rest, "[\""):
+			quote := rest[1:2]
+			end := strings.Index(rest[2:], quote+"]")
+			if end < 0 {
+				return nil, invalid
+			}
+			segments = append(segments, pathSegment{key: rest[2 : end+2]})
+			rest = rest[end+4:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, invalid
+			}
+			if rest[1:end] == "*" {
+				segments = append(segments, pathSegment{wildcard: true})
+			} else {
+				index, err := strconv.Atoi(rest[1:end])
+				if err != nil || index < 0 {
+					return nil, invalid
+				}
+				segments = append(segments, pathSegment{index: index, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, invalid
+		}
+	}
+	if len(segments) == 0 {
+		return nil, errors.Errorf("JSONPath '%s' must target a field", path)
+	}
+	return segments, nil
+}
+
+// matchPaths returns the concrete paths, of string keys and int indexes, matching the segments
+// in the value. The wildcards, indexes and keys of objects must exist, the trailing keys may not.
+func matchPaths(value interface{}, segments []pathSegment, prefix []interface{}) [][]interface{} {
+	if len(segments) == 0 {
+		return [][]interface{}{append([]interface{}{}, prefix...)}
+	}
+	segment, rest := segments[0], segments[1:]
+	var paths [][]interface{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		switch {
+		case segment.wildcard:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				paths = append(paths, matchPaths(v[key], rest, append(prefix, key))...)
+			}
+		case !segment.isIndex:
+			paths = matchPaths(v[segment.key], rest, append(prefix, segment.key))
+		}
+	case []interface{}:
+		switch {
+		case segment.wildcard:
+			for i := range v {
+				paths = append(paths, matchPaths(v[i], rest, append(prefix, i))...)
+			}
+		case segment.isIndex && segment.index < len(v):
+			paths = matchPaths(v[segment.index], rest, append(prefix, segment.index))
+		}
+	case nil:
+		if !segment.wildcard && !segment.isIndex {
+			paths = matchPaths(nil, rest, append(prefix, segment.key))
+		}
+	}
+	return paths
+}
+
+// concretePath returns the segments as a concrete path, with the wildcards replaced in order by
+// the keys or indexes matched by the wildcards of another path
+func concretePath(segments []pathSegment, wildcards []interface{}) ([]interface{}, error) {
+	var path []interface{}
+	for _, segment := range segments {
+		switch {
+		case segment.wildcard:
+			if len(wildcards) == 0 {
+				return nil, errors.New("the target path has more wildcards than the source path")
+			}
+			path = append(path, wildcards[0])
+			wildcards = wildcards[1:]
+		case segment.isIndex:
+			path = append(path, segment.index)
+		default:
+			path = append(path, segment.key)
+		}
+	}
+	return path, nil
+}
+
+// wildcardValues returns the keys or indexes of a concrete path matched by wildcard segments
+func wildcardValues(segments []pathSegment, path []interface{}) []interface{} {
+	var values []interface{}
+	for i, segment := range segments {
+		if segment.wildcard {
+			values = append(values, path[i])
+		}
+	}
+	return values
+}
+
+// getAt returns the value at the concrete path of the object
+func getAt(obj map[string]interface{}, path []interface{}) (interface{}, bool) {
+	var value interface{} = obj
+	for _, p := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			key, ok := p.(string)
+			if !ok {
+				return nil, false
+			}
+			if value, ok = v[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			index, ok := p.(int)
+			if !ok || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setAt sets the value at the concrete path of the object, creating the parent objects as needed
+func setAt(obj map[string]interface{}, path []interface{}, value interface{}) error {
+	var parent interface{} = obj
+	for i, p := range path {
+		last := i == len(path)-1
+		switch v := parent.(type) {
+		case map[string]interface{}:
+			key, ok := p.(string)
+			if !ok {
+				return errors.Errorf("field '%s' is not an array", formatPath(path[:i]))
+			}
+			if last {
+				v[key] = value
+				return nil
+			}
+			child, ok := v[key]
+			if !ok || child == nil {
+				child = map[string]interface{}{}
+				v[key] = child
+			}
+			parent = child
+		case []interface{}:
+			index, ok := p.(int)
+			if !ok || index >= len(v) {
+				return errors.Errorf("field '%s' is not an object", formatPath(path[:i]))
+			}
+			if last {
+				v[index] = value
+				return nil
+			}
+			parent = v[index]
+		default:
+			return errors.Errorf("field '%s' is not an object", formatPath(path[:i]))
+		}
+	}
+	return nil
+}
+
+// deleteAt deletes the field or array element at the concrete path of the object
+func deleteAt(obj map[string]interface{}, path []interface{}) error {
+	parentPath, last := path[:len(path)-1], path[len(path)-1]
+	parent, ok := getAt(obj, parentPath)
+	if !ok {
+		return nil
+	}
+	switch v := parent.(type) {
+	case map[string]interface{}:
+		if key, ok := last.(string); ok {
+			delete(v, key)
+		}
+	case []interface{}:
+		if index, ok := last.(int); ok && index < len(v) {
+			elements := append(append([]interface{}{}, v[:index]...), v[index+1:]...)
+			return setAt(obj, parentPath, elements)
+		}
+	}
+	return nil
+}
+
+// formatPath returns a concrete path as a JSONPath
+func formatPath(path []interface{}) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, p := range path {
+		switch v := p.(type) {
+		case string:
+			b.WriteString("." + v)
+		case int:
+			b.WriteString("[" + strconv.Itoa(v) + "]")
+		}
+	}
+	return b.String()
+}
+
+// patchConverter returns a converter which applies the patches to the resource in order
+func patchConverter(patches []mapping.Patch) (ObjectConverter, error) {
+	type compiledPatch struct {
+		mapping.Patch
+		path []pathSegment
+		to   []pathSegment
+	}
+	var compiled []compiledPatch
+	for _, patch := range patches {
+		path, err := parseJSONPath(patch.Path)
+		if err != nil {
+			return nil, err
+		}
+		c := compiledPatch{Patch: patch, path: path}
+		switch patch.Op {
+		case PatchSet, PatchDelete:
+		case PatchRename:
+			if c.to, err = parseJSONPath(patch.To); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.Errorf("unsupported patch operation '%s', must be one of: %s, %s, %s", patch.Op, PatchSet, PatchRename, PatchDelete)
+		}
+		compiled = append(compiled, c)
+	}
+
+	return func(obj map[string]interface{}) (map[string]interface{}, error) {
+		for _, patch := range compiled {
+			paths := matchPaths(obj, patch.path, nil)
+			// Paths are processed in reverse order so that deleting array elements does not
+			// shift the indexes of the paths still to be processed
+			for i := len(paths) - 1; i >= 0; i-- {
+				path := paths[i]
+				switch patch.Op {
+				case PatchSet:
+					if err := setAt(obj, path, patch.Value); err != nil {
+						return nil, errors.Wrapf(err, "failed to set '%s'", patch.Path)
+					}
+				case PatchDelete:
+					if err := deleteAt(obj, path); err != nil {
+						return nil, errors.Wrapf(err, "failed to delete '%s'", patch.Path)
+					}
+				case PatchRename:
+					value, ok := getAt(obj, path)
+					if !ok {
+						continue
+					}
+					to, err := concretePath(patch.to, wildcardValues(patch.path, path))
+					if err != nil {
+						return nil, errors.Wrapf(err, "failed to rename '%s' to '%s'", patch.Path, patch.To)
+					}
+					if err := deleteAt(obj, path); err != nil {
+						return nil, errors.Wrapf(err, "failed to rename '%s' to '%s'", patch.Path, patch.To)
+					}
+					if err := setAt(obj, to, value); err != nil {
+						return nil, errors.Wrapf(err, "failed to rename '%s' to '%s'", patch.Path, patch.To)
+					}
+				}
+			}
+		}
+		return obj, nil
+	}, nil
+}
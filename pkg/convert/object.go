@@ -0,0 +1,222 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	yamlv2 "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// ObjectConverter converts a decoded resource after its API is mapped, for conversions which
+// need more than replacing the API version, and returns the converted resource
+type ObjectConverter func(obj map[string]interface{}) (map[string]interface{}, error)
+
+// ObjectCheck checks a resource after its API is mapped and converted, and returns warnings
+// about the changes of behavior of the resource caused by the conversion. The resource of the
+// warnings is set by Apply.
+type ObjectCheck func(obj map[string]interface{}) []Warning
+
+// ConversionCheck checks a resource after its API is mapped and converted like ObjectCheck,
+// with the original resource, for the changes of behavior caused by the values replaced
+type ConversionCheck func(original, obj map[string]interface{}) []Warning
+
+// settingsConverter converts a resource like ObjectConverter, with the settings of the built-in
+// conversions
+type settingsConverter func(obj map[string]interface{}, s Settings) (map[string]interface{}, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[schema.GroupVersionKind][]func(Settings) ObjectConverter{}
+	checks     = map[schema.GroupVersionKind][]ConversionCheck{}
+)
+
+// Register registers a converter for the resources using a deprecated API. The converters
+// of an API are applied in the order they are registered, before the patches, the
+// transforms, the script, the converter and the template of the mapping.
+func Register(deprecatedAPI schema.GroupVersionKind, converter ObjectConverter) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[deprecatedAPI] = append(registry[deprecatedAPI], func(Settings) ObjectConverter { return converter })
+}
+
+// registerSettingsConverter registers a built-in converter reading the settings of the
+// applier, see Register
+func registerSettingsConverter(deprecatedAPI schema.GroupVersionKind, converter settingsConverter) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[deprecatedAPI] = append(registry[deprecatedAPI], func(s Settings) ObjectConverter {
+		return func(obj map[string]interface{}) (map[string]interface{}, error) {
+			return converter(obj, s)
+		}
+	})
+}
+
+// RegisterCheck registers a check for the resources using a deprecated API
+func RegisterCheck(deprecatedAPI schema.GroupVersionKind, check ObjectCheck) {
+	RegisterConversionCheck(deprecatedAPI, func(_, obj map[string]interface{}) []Warning {
+		return check(obj)
+	})
+}
+
+// RegisterConversionCheck registers a check for the resources using a deprecated API, which
+// is also passed the resources before their conversion
+func RegisterConversionCheck(deprecatedAPI schema.GroupVersionKind, check ConversionCheck) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	checks[deprecatedAPI] = append(checks[deprecatedAPI], check)
+}
+
+// Converts returns true if the resources using the deprecated API of the mapping are converted
+// beyond the replacement of their API, i.e. by a registered converter or by the patches, the
+// transforms, the script, the converter or the template of the mapping
+func Converts(m *mapping.Mapping) bool {
+	if len(m.Patches) > 0 || len(m.Transforms) > 0 || m.Script != "" || m.Converter != nil || m.Template != "" {
+		return true
+	}
+	gvk, err := mapping.ParseAPI(m.DeprecatedAPI)
+	if err != nil {
+		return false
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return len(registry[gvk]) > 0
+}
+
+// objectChecks returns the checks of the resources using the deprecated API of the mapping
+func objectChecks(m *mapping.Mapping) []ConversionCheck {
+	gvk, err := mapping.ParseAPI(m.DeprecatedAPI)
+	if err != nil {
+		return nil
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return append([]ConversionCheck(nil), checks[gvk]...)
+}
+
+// objectConverters returns the converters applied to the resources using the deprecated API
+// of the mapping, with the built-in converters reading the settings. The converters running a
+// script are canceled when the context is done.
+func objectConverters(ctx context.Context, m *mapping.Mapping, s Settings) ([]ObjectConverter, error) {
+	var converters []ObjectConverter
+	if gvk, err := mapping.ParseAPI(m.DeprecatedAPI); err == nil {
+		registryMu.RLock()
+		for _, converter := range registry[gvk] {
+			converters = append(converters, converter(s))
+		}
+		registryMu.RUnlock()
+	}
+	if len(m.Patches) > 0 {
+		converter, err := patchConverter(m.Patches)
+		if err != nil {
+			return nil, err
+		}
+		converters = append(converters, converter)
+	}
+	if len(m.Transforms) > 0 {
+		converter, err := celConverter(m.Transforms)
+		if err != nil {
+			return nil, err
+		}
+		converters = append(converters, converter)
+	}
+	if m.Script != "" {
+		converter, err := starlarkConverter(ctx, m.Script)
+		if err != nil {
+			return nil, err
+		}
+		converters = append(converters, converter)
+	}
+	if m.Converter != nil {
+		converter, err := loadPluginConverter(m.Converter)
+		if err != nil {
+			return nil, err
+		}
+		converters = append(converters, converter)
+	}
+	return converters, nil
+}
+
+// convertObject decodes the resource of a document, applies the converters and encodes it
+// again, and returns the document and the converted resource. The separator and comment lines
+// heading the document are kept. The resource is decoded as YAML 1.2, so that values like "y"
+// or "on" are not turned into booleans.
+func convertObject(doc string, converters []ObjectConverter) (string, map[string]interface{}, error) {
+	header, body := splitHeader(doc)
+	obj := map[string]interface{}{}
+	if err := yamlv3.Unmarshal([]byte(body), &obj); err != nil {
+		return "", nil, errors.Wrap(err, "failed to decode resource")
+	}
+	for _, converter := range converters {
+		var err error
+		if obj, err = converter(obj); err != nil {
+			return "", nil, errors.Wrap(err, "failed to convert resource")
+		}
+	}
+	// The resource is encoded directly as YAML instead of through JSON, at a fraction of the
+	// allocations, with whole numbers encoded as integers as through JSON
+	b, err := yamlv2.Marshal(normalizeNumbers(obj))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to encode resource")
+	}
+	return header + string(b), obj, nil
+}
+
+// normalizeNumbers returns the value with the whole floating point numbers it contains turned
+// into integers, as they are when encoded through JSON, e.g. 1.0 is encoded as 1
+func normalizeNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			value[k] = normalizeNumbers(v)
+		}
+	case []interface{}:
+		for i, v := range value {
+			value[i] = normalizeNumbers(v)
+		}
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < math.MaxInt64 {
+			return int(value)
+		}
+	}
+	return value
+}
+
+// splitHeader splits a document into its heading separator, comment and blank lines, and its body
+func splitHeader(doc string) (string, string) {
+	offset := 0
+	for offset < len(doc) {
+		end := strings.IndexByte(doc[offset:], '\n')
+		if end < 0 {
+			break
+		}
+		line := strings.TrimSpace(doc[offset : offset+end])
+		if line != "" && !strings.HasPrefix(line, "#") && !isSeparator(line) {
+			break
+		}
+		offset += end + 1
+	}
+	return doc[:offset], doc[offset:]
+}
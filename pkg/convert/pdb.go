@@ -0,0 +1,48 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func init() {
+	RegisterCheck(schema.GroupVersionKind{Group: "policy", Version: "v1beta1", Kind: "PodDisruptionBudget"}, checkPDB)
+}
+
+// checkPDB warns about a PodDisruptionBudget mapped to policy/v1 with an empty selector, which
+// selects no pods in policy/v1beta1 but all the pods of the namespace in policy/v1
+func checkPDB(obj map[string]interface{}) []Warning {
+	if obj["apiVersion"] != "policy/v1" {
+		return nil
+	}
+	spec, ok := obj["spec"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	selector, ok := spec["selector"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	matchLabels, _ := selector["matchLabels"].(map[string]interface{})
+	matchExpressions, _ := selector["matchExpressions"].([]interface{})
+	if len(matchLabels) > 0 || len(matchExpressions) > 0 {
+		return nil
+	}
+	return []Warning{{Message: "the empty selector selected no pods in policy/v1beta1 but selects all the pods of the namespace in policy/v1, " +
+		"which may block evictions of unrelated pods; set a selector matching the intended pods"}}
+}
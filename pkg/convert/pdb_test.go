@@ -0,0 +1,89 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPDB(t *testing.T) {
+	m := testMapping("PodDisruptionBudget", "policy/v1beta1", "policy/v1")
+	pdb := func(apiVersion, spec string) string {
+		return "apiVersion: " + apiVersion + `
+kind: PodDisruptionBudget
+metadata:
+  name: web
+spec:
+  minAvailable: 1
+` + spec
+	}
+	tests := []struct {
+		name    string
+		spec    string
+		warning bool
+	}{
+		{
+			name: "selector with labels",
+			spec: `  selector:
+    matchLabels:
+      app: web
+`,
+		},
+		{
+			name: "selector with expressions",
+			spec: `  selector:
+    matchExpressions:
+    - key: app
+      operator: In
+      values:
+      - web
+`,
+		},
+		{
+			name:    "empty selector",
+			spec:    "  selector: {}\n",
+			warning: true,
+		},
+		{
+			name: "selector with empty labels",
+			spec: `  selector:
+    matchLabels: {}
+`,
+			warning: true,
+		},
+		{
+			name: "without selector",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conversion := assertApply(t, m, DefaultSettings(), pdb("policy/v1beta1", tt.spec), pdb("policy/v1", tt.spec))
+			switch {
+			case !tt.warning && len(conversion.Warnings) != 0:
+				t.Errorf("expected no warnings, got %+v", conversion.Warnings)
+			case tt.warning && len(conversion.Warnings) != 1:
+				t.Fatalf("expected 1 warning, got %+v", conversion.Warnings)
+			case tt.warning:
+				warning := conversion.Warnings[0]
+				if !strings.Contains(warning.Message, "selects all the pods of the namespace in policy/v1") || warning.Resource.Name != "web" {
+					t.Errorf("unexpected warning %+v", warning)
+				}
+			}
+		})
+	}
+}
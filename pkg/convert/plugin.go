@@ -0,0 +1,48 @@
+//go:build cgo && (linux || darwin || freebsd)
+
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"plugin"
+
+	"github.com/pkg/errors"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// loadPluginConverter loads the conversion function of a mapping from a Go plugin. Go plugins
+// must be built with the same Go version and dependency versions as the plugin binary, and
+// are only supported by binaries built with cgo on Linux, FreeBSD and macOS.
+func loadPluginConverter(c *mapping.Converter) (ObjectConverter, error) {
+	p, err := plugin.Open(c.Plugin)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open converter plugin: %s", c.Plugin)
+	}
+	sym, err := p.Lookup(c.Function)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find converter function '%s' in plugin: %s", c.Function, c.Plugin)
+	}
+	switch fn := sym.(type) {
+	case func(map[string]interface{}) (map[string]interface{}, error):
+		return fn, nil
+	case *ObjectConverter:
+		return *fn, nil
+	}
+	return nil, errors.Errorf("converter function '%s' in plugin %s must have the signature func(map[string]interface{}) (map[string]interface{}, error)", c.Function, c.Plugin)
+}
@@ -0,0 +1,31 @@
+//go:build !cgo || !(linux || darwin || freebsd)
+
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// loadPluginConverter fails as Go plugins are not supported by binaries built without cgo, as
+// the released binaries, or on other platforms than Linux, FreeBSD and macOS
+func loadPluginConverter(c *mapping.Converter) (ObjectConverter, error) {
+	return nil, errors.Errorf("failed to open converter plugin: %s: converter plugins require a binary built with cgo on Linux, FreeBSD or macOS; the released binaries are built without cgo, use patches, transforms, a template or a script instead, or build the binary from source with CGO_ENABLED=1", c.Plugin)
+}
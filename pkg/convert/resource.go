@@ -0,0 +1,120 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"strings"
+)
+
+// scanResource returns the identity of the resource of a document by scanning its lines,
+// without decoding the document. It handles the block style resources are usually written in,
+// and returns false for anything else, e.g. quoted or flow style values, in which case the
+// document must be decoded.
+func scanResource(doc string) (Resource, bool) {
+	var resource Resource
+	inMetadata := false
+	metadataIndent := 0
+	// continued is true after an identity field, whose value could continue on the next lines
+	continued := false
+	for offset := 0; offset < len(doc); {
+		end := strings.IndexByte(doc[offset:], '\n')
+		if end < 0 {
+			end = len(doc)
+		} else {
+			end += offset
+		}
+		line := strings.TrimRight(doc[offset:end], " \t\r")
+		offset = end + 1
+
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed[0] == '#' || isSeparator(line) {
+			continue
+		}
+		indent := len(line) - len(trimmed)
+		if continued && indent > 0 && (!inMetadata || metadataIndent > 0 && indent > metadataIndent) {
+			return resource, false
+		}
+		continued = false
+		switch {
+		case indent == 0 && trimmed[0] == '-':
+			// An entry of a sequence of a top-level field, e.g. of webhooks
+			inMetadata = false
+			continue
+		case indent == 0:
+			inMetadata = false
+		case !inMetadata:
+			// A field of another top-level field than metadata
+			continue
+		case metadataIndent == 0:
+			metadataIndent = indent
+		case indent > metadataIndent:
+			// A field of a metadata field, e.g. of labels
+			continue
+		case indent < metadataIndent:
+			return resource, false
+		}
+
+		key, value, ok := scanField(trimmed)
+		if !ok {
+			return resource, false
+		}
+		switch {
+		case indent == 0 && key == "apiVersion":
+			resource.APIVersion = value
+		case indent == 0 && key == "kind":
+			resource.Kind = value
+		case indent == 0 && key == "metadata":
+			if value != "" {
+				return resource, false
+			}
+			inMetadata = true
+			continue
+		case indent > 0 && key == "name":
+			resource.Name = value
+		case indent > 0 && key == "namespace":
+			resource.Namespace = value
+		default:
+			continue
+		}
+		if value == "" {
+			return resource, false
+		}
+		continued = true
+	}
+	return resource, resource.APIVersion != "" && resource.Kind != ""
+}
+
+// scanField splits a line of a block mapping into its key and value. It returns false if the
+// line is not a simple key, or if the value of one of the identity fields of a resource is not
+// a plain scalar.
+func scanField(line string) (string, string, bool) {
+	if strings.ContainsAny(line[:1], "-?\"'{[&*!|>%@`") {
+		return "", "", false
+	}
+	colon := strings.Index(line, ":")
+	if colon < 0 || (colon+1 < len(line) && line[colon+1] != ' ') {
+		return "", "", false
+	}
+	key, value := line[:colon], strings.TrimSpace(line[colon+1:])
+	switch key {
+	case "apiVersion", "kind", "metadata", "name", "namespace":
+		if value != "" && (strings.ContainsAny(value[:1], "#\"'{[&*!|>%@`") || strings.Contains(value, " #")) {
+			return "", "", false
+		}
+	}
+	return key, value, true
+}
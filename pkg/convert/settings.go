@@ -0,0 +1,83 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"context"
+)
+
+// Settings configures the values set by the built-in conversions for fields which are required
+// by the new API but have no equivalent in the deprecated API, and the removal of resources
+// using APIs without replacement
+type Settings struct {
+	// CommentRemoved keeps the resources using APIs without replacement in the manifest as
+	// commented-out documents, instead of deleting them
+	CommentRemoved bool
+
+	// CSRSignerName is the signerName of certificate signing requests which do not set it, or
+	// set it to the legacy-unknown signer not allowed by certificates.k8s.io/v1
+	CSRSignerName string
+
+	// IngressClassName enables rewriting the kubernetes.io/ingress.class annotation of Ingresses
+	// mapped to networking.k8s.io/v1 to spec.ingressClassName
+	IngressClassName bool
+
+	// IngressClassNames maps the ingress class annotation values to the ingressClassName set.
+	// Values which are not mapped are kept.
+	IngressClassNames map[string]string
+
+	// IngressPathType is the pathType of Ingress HTTP paths which do not set it, as
+	// networking.k8s.io/v1 requires it
+	IngressPathType string
+
+	// WebhookSideEffects is the sideEffects of admission webhooks which do not set it, or set
+	// it to Unknown, which admissionregistration.k8s.io/v1 does not allow. Webhooks set to
+	// Some, also not allowed, are set to NoneOnDryRun.
+	WebhookSideEffects string
+
+	// WebhookAdmissionReviewVersions are the admissionReviewVersions of admission webhooks
+	// which do not set them
+	WebhookAdmissionReviewVersions []string
+}
+
+// DefaultSettings returns the default settings of the built-in conversions
+func DefaultSettings() Settings {
+	return Settings{
+		CSRSignerName:                  "kubernetes.io/kube-apiserver-client",
+		IngressPathType:                "ImplementationSpecific",
+		WebhookSideEffects:             "None",
+		WebhookAdmissionReviewVersions: []string{"v1beta1"},
+	}
+}
+
+// settingsKey is the key of the settings of the built-in conversions in a context
+type settingsKey struct{}
+
+// WithSettings returns a copy of the context carrying the settings of the built-in
+// conversions, which are used by the appliers prepared with the context
+func WithSettings(ctx context.Context, s Settings) context.Context {
+	return context.WithValue(ctx, settingsKey{}, s)
+}
+
+// SettingsFromContext returns the settings of the built-in conversions carried by the
+// context, the default settings if it carries none
+func SettingsFromContext(ctx context.Context) Settings {
+	if s, ok := ctx.Value(settingsKey{}).(Settings); ok {
+		return s
+	}
+	return DefaultSettings()
+}
@@ -0,0 +1,200 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"context"
+	"math/big"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.starlark.net/starlark"
+)
+
+// starlarkConvertFunction is the function a Starlark script must define
+const starlarkConvertFunction = "convert"
+
+// starlarkMaxSteps is the maximum number of steps of the execution of a Starlark script, to load
+// it or to convert a resource, so that a script which does not terminate fails instead of
+// blocking the mapping
+const starlarkMaxSteps = 10000000
+
+// starlarkConverter returns a converter which calls the convert function of a Starlark script
+// with the resource as a dict, and returns the dict it returns. Scripts run sandboxed: they
+// cannot load modules or access the file system or the network. The execution of the script
+// fails if it exceeds starlarkMaxSteps steps, or is canceled when the context is done.
+func starlarkConverter(ctx context.Context, script string) (ObjectConverter, error) {
+	thread := newStarlarkThread(script)
+	stop := cancelOnDone(ctx, thread)
+	globals, err := starlark.ExecFile(thread, script, nil, nil)
+	stop()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load Starlark script: %s", script)
+	}
+	fn, ok := globals[starlarkConvertFunction].(starlark.Callable)
+	if !ok {
+		return nil, errors.Errorf("Starlark script %s must define a function '%s(object)'", script, starlarkConvertFunction)
+	}
+
+	return func(obj map[string]interface{}) (map[string]interface{}, error) {
+		arg, err := toStarlark(obj)
+		if err != nil {
+			return nil, err
+		}
+		thread := newStarlarkThread(script)
+		stop := cancelOnDone(ctx, thread)
+		out, err := starlark.Call(thread, fn, starlark.Tuple{arg}, nil)
+		stop()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to run Starlark script: %s", script)
+		}
+		value, err := fromStarlark(out)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value returned by Starlark script: %s", script)
+		}
+		converted, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("Starlark script %s must return a dict, got %s", script, out.Type())
+		}
+		return converted, nil
+	}, nil
+}
+
+// newStarlarkThread returns the thread executing a Starlark script, limited to starlarkMaxSteps
+func newStarlarkThread(script string) *starlark.Thread {
+	thread := &starlark.Thread{Name: script}
+	thread.SetMaxExecutionSteps(starlarkMaxSteps)
+	return thread
+}
+
+// cancelOnDone cancels the execution of the thread when the context is done, until the function
+// returned is called
+func cancelOnDone(ctx context.Context, thread *starlark.Thread) func() {
+	if err := ctx.Err(); err != nil {
+		thread.Cancel(err.Error())
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// toStarlark converts a decoded YAML value to a Starlark value
+func toStarlark(value interface{}) (starlark.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case uint64:
+		return starlark.MakeUint64(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	case []interface{}:
+		elems := make([]starlark.Value, 0, len(v))
+		for _, e := range v {
+			elem, err := toStarlark(e)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		dict := starlark.NewDict(len(v))
+		for _, key := range keys {
+			elem, err := toStarlark(v[key])
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlark.String(key), elem); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+	return nil, errors.Errorf("unsupported value of type %T", value)
+}
+
+// fromStarlark converts a Starlark value to a value which can be encoded as YAML
+func fromStarlark(value starlark.Value) (interface{}, error) {
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		f, _ := new(big.Float).SetInt(v.BigInt()).Float64()
+		return f, nil
+	case starlark.Float:
+		return float64(v), nil
+	case *starlark.List:
+		return fromStarlarkIterable(v)
+	case starlark.Tuple:
+		return fromStarlarkIterable(v)
+	case *starlark.Dict:
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, errors.Errorf("dict key %s is not a string", item[0])
+			}
+			elem, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[key] = elem
+		}
+		return m, nil
+	}
+	return nil, errors.Errorf("unsupported value of type %s", value.Type())
+}
+
+// fromStarlarkIterable converts a Starlark list or tuple to a slice
+func fromStarlarkIterable(iterable starlark.Indexable) ([]interface{}, error) {
+	elems := make([]interface{}, 0, iterable.Len())
+	for i := 0; i < iterable.Len(); i++ {
+		elem, err := fromStarlark(iterable.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
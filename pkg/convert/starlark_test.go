@@ -0,0 +1,52 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStarlarkConverterLimits(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "loop.star")
+	err := os.WriteFile(script, []byte("def convert(object):\n    for i in range(1 << 40):\n        pass\n    return object\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := map[string]interface{}{"kind": "Ingress"}
+
+	converter, err := starlarkConverter(context.Background(), script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := converter(obj); err == nil || !strings.Contains(err.Error(), "too many steps") {
+		t.Errorf("expected the script exceeding the steps limit to fail, got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	converter, err = starlarkConverter(ctx, script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := converter(obj); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("expected the script to be canceled with the context, got: %v", err)
+	}
+}
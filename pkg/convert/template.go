@@ -0,0 +1,81 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"bytes"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// parseTemplate returns the parsed template of the mapping, or nil if it has none
+func parseTemplate(m *mapping.Mapping) (*template.Template, error) {
+	if m.Template == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("mapping").Funcs(funcMap()).Parse(m.Template)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse mapping template")
+	}
+	return tmpl, nil
+}
+
+// funcMap returns the sprig functions available to mapping templates. As in Helm, the functions
+// reading the environment are removed so that a map file cannot copy the environment of the
+// process, including its credentials, into the stored release.
+func funcMap() template.FuncMap {
+	f := sprig.TxtFuncMap()
+	delete(f, "env")
+	delete(f, "expandenv")
+	return f
+}
+
+// templateConverter returns a converter which renders the template with the original resource,
+// as it was before conversion, as context and merges the rendered fields into the resource
+func templateConverter(tmpl *template.Template, original map[string]interface{}) ObjectConverter {
+	return func(obj map[string]interface{}) (map[string]interface{}, error) {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, original); err != nil {
+			return nil, errors.Wrap(err, "failed to render mapping template")
+		}
+		rendered := map[string]interface{}{}
+		if err := yamlv3.Unmarshal(buf.Bytes(), &rendered); err != nil {
+			return nil, errors.Wrap(err, "failed to decode rendered mapping template")
+		}
+		mergeObjects(obj, rendered)
+		return obj, nil
+	}
+}
+
+// mergeObjects merges the fields of src into dst. Nested objects are merged, other values
+// including arrays are replaced.
+func mergeObjects(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcObj, srcOK := value.(map[string]interface{})
+		dstObj, dstOK := dst[key].(map[string]interface{})
+		if srcOK && dstOK {
+			mergeObjects(dstObj, srcObj)
+			continue
+		}
+		dst[key] = value
+	}
+}
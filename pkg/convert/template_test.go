@@ -0,0 +1,42 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+func TestParseTemplateWithoutEnvironment(t *testing.T) {
+	for _, tmpl := range []string{
+		`token: {{ env "KUBE_TOKEN" }}`,
+		`token: {{ expandenv "$KUBE_TOKEN" }}`,
+	} {
+		_, err := parseTemplate(&mapping.Mapping{Template: tmpl})
+		if err == nil {
+			t.Fatalf("expected template %q to fail to parse", tmpl)
+		}
+		if !strings.Contains(err.Error(), "not defined") {
+			t.Errorf("unexpected error for template %q: %v", tmpl, err)
+		}
+	}
+	if _, err := parseTemplate(&mapping.Mapping{Template: `name: {{ .metadata.name | upper }}`}); err != nil {
+		t.Fatalf("expected the other sprig functions to be available: %v", err)
+	}
+}
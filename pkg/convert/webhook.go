@@ -0,0 +1,107 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func init() {
+	for _, kind := range []string{"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"} {
+		gvk := schema.GroupVersionKind{Group: "admissionregistration.k8s.io", Version: "v1beta1", Kind: kind}
+		registerSettingsConverter(gvk, convertWebhookConfiguration)
+		RegisterConversionCheck(gvk, checkWebhookSideEffects)
+	}
+}
+
+// convertWebhookConfiguration sets the fields of the webhooks of a webhook configuration mapped
+// to admissionregistration.k8s.io/v1 which are required by v1, or whose default changed in v1
+func convertWebhookConfiguration(obj map[string]interface{}, s Settings) (map[string]interface{}, error) {
+	if obj["apiVersion"] != "admissionregistration.k8s.io/v1" {
+		return obj, nil
+	}
+	webhooks, _ := obj["webhooks"].([]interface{})
+	for _, webhook := range webhooks {
+		w, ok := webhook.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// v1 only allows webhooks without side effects, or skipping them on dry runs, which
+		// is the closest to webhooks declaring side effects
+		switch w["sideEffects"] {
+		case nil, "Unknown":
+			w["sideEffects"] = s.WebhookSideEffects
+		case "Some":
+			w["sideEffects"] = "NoneOnDryRun"
+		}
+		if _, ok := w["admissionReviewVersions"]; !ok {
+			versions := make([]interface{}, len(s.WebhookAdmissionReviewVersions))
+			for i, version := range s.WebhookAdmissionReviewVersions {
+				versions[i] = version
+			}
+			w["admissionReviewVersions"] = versions
+		}
+		// The v1beta1 defaults are set explicitly, as v1 has different defaults
+		setDefault(w, "failurePolicy", "Ignore")
+		setDefault(w, "matchPolicy", "Exact")
+		setDefault(w, "timeoutSeconds", 30)
+	}
+	return obj, nil
+}
+
+// checkWebhookSideEffects warns about the webhooks of a webhook configuration mapped to
+// admissionregistration.k8s.io/v1 which declared side effects not allowed by v1, as they are
+// called on dry-run requests with the sideEffects set, while they were not in v1beta1
+func checkWebhookSideEffects(original, obj map[string]interface{}) []Warning {
+	if obj["apiVersion"] != "admissionregistration.k8s.io/v1" {
+		return nil
+	}
+	originalWebhooks, _ := original["webhooks"].([]interface{})
+	webhooks, _ := obj["webhooks"].([]interface{})
+	var warnings []Warning
+	for i, webhook := range webhooks {
+		w, ok := webhook.(map[string]interface{})
+		if !ok || i >= len(originalWebhooks) {
+			continue
+		}
+		o, ok := originalWebhooks[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		sideEffects := o["sideEffects"]
+		if sideEffects != "Unknown" && sideEffects != "Some" {
+			continue
+		}
+		message := fmt.Sprintf("webhook '%v' declared sideEffects %s, not allowed by v1, which is set to %v: ", w["name"], sideEffects, w["sideEffects"])
+		if w["sideEffects"] == "None" {
+			message += "dry-run requests are now sent to the webhook, which must have no side effects"
+		} else {
+			message += "dry-run requests are now sent to the webhook, which must skip its side effects on them"
+		}
+		warnings = append(warnings, Warning{Message: message})
+	}
+	return warnings
+}
+
+// setDefault sets a field of an object if it is not set
+func setDefault(obj map[string]interface{}, field string, value interface{}) {
+	if _, ok := obj[field]; !ok {
+		obj[field] = value
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+func TestWebhookSideEffects(t *testing.T) {
+	manifest := `apiVersion: admissionregistration.k8s.io/v1beta1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: policy
+webhooks:
+- name: some.example.com
+  sideEffects: Some
+- name: unknown.example.com
+  sideEffects: Unknown
+- name: unset.example.com
+- name: none.example.com
+  sideEffects: None
+`
+	m := &mapping.Mapping{
+		DeprecatedAPI: "apiVersion: admissionregistration.k8s.io/v1beta1\nkind: ValidatingWebhookConfiguration\n",
+		NewAPI:        "apiVersion: admissionregistration.k8s.io/v1\nkind: ValidatingWebhookConfiguration\n",
+	}
+	mapped, conversion, err := Apply(context.Background(), manifest, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"name: some.example.com\n  sideEffects: NoneOnDryRun",
+		"name: unknown.example.com\n  sideEffects: None\n",
+		"name: unset.example.com\n  sideEffects: None\n",
+		"name: none.example.com\n  sideEffects: None\n",
+	} {
+		if !strings.Contains(mapped, want) {
+			t.Errorf("mapped manifest:\n%s\ndoes not contain %q", mapped, want)
+		}
+	}
+	if len(conversion.Warnings) != 2 {
+		t.Fatalf("expected 2 warnings, got %+v", conversion.Warnings)
+	}
+	for i, want := range []string{"webhook 'some.example.com' declared sideEffects Some", "webhook 'unknown.example.com' declared sideEffects Unknown"} {
+		if !strings.Contains(conversion.Warnings[i].Message, want) {
+			t.Errorf("warning %q does not contain %q", conversion.Warnings[i].Message, want)
+		}
+	}
+}
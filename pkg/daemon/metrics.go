@@ -0,0 +1,157 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemon
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	"github.com/helm/helm-mapkubeapis/pkg/report"
+)
+
+// metricsNamespace is the prefix of the names of the metrics
+const metricsNamespace = "mapkubeapis"
+
+// Metrics are the Prometheus metrics of the results of the daemon runs
+type Metrics struct {
+	registry        *prometheus.Registry
+	releases        *prometheus.GaugeVec
+	deprecatedAPIs  *prometheus.GaugeVec
+	lastRunTime     prometheus.Gauge
+	lastRunDuration prometheus.Gauge
+	lastRunSuccess  prometheus.Gauge
+	nextRunTime     prometheus.Gauge
+	leader          prometheus.Gauge
+	runs            *prometheus.CounterVec
+}
+
+// NewMetrics returns the metrics of the daemon, in a registry of their own
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		releases: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "releases",
+			Help:      "Number of releases checked by the last successful run, by status: clean, pending, mapped or failed.",
+		}, []string{"status"}),
+		deprecatedAPIs: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "release_deprecated_apis",
+			Help:      "Number of resources of the releases using a deprecated or removed API, found by the last successful run and not mapped.",
+		}, []string{"namespace", "release", "api_version", "kind"}),
+		lastRunTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_run_timestamp_seconds",
+			Help:      "Start time of the last run, in seconds since the Unix epoch.",
+		}),
+		lastRunDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_run_duration_seconds",
+			Help:      "Duration of the last run, in seconds.",
+		}),
+		lastRunSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_run_success",
+			Help:      "Whether the last run succeeded, 1 if so or 0.",
+		}),
+		nextRunTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "next_run_timestamp_seconds",
+			Help:      "Time of the next run, in seconds since the Unix epoch.",
+		}),
+		leader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "leader",
+			Help:      "Whether the daemon runs, 1 if so or 0 while it waits for the leadership, as only the metrics of the leader are relevant.",
+		}),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "runs_total",
+			Help:      "Number of runs since the daemon started, by result: succeeded or failed.",
+		}, []string{"result"}),
+	}
+	m.registry.MustRegister(m.releases, m.deprecatedAPIs, m.lastRunTime, m.lastRunDuration, m.lastRunSuccess, m.nextRunTime, m.leader, m.runs)
+	return m
+}
+
+// Handler returns the handler serving the metrics in the Prometheus exposition formats
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// SetState sets the metrics of the last run and of the releases of the state, e.g. the state
+// loaded on start, without counting a run
+func (m *Metrics) SetState(state *State) {
+	if state.LastRunTime.IsZero() {
+		return
+	}
+	m.lastRunTime.Set(float64(state.LastRunTime.Unix()))
+	m.lastRunDuration.Set(state.LastRunDuration.Seconds())
+	if state.LastError == "" {
+		m.lastRunSuccess.Set(1)
+	} else {
+		m.lastRunSuccess.Set(0)
+	}
+
+	m.releases.Reset()
+	for _, status := range []string{report.StatusClean, report.StatusPending, report.StatusMapped, report.StatusFailed} {
+		m.releases.WithLabelValues(status)
+	}
+	m.deprecatedAPIs.Reset()
+	for _, release := range state.Releases {
+		m.releases.WithLabelValues(release.Status).Inc()
+		if release.Status != report.StatusPending {
+			continue
+		}
+		for _, api := range release.MappedAPIs {
+			gvk, err := mapping.ParseAPI(api.DeprecatedAPI)
+			if err != nil {
+				continue
+			}
+			m.deprecatedAPIs.WithLabelValues(release.Namespace, release.Name, gvk.GroupVersion().String(), gvk.Kind).Add(float64(api.Count))
+		}
+	}
+}
+
+// ObserveRun sets the metrics of a run recorded in the state, and counts it
+func (m *Metrics) ObserveRun(state *State) {
+	m.SetState(state)
+	if state.LastError == "" {
+		m.runs.WithLabelValues("succeeded").Inc()
+	} else {
+		m.runs.WithLabelValues("failed").Inc()
+	}
+}
+
+// SetLeader sets whether the daemon runs, as the leader of its replicas or without leader
+// election
+func (m *Metrics) SetLeader(leader bool) {
+	if leader {
+		m.leader.Set(1)
+	} else {
+		m.leader.Set(0)
+	}
+}
+
+// SetNextRunTime sets the time of the next run
+func (m *Metrics) SetNextRunTime(next time.Time) {
+	m.nextRunTime.Set(float64(next.Unix()))
+}
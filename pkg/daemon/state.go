@@ -0,0 +1,198 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package daemon maintains the state and the metrics of the scheduled runs of the daemon mode,
+// which periodically checks, and optionally maps, the releases of a cluster.
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/report"
+)
+
+// State is the state of the daemon kept between its runs
+type State struct {
+	// LastRunTime is the start time of the last run, zero if the daemon never ran
+	LastRunTime time.Time `json:"lastRunTime,omitempty"`
+
+	// LastRunDuration is the duration of the last run
+	LastRunDuration time.Duration `json:"lastRunDuration,omitempty"`
+
+	// LastError is the error which failed the last run, empty if it succeeded
+	LastError string `json:"lastError,omitempty"`
+
+	// Releases are the releases checked by the last successful run, keyed by namespace/name
+	Releases map[string]*ReleaseState `json:"releases,omitempty"`
+}
+
+// ReleaseState is the state of a release checked by the daemon
+type ReleaseState struct {
+	Name       string             `json:"name"`
+	Namespace  string             `json:"namespace"`
+	Revision   int                `json:"revision"`
+	Status     string             `json:"status"`
+	MappedAPIs []common.MappedAPI `json:"mappedAPIs,omitempty"`
+	Error      string             `json:"error,omitempty"`
+
+	// FirstFoundTime is the time the deprecated or removed APIs of the release were first found,
+	// kept until a run finds none
+	FirstFoundTime *time.Time `json:"firstFoundTime,omitempty"`
+
+	// LastMappedTime is the time the release was last mapped by the daemon
+	LastMappedTime *time.Time `json:"lastMappedTime,omitempty"`
+}
+
+// Changes are the changes of the releases found by a run since the previous run
+type Changes struct {
+	// Found are the releases whose deprecated or removed APIs were found by the run, not found
+	// by the previous run
+	Found []string
+
+	// Resolved are the releases whose deprecated or removed APIs found by the previous run were
+	// mapped, or which have none anymore, e.g. as they were upgraded or uninstalled
+	Resolved []string
+}
+
+// LoadState reads the state of a file, or returns an empty state if the file does not exist
+func LoadState(path string) (*State, error) {
+	state := &State{}
+	if path == "" {
+		return state, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read state file: %s", path)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode state file: %s", path)
+	}
+	return state, nil
+}
+
+// Save writes the state to a file, replacing it atomically so that an interrupted write does
+// not lose the previous state
+func (s *State) Save(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode state")
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return errors.Wrapf(err, "failed to write state file: %s", path)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	return errors.Wrapf(err, "failed to write state file: %s", path)
+}
+
+// Update records the results of the releases checked by a run started at the time, and returns
+// the changes since the previous run
+func (s *State) Update(results []report.Release, now time.Time) Changes {
+	var changes Changes
+	releases := map[string]*ReleaseState{}
+	for _, result := range results {
+		key := result.Namespace + "/" + result.Name
+		previous := s.Releases[key]
+		release := &ReleaseState{
+			Name:       result.Name,
+			Namespace:  result.Namespace,
+			Revision:   result.Revision,
+			Status:     result.Status,
+			MappedAPIs: result.MappedAPIs,
+			Error:      result.Error,
+		}
+		if previous != nil {
+			release.LastMappedTime = previous.LastMappedTime
+		}
+		switch result.Status {
+		case report.StatusPending:
+			release.FirstFoundTime = &now
+			if previous != nil && previous.FirstFoundTime != nil {
+				release.FirstFoundTime = previous.FirstFoundTime
+			} else {
+				changes.Found = append(changes.Found, key)
+			}
+		case report.StatusMapped:
+			release.LastMappedTime = &now
+			if previous == nil || previous.Status != report.StatusPending {
+				changes.Found = append(changes.Found, key)
+			}
+			changes.Resolved = append(changes.Resolved, key)
+		case report.StatusFailed:
+			// The APIs of a release which could not be checked are unknown, keep the time
+			// they were first found
+			if previous != nil {
+				release.FirstFoundTime = previous.FirstFoundTime
+			}
+		default:
+			if previous != nil && previous.Status == report.StatusPending {
+				changes.Resolved = append(changes.Resolved, key)
+			}
+		}
+		releases[key] = release
+	}
+	for key, previous := range s.Releases {
+		if _, ok := releases[key]; !ok && previous.Status == report.StatusPending {
+			changes.Resolved = append(changes.Resolved, key)
+		}
+	}
+	sort.Strings(changes.Found)
+	sort.Strings(changes.Resolved)
+	s.Releases = releases
+	return changes
+}
+
+// Results returns the results of the releases of the state, sorted by namespace and name
+func (s *State) Results() []report.Release {
+	var results []report.Release
+	for _, release := range s.Releases {
+		results = append(results, report.Release{
+			Name:       release.Name,
+			Namespace:  release.Namespace,
+			Revision:   release.Revision,
+			Status:     release.Status,
+			MappedAPIs: release.MappedAPIs,
+			Error:      release.Error,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Namespace != results[j].Namespace {
+			return results[i].Namespace < results[j].Namespace
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
@@ -0,0 +1,170 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package election implements the coordination.k8s.io leader election of the in-cluster modes
+// run with several replicas, so that only the leader maps releases.
+package election
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+const (
+	// leaseDuration is the duration a leader holds the lease without renewing it, after which
+	// another replica takes over
+	leaseDuration = 15 * time.Second
+
+	// renewDeadline is the duration the leader retries renewing the lease for before it gives
+	// up the leadership
+	renewDeadline = 10 * time.Second
+
+	// retryPeriod is the period the replicas try to acquire or renew the lease at
+	retryPeriod = 2 * time.Second
+)
+
+// Election is the leader election of the replicas of a component, with a Lease
+type Election struct {
+	lock   *resourcelock.LeaseLock
+	logger common.Logger
+
+	// leading is set while the replica is the leader
+	leading int32
+}
+
+// New returns the leader election of the replicas holding the Lease of the name in the
+// namespace, that of the kube config settings if empty, e.g. that of the pod service account
+func New(kubeConfig common.KubeConfig, namespace, name string, logger common.Logger) (*Election, error) {
+	if namespace == "" {
+		var err error
+		namespace, _, err = common.RESTClientGetter(kubeConfig).ToRawKubeConfigLoader().Namespace()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get the namespace of the leader election")
+		}
+	}
+	clientSet, err := common.ClientSet(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return &Election{
+		lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Client:     clientSet.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: fmt.Sprintf("%s_%s", hostname, uuid.NewUUID())},
+		},
+		logger: common.LoggerOrDefault(logger),
+	}, nil
+}
+
+// IsLeader returns true while the replica is the leader
+func (e *Election) IsLeader() bool {
+	return atomic.LoadInt32(&e.leading) == 1
+}
+
+// Run waits for the replica to be elected, then runs the function with a context canceled when
+// the context is done or when the leadership is lost. The lease is renewed until the function
+// returns, so that the work in progress, e.g. the release being mapped, is completed before
+// another replica takes over, then released. Run returns nil if the context is done, and an
+// error if the leadership is lost, in which case the replica should exit so that it is
+// restarted and joins the election again.
+func (e *Election) Run(ctx context.Context, run func(ctx context.Context) error) error {
+	lease := e.lock.LeaseMeta.Namespace + "/" + e.lock.LeaseMeta.Name
+	// The election is stopped once the function returns, and not when the context is done
+	electionCtx, stopElection := context.WithCancel(context.Background())
+	defer stopElection()
+	started := make(chan struct{})
+	done := make(chan struct{})
+	var runErr error
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            e.lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            lease,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				defer close(done)
+				defer stopElection()
+				close(started)
+				atomic.StoreInt32(&e.leading, 1)
+				defer atomic.StoreInt32(&e.leading, 0)
+				e.logger.Printf("Elected leader, holding lease '%s'.\n", lease)
+				runCtx, cancel := context.WithCancel(ctx)
+				defer cancel()
+				go func() {
+					select {
+					case <-leaderCtx.Done():
+						cancel()
+					case <-runCtx.Done():
+					}
+				}()
+				runErr = run(runCtx)
+			},
+			OnStoppedLeading: func() {},
+			OnNewLeader: func(identity string) {
+				if identity != e.lock.Identity() {
+					e.logger.Printf("Lease '%s' held by leader '%s', waiting for the leadership.\n", lease, identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create the leader election")
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			// The election is stopped at once unless the replica is the leader
+			select {
+			case <-started:
+			default:
+				stopElection()
+			}
+		case <-started:
+		}
+	}()
+
+	e.logger.Printf("Waiting for the leadership, with lease '%s'.\n", lease)
+	elector.Run(electionCtx)
+	select {
+	case <-started:
+		<-done
+	default:
+		return nil
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if ctx.Err() == nil {
+		return errors.Errorf("lost the leadership, the lease '%s' could not be renewed", lease)
+	}
+	e.logger.Printf("Lease '%s' released.\n", lease)
+	return nil
+}
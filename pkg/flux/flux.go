@@ -0,0 +1,177 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package flux writes the patches of Flux HelmReleases which map the deprecated or removed
+// APIs of their releases at the source, with a Kustomize post-renderer.
+package flux
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+// defaultAPIVersion is the API version of the HelmRelease patch if that of the HelmRelease is
+// not known
+const defaultAPIVersion = "helm.toolkit.fluxcd.io/v2"
+
+// HelmReleasePatch returns the patch of the Flux HelmRelease of a release adding a Kustomize
+// post-renderer which maps the resources of the findings, as in the mapped manifest of the
+// release, or removes them if their API has no replacement. The fields of a resource other
+// than its metadata and status are replaced by those of the mapped resource, so that the
+// conversions of the fields are applied too. The post-renderer must be merged into the
+// existing post-renderers of the HelmRelease, if any.
+func HelmReleasePatch(managedBy *common.ManagedBy, findings common.Findings, manifest string) ([]byte, error) {
+	if managedBy == nil || managedBy.Controller != common.ControllerFlux {
+		return nil, errors.New("release is not managed by a Flux HelmRelease")
+	}
+	objects, err := convert.Objects(manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the mapped manifest")
+	}
+
+	var patches []interface{}
+	for _, finding := range findings {
+		var patch interface{}
+		var err error
+		switch finding.Action {
+		case common.ActionMapped:
+			patch, err = mappedResourcePatch(finding, objects)
+		case common.ActionRemoved:
+			patch, err = removedResourcePatch(finding.Resource)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, map[string]interface{}{
+			"target": target(finding.Resource),
+			"patch":  patch,
+		})
+	}
+	if len(patches) == 0 {
+		return nil, errors.New("no resources of the release are mapped or removed")
+	}
+
+	apiVersion := managedBy.APIVersion
+	if apiVersion == "" {
+		apiVersion = defaultAPIVersion
+	}
+	metadata := map[string]interface{}{"name": managedBy.Name}
+	if managedBy.Namespace != "" {
+		metadata["namespace"] = managedBy.Namespace
+	}
+	helmRelease := map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "HelmRelease",
+		"metadata":   metadata,
+		"spec": map[string]interface{}{
+			"postRenderers": []interface{}{
+				map[string]interface{}{"kustomize": map[string]interface{}{"patches": patches}},
+			},
+		},
+	}
+	b, err := yaml.Marshal(helmRelease)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the HelmRelease patch")
+	}
+	header := fmt.Sprintf("# Post-renderer of %s mapping the deprecated or removed APIs of its release.\n# Merge it into the existing spec.postRenderers of the HelmRelease, if any.\n", managedBy)
+	return append([]byte(header), b...), nil
+}
+
+// target returns the Kustomize patch target selecting a resource in the rendered manifest
+func target(resource convert.Resource) map[string]interface{} {
+	gv, _ := schema.ParseGroupVersion(resource.APIVersion)
+	t := map[string]interface{}{"version": gv.Version, "kind": resource.Kind, "name": resource.Name}
+	if gv.Group != "" {
+		t["group"] = gv.Group
+	}
+	if resource.Namespace != "" {
+		t["namespace"] = resource.Namespace
+	}
+	return t
+}
+
+// mappedResourcePatch returns the JSON patch replacing the API version, kind and fields other
+// than the metadata and status of a resource by those of the resource in the mapped manifest
+func mappedResourcePatch(finding common.Finding, objects []map[string]interface{}) (string, error) {
+	gvk, err := mapping.ParseAPI(finding.NewAPI)
+	if err != nil {
+		return "", err
+	}
+	var mapped map[string]interface{}
+	for _, obj := range objects {
+		resource := convert.ObjectResource(obj)
+		if resource.Kind == gvk.Kind && resource.Name == finding.Resource.Name && resource.Namespace == finding.Resource.Namespace {
+			mapped = obj
+			break
+		}
+	}
+	if mapped == nil {
+		return "", errors.Errorf("%s '%s' not found in the mapped manifest", gvk.Kind, finding.Resource.Name)
+	}
+
+	ops := []interface{}{
+		map[string]interface{}{"op": "replace", "path": "/apiVersion", "value": gvk.GroupVersion().String()},
+	}
+	if gvk.Kind != finding.Resource.Kind {
+		ops = append(ops, map[string]interface{}{"op": "replace", "path": "/kind", "value": gvk.Kind})
+	}
+	var fields []string
+	for field := range mapped {
+		switch field {
+		case "apiVersion", "kind", "metadata", "status":
+		default:
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		// add replaces the field if it exists
+		ops = append(ops, map[string]interface{}{"op": "add", "path": "/" + field, "value": mapped[field]})
+	}
+	b, err := yaml.Marshal(ops)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to encode the patch of %s '%s'", finding.Resource.Kind, finding.Resource.Name)
+	}
+	return string(b), nil
+}
+
+// removedResourcePatch returns the strategic merge patch deleting a resource
+func removedResourcePatch(resource convert.Resource) (string, error) {
+	metadata := map[string]interface{}{"name": resource.Name}
+	if resource.Namespace != "" {
+		metadata["namespace"] = resource.Namespace
+	}
+	b, err := yaml.Marshal(map[string]interface{}{
+		"$patch":     "delete",
+		"apiVersion": resource.APIVersion,
+		"kind":       resource.Kind,
+		"metadata":   metadata,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to encode the patch of %s '%s'", resource.Kind, resource.Name)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gitrepo automates the Git operations of the remediation of application repositories:
+// cloning a repository, creating a branch, and committing and pushing the mapped files, so that
+// a pull request can be opened from the branch. The git client of the PATH is used, so that its
+// configuration and credential helpers apply.
+package gitrepo
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Repository is a clone of a Git repository
+type Repository struct {
+	// Dir is the working tree of the clone
+	Dir string
+}
+
+// Clone clones the repository of the URL into the directory, which must not exist or be empty
+func Clone(ctx context.Context, url, dir string) (*Repository, error) {
+	if _, err := git(ctx, "", "clone", "--quiet", "--", url, dir); err != nil {
+		return nil, errors.Wrapf(err, "failed to clone Git repository: %s", url)
+	}
+	return &Repository{Dir: dir}, nil
+}
+
+// CreateBranch creates a branch from the checked out commit and checks it out
+func (r *Repository) CreateBranch(ctx context.Context, branch string) error {
+	if _, err := git(ctx, r.Dir, "checkout", "--quiet", "-b", branch); err != nil {
+		return errors.Wrapf(err, "failed to create Git branch: %s", branch)
+	}
+	return nil
+}
+
+// Commit commits the changes of the files, relative to the working tree, with the message
+func (r *Repository) Commit(ctx context.Context, message string, files []string) error {
+	if _, err := git(ctx, r.Dir, append([]string{"add", "--"}, files...)...); err != nil {
+		return errors.Wrap(err, "failed to stage mapped files")
+	}
+	if _, err := git(ctx, r.Dir, "commit", "--quiet", "-m", message); err != nil {
+		return errors.Wrap(err, "failed to commit mapped files")
+	}
+	return nil
+}
+
+// Push pushes the branch to the origin remote of the clone
+func (r *Repository) Push(ctx context.Context, branch string) error {
+	if _, err := git(ctx, r.Dir, "push", "--quiet", "origin", branch); err != nil {
+		return errors.Wrapf(err, "failed to push Git branch: %s", branch)
+	}
+	return nil
+}
+
+// git runs the git command with the arguments in the directory and returns its output. The
+// error holds the error output of the command.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.Wrap(err, msg)
+		}
+		return "", err
+	}
+	return string(out), nil
+}
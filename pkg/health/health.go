@@ -0,0 +1,109 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health serves the liveness and readiness probes of the long-running modes, i.e. the
+// daemon, the operator and the servers, for the Kubernetes probes of their pods.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// LivenessPath is the path of the liveness probe, which succeeds while the process serves it
+	LivenessPath = "/healthz"
+
+	// ReadinessPath is the path of the readiness probe, which succeeds once the component is
+	// ready and until it stops
+	ReadinessPath = "/readyz"
+
+	// checkTimeout bounds the duration of the readiness checks of a probe
+	checkTimeout = 5 * time.Second
+)
+
+// Check returns an error if the component is not ready
+type Check func(ctx context.Context) error
+
+// Probes are the liveness and readiness probes of a component
+type Probes struct {
+	mutex  sync.Mutex
+	checks map[string]Check
+
+	// stopping is set once the component is stopping, so that it is not ready anymore
+	stopping int32
+}
+
+// New returns the probes of a component, which is ready until it stops if no readiness check is
+// added
+func New() *Probes {
+	return &Probes{checks: map[string]Check{}}
+}
+
+// AddReadinessCheck adds a check which must succeed for the component to be ready
+func (p *Probes) AddReadinessCheck(name string, check Check) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.checks[name] = check
+}
+
+// SetStopping marks the component as stopping, so that it is not ready anymore and receives no
+// new work, e.g. requests, while the work in progress is completed
+func (p *Probes) SetStopping() {
+	atomic.StoreInt32(&p.stopping, 1)
+}
+
+// Register registers the handlers of the probes in the mux
+func (p *Probes) Register(mux *http.ServeMux) {
+	mux.HandleFunc(LivenessPath, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc(ReadinessPath, p.serveReadiness)
+}
+
+// serveReadiness serves the readiness probe, listing the checks which failed if any
+func (p *Probes) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	var failures []string
+	if atomic.LoadInt32(&p.stopping) == 1 {
+		failures = append(failures, "stopping")
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+		p.mutex.Lock()
+		checks := make(map[string]Check, len(p.checks))
+		for name, check := range p.checks {
+			checks[name] = check
+		}
+		p.mutex.Unlock()
+		for name, check := range checks {
+			if err := check(ctx); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %s", name, err))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		http.Error(w, "not ready\n"+strings.Join(failures, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
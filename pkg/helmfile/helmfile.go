@@ -0,0 +1,138 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package helmfile resolves the releases declared in helmfile state files, so that the releases
+// deployed with helmfile are checked and mapped without listing them manually.
+package helmfile
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+)
+
+// Release is a release declared in a helmfile state file
+type Release struct {
+	Name        string `yaml:"name"`
+	Namespace   string `yaml:"namespace"`
+	KubeContext string `yaml:"kubeContext"`
+	Installed   *bool  `yaml:"installed"`
+}
+
+// state is the part of a helmfile state file declaring releases
+type state struct {
+	HelmDefaults struct {
+		KubeContext string `yaml:"kubeContext"`
+	} `yaml:"helmDefaults"`
+	Helmfiles []subHelmfile `yaml:"helmfiles"`
+	Releases  []Release     `yaml:"releases"`
+}
+
+// subHelmfile is a state file included by a helmfile state file, declared by its path or as
+// an object with a path
+type subHelmfile struct {
+	Path string `yaml:"path"`
+}
+
+// UnmarshalYAML decodes a sub-helmfile declared by its path or as an object with a path
+func (s *subHelmfile) UnmarshalYAML(node *yamlv3.Node) error {
+	if node.Kind == yamlv3.ScalarNode {
+		return node.Decode(&s.Path)
+	}
+	type plain subHelmfile
+	return node.Decode((*plain)(s))
+}
+
+// Load returns the releases declared in the helmfile state file and the state files it
+// includes, which are resolved relative to the file. The releases with installed: false are
+// skipped, and the kube context of a release defaults to that of helmDefaults. State files
+// using Go template syntax must be rendered first, e.g. with helmfile build.
+func Load(path string) ([]Release, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read helmfile: %s", path)
+	}
+	return parse(data, path, filepath.Dir(path), map[string]bool{})
+}
+
+// Read returns the releases declared in the helmfile state read from r, such as the output of
+// helmfile build. Included state files are resolved relative to the working directory.
+func Read(r io.Reader) ([]Release, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read helmfile")
+	}
+	return parse(data, "-", ".", map[string]bool{})
+}
+
+// parse returns the releases declared in the documents of a helmfile state and the state
+// files it includes, visited recording the files parsed to break cycles
+func parse(data []byte, name, dir string, visited map[string]bool) ([]Release, error) {
+	var releases []Release
+	for _, doc := range convert.Split(string(data)) {
+		var s state
+		if err := yamlv3.Unmarshal([]byte(doc), &s); err != nil {
+			if strings.Contains(doc, "{{") {
+				return nil, errors.Wrapf(err, "failed to parse helmfile %s, render its templates first, e.g. helmfile build | helm mapkubeapis helmfile -f -", name)
+			}
+			return nil, errors.Wrapf(err, "failed to parse helmfile: %s", name)
+		}
+		for _, release := range s.Releases {
+			if release.Installed != nil && !*release.Installed {
+				continue
+			}
+			if release.Name == "" {
+				return nil, errors.Errorf("release without name in helmfile: %s", name)
+			}
+			if release.KubeContext == "" {
+				release.KubeContext = s.HelmDefaults.KubeContext
+			}
+			releases = append(releases, release)
+		}
+		for _, sub := range s.Helmfiles {
+			path := sub.Path
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			matches, err := filepath.Glob(path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid helmfile path: %s", sub.Path)
+			}
+			for _, match := range matches {
+				if visited[match] {
+					continue
+				}
+				visited[match] = true
+				subData, err := os.ReadFile(match)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to read helmfile: %s", match)
+				}
+				subReleases, err := parse(subData, match, filepath.Dir(match), visited)
+				if err != nil {
+					return nil, err
+				}
+				releases = append(releases, subReleases...)
+			}
+		}
+	}
+	return releases, nil
+}
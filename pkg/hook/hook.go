@@ -0,0 +1,87 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/pkg/errors"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+const (
+	// PreMap is the name of the hook run before a release is updated
+	PreMap = "pre-map"
+
+	// PostMap is the name of the hook run after a release is updated
+	PostMap = "post-map"
+)
+
+// Command returns a hook which runs the command with the shell. The change summary of the
+// release is passed on stdin as a JSON document, and the release name, namespace and hook
+// name in the MAPKUBEAPIS_RELEASE, MAPKUBEAPIS_NAMESPACE and MAPKUBEAPIS_HOOK environment
+// variables. The hook fails if the command exits with a non-zero code, and the command is
+// killed if the context is done before it exits.
+func Command(name, command string) common.Hook {
+	return func(ctx context.Context, result *common.ReleaseResult) error {
+		summary, err := json.Marshal(result)
+		if err != nil {
+			return errors.Wrapf(err, "failed to encode the change summary for the %s hook", name)
+		}
+
+		cmd := shellCommand(ctx, command)
+		cmd.Stdin = bytes.NewReader(summary)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(),
+			"MAPKUBEAPIS_RELEASE="+result.Name,
+			"MAPKUBEAPIS_NAMESPACE="+result.Namespace,
+			"MAPKUBEAPIS_HOOK="+name,
+		)
+		if err := cmd.Run(); err != nil {
+			return errors.Wrapf(err, "%s hook '%s' failed", name, command)
+		}
+		return nil
+	}
+}
+
+// shellCommand returns the command to run the command line with the shell of the platform,
+// killed when the context is done
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// Chain returns a hook which runs the hooks in order, until one of them returns an error
+func Chain(hooks ...common.Hook) common.Hook {
+	return func(ctx context.Context, result *common.ReleaseResult) error {
+		for _, hook := range hooks {
+			if err := hook(ctx, result); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,340 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mapkubeapis is the library interface of the mapping engine. It allows other
+// tooling to check and map Helm releases containing deprecated or removed Kubernetes APIs
+// without shelling out to the plugin:
+//
+//	mapper := mapkubeapis.New(
+//		mapkubeapis.WithMapFile("config/Map.yaml"),
+//		mapkubeapis.WithNamespace("default"),
+//	)
+//	result, err := mapper.MapRelease(ctx, "my-release")
+package mapkubeapis
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/helm/helm-mapkubeapis/pkg/chartrepo"
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+// Result is the result of checking or mapping a release
+type Result = common.ReleaseResult
+
+// ManifestResult is the result of mapping a manifest stream
+type ManifestResult = common.ManifestResult
+
+// ChartResult is the result of mapping the templates of a chart
+type ChartResult = common.ChartResult
+
+// Mapper checks and maps Helm releases containing deprecated or removed Kubernetes APIs
+type Mapper struct {
+	allowEmpty bool
+	checkLive  bool
+	conversion *convert.Settings
+	dryRun     bool
+	force      bool
+	kubeConfig common.KubeConfig
+	lock       bool
+	logger     common.Logger
+	provider   mapping.MappingProvider
+	namespace  string
+	postMap    common.Hook
+	preMap     common.Hook
+	requireNew bool
+	storage    common.ReleaseStorage
+	driver     string
+	timeout    time.Duration
+	validate   common.Hook
+}
+
+// Option configures a Mapper
+type Option func(*Mapper)
+
+// WithAllowEmptyRelease sets whether MapRelease maps a release when all its resources are
+// removed, as their APIs have no replacement. Otherwise, it fails, as an empty release breaks
+// the next Helm operations.
+func WithAllowEmptyRelease(allowEmptyRelease bool) Option {
+	return func(m *Mapper) {
+		m.allowEmpty = allowEmptyRelease
+	}
+}
+
+// WithCheckLiveObjects sets whether the live objects of the resources removed from a release
+// manifest, as their API has no replacement, are looked up in the cluster. A warning is logged
+// and added to the findings for each live object, which is orphaned by Helm.
+func WithCheckLiveObjects(checkLiveObjects bool) Option {
+	return func(m *Mapper) {
+		m.checkLive = checkLiveObjects
+	}
+}
+
+// WithConversionSettings sets the settings of the built-in conversions, e.g. the signerName set
+// on certificate signing requests mapped to v1. The default settings are used if it is not
+// set, or those carried by the context, see convert.WithSettings.
+func WithConversionSettings(settings convert.Settings) Option {
+	return func(m *Mapper) {
+		m.conversion = &settings
+	}
+}
+
+// WithDryRun sets whether MapRelease only reports the APIs which would be mapped
+// without modifying release storage
+func WithDryRun(dryRun bool) Option {
+	return func(m *Mapper) {
+		m.dryRun = dryRun
+	}
+}
+
+// WithForce sets whether MapRelease maps a release reconciled by a GitOps controller, e.g. an
+// Argo CD Application. Otherwise, the update of the release is skipped with a warning, as the
+// controller reverts the release to its source, where the APIs must be mapped instead.
+func WithForce(force bool) Option {
+	return func(m *Mapper) {
+		m.force = force
+	}
+}
+
+// WithKubeConfig sets the Kubernetes configuration used to access the cluster
+func WithKubeConfig(kubeConfig common.KubeConfig) Option {
+	return func(m *Mapper) {
+		m.kubeConfig = kubeConfig
+	}
+}
+
+// WithLock sets whether MapRelease holds a coordination.k8s.io Lease named
+// mapkubeapis.<release> in the release namespace while the release is mapped, so that
+// concurrent runs, e.g. of several operators or scheduled jobs, fail instead of mapping the
+// same release simultaneously
+func WithLock(lock bool) Option {
+	return func(m *Mapper) {
+		m.lock = lock
+	}
+}
+
+// WithLogger sets the logger the progress of checking and mapping releases is logged to.
+// The standard logger of the log package is used if it is not set.
+func WithLogger(logger common.Logger) Option {
+	return func(m *Mapper) {
+		m.logger = logger
+	}
+}
+
+// WithMapFile sets the source of the API mapping file, which is a path, an http:// or https://
+// URL, an oci:// reference, or "embedded" for the mapping file embedded in the binary. The
+// mapping file is loaded once, and reused for all the releases mapped by the Mapper.
+func WithMapFile(mapFile string) Option {
+	return func(m *Mapper) {
+		m.provider = mapping.NewCachedProvider(mapping.NewProvider(mapFile))
+	}
+}
+
+// WithMappingProvider sets the provider of the API mappings. The mapping file embedded in the
+// binary is used if neither a provider nor a mapping file is set.
+func WithMappingProvider(provider mapping.MappingProvider) Option {
+	return func(m *Mapper) {
+		m.provider = provider
+	}
+}
+
+// WithNamespace sets the namespace of the releases. The namespace of the current
+// Kubernetes context is used if it is not set.
+func WithNamespace(namespace string) Option {
+	return func(m *Mapper) {
+		m.namespace = namespace
+	}
+}
+
+// WithPreMapHook sets a hook run with the change summary of a release before it is updated.
+// If the hook returns an error, the release is not updated.
+func WithPreMapHook(hook common.Hook) Option {
+	return func(m *Mapper) {
+		m.preMap = hook
+	}
+}
+
+// WithPostMapHook sets a hook run with the change summary of a release after it is updated
+func WithPostMapHook(hook common.Hook) Option {
+	return func(m *Mapper) {
+		m.postMap = hook
+	}
+}
+
+// WithRequireNewAPI sets whether mapping a release fails if a supported API is not served by
+// the cluster. Otherwise, the deprecated API is left unmapped with a warning.
+func WithRequireNewAPI(requireNewAPI bool) Option {
+	return func(m *Mapper) {
+		m.requireNew = requireNewAPI
+	}
+}
+
+// WithReleaseTimeout sets the timeout of checking or mapping a release, no timeout if zero.
+// Once the update of a release started, it is completed or reverted whatever the timeout.
+func WithReleaseTimeout(timeout time.Duration) Option {
+	return func(m *Mapper) {
+		m.timeout = timeout
+	}
+}
+
+// WithStorage sets the release storage releases are checked and mapped in. The Helm release
+// storage of the namespace is used if it is not set. Kubernetes events are only recorded for
+// releases mapped in the Helm release storage.
+func WithStorage(storage common.ReleaseStorage) Option {
+	return func(m *Mapper) {
+		m.storage = storage
+	}
+}
+
+// WithStorageDriver sets the Helm storage driver of the release storage releases are checked
+// and mapped in, one of: secret, configmap, memory, sql. The driver of the HELM_DRIVER
+// environment variable is used if it is not set. It is ignored if WithStorage is set.
+func WithStorageDriver(driver string) Option {
+	return func(m *Mapper) {
+		m.driver = driver
+	}
+}
+
+// WithValidator sets a hook run with the change summary of a release before it is updated, also
+// in dry-run mode. If the hook returns an error, the release is not updated.
+func WithValidator(hook common.Hook) Option {
+	return func(m *Mapper) {
+		m.validate = hook
+	}
+}
+
+// New returns a Mapper configured with the options
+func New(opts ...Option) *Mapper {
+	m := &Mapper{}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.provider == nil {
+		m.provider = &mapping.EmbeddedProvider{}
+	}
+	return m
+}
+
+// ImportMappedRelease writes the mapped version of the release records of a release, exported
+// and mapped without access to release storage, back to release storage as a new version, see
+// v3.ImportMappedRelease. The namespace is that of the records if not set.
+func (m *Mapper) ImportMappedRelease(ctx context.Context, records []*release.Release) (*Result, error) {
+	ctx = m.context(ctx)
+	return v3.ImportMappedRelease(ctx, m.mapOptions(""), records)
+}
+
+// MapRelease checks the latest version of the release for deprecated or removed APIs. If it
+// finds any, it creates a new release version with the APIs mapped to supported versions
+// and supersedes the latest version, unless the Mapper is in dry-run mode. If the context is
+// canceled while the release is updated, the update is completed before MapRelease returns.
+func (m *Mapper) MapRelease(ctx context.Context, releaseName string) (*Result, error) {
+	ctx = m.context(ctx)
+	return v3.MapReleaseWithUnSupportedAPIs(ctx, m.mapOptions(releaseName))
+}
+
+// MapStorageObject maps the deprecated or removed APIs of the release version stored in the
+// Secret or ConfigMap of the name, e.g. sh.helm.release.v1.my-release.v7, replacing its release
+// payload in place without loading the release through Helm release storage, for the repair of
+// releases whose storage is too damaged for Helm to load them. The release storage set with
+// WithStorage, if any, is ignored.
+func (m *Mapper) MapStorageObject(ctx context.Context, objectName string) (*Result, error) {
+	ctx = m.context(ctx)
+	return v3.MapStorageObjectWithUnSupportedAPIs(ctx, m.mapOptions(""), objectName)
+}
+
+// CheckRelease checks the latest version of the release for deprecated or removed APIs
+// without modifying release storage
+func (m *Mapper) CheckRelease(ctx context.Context, releaseName string) (*Result, error) {
+	ctx = m.context(ctx)
+	return v3.CheckReleaseWithUnSupportedAPIs(ctx, m.mapOptions(releaseName))
+}
+
+// MapManifests maps the deprecated or removed APIs in a multi-document YAML manifest stream,
+// such as the output of helm template, to supported APIs for the target Kubernetes version.
+// Neither Helm release storage nor the cluster is accessed.
+func (m *Mapper) MapManifests(ctx context.Context, r io.Reader, kubeVersion string) (*ManifestResult, error) {
+	ctx = m.context(ctx)
+	return common.MapManifests(ctx, r, m.provider, kubeVersion, m.logger)
+}
+
+// MapManifestStream is like MapManifests, but writes the manifest stream with its APIs mapped
+// to w one document at a time as it is read, so that huge streams are mapped with memory
+// proportional to their largest document. The manifest of the result is empty.
+func (m *Mapper) MapManifestStream(ctx context.Context, r io.Reader, w io.Writer, kubeVersion string) (*ManifestResult, error) {
+	ctx = m.context(ctx)
+	return common.MapManifestStream(ctx, r, w, m.provider, kubeVersion, m.logger)
+}
+
+// MapChart maps the deprecated or removed APIs in the templates and CRDs of a chart directory
+// to supported APIs for the target Kubernetes version, rewriting the templates in place unless
+// the Mapper is in dry-run mode, see common.MapChart
+func (m *Mapper) MapChart(ctx context.Context, chartDir, kubeVersion string) (*ChartResult, error) {
+	ctx = m.context(ctx)
+	return common.MapChart(ctx, chartDir, m.provider, kubeVersion, m.dryRun, m.logger)
+}
+
+// MapChartArchive maps the deprecated or removed APIs in the templates and CRDs of a packaged
+// chart archive, and writes the mapped chart to a new archive unless the Mapper is in dry-run
+// mode, see common.MapChartArchive
+func (m *Mapper) MapChartArchive(ctx context.Context, archive, kubeVersion string, opts common.ChartArchiveOptions) (*ChartResult, error) {
+	ctx = m.context(ctx)
+	return common.MapChartArchive(ctx, archive, m.provider, kubeVersion, opts, m.dryRun, m.logger)
+}
+
+// MapOCIChart maps the deprecated or removed APIs in the templates and CRDs of a chart stored
+// in an OCI registry, and pushes the mapped chart with its new version as tag unless the Mapper
+// is in dry-run mode, see chartrepo.MapOCIChart
+func (m *Mapper) MapOCIChart(ctx context.Context, ref, kubeVersion string, opts common.ChartArchiveOptions) (*ChartResult, error) {
+	ctx = m.context(ctx)
+	return chartrepo.MapOCIChart(ctx, ref, m.provider, kubeVersion, opts, m.dryRun, m.logger)
+}
+
+// context returns the context with the conversion settings of the Mapper, if set
+func (m *Mapper) context(ctx context.Context) context.Context {
+	if m.conversion == nil {
+		return ctx
+	}
+	return convert.WithSettings(ctx, *m.conversion)
+}
+
+func (m *Mapper) mapOptions(releaseName string) common.MapOptions {
+	return common.MapOptions{
+		AllowEmptyRelease: m.allowEmpty,
+		CheckLiveObjects:  m.checkLive,
+		DryRun:            m.dryRun,
+		Force:             m.force,
+		KubeConfig:        m.kubeConfig,
+		Lock:              m.lock,
+		Logger:            m.logger,
+		MappingProvider:   m.provider,
+		PostMapHook:       m.postMap,
+		PreMapHook:        m.preMap,
+		ReleaseName:       releaseName,
+		ReleaseNamespace:  m.namespace,
+		RequireNewAPI:     m.requireNew,
+		Storage:           m.storage,
+		StorageDriver:     m.driver,
+		Timeout:           m.timeout,
+		Validate:          m.validate,
+	}
+}
@@ -17,18 +17,77 @@ limitations under the License.
 package mapping
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
 
+	"github.com/pkg/errors"
 	"sigs.k8s.io/yaml"
 )
 
-// LoadMapfile loads a Map.yaml file into a *Metadata.
+// LoadMapfile loads a Map.yaml file into a *Metadata
 func LoadMapfile(filename string) (*Metadata, error) {
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
+	y, err := parseMapdata(b, filename)
+	if err != nil {
+		return y, err
+	}
+	// Plugin and script paths are relative to the mapping file
+	for _, mapping := range y.Mappings {
+		if mapping.Converter != nil {
+			mapping.Converter.Plugin = resolvePath(filename, mapping.Converter.Plugin)
+		}
+		mapping.Script = resolvePath(filename, mapping.Script)
+	}
+	return y, nil
+}
+
+// LoadMapdata loads mapping data in the Map.yaml format read from a source other than a file,
+// e.g. a ConfigMap, into a *Metadata. Such mapping data cannot reference plugins or scripts,
+// which run code from the file system of the host.
+func LoadMapdata(b []byte, source string) (*Metadata, error) {
+	return loadMapdata(b, source)
+}
+
+// resolvePath returns the path relative to the mapping file, if it is set and not absolute
+func resolvePath(filename, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(filename), path)
+}
+
+// loadMapdata loads mapping data in the Map.yaml format read from a source other than a file
+// into a *Metadata, and fails if a mapping references a plugin or a script, so that a mapping
+// file served from a URL, a registry or a ConfigMap cannot run a plugin or script of the host
+func loadMapdata(b []byte, source string) (*Metadata, error) {
+	y, err := parseMapdata(b, source)
+	if err != nil {
+		return y, err
+	}
+	for _, mapping := range y.Mappings {
+		if mapping.Converter != nil || mapping.Script != "" {
+			return nil, errors.Errorf("the mapping of API %s references a converter plugin or a script, which are only allowed in a mapping file loaded from a file", strings.Join(strings.Fields(mapping.DeprecatedAPI), " "))
+		}
+	}
+	return y, nil
+}
+
+// parseMapdata parses mapping data in the Map.yaml format into a *Metadata
+func parseMapdata(b []byte, source string) (*Metadata, error) {
 	y := new(Metadata)
-	err = yaml.Unmarshal(b, y)
+	err := yaml.Unmarshal(b, y)
+	y.Source = source
+	y.Checksum = checksum(b)
 	return y, err
 }
+
+// checksum returns the SHA-256 checksum of mapping data
+func checksum(b []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+}
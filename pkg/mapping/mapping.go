@@ -16,6 +16,15 @@ limitations under the License.
 
 package mapping
 
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
 // Mapping describes mappings which defines the Kubernetes
 // API deprecations and the new replacement API
 type Mapping struct {
@@ -30,4 +39,123 @@ type Mapping struct {
 
 	// Kubernetes version API is removed in
 	RemovedInVersion string `json:"removedInVersion,omitempty"`
+
+	// Notes about the migration to the new API
+	Notes string `json:"notes,omitempty"`
+
+	// Link to documentation about the deprecation or migration
+	Link string `json:"link,omitempty"`
+
+	// Converter is a custom conversion function applied to the resources after the API is mapped
+	Converter *Converter `json:"converter,omitempty"`
+
+	// Template is a Go template rendering fields merged into the resources after the API is
+	// mapped, with the original resource as context
+	Template string `json:"template,omitempty"`
+
+	// Script is the path of a Starlark script converting the resources after the API is mapped,
+	// relative to the mapping file if not absolute. Only mapping data loaded from a file can
+	// reference a script. The script must define a function convert(object) which returns the
+	// converted resource.
+	Script string `json:"script,omitempty"`
+
+	// Patches are field rewrites applied to the resources after the API is mapped
+	Patches []Patch `json:"patches,omitempty"`
+
+	// Transforms are CEL expressions setting fields of the resources after the API is mapped
+	Transforms []Transform `json:"transforms,omitempty"`
+}
+
+// Patch rewrites the fields of a resource targeted by a JSONPath
+type Patch struct {
+	// Op is the operation, one of: set, rename, delete
+	Op string `json:"op"`
+
+	// Path is the JSONPath of the fields, e.g. $.spec.rules[*].http.paths[*].pathType
+	Path string `json:"path"`
+
+	// To is the JSONPath the fields are renamed to. Its wildcards are replaced in order by the
+	// keys or indexes matched by the wildcards of Path.
+	To string `json:"to,omitempty"`
+
+	// Value is the value the fields are set to
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Transform sets a field of a resource to the value of a CEL expression evaluated against the
+// resource, which is bound to the variable object. The field is deleted if the expression
+// evaluates to null.
+type Transform struct {
+	// Field is the dot-separated path of the field, e.g. spec.ingressClassName
+	Field string `json:"field"`
+
+	// Expression is the CEL expression, e.g. object.metadata.annotations["kubernetes.io/ingress.class"]
+	Expression string `json:"expression"`
+}
+
+// Converter references a custom conversion function exported by a Go plugin. The function
+// must have the signature func(map[string]interface{}) (map[string]interface{}, error).
+type Converter struct {
+	// Plugin is the path of the Go plugin, relative to the mapping file if not absolute. Only
+	// mapping data loaded from a file can reference a plugin.
+	Plugin string `json:"plugin"`
+
+	// Function is the name of the conversion function exported by the plugin
+	Function string `json:"function"`
+}
+
+// AppliesTo returns true if the mapping applies to the Kubernetes version, that is if the API
+// is deprecated (or removed, if the deprecated version is unset) in that version or earlier
+func (m *Mapping) AppliesTo(kubeVersion string) (bool, error) {
+	apiVersion := m.DeprecatedInVersion
+	if apiVersion == "" {
+		apiVersion = m.RemovedInVersion
+	}
+	if !semver.IsValid(apiVersion) {
+		return false, errors.Errorf("Failed to get the deprecated or removed Kubernetes version for API: %s", strings.Join(strings.Fields(m.DeprecatedAPI), " "))
+	}
+	return semver.Compare(apiVersion, kubeVersion) <= 0, nil
+}
+
+// ParseAPI returns the group, version and kind of a mapping API string
+func ParseAPI(api string) (schema.GroupVersionKind, error) {
+	var typeMeta struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}
+	if err := yaml.Unmarshal([]byte(api), &typeMeta); err != nil {
+		return schema.GroupVersionKind{}, errors.Wrapf(err, "failed to parse API: %s", strings.Join(strings.Fields(api), " "))
+	}
+	return schema.FromAPIVersionAndKind(typeMeta.APIVersion, typeMeta.Kind), nil
+}
+
+// Collapse returns a mapping of the deprecated API of the first mapping of a chain to the new
+// API of the last mapping, where each mapping maps the new API of the previous one. The patches
+// and transforms of the chain are applied in order, while a chain can only have one converter,
+// template or script.
+func Collapse(chain []*Mapping) (*Mapping, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("failed to collapse empty mapping chain")
+	}
+	collapsed := *chain[0]
+	if len(chain) == 1 {
+		return &collapsed, nil
+	}
+	collapsed.NewAPI = chain[len(chain)-1].NewAPI
+	collapsed.Patches = nil
+	collapsed.Transforms = nil
+	for i, m := range chain {
+		collapsed.Patches = append(collapsed.Patches, m.Patches...)
+		collapsed.Transforms = append(collapsed.Transforms, m.Transforms...)
+		if i == 0 {
+			continue
+		}
+		if m.Converter != nil || m.Template != "" || m.Script != "" {
+			if collapsed.Converter != nil || collapsed.Template != "" || collapsed.Script != "" {
+				return nil, errors.Errorf("Failed to collapse the mappings of API %s: more than one mapping of the chain has a converter, template or script", strings.Join(strings.Fields(chain[0].DeprecatedAPI), " "))
+			}
+			collapsed.Converter, collapsed.Template, collapsed.Script = m.Converter, m.Template, m.Script
+		}
+	}
+	return &collapsed, nil
 }
@@ -16,8 +16,88 @@ limitations under the License.
 
 package mapping
 
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
 // Metadata for a Mapping file. This models the structure of a Mapping.yaml file.
 type Metadata struct {
+	// Version of the mapping data.
+	Version string `json:"version,omitempty"`
+
 	// Mappings are a list of mappings.
 	Mappings []*Mapping `json:"mappings,omitempty"`
+
+	// Source the mapping data was loaded from.
+	Source string `json:"-"`
+
+	// Checksum of the mapping data as loaded from the source.
+	Checksum string `json:"-"`
+}
+
+// Lookup returns the mappings of a deprecated or removed API. If apiVersion is empty, the
+// mappings of all API versions of the kind are returned. The kind is matched case-insensitively.
+func (m *Metadata) Lookup(apiVersion, kind string) []*Mapping {
+	var mappings []*Mapping
+	for _, mapping := range m.Mappings {
+		gvk, err := ParseAPI(mapping.DeprecatedAPI)
+		if err != nil {
+			continue
+		}
+		if !strings.EqualFold(gvk.Kind, kind) {
+			continue
+		}
+		if apiVersion != "" && gvk.GroupVersion().String() != apiVersion {
+			continue
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings
+}
+
+// Effective returns the mappings which apply to the Kubernetes version
+func (m *Metadata) Effective(kubeVersion string) (*Metadata, error) {
+	effective := &Metadata{Version: m.Version, Source: m.Source, Checksum: m.Checksum}
+	for _, mapping := range m.Mappings {
+		applies, err := mapping.AppliesTo(kubeVersion)
+		if err != nil {
+			return nil, err
+		}
+		if applies {
+			effective.Mappings = append(effective.Mappings, mapping)
+		}
+	}
+	return effective, nil
+}
+
+// Validate returns an error if the mapping data has no mappings, or a mapping without a valid
+// deprecated API, new API or deprecated or removed Kubernetes version
+func (m *Metadata) Validate() error {
+	if len(m.Mappings) == 0 {
+		return errors.Errorf("no mappings found in mapping file: %s", m.Source)
+	}
+	for i, mapping := range m.Mappings {
+		gvk, err := ParseAPI(mapping.DeprecatedAPI)
+		if err != nil {
+			return errors.Wrapf(err, "invalid mapping %d", i+1)
+		}
+		if gvk.Version == "" || gvk.Kind == "" {
+			return errors.Errorf("invalid mapping %d: deprecatedAPI must set apiVersion and kind", i+1)
+		}
+		if mapping.NewAPI != "" {
+			gvk, err := ParseAPI(mapping.NewAPI)
+			if err != nil {
+				return errors.Wrapf(err, "invalid mapping %d", i+1)
+			}
+			if gvk.Version == "" || gvk.Kind == "" {
+				return errors.Errorf("invalid mapping %d: newAPI must set apiVersion and kind", i+1)
+			}
+		}
+		if _, err := mapping.AppliesTo("v1.0.0"); err != nil {
+			return errors.Wrapf(err, "invalid mapping %d", i+1)
+		}
+	}
+	return nil
 }
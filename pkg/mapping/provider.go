@@ -0,0 +1,267 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mapping
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/registry"
+	dockerauth "oras.land/oras-go/pkg/auth/docker"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/oras"
+
+	"github.com/helm/helm-mapkubeapis/config"
+)
+
+const (
+	// EmbeddedSource is the source of the mapping file embedded in the binary
+	EmbeddedSource = "embedded"
+
+	// MapFileMediaType is the media type of a mapping file layer in an OCI artifact
+	MapFileMediaType = "application/vnd.helm.mapkubeapis.mapfile.v1+yaml"
+)
+
+// MappingProvider provides the mapping data consumed by the mapping engine. It allows
+// mappings to be backed by other sources, e.g. a database or a configuration service.
+// The mapping data returned may be shared and must not be modified.
+type MappingProvider interface {
+	Mappings(ctx context.Context) (*Metadata, error)
+}
+
+// RevisionProvider is a MappingProvider which can tell whether its mapping data changed without
+// loading it, e.g. by the digest of an OCI artifact. The revision changes with the mapping data.
+type RevisionProvider interface {
+	MappingProvider
+	Revision(ctx context.Context) (string, error)
+}
+
+// CachedProvider provides the mapping data of a provider loaded once, so that the releases of
+// a run are mapped with the same mapping data, and a mapping file is not downloaded or read
+// again for each release. A failure to load the mapping data is not cached.
+type CachedProvider struct {
+	Provider MappingProvider
+
+	mu       sync.Mutex
+	metadata *Metadata
+}
+
+// NewCachedProvider returns the provider caching the mapping data of the provider
+func NewCachedProvider(provider MappingProvider) *CachedProvider {
+	return &CachedProvider{Provider: provider}
+}
+
+// Mappings returns the mapping data loaded by the first successful call
+func (p *CachedProvider) Mappings(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	mapMetadata, err := p.Provider.Mappings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.metadata = mapMetadata
+	return mapMetadata, nil
+}
+
+// NewProvider returns the provider of the mapping data at the source, which is one of:
+// an http:// or https:// URL, an oci:// reference, "embedded" for the mapping file
+// embedded in the binary, or otherwise the path of a mapping file.
+func NewProvider(source string) MappingProvider {
+	switch {
+	case source == EmbeddedSource:
+		return &EmbeddedProvider{}
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		return &URLProvider{URL: source}
+	case strings.HasPrefix(source, "oci://"):
+		return &OCIProvider{Reference: strings.TrimPrefix(source, "oci://")}
+	}
+	return &FileProvider{Path: source}
+}
+
+// FileProvider provides the mapping data of a mapping file
+type FileProvider struct {
+	Path string
+}
+
+// Mappings loads the mapping file
+func (p *FileProvider) Mappings(ctx context.Context) (*Metadata, error) {
+	mapMetadata, err := LoadMapfile(p.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to load mapping file: %s", p.Path)
+	}
+	return mapMetadata, nil
+}
+
+// Revision returns the modification time and size of the mapping file
+func (p *FileProvider) Revision(ctx context.Context) (string, error) {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to load mapping file: %s", p.Path)
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// downloadTimeout is the timeout of the download of a mapping file by the default client
+const downloadTimeout = time.Minute
+
+// defaultClient is the HTTP client of the URL providers without client, with a timeout so that
+// a stalled server does not block the releases checked with the mappings
+var defaultClient = &http.Client{Timeout: downloadTimeout}
+
+// URLProvider provides the mapping data of a mapping file served over HTTP
+type URLProvider struct {
+	URL string
+
+	// Client is the HTTP client used to download the mapping file, a client with a timeout of
+	// a minute if nil
+	Client *http.Client
+}
+
+// Mappings downloads the mapping file
+func (p *URLProvider) Mappings(ctx context.Context) (*Metadata, error) {
+	client := p.Client
+	if client == nil {
+		client = defaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create request for mapping file: %s", p.URL)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to download mapping file: %s", p.URL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Failed to download mapping file: %s: %s", p.URL, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to download mapping file: %s", p.URL)
+	}
+	mapMetadata, err := loadMapdata(b, p.URL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to load mapping file: %s", p.URL)
+	}
+	return mapMetadata, nil
+}
+
+// OCIProvider provides the mapping data of a mapping file stored as an OCI artifact. The
+// layer with the MapFileMediaType media type is used, or the only layer of the artifact.
+// The registry credentials of Helm are used to pull the artifact.
+type OCIProvider struct {
+	// Reference of the artifact, without the oci:// scheme, e.g. ghcr.io/org/mapfile:v1
+	Reference string
+}
+
+// Mappings pulls the mapping file from the registry
+func (p *OCIProvider) Mappings(ctx context.Context) (*Metadata, error) {
+	resolver, err := registryResolver()
+	if err != nil {
+		return nil, err
+	}
+
+	store := content.NewMemory()
+	var layers []ocispec.Descriptor
+	_, err = oras.Copy(ctx, content.Registry{Resolver: resolver}, p.Reference, store, "",
+		oras.WithPullEmptyNameAllowed(),
+		oras.WithLayerDescriptors(func(l []ocispec.Descriptor) {
+			layers = l
+		}))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to pull mapping file: oci://%s", p.Reference)
+	}
+
+	var layer *ocispec.Descriptor
+	for i := range layers {
+		if layers[i].MediaType == MapFileMediaType {
+			layer = &layers[i]
+			break
+		}
+	}
+	if layer == nil && len(layers) == 1 {
+		layer = &layers[0]
+	}
+	if layer == nil {
+		return nil, errors.Errorf("Failed to find mapping file layer of media type %s in: oci://%s", MapFileMediaType, p.Reference)
+	}
+	_, b, ok := store.Get(*layer)
+	if !ok {
+		return nil, errors.Errorf("Failed to pull mapping file: oci://%s", p.Reference)
+	}
+	mapMetadata, err := loadMapdata(b, "oci://"+p.Reference)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to load mapping file: oci://%s", p.Reference)
+	}
+	return mapMetadata, nil
+}
+
+// Revision returns the digest of the artifact, resolved without pulling it
+func (p *OCIProvider) Revision(ctx context.Context) (string, error) {
+	resolver, err := registryResolver()
+	if err != nil {
+		return "", err
+	}
+	_, desc, err := resolver.Resolve(ctx, p.Reference)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to resolve mapping file: oci://%s", p.Reference)
+	}
+	return desc.Digest.String(), nil
+}
+
+// registryResolver returns the resolver of the registries, with the registry credentials of Helm
+func registryResolver() (remotes.Resolver, error) {
+	authClient, err := dockerauth.NewClientWithDockerFallback(helmpath.ConfigPath(registry.CredentialsFileBasename))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to load registry credentials")
+	}
+	resolver, err := authClient.ResolverWithOpts()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create registry resolver")
+	}
+	return resolver, nil
+}
+
+// EmbeddedProvider provides the mapping data of the mapping file embedded in the binary
+type EmbeddedProvider struct{}
+
+// Mappings loads the embedded mapping file
+func (p *EmbeddedProvider) Mappings(ctx context.Context) (*Metadata, error) {
+	mapMetadata, err := loadMapdata(config.MapFile, EmbeddedSource)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to load embedded mapping file")
+	}
+	return mapMetadata, nil
+}
+
+// Revision returns the checksum of the embedded mapping file, which does not change
+func (p *EmbeddedProvider) Revision(ctx context.Context) (string, error) {
+	return checksum(config.MapFile), nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mapping
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Logger is the logger the reloads of the mapping data are logged to
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// ReloadingProvider provides the mapping data of a provider, which Watch reloads when it
+// changes, so that a long-running process picks up a new mapping file without restarting. The
+// mapping data reloaded is validated before it replaces the mapping data in use, which is kept if
+// the mapping data fails to load or is invalid. The mapping data of a RevisionProvider is only
+// reloaded when its revision changes.
+type ReloadingProvider struct {
+	Provider MappingProvider
+
+	// Logger the reloads are logged to, the standard logger if nil
+	Logger Logger
+
+	mu       sync.Mutex
+	metadata *Metadata
+	revision string
+}
+
+// NewReloadingProvider returns the provider reloading the mapping data of the provider
+func NewReloadingProvider(provider MappingProvider) *ReloadingProvider {
+	return &ReloadingProvider{Provider: provider}
+}
+
+// Mappings returns the mapping data in use, loaded by the first successful call or reloaded since
+func (p *ReloadingProvider) Mappings(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	mapMetadata, err := p.Provider.Mappings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.metadata = mapMetadata
+	return mapMetadata, nil
+}
+
+// Reload loads the mapping data again if it changed, and switches to it once validated. It
+// returns true if the mapping data in use was replaced.
+func (p *ReloadingProvider) Reload(ctx context.Context) (bool, error) {
+	var revision string
+	if revisioner, ok := p.Provider.(RevisionProvider); ok {
+		var err error
+		if revision, err = revisioner.Revision(ctx); err != nil {
+			return false, err
+		}
+		p.mu.Lock()
+		unchanged := p.metadata != nil && revision == p.revision
+		p.mu.Unlock()
+		if unchanged {
+			return false, nil
+		}
+	}
+	mapMetadata, err := p.Provider.Mappings(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := mapMetadata.Validate(); err != nil {
+		// The invalid mapping data is not loaded again until its revision changes
+		p.mu.Lock()
+		if p.metadata != nil {
+			p.revision = revision
+		}
+		p.mu.Unlock()
+		return false, errors.Wrap(err, "mapping file reloaded is invalid")
+	}
+
+	p.mu.Lock()
+	previous := p.metadata
+	p.revision = revision
+	if previous != nil && previous.Version == mapMetadata.Version && reflect.DeepEqual(previous.Mappings, mapMetadata.Mappings) {
+		p.mu.Unlock()
+		return false, nil
+	}
+	p.metadata = mapMetadata
+	p.mu.Unlock()
+	if previous != nil {
+		p.logger().Printf("Mapping file %s reloaded: version %s -> %s, %d mappings (%s).\n",
+			mapMetadata.Source, versionOf(previous), versionOf(mapMetadata), len(mapMetadata.Mappings), mapMetadata.Checksum)
+	}
+	return true, nil
+}
+
+// Watch reloads the mapping data at the interval until the context is done. A failure to reload
+// is logged, and the mapping data in use is kept.
+func (p *ReloadingProvider) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := p.Reload(ctx); err != nil && ctx.Err() == nil {
+			p.logger().Printf("Warning: failed to reload the mapping file, the mappings in use are kept: %s\n", err)
+		}
+	}
+}
+
+// logger returns the logger of the provider, or the standard logger
+func (p *ReloadingProvider) logger() Logger {
+	if p.Logger == nil {
+		return log.Default()
+	}
+	return p.Logger
+}
+
+// versionOf returns the version of the mapping data, for logging
+func versionOf(mapMetadata *Metadata) string {
+	if mapMetadata.Version == "" {
+		return "(none)"
+	}
+	return mapMetadata.Version
+}
@@ -0,0 +1,119 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+const (
+	// FormatJSON posts the run summary as a JSON document
+	FormatJSON = "json"
+
+	// FormatSlack posts the run summary as a Slack-compatible message payload
+	FormatSlack = "slack"
+
+	// StatusSucceeded is the summary status of a run which completed successfully
+	StatusSucceeded = "succeeded"
+
+	// StatusFailed is the summary status of a run which failed
+	StatusFailed = "failed"
+
+	// requestTimeout is the timeout for posting a notification to the webhook
+	requestTimeout = 30 * time.Second
+)
+
+// Summary is the summary of a mapping run sent to the webhook
+type Summary struct {
+	Release    string             `json:"release"`
+	Namespace  string             `json:"namespace,omitempty"`
+	DryRun     bool               `json:"dryRun"`
+	Status     string             `json:"status"`
+	Error      string             `json:"error,omitempty"`
+	MappedAPIs []common.MappedAPI `json:"mappedAPIs,omitempty"`
+	StartTime  time.Time          `json:"startTime"`
+	EndTime    time.Time          `json:"endTime"`
+}
+
+// slackMessage is a Slack-compatible incoming webhook payload
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// ValidateFormat returns an error if the notification format is not supported
+func ValidateFormat(format string) error {
+	if format != FormatJSON && format != FormatSlack {
+		return errors.Errorf("unsupported notification format '%s', must be one of: %s, %s", format, FormatJSON, FormatSlack)
+	}
+	return nil
+}
+
+// Send posts the run summary to the webhook URL in the given format
+func Send(url, format string, summary Summary) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+
+	var payload interface{} = summary
+	if format == FormatSlack {
+		payload = slackMessage{Text: slackText(summary)}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode notification")
+	}
+
+	client := &http.Client{Timeout: requestTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "failed to post notification to '%s'", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("notification to '%s' failed with status: %s", url, resp.Status)
+	}
+	return nil
+}
+
+func slackText(summary Summary) string {
+	var b strings.Builder
+	mode := ""
+	if summary.DryRun {
+		mode = " (dry-run)"
+	}
+	fmt.Fprintf(&b, "*mapkubeapis* run for release `%s`", summary.Release)
+	if summary.Namespace != "" {
+		fmt.Fprintf(&b, " in namespace `%s`", summary.Namespace)
+	}
+	fmt.Fprintf(&b, " %s%s.", summary.Status, mode)
+	if summary.Error != "" {
+		fmt.Fprintf(&b, "\nError: %s", summary.Error)
+	}
+	for _, api := range summary.MappedAPIs {
+		fmt.Fprintf(&b, "\n• %d x `%s` -> `%s`", api.Count, common.FlattenAPI(api.DeprecatedAPI), common.FlattenAPI(api.NewAPI))
+	}
+	return b.String()
+}
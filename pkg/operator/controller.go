@@ -0,0 +1,421 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapkubeapis"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	"github.com/helm/helm-mapkubeapis/pkg/report"
+	"github.com/helm/helm-mapkubeapis/pkg/schedule"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+// resyncPeriod is the period the jobs are reconciled again at, whether they changed or not
+const resyncPeriod = 10 * time.Minute
+
+// Options are the options of the controller
+type Options struct {
+	// Namespace of the jobs watched, all namespaces if empty
+	Namespace string
+
+	// OperatorNamespace is the namespace of the operator. Its jobs can select the releases of any
+	// namespace, while the jobs of the other namespaces can only select those of their own
+	// namespace, so that a job cannot map the releases of other tenants.
+	OperatorNamespace string
+
+	// MapFile is the source of the mapping file of the jobs which do not set one, see
+	// mapping.NewProvider
+	MapFile string
+
+	// MappingProvider is the provider of the mapping data of the jobs which do not set a mapping
+	// file, e.g. a mapping.ReloadingProvider; that of MapFile if nil
+	MappingProvider mapping.MappingProvider
+
+	// StorageDriver is the Helm storage driver of the releases, that of HELM_DRIVER if empty
+	StorageDriver string
+
+	// Logger the progress of the jobs is logged to, the standard logger if nil
+	Logger common.Logger
+}
+
+// Controller runs the MapKubeAPIsJob resources of the cluster, when they change or on their
+// schedule, and records the result of each run in their status
+type Controller struct {
+	kubeConfig common.KubeConfig
+	options    Options
+	logger     common.Logger
+	client     dynamic.Interface
+	informer   cache.SharedIndexInformer
+	queue      workqueue.RateLimitingInterface
+}
+
+// NewController returns the controller of the MapKubeAPIsJob resources of the cluster
+func NewController(kubeConfig common.KubeConfig, options Options) (*Controller, error) {
+	restConfig, err := common.RESTClientGetter(kubeConfig).ToRESTConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Kubernetes client configuration")
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Kubernetes client")
+	}
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, resyncPeriod, options.Namespace, nil)
+	c := &Controller{
+		kubeConfig: kubeConfig,
+		options:    options,
+		logger:     common.LoggerOrDefault(options.Logger),
+		client:     client,
+		informer:   factory.ForResource(JobResource).Informer(),
+		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), Kind),
+	}
+	c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+	})
+	return c, nil
+}
+
+// enqueue adds the key of a job to the queue
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		c.logger.Printf("Warning: %s\n", err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// Run runs the controller with the number of workers, which run jobs concurrently, until the
+// context is canceled. The jobs running when the context is canceled are completed first, as
+// a release being mapped is completed or reverted whatever the context.
+func (c *Controller) Run(ctx context.Context, workers int) error {
+	go c.informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
+		c.queue.ShutDown()
+		if ctx.Err() != nil {
+			return nil
+		}
+		return errors.Errorf("failed to sync the cache of the %s resources", Kind)
+	}
+	c.logger.Printf("Controller of the %s resources started.\n", Kind)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.work(ctx)
+		}()
+	}
+	<-ctx.Done()
+	c.queue.ShutDown()
+	wg.Wait()
+	c.logger.Printf("Controller of the %s resources stopped.\n", Kind)
+	return nil
+}
+
+// Ready returns an error until the cache of the jobs is synced, i.e. the controller runs them
+func (c *Controller) Ready(ctx context.Context) error {
+	if !c.informer.HasSynced() {
+		return errors.Errorf("cache of the %s resources not synced", Kind)
+	}
+	return nil
+}
+
+// work reconciles the jobs of the queue until it is shut down or the context is canceled
+func (c *Controller) work(ctx context.Context) {
+	for {
+		key, shutdown := c.queue.Get()
+		if shutdown {
+			return
+		}
+		if ctx.Err() != nil {
+			c.queue.Done(key)
+			return
+		}
+		if err := c.reconcile(ctx, key.(string)); err != nil {
+			c.logger.Printf("Failed to reconcile %s '%s', retrying: %s\n", Kind, key, err)
+			c.queue.AddRateLimited(key)
+		} else {
+			c.queue.Forget(key)
+		}
+		c.queue.Done(key)
+	}
+}
+
+// reconcile runs the job of the key if it is due, records its status, and requeues it at its
+// next run time if it is scheduled
+func (c *Controller) reconcile(ctx context.Context, key string) error {
+	obj, exists, err := c.informer.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+	job, err := jobFromUnstructured(obj.(*unstructured.Unstructured))
+	if err != nil {
+		c.logger.Printf("Warning: %s\n", err)
+		return nil
+	}
+
+	status := job.Status
+	status.ObservedGeneration = job.Generation
+	status.NextRunTime = nil
+	now := time.Now()
+	var sched *schedule.Schedule
+	if job.Spec.Schedule != "" {
+		if sched, err = schedule.Parse(job.Spec.Schedule); err != nil {
+			return c.updateStatus(ctx, job, failedStatus(status, err))
+		}
+	}
+	if err := job.Spec.validate(); err != nil {
+		return c.updateStatus(ctx, job, failedStatus(status, err))
+	}
+
+	var due bool
+	if sched == nil {
+		due = job.Status.ObservedGeneration != job.Generation
+	} else {
+		last := job.CreationTimestamp.Time
+		if job.Status.LastRunTime != nil {
+			last = job.Status.LastRunTime.Time
+		}
+		next := sched.Next(last)
+		due = !next.IsZero() && !now.Before(next)
+		if !due {
+			status.NextRunTime = metaTime(next)
+		}
+	}
+	if due {
+		status = c.run(ctx, job, now)
+		status.ObservedGeneration = job.Generation
+		if sched != nil {
+			status.NextRunTime = metaTime(sched.Next(now))
+		}
+	}
+	if status.NextRunTime != nil {
+		c.queue.AddAfter(key, time.Until(status.NextRunTime.Time))
+	}
+	if !due && status.ObservedGeneration == job.Status.ObservedGeneration && timeEqual(status.NextRunTime, job.Status.NextRunTime) {
+		return nil
+	}
+	return c.updateStatus(ctx, job, status)
+}
+
+// run checks or maps the releases selected by the job, and returns the status of the run
+func (c *Controller) run(ctx context.Context, job *Job, now time.Time) JobStatus {
+	status := JobStatus{LastRunTime: metaTime(now)}
+	c.logger.Printf("Run %s '%s/%s'.\n", Kind, job.Namespace, job.Name)
+	releases, err := c.selectReleases(ctx, job)
+	if err != nil {
+		return failedStatus(status, err)
+	}
+	// The mapping file is loaded once for the releases of the run
+	provider := mapping.NewCachedProvider(c.mappingProvider(job))
+	mapMetadata, err := provider.Mappings(ctx)
+	if err != nil {
+		return failedStatus(status, err)
+	}
+	if job.Spec.MapFile != nil && job.Namespace != c.options.OperatorNamespace {
+		if err := checkUntrustedMappings(mapMetadata); err != nil {
+			return failedStatus(status, err)
+		}
+	}
+	opts := []mapkubeapis.Option{
+		mapkubeapis.WithDryRun(job.Spec.DryRun),
+		mapkubeapis.WithForce(job.Spec.Force),
+		mapkubeapis.WithKubeConfig(c.kubeConfig),
+		mapkubeapis.WithLogger(c.logger),
+		mapkubeapis.WithMappingProvider(provider),
+		mapkubeapis.WithStorageDriver(c.options.StorageDriver),
+	}
+	for _, rel := range releases {
+		mapper := mapkubeapis.New(append(opts, mapkubeapis.WithNamespace(rel.Namespace))...)
+		result, err := mapper.MapRelease(ctx, rel.Name)
+		releaseStatus := newReleaseStatus(rel, result, err)
+		status.Summary.Releases++
+		switch releaseStatus.Status {
+		case report.StatusClean:
+			status.Summary.Clean++
+			continue
+		case report.StatusPending:
+			status.Summary.Pending++
+		case report.StatusMapped:
+			status.Summary.Mapped++
+		case report.StatusFailed:
+			status.Summary.Failed++
+		}
+		status.Releases = append(status.Releases, releaseStatus)
+	}
+
+	status.Phase = PhaseSucceeded
+	if status.Summary.Failed > 0 {
+		status.Phase = PhasePartiallyFailed
+		status.Message = fmt.Sprintf("failed to check or map %d of %d releases", status.Summary.Failed, status.Summary.Releases)
+	}
+	c.logger.Printf("%s '%s/%s' completed: %d releases, %d clean, %d pending, %d mapped, %d failed.\n", Kind, job.Namespace, job.Name,
+		status.Summary.Releases, status.Summary.Clean, status.Summary.Pending, status.Summary.Mapped, status.Summary.Failed)
+	return status
+}
+
+// selectReleases returns the latest version of the releases selected by the job. A job outside
+// the namespace of the operator only selects the releases of its namespace.
+func (c *Controller) selectReleases(ctx context.Context, job *Job) ([]*release.Release, error) {
+	spec := job.Spec
+	if job.Namespace != c.options.OperatorNamespace {
+		for _, namespace := range spec.Namespaces {
+			if namespace != job.Namespace {
+				return nil, errors.Errorf("a job in namespace '%s' can only select the releases of its namespace, not of namespace '%s'; only the jobs in the namespace of the operator can select other namespaces", job.Namespace, namespace)
+			}
+		}
+		spec.Namespaces = []string{job.Namespace}
+	}
+	var releases []*release.Release
+	if len(spec.Namespaces) == 0 {
+		all, err := v3.ListReleases(ctx, "", true, c.options.StorageDriver, c.kubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		releases = all
+	}
+	for _, namespace := range spec.Namespaces {
+		namespaceReleases, err := v3.ListReleases(ctx, namespace, false, c.options.StorageDriver, c.kubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, namespaceReleases...)
+	}
+	var selected []*release.Release
+	for _, rel := range releases {
+		if spec.selects(rel.Name) {
+			selected = append(selected, rel)
+		}
+	}
+	return selected, nil
+}
+
+// mappingProvider returns the provider of the mapping file of the job
+func (c *Controller) mappingProvider(job *Job) mapping.MappingProvider {
+	switch {
+	case job.Spec.MapFile == nil:
+	case job.Spec.MapFile.ConfigMapKeyRef != nil:
+		return &common.ConfigMapProvider{
+			KubeConfig: c.kubeConfig,
+			Namespace:  job.Namespace,
+			Name:       job.Spec.MapFile.ConfigMapKeyRef.Name,
+			Key:        job.Spec.MapFile.ConfigMapKeyRef.Key,
+		}
+	case job.Spec.MapFile.Source != "":
+		return mapping.NewProvider(job.Spec.MapFile.Source)
+	}
+	if c.options.MappingProvider != nil {
+		return c.options.MappingProvider
+	}
+	return mapping.NewProvider(c.options.MapFile)
+}
+
+// checkUntrustedMappings returns an error if the mapping file of a job outside the namespace of
+// the operator has a mapping running code in the operator: a plugin converter, a script or a
+// template. Such a job can only map its releases with patches and transforms.
+func checkUntrustedMappings(mapMetadata *mapping.Metadata) error {
+	for _, m := range mapMetadata.Mappings {
+		if m.Converter != nil || m.Script != "" || m.Template != "" {
+			return errors.Errorf("the mapping of API %s has a converter, script or template, which only the jobs in the namespace of the operator can use", strings.Join(strings.Fields(m.DeprecatedAPI), " "))
+		}
+	}
+	return nil
+}
+
+// updateStatus updates the status of the job, retrying on conflicts with the latest version of
+// the job, so that a run is not repeated because its status could not be recorded
+func (c *Controller) updateStatus(ctx context.Context, job *Job, status JobStatus) error {
+	statusObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the job status")
+	}
+	client := c.client.Resource(JobResource).Namespace(job.Namespace)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := client.Get(ctx, job.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		obj.Object["status"] = statusObj
+		_, err = client.UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+		return err
+	})
+	return errors.Wrapf(err, "failed to update the status of %s '%s/%s'", Kind, job.Namespace, job.Name)
+}
+
+// newReleaseStatus returns the status of a release checked or mapped by a job
+func newReleaseStatus(rel *release.Release, result *mapkubeapis.Result, err error) ReleaseStatus {
+	status := ReleaseStatus{Name: rel.Name, Namespace: rel.Namespace, Revision: rel.Version, Status: report.StatusClean}
+	switch {
+	case err != nil:
+		status.Status = report.StatusFailed
+		status.Error = err.Error()
+		return status
+	case result.Mapped:
+		status.Status = report.StatusMapped
+	case len(result.MappedAPIs) > 0:
+		status.Status = report.StatusPending
+	}
+	status.Revision = result.Revision
+	status.MappedAPIs = result.MappedAPIs
+	if result.ManagedBy != nil {
+		status.ManagedBy = result.ManagedBy.String()
+	}
+	return status
+}
+
+// failedStatus returns the status of a job which failed
+func failedStatus(status JobStatus, err error) JobStatus {
+	status.Phase = PhaseFailed
+	status.Message = err.Error()
+	return status
+}
+
+// metaTime returns the time as a *metav1.Time, nil for the zero time
+func metaTime(t time.Time) *metav1.Time {
+	if t.IsZero() {
+		return nil
+	}
+	mt := metav1.NewTime(t.Truncate(time.Second))
+	return &mt
+}
+
+// timeEqual returns true if both times are nil or equal
+func timeEqual(a, b *metav1.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(b)
+}
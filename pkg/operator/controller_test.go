@@ -0,0 +1,234 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package operator
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/report"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+const widgetManifest = `---
+# Source: widget/templates/widget.yaml
+apiVersion: example.com/v1beta1
+kind: Widget
+metadata:
+  name: widget
+spec:
+  replicas: 2
+`
+
+// mapFiles are the mapping files served to the jobs by path
+var mapFiles = map[string]string{
+	"/patches.yaml": `mappings:
+  - deprecatedAPI: "apiVersion: example.com/v1beta1\nkind: Widget\n"
+    newAPI: "apiVersion: example.com/v1\nkind: Widget\n"
+    removedInVersion: "v1.25"
+    patches:
+      - op: rename
+        path: $.spec.replicas
+        to: $.spec.size
+`,
+	"/template.yaml": `mappings:
+  - deprecatedAPI: "apiVersion: example.com/v1beta1\nkind: Widget\n"
+    newAPI: "apiVersion: example.com/v1\nkind: Widget\n"
+    removedInVersion: "v1.25"
+    template: |
+      spec:
+        size: 1
+`,
+}
+
+// newAPIServer returns a test server serving the version of a Kubernetes API server, which
+// Helm checks before listing releases, and the mapping files
+func newAPIServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/version" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"major": "1", "minor": "25", "gitVersion": "v1.25.0"}`))
+			return
+		}
+		mapFile, ok := mapFiles[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(mapFile))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestController returns the controller of the job, whose releases are in the in-memory
+// release storage
+func newTestController(t *testing.T, kubeConfig common.KubeConfig, job *Job) *Controller {
+	t.Helper()
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &unstructured.Unstructured{Object: obj}
+	u.SetAPIVersion(Group + "/" + Version)
+	u.SetKind(Kind)
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{JobResource: Kind + "List"}, u)
+	informer := dynamicinformer.NewDynamicSharedInformerFactory(client, resyncPeriod).ForResource(JobResource).Informer()
+	if err := informer.GetIndexer().Add(u); err != nil {
+		t.Fatal(err)
+	}
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	t.Cleanup(queue.ShutDown)
+	return &Controller{
+		kubeConfig: kubeConfig,
+		options:    Options{OperatorNamespace: "mapkubeapis", MapFile: "embedded", StorageDriver: "memory"},
+		logger:     log.New(io.Discard, "", 0),
+		client:     client,
+		informer:   informer,
+		queue:      queue,
+	}
+}
+
+// jobStatus returns the status of the job recorded by the controller
+func jobStatus(t *testing.T, c *Controller, job *Job) JobStatus {
+	t.Helper()
+	obj, err := c.client.Resource(JobResource).Namespace(job.Namespace).Get(context.Background(), job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := jobFromUnstructured(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return updated.Status
+}
+
+func TestReconcile(t *testing.T) {
+	apiServer := newAPIServer(t)
+	kubeConfig := common.KubeConfig{APIServer: apiServer.URL, KubeVersion: "v1.25.0"}
+	tests := []struct {
+		name      string
+		namespace string
+		spec      JobSpec
+		phase     string
+		message   string
+		summary   JobSummary
+	}{
+		{
+			name:      "patches of the job mapping file",
+			namespace: "team-a",
+			spec:      JobSpec{MapFile: &MapFileSource{Source: apiServer.URL + "/patches.yaml"}},
+			phase:     PhaseSucceeded,
+			summary:   JobSummary{Releases: 1, Mapped: 1},
+		},
+		{
+			name:      "dry run",
+			namespace: "team-b",
+			spec:      JobSpec{MapFile: &MapFileSource{Source: apiServer.URL + "/patches.yaml"}, DryRun: true},
+			phase:     PhaseSucceeded,
+			summary:   JobSummary{Releases: 1, Pending: 1},
+		},
+		{
+			name:      "template of an untrusted job",
+			namespace: "team-c",
+			spec:      JobSpec{MapFile: &MapFileSource{Source: apiServer.URL + "/template.yaml"}},
+			phase:     PhaseFailed,
+			message:   "only the jobs in the namespace of the operator can use",
+		},
+		{
+			name:      "other namespace of an untrusted job",
+			namespace: "team-d",
+			spec:      JobSpec{Namespaces: []string{"team-a"}},
+			phase:     PhaseFailed,
+			message:   "a job in namespace 'team-d' can only select the releases of its namespace",
+		},
+		{
+			name:      "mapping file path",
+			namespace: "team-e",
+			spec:      JobSpec{MapFile: &MapFileSource{Source: "/etc/passwd"}},
+			phase:     PhaseFailed,
+			message:   "invalid mapFile.source '/etc/passwd'",
+		},
+		{
+			name:      "invalid schedule",
+			namespace: "team-f",
+			spec:      JobSpec{Schedule: "every day"},
+			phase:     PhaseFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := v3.GetActionConfig(tt.namespace, "memory", kubeConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rel := &release.Release{
+				Name:      "widget",
+				Namespace: tt.namespace,
+				Version:   1,
+				Info:      &release.Info{Status: release.StatusDeployed},
+				Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "widget", Version: "1.0.0"}},
+				Manifest:  widgetManifest,
+			}
+			if err := cfg.Releases.Create(rel); err != nil {
+				t.Fatal(err)
+			}
+
+			job := &Job{ObjectMeta: metav1.ObjectMeta{Name: "map", Namespace: tt.namespace, Generation: 1}, Spec: tt.spec}
+			c := newTestController(t, kubeConfig, job)
+			if err := c.reconcile(context.Background(), tt.namespace+"/map"); err != nil {
+				t.Fatal(err)
+			}
+			status := jobStatus(t, c, job)
+			if status.Phase != tt.phase || !strings.Contains(status.Message, tt.message) || status.ObservedGeneration != 1 {
+				t.Fatalf("expected phase %s with message %q, got %+v", tt.phase, tt.message, status)
+			}
+			if status.Summary != tt.summary {
+				t.Errorf("expected summary %+v, got %+v", tt.summary, status.Summary)
+			}
+
+			latest, err := cfg.Releases.Last("widget")
+			if err != nil {
+				t.Fatal(err)
+			}
+			mapped := tt.summary.Mapped > 0
+			if (latest.Version == 2) != mapped || strings.Contains(latest.Manifest, "size: 2") != mapped {
+				t.Errorf("expected release mapped %t, got version %d:\n%s", mapped, latest.Version, latest.Manifest)
+			}
+			if mapped && (len(status.Releases) != 1 || status.Releases[0].Status != report.StatusMapped) {
+				t.Errorf("unexpected release statuses %+v", status.Releases)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package operator implements the controller mapping the deprecated or removed Kubernetes APIs
+// of the releases selected by MapKubeAPIsJob custom resources, and reporting the results in
+// their status.
+package operator
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+const (
+	// Group is the API group of the MapKubeAPIsJob resource
+	Group = "mapkubeapis.helm.sh"
+
+	// Version is the API version of the MapKubeAPIsJob resource
+	Version = "v1alpha1"
+
+	// Kind is the kind of the MapKubeAPIsJob resource
+	Kind = "MapKubeAPIsJob"
+)
+
+// JobResource is the group, version and resource of the MapKubeAPIsJob resource
+var JobResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "mapkubeapisjobs"}
+
+// Phases of the last run of a job
+const (
+	// PhaseSucceeded is the phase of a run which checked or mapped all the selected releases
+	PhaseSucceeded = "Succeeded"
+
+	// PhasePartiallyFailed is the phase of a run which failed to check or map some of the
+	// selected releases
+	PhasePartiallyFailed = "PartiallyFailed"
+
+	// PhaseFailed is the phase of a run which failed, e.g. as the releases could not be listed
+	// or the job is not valid
+	PhaseFailed = "Failed"
+)
+
+// Job is a MapKubeAPIsJob, which maps the deprecated or removed Kubernetes APIs of the
+// releases it selects once, or on a schedule
+type Job struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   JobSpec   `json:"spec,omitempty"`
+	Status JobStatus `json:"status,omitempty"`
+}
+
+// JobSpec is the specification of a MapKubeAPIsJob
+type JobSpec struct {
+	// Namespaces of the releases, all namespaces if empty. A job outside the namespace of the
+	// operator can only select the releases of its own namespace, the default.
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Releases are the patterns of the names of the releases, as matched by path.Match, e.g.
+	// web-*; all the releases of the namespaces if empty
+	Releases []string `json:"releases,omitempty"`
+
+	// MapFile is the mapping file the releases are mapped with, that of the controller if nil
+	MapFile *MapFileSource `json:"mapFile,omitempty"`
+
+	// DryRun only reports the releases with deprecated or removed APIs, without mapping them
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Force maps the releases managed by a GitOps controller, which are skipped otherwise
+	Force bool `json:"force,omitempty"`
+
+	// Schedule of the runs of the job in the cron format, e.g. "0 3 * * *"; the job runs once
+	// for each change of its specification if empty
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// MapFileSource is the source of a mapping file, one of Source or ConfigMapKeyRef
+type MapFileSource struct {
+	// Source of the mapping file: an http:// or https:// URL, an oci:// reference, or
+	// "embedded" for the mapping file embedded in the controller
+	Source string `json:"source,omitempty"`
+
+	// ConfigMapKeyRef is the key of a ConfigMap in the namespace of the job
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+// JobStatus is the status of a MapKubeAPIsJob, the result of its last run
+type JobStatus struct {
+	// ObservedGeneration is the generation of the specification of the job last reconciled
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Phase of the last run, one of: Succeeded, PartiallyFailed, Failed
+	Phase string `json:"phase,omitempty"`
+
+	// Message describes the failure of the last run
+	Message string `json:"message,omitempty"`
+
+	// LastRunTime is the start time of the last run
+	LastRunTime *metav1.Time `json:"lastRunTime,omitempty"`
+
+	// NextRunTime is the time of the next run of a scheduled job
+	NextRunTime *metav1.Time `json:"nextRunTime,omitempty"`
+
+	// Summary counts the releases of the last run by status
+	Summary JobSummary `json:"summary,omitempty"`
+
+	// Releases are the releases of the last run with deprecated or removed APIs, or which
+	// failed to be checked or mapped
+	Releases []ReleaseStatus `json:"releases,omitempty"`
+}
+
+// JobSummary counts the releases of a run by status
+type JobSummary struct {
+	Releases int `json:"releases"`
+	Clean    int `json:"clean"`
+	Pending  int `json:"pending"`
+	Mapped   int `json:"mapped"`
+	Failed   int `json:"failed"`
+}
+
+// ReleaseStatus is the result of checking or mapping a release
+type ReleaseStatus struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision,omitempty"`
+
+	// Status of the release, one of: clean, pending, mapped, failed
+	Status string `json:"status"`
+
+	// MappedAPIs are the deprecated or removed APIs found, which were or would be mapped
+	MappedAPIs []common.MappedAPI `json:"mappedAPIs,omitempty"`
+
+	// ManagedBy is the GitOps controller reconciling the release, if any
+	ManagedBy string `json:"managedBy,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// jobFromUnstructured returns the job of an object of the MapKubeAPIsJob resource
+func jobFromUnstructured(obj *unstructured.Unstructured) (*Job, error) {
+	job := new(Job)
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, job); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s '%s/%s'", Kind, obj.GetNamespace(), obj.GetName())
+	}
+	return job, nil
+}
+
+// validate returns an error if the specification of the job is not valid
+func (s JobSpec) validate() error {
+	for _, pattern := range s.Releases {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid release pattern '%s'", pattern)
+		}
+	}
+	if s.MapFile != nil {
+		switch {
+		case s.MapFile.Source != "" && s.MapFile.ConfigMapKeyRef != nil:
+			return errors.New("only one of mapFile.source and mapFile.configMapKeyRef may be set")
+		case s.MapFile.ConfigMapKeyRef != nil && (s.MapFile.ConfigMapKeyRef.Name == "" || s.MapFile.ConfigMapKeyRef.Key == ""):
+			return errors.New("mapFile.configMapKeyRef requires a name and a key")
+		case s.MapFile.Source != "" && !validSource(s.MapFile.Source):
+			return errors.Errorf("invalid mapFile.source '%s': must be an http:// or https:// URL, an oci:// reference, or %s", s.MapFile.Source, mapping.EmbeddedSource)
+		}
+	}
+	return nil
+}
+
+// validSource returns true if the source of a mapping file is a URL, an OCI reference, or the
+// embedded mapping file. Paths are not valid, so that a job cannot read the files of the operator.
+func validSource(source string) bool {
+	return source == mapping.EmbeddedSource ||
+		strings.HasPrefix(source, "http://") ||
+		strings.HasPrefix(source, "https://") ||
+		strings.HasPrefix(source, "oci://")
+}
+
+// selects returns true if the job selects the release of the name
+func (s JobSpec) selects(releaseName string) bool {
+	if len(s.Releases) == 0 {
+		return true
+	}
+	for _, pattern := range s.Releases {
+		if ok, _ := path.Match(pattern, releaseName); ok {
+			return true
+		}
+	}
+	return false
+}
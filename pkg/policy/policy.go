@@ -0,0 +1,149 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+)
+
+const (
+	// ActionAbort fails the run if a policy denies the change
+	ActionAbort = "abort"
+
+	// ActionDryRun leaves the release unchanged, as in dry-run mode, if a policy denies the change
+	ActionDryRun = "dry-run"
+
+	// query is the Rego query evaluated to get the denials of the policies
+	query = "data.mapkubeapis.deny"
+)
+
+// Input is the input document the policies are evaluated against
+type Input struct {
+	Release    Release                  `json:"release"`
+	MappedAPIs []common.MappedAPI       `json:"mappedAPIs,omitempty"`
+	Findings   common.Findings          `json:"findings,omitempty"`
+	Manifests  []map[string]interface{} `json:"manifests"`
+}
+
+// Release identifies the release in the input document
+type Release struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+}
+
+// ValidateAction returns an error if the policy action is not supported
+func ValidateAction(action string) error {
+	if action != ActionAbort && action != ActionDryRun {
+		return errors.Errorf("unsupported policy action '%s', must be one of: %s, %s", action, ActionAbort, ActionDryRun)
+	}
+	return nil
+}
+
+// Evaluate evaluates the Rego policies of the directory against the input with the opa CLI,
+// and returns the messages of the deny rules of the mapkubeapis package
+func Evaluate(policyDir string, input Input) ([]string, error) {
+	opa, err := exec.LookPath("opa")
+	if err != nil {
+		return nil, errors.Wrap(err, "the opa CLI is required to evaluate policies")
+	}
+	b, err := json.Marshal(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the policy input")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(opa, "eval", "--format", "json", "--stdin-input", "--data", policyDir, query)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "failed to evaluate policies: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	var output struct {
+		Result []struct {
+			Expressions []struct {
+				Value interface{} `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the policy evaluation result")
+	}
+	var denials []string
+	for _, result := range output.Result {
+		for _, expression := range result.Expressions {
+			values, ok := expression.Value.([]interface{})
+			if !ok {
+				continue
+			}
+			for _, value := range values {
+				if msg, ok := value.(string); ok {
+					denials = append(denials, msg)
+				} else {
+					denials = append(denials, fmt.Sprint(value))
+				}
+			}
+		}
+	}
+	return denials, nil
+}
+
+// Gate returns a pre-map hook which evaluates the Rego policies of the directory against the
+// release with its APIs mapped. If any policy denies the change, the hook fails or, with
+// ActionDryRun, skips the update of the release.
+func Gate(policyDir, action string) common.Hook {
+	return func(_ context.Context, result *common.ReleaseResult) error {
+		manifests, err := convert.Objects(result.Manifest)
+		if err != nil {
+			return err
+		}
+		input := Input{
+			Release: Release{
+				Name:      result.Name,
+				Namespace: result.Namespace,
+				Revision:  result.Revision,
+			},
+			MappedAPIs: result.MappedAPIs,
+			Findings:   result.Findings,
+			Manifests:  manifests,
+		}
+		denials, err := Evaluate(policyDir, input)
+		if err != nil {
+			return err
+		}
+		if len(denials) == 0 {
+			return nil
+		}
+		err = errors.Errorf("denied by policy: %s", strings.Join(denials, "; "))
+		if action == ActionDryRun {
+			return errors.Wrap(common.ErrSkipUpdate, err.Error())
+		}
+		return err
+	}
+}
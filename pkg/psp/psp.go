@@ -0,0 +1,355 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package psp describes the protections lost when PodSecurityPolicy resources are removed
+// from a release, and suggests the Pod Security Admission levels which replace them.
+package psp
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Pod Security Standard levels, from the most to the least restrictive
+const (
+	// LevelRestricted is the heavily restricted Pod Security Standard
+	LevelRestricted = "restricted"
+
+	// LevelBaseline is the minimally restrictive Pod Security Standard, preventing known
+	// privilege escalations
+	LevelBaseline = "baseline"
+
+	// LevelPrivileged is the unrestricted Pod Security Standard
+	LevelPrivileged = "privileged"
+)
+
+// Pod Security Admission namespace labels
+const (
+	LabelEnforce = "pod-security.kubernetes.io/enforce"
+	LabelAudit   = "pod-security.kubernetes.io/audit"
+	LabelWarn    = "pod-security.kubernetes.io/warn"
+)
+
+// baselineCapabilities are the capabilities which pods may add under the baseline level
+var baselineCapabilities = map[string]bool{
+	"AUDIT_WRITE": true, "CHOWN": true, "DAC_OVERRIDE": true, "FOWNER": true, "FSETID": true,
+	"KILL": true, "MKNOD": true, "NET_BIND_SERVICE": true, "SETFCAP": true, "SETGID": true,
+	"SETPCAP": true, "SETUID": true, "SYS_CHROOT": true,
+}
+
+// restrictedVolumes are the volume types which pods may use under the restricted level
+var restrictedVolumes = map[string]bool{
+	"configMap": true, "csi": true, "downwardAPI": true, "emptyDir": true, "ephemeral": true,
+	"persistentVolumeClaim": true, "projected": true, "secret": true,
+}
+
+// Guidance describes a removed PodSecurityPolicy and the Pod Security Standard it maps to
+type Guidance struct {
+	// Name of the PodSecurityPolicy
+	Name string
+
+	// Level is the most restrictive Pod Security Standard which allows all the pods the
+	// policy allows
+	Level string
+
+	// Reasons are the settings of the policy which prevent a more restrictive level
+	Reasons []string
+
+	// Lost are the protections of the policy which Pod Security Admission does not enforce
+	// at the level
+	Lost []string
+}
+
+// Analyze returns the guidance for the PodSecurityPolicy resources among the objects
+func Analyze(objects []map[string]interface{}) []Guidance {
+	var guidance []Guidance
+	for _, obj := range objects {
+		if obj["kind"] != "PodSecurityPolicy" {
+			continue
+		}
+		guidance = append(guidance, analyze(obj))
+	}
+	return guidance
+}
+
+// analyze returns the guidance for a PodSecurityPolicy
+func analyze(obj map[string]interface{}) Guidance {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	spec, _ := obj["spec"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	g := Guidance{Level: LevelRestricted}
+	g.Name, _ = metadata["name"].(string)
+
+	var baseline, restricted []string
+	if spec["privileged"] == true {
+		baseline = append(baseline, "allows privileged containers")
+	}
+	for _, field := range []string{"hostNetwork", "hostPID", "hostIPC"} {
+		if spec[field] == true {
+			baseline = append(baseline, fmt.Sprintf("allows %s", field))
+		}
+	}
+	if len(list(spec["hostPorts"])) > 0 {
+		baseline = append(baseline, "allows host ports")
+	}
+	for _, capability := range stringList(spec["allowedCapabilities"]) {
+		if !baselineCapabilities[capability] {
+			baseline = append(baseline, fmt.Sprintf("allows adding capability %s", capability))
+		}
+	}
+	volumes := stringList(spec["volumes"])
+	for _, volume := range volumes {
+		switch volume {
+		case "*", "hostPath":
+			baseline = append(baseline, fmt.Sprintf("allows %s volumes", volume))
+		}
+	}
+	for _, procMount := range stringList(spec["allowedProcMountTypes"]) {
+		if procMount == "Unmasked" {
+			baseline = append(baseline, "allows unmasked /proc mounts")
+		}
+	}
+	if len(list(spec["allowedUnsafeSysctls"])) > 0 {
+		baseline = append(baseline, "allows unsafe sysctls")
+	}
+
+	if spec["allowPrivilegeEscalation"] != false {
+		restricted = append(restricted, "allows privilege escalation")
+	}
+	if !runsAsNonRoot(spec) {
+		restricted = append(restricted, "allows running as root")
+	}
+	if !dropsAllCapabilities(spec) {
+		restricted = append(restricted, "does not require dropping all capabilities")
+	}
+	for _, volume := range volumes {
+		if volume != "*" && volume != "hostPath" && !restrictedVolumes[volume] {
+			restricted = append(restricted, fmt.Sprintf("allows %s volumes", volume))
+		}
+	}
+	if !requiresSeccomp(annotations) {
+		restricted = append(restricted, "does not require the RuntimeDefault or Localhost seccomp profile")
+	}
+
+	switch {
+	case len(baseline) > 0:
+		g.Level, g.Reasons = LevelPrivileged, baseline
+	case len(restricted) > 0:
+		g.Level, g.Reasons = LevelBaseline, restricted
+	}
+	g.Lost = lostProtections(spec, annotations, g.Level)
+	return g
+}
+
+// lostProtections returns the protections of a PodSecurityPolicy which Pod Security Admission
+// does not enforce at the level
+func lostProtections(spec, annotations map[string]interface{}, level string) []string {
+	var lost []string
+	if spec["readOnlyRootFilesystem"] == true {
+		lost = append(lost, "read-only root filesystem is not required")
+	}
+	for _, field := range []string{"runAsUser", "runAsGroup", "fsGroup", "supplementalGroups"} {
+		if rule(spec, field) == "MustRunAs" && len(list(fieldOf(spec, field)["ranges"])) > 0 {
+			lost = append(lost, fmt.Sprintf("%s ID ranges are not enforced", field))
+		}
+	}
+	if rule(spec, "seLinux") == "MustRunAs" {
+		lost = append(lost, "SELinux options are not enforced")
+	}
+	if len(list(spec["allowedHostPaths"])) > 0 && level == LevelPrivileged {
+		lost = append(lost, "allowed host paths are not restricted")
+	}
+	if len(list(spec["allowedFlexVolumes"])) > 0 {
+		lost = append(lost, "allowed FlexVolume drivers are not restricted")
+	}
+	if len(list(spec["allowedCSIDrivers"])) > 0 {
+		lost = append(lost, "allowed CSI drivers are not restricted")
+	}
+	if len(list(spec["forbiddenSysctls"])) > 0 && level == LevelPrivileged {
+		lost = append(lost, "forbidden sysctls are not enforced")
+	}
+	// Pod Security Admission only validates pods, it does not set defaults like policies did
+	if len(list(spec["defaultAddCapabilities"])) > 0 {
+		lost = append(lost, "default added capabilities are no longer set on pods")
+	}
+	if _, ok := spec["defaultAllowPrivilegeEscalation"]; ok {
+		lost = append(lost, "the default of allowPrivilegeEscalation is no longer set on pods")
+	}
+	for _, annotation := range []string{
+		"seccomp.security.alpha.kubernetes.io/defaultProfileName",
+		"apparmor.security.beta.kubernetes.io/defaultProfileName",
+	} {
+		if _, ok := annotations[annotation]; ok {
+			lost = append(lost, fmt.Sprintf("the default profile of %s is no longer set on pods", annotation))
+		}
+	}
+	return lost
+}
+
+// runsAsNonRoot returns true if a PodSecurityPolicy requires containers to run as non-root
+func runsAsNonRoot(spec map[string]interface{}) bool {
+	switch rule(spec, "runAsUser") {
+	case "MustRunAsNonRoot":
+		return true
+	case "MustRunAs":
+		ranges := list(fieldOf(spec, "runAsUser")["ranges"])
+		for _, r := range ranges {
+			idRange, _ := r.(map[string]interface{})
+			if minID, _ := idRange["min"].(int); minID == 0 {
+				return false
+			}
+		}
+		return len(ranges) > 0
+	}
+	return false
+}
+
+// dropsAllCapabilities returns true if a PodSecurityPolicy requires all capabilities to be dropped
+func dropsAllCapabilities(spec map[string]interface{}) bool {
+	for _, capability := range stringList(spec["requiredDropCapabilities"]) {
+		if capability == "ALL" {
+			return true
+		}
+	}
+	return false
+}
+
+// requiresSeccomp returns true if a PodSecurityPolicy only allows the RuntimeDefault or
+// Localhost seccomp profiles
+func requiresSeccomp(annotations map[string]interface{}) bool {
+	allowed, _ := annotations["seccomp.security.alpha.kubernetes.io/allowedProfileNames"].(string)
+	if allowed == "" {
+		return false
+	}
+	for _, profile := range strings.Split(allowed, ",") {
+		profile = strings.TrimSpace(profile)
+		if profile != "runtime/default" && profile != "docker/default" && !strings.HasPrefix(profile, "localhost/") {
+			return false
+		}
+	}
+	return true
+}
+
+// Labels returns the Pod Security Admission namespace labels suggested to replace the policies.
+// Pods are enforced at the least restrictive level of the policies, so that the pods allowed by
+// any of the policies are still allowed, and audited and warned at the restricted level.
+func Labels(guidance []Guidance) map[string]string {
+	if len(guidance) == 0 {
+		return nil
+	}
+	level := LevelRestricted
+	for _, g := range guidance {
+		if rank(g.Level) > rank(level) {
+			level = g.Level
+		}
+	}
+	return map[string]string{
+		LabelEnforce: level,
+		LabelAudit:   LevelRestricted,
+		LabelWarn:    LevelRestricted,
+	}
+}
+
+// rank orders the levels from the most to the least restrictive
+func rank(level string) int {
+	switch level {
+	case LevelBaseline:
+		return 1
+	case LevelPrivileged:
+		return 2
+	}
+	return 0
+}
+
+// Report describes the PodSecurityPolicy resources removed from a release
+type Report struct {
+	Release   string
+	Namespace string
+	Guidance  []Guidance
+}
+
+// Labels returns the suggested Pod Security Admission labels of the release namespace
+func (r *Report) Labels() []string {
+	labels := Labels(r.Guidance)
+	var pairs []string
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// Write renders the report as a Markdown document
+func (r *Report) Write(w io.Writer) error {
+	t := template.Must(template.New("psp").Funcs(map[string]interface{}{
+		"join": strings.Join,
+	}).Parse(markdownTemplate))
+	return t.Execute(w, r)
+}
+
+const markdownTemplate = `# PodSecurityPolicy removal: {{ .Release }}
+
+The following PodSecurityPolicy resources were removed from release ` + "`{{ .Release }}`" + ` in namespace ` + "`{{ .Namespace }}`" + `, as the PodSecurityPolicy API has no replacement. Enforce the Pod Security Standards with Pod Security Admission instead.
+{{ range .Guidance }}
+## ` + "`{{ .Name }}`" + `
+
+Closest Pod Security Standard: **{{ .Level }}**{{ if .Reasons }}, as the policy {{ join .Reasons ", " }}{{ end }}.
+{{ if .Lost }}
+Protections not enforced by Pod Security Admission:
+{{ range .Lost }}
+- {{ . }}{{ end }}
+{{ end }}{{ end }}
+## Suggested namespace labels
+
+` + "```console" + `
+kubectl label --overwrite namespace {{ .Namespace }}{{ range .Labels }} \
+  {{ . }}{{ end }}
+` + "```" + `
+
+Review the workloads of the namespace before enforcing the labels, e.g. with ` + "`kubectl label --dry-run=server`" + `.
+`
+
+// list returns a decoded array value, or nil if it is not an array
+func list(value interface{}) []interface{} {
+	l, _ := value.([]interface{})
+	return l
+}
+
+// stringList returns the string elements of a decoded array value
+func stringList(value interface{}) []string {
+	var s []string
+	for _, v := range list(value) {
+		if str, ok := v.(string); ok {
+			s = append(s, str)
+		}
+	}
+	return s
+}
+
+// fieldOf returns a decoded object field of an object, or nil if it is not an object
+func fieldOf(obj map[string]interface{}, field string) map[string]interface{} {
+	m, _ := obj[field].(map[string]interface{})
+	return m
+}
+
+// rule returns the rule of a strategy field of a PodSecurityPolicy spec, e.g. runAsUser
+func rule(spec map[string]interface{}, field string) string {
+	r, _ := fieldOf(spec, field)["rule"].(string)
+	return r
+}
@@ -0,0 +1,276 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package report
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+const (
+	// FormatMarkdown renders the report as a Markdown document
+	FormatMarkdown = "markdown"
+
+	// FormatHTML renders the report as an HTML document
+	FormatHTML = "html"
+)
+
+// Release status values in a report
+const (
+	// StatusClean is the status of a release with no deprecated or removed APIs
+	StatusClean = "clean"
+
+	// StatusPending is the status of a release with deprecated or removed APIs which are not mapped yet
+	StatusPending = "pending"
+
+	// StatusMapped is the status of a release with deprecated or removed APIs which were mapped
+	StatusMapped = "mapped"
+
+	// StatusFailed is the status of a release which could not be checked or mapped
+	StatusFailed = "failed"
+)
+
+// Release are the findings for a release in a report
+type Release struct {
+	// Context is the kubeconfig context of the cluster of the release in a multi-cluster report
+	Context string
+
+	Name       string
+	Namespace  string
+	Revision   int
+	Status     string
+	MappedAPIs []common.MappedAPI
+	Error      string
+}
+
+// ManualActionAPIs returns the APIs found in the release which have no replacement API and
+// require manual action
+func (r Release) ManualActionAPIs() []common.MappedAPI {
+	var apis []common.MappedAPI
+	for _, api := range r.MappedAPIs {
+		if api.NewAPI == "" {
+			apis = append(apis, api)
+		}
+	}
+	return apis
+}
+
+// Namespace are the releases of a namespace in a report
+type Namespace struct {
+	Name     string
+	Releases []Release
+}
+
+// Cluster is a cluster of a multi-cluster report, named after its kubeconfig context. Error
+// is set if the releases of the cluster could not be listed.
+type Cluster struct {
+	Name  string
+	Error string
+}
+
+// Section are the releases of a cluster in a report, grouped by namespace. The cluster is not
+// set in a single-cluster report. Heading is the Markdown heading of the namespaces of the
+// section, which are nested in the section of the cluster of a multi-cluster report.
+type Section struct {
+	Cluster    *Cluster
+	Heading    string
+	Namespaces []Namespace
+}
+
+// Report is an upgrade readiness report summarizing the findings for a set of releases
+type Report struct {
+	Title       string
+	GeneratedAt time.Time
+	Releases    []Release
+
+	// Clusters are the clusters of a multi-cluster report, in the order of their sections,
+	// whose releases are those of their context; nil in a single-cluster report
+	Clusters []Cluster
+}
+
+// Sections returns the releases of the report grouped by cluster, i.e. a single section of
+// all the releases in a single-cluster report
+func (r *Report) Sections() []Section {
+	if len(r.Clusters) == 0 {
+		return []Section{{Heading: "##", Namespaces: r.Namespaces()}}
+	}
+	var sections []Section
+	for i := range r.Clusters {
+		cluster := &r.Clusters[i]
+		var releases []Release
+		for _, rel := range r.Releases {
+			if rel.Context == cluster.Name {
+				releases = append(releases, rel)
+			}
+		}
+		sections = append(sections, Section{Cluster: cluster, Heading: "###", Namespaces: groupByNamespace(releases)})
+	}
+	return sections
+}
+
+// Namespaces returns the releases of the report grouped by namespace, sorted by name
+func (r *Report) Namespaces() []Namespace {
+	return groupByNamespace(r.Releases)
+}
+
+// groupByNamespace returns the releases grouped by namespace, sorted by name
+func groupByNamespace(releases []Release) []Namespace {
+	byNamespace := map[string][]Release{}
+	for _, rel := range releases {
+		byNamespace[rel.Namespace] = append(byNamespace[rel.Namespace], rel)
+	}
+	var namespaces []Namespace
+	for name, releases := range byNamespace {
+		sort.Slice(releases, func(i, j int) bool { return releases[i].Name < releases[j].Name })
+		namespaces = append(namespaces, Namespace{Name: name, Releases: releases})
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	return namespaces
+}
+
+// Count returns the number of releases in the report with the given status
+func (r *Report) Count(status string) int {
+	var count int
+	for _, rel := range r.Releases {
+		if rel.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// ManualAction returns the releases of the report which require manual action, that is
+// releases which failed or contain APIs without a replacement
+func (r *Report) ManualAction() []Release {
+	var releases []Release
+	for _, section := range r.Sections() {
+		for _, ns := range section.Namespaces {
+			for _, rel := range ns.Releases {
+				if rel.Status == StatusFailed || len(rel.ManualActionAPIs()) > 0 {
+					releases = append(releases, rel)
+				}
+			}
+		}
+	}
+	return releases
+}
+
+// ValidateFormat returns an error if the report format is not supported
+func ValidateFormat(format string) error {
+	if format != FormatMarkdown && format != FormatHTML {
+		return errors.Errorf("unsupported report format '%s', must be one of: %s, %s", format, FormatMarkdown, FormatHTML)
+	}
+	return nil
+}
+
+// Write renders the report in the given format
+func (r *Report) Write(w io.Writer, format string) error {
+	funcs := map[string]interface{}{
+		"flatten": common.FlattenAPI,
+		"date":    func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+	}
+	switch format {
+	case FormatMarkdown:
+		t := template.Must(template.New("report").Funcs(funcs).Parse(markdownTemplate))
+		return t.Execute(w, r)
+	case FormatHTML:
+		t := htmltemplate.Must(htmltemplate.New("report").Funcs(funcs).Parse(htmlTemplate))
+		return t.Execute(w, r)
+	}
+	return ValidateFormat(format)
+}
+
+const markdownTemplate = `# {{ .Title }}
+
+Generated at {{ date .GeneratedAt }}.
+
+## Summary
+
+| Status | Releases |
+|--------|----------|
+| Clean | {{ .Count "clean" }} |
+| Pending | {{ .Count "pending" }} |
+| Mapped | {{ .Count "mapped" }} |
+| Failed | {{ .Count "failed" }} |
+{{ range .Sections }}{{ with .Cluster }}
+## Cluster ` + "`{{ .Name }}`" + `
+{{ if .Error }}
+Error: {{ .Error }}
+{{ end }}{{ end }}{{ $heading := .Heading }}{{ range .Namespaces }}
+{{ $heading }} Namespace ` + "`{{ .Name }}`" + `
+{{ range .Releases }}
+{{ $heading }}# {{ .Name }}{{ if .Revision }} (revision {{ .Revision }}){{ end }}: {{ .Status }}
+{{ if .Error }}
+Error: {{ .Error }}
+{{ end }}{{ if .MappedAPIs }}
+| Deprecated API | New API | Removed in | Count |
+|----------------|---------|------------|-------|
+{{ range .MappedAPIs }}| ` + "`{{ flatten .DeprecatedAPI }}`" + ` | {{ if .NewAPI }}` + "`{{ flatten .NewAPI }}`" + `{{ else }}_none_{{ end }} | {{ .RemovedInVersion }} | {{ .Count }} |
+{{ end }}{{ end }}{{ end }}{{ end }}{{ end }}
+## Manual action required
+
+{{ range .ManualAction }}- ` + "`{{ if .Context }}{{ .Context }}/{{ end }}{{ .Namespace }}/{{ .Name }}`" + `: {{ if .Error }}{{ .Error }}{{ else }}{{ range $i, $api := .ManualActionAPIs }}{{ if $i }}, {{ end }}` + "`{{ flatten $api.DeprecatedAPI }}`" + ` has no replacement API{{ end }}{{ end }}
+{{ else }}None.
+{{ end }}`
+
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.failed { color: #b00; }
+</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+<p>Generated at {{ date .GeneratedAt }}.</p>
+<h2>Summary</h2>
+<table>
+<tr><th>Status</th><th>Releases</th></tr>
+<tr><td>Clean</td><td>{{ .Count "clean" }}</td></tr>
+<tr><td>Pending</td><td>{{ .Count "pending" }}</td></tr>
+<tr><td>Mapped</td><td>{{ .Count "mapped" }}</td></tr>
+<tr><td>Failed</td><td>{{ .Count "failed" }}</td></tr>
+</table>
+{{ range .Sections }}{{ $nested := .Cluster }}{{ with .Cluster }}<h2>Cluster <code>{{ .Name }}</code></h2>
+{{ if .Error }}<p class="failed">Error: {{ .Error }}</p>
+{{ end }}{{ end }}{{ range .Namespaces }}{{ if $nested }}<h3>{{ else }}<h2>{{ end }}Namespace <code>{{ .Name }}</code>{{ if $nested }}</h3>{{ else }}</h2>{{ end }}
+{{ range .Releases }}{{ if $nested }}<h4>{{ else }}<h3>{{ end }}{{ .Name }}{{ if .Revision }} (revision {{ .Revision }}){{ end }}: {{ .Status }}{{ if $nested }}</h4>{{ else }}</h3>{{ end }}
+{{ if .Error }}<p class="failed">Error: {{ .Error }}</p>
+{{ end }}{{ if .MappedAPIs }}<table>
+<tr><th>Deprecated API</th><th>New API</th><th>Removed in</th><th>Count</th></tr>
+{{ range .MappedAPIs }}<tr><td><code>{{ flatten .DeprecatedAPI }}</code></td><td>{{ if .NewAPI }}<code>{{ flatten .NewAPI }}</code>{{ else }}<em>none</em>{{ end }}</td><td>{{ .RemovedInVersion }}</td><td>{{ .Count }}</td></tr>
+{{ end }}</table>
+{{ end }}{{ end }}{{ end }}{{ end }}<h2>Manual action required</h2>
+<ul>
+{{ range .ManualAction }}<li><code>{{ if .Context }}{{ .Context }}/{{ end }}{{ .Namespace }}/{{ .Name }}</code>: {{ if .Error }}{{ .Error }}{{ else }}{{ range $i, $api := .ManualActionAPIs }}{{ if $i }}, {{ end }}<code>{{ flatten $api.DeprecatedAPI }}</code> has no replacement API{{ end }}{{ end }}</li>
+{{ else }}<li>None.</li>
+{{ end }}</ul>
+</body>
+</html>
+`
@@ -0,0 +1,201 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package schedule parses cron schedules and computes their next run times.
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// descriptors are the predefined schedules
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field is the range of values of a field of a cron schedule
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+// Schedule is a cron schedule, in the standard 5 fields format: minute, hour, day of month,
+// month and day of week
+type Schedule struct {
+	spec string
+
+	// sets of the values of the fields
+	minutes, hours, days, months, weekdays uint64
+
+	// restricted days of month and days of week, a time matches either if both are restricted
+	daysRestricted, weekdaysRestricted bool
+}
+
+// Parse parses a cron schedule in the standard 5 fields format, e.g. "0 3 * * *". The fields
+// accept *, values, ranges, lists and steps, e.g. 1-5, 1,15 or */10, and the names of the
+// months and days of week, e.g. jan or mon. The predefined schedules @yearly, @annually,
+// @monthly, @weekly, @daily, @midnight and @hourly are also accepted.
+func Parse(spec string) (*Schedule, error) {
+	expanded := strings.TrimSpace(spec)
+	if descriptor, ok := descriptors[expanded]; ok {
+		expanded = descriptor
+	}
+	parts := strings.Fields(expanded)
+	if len(parts) != len(fields) {
+		return nil, errors.Errorf("invalid schedule '%s': expected %d fields, found %d", spec, len(fields), len(parts))
+	}
+	sets := make([]uint64, len(fields))
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid schedule '%s'", spec)
+		}
+		sets[i] = set
+	}
+	// Sunday is either 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &Schedule{
+		spec:               spec,
+		minutes:            sets[0],
+		hours:              sets[1],
+		days:               sets[2],
+		months:             sets[3],
+		weekdays:           sets[4],
+		daysRestricted:     parts[2] != "*",
+		weekdaysRestricted: parts[4] != "*",
+	}, nil
+}
+
+// parseField returns the set of the values of a field of a cron schedule
+func parseField(part string, f field) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(part, ",") {
+		rangePart, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			rangePart = item[:i]
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step < 1 {
+				return 0, errors.Errorf("invalid step '%s' of %s", item[i+1:], f.name)
+			}
+		}
+		low, high := f.min, f.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = parseValue(bounds[0], f); err != nil {
+				return 0, err
+			}
+			if high, err = parseValue(bounds[1], f); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, errors.Errorf("invalid range '%s' of %s", rangePart, f.name)
+			}
+		default:
+			value, err := parseValue(rangePart, f)
+			if err != nil {
+				return 0, err
+			}
+			low = value
+			if step == 1 {
+				high = value
+			}
+		}
+		for value := low; value <= high; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+	return set, nil
+}
+
+// parseValue returns the value of a field of a cron schedule, a number or a name
+func parseValue(s string, f field) (int, error) {
+	if value, ok := f.names[strings.ToLower(s)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(s)
+	if err != nil || value < f.min || value > f.max {
+		return 0, errors.Errorf("invalid value '%s' of %s, must be between %d and %d", s, f.name, f.min, f.max)
+	}
+	return value, nil
+}
+
+// String returns the schedule as parsed
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// Next returns the first time of the schedule after t, in the location of t. It returns the
+// zero time if the schedule has no time in the next 5 years, e.g. on February 30.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hours&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay returns true if the day of t matches the day of month and day of week of the
+// schedule. If both are restricted, the day matches either.
+func (s *Schedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+	if s.daysRestricted && s.weekdaysRestricted {
+		return day || weekday
+	}
+	return day && weekday
+}
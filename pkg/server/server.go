@@ -0,0 +1,372 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package server implements the HTTP API of the server mode, which checks and maps the
+// releases of a cluster, or raw manifests, on request, e.g. of internal portals or upgrade
+// orchestrators.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapkubeapis"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	"github.com/helm/helm-mapkubeapis/pkg/report"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+const (
+	// CheckPath is the path of the API checking releases or a manifest for deprecated or
+	// removed APIs, without modifying release storage
+	CheckPath = "/v1/check"
+
+	// MapPath is the path of the API mapping the deprecated or removed APIs of releases or of
+	// a manifest
+	MapPath = "/v1/map"
+
+	// maxRequestSize bounds the size of a request, e.g. of the manifest it maps
+	maxRequestSize = 16 * 1024 * 1024
+)
+
+// Options are the options of the server
+type Options struct {
+	KubeConfig common.KubeConfig
+
+	// MappingProvider provides the API mappings the releases and manifests are mapped with
+	MappingProvider mapping.MappingProvider
+
+	StorageDriver  string
+	ReleaseTimeout time.Duration
+
+	// Token is the bearer token the requests must be authenticated with, if set
+	Token string
+
+	Logger common.Logger
+}
+
+// Request is the body of a request, which selects releases, or sets a manifest
+type Request struct {
+	// Namespaces of the releases, all namespaces if empty
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Releases are the patterns of the names of the releases, as matched by path.Match, e.g.
+	// web-*; all the releases of the namespaces if empty
+	Releases []string `json:"releases,omitempty"`
+
+	// Manifest is a multi-document YAML manifest stream, e.g. the output of helm template,
+	// checked or mapped instead of releases
+	Manifest string `json:"manifest,omitempty"`
+
+	// KubeVersion is the Kubernetes version the manifest is mapped for, that of the cluster
+	// if not set
+	KubeVersion string `json:"kubeVersion,omitempty"`
+
+	// DryRun reports the releases which would be mapped without mapping them
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Force maps the releases even if they are managed by a GitOps controller
+	Force bool `json:"force,omitempty"`
+}
+
+// Response is the body of the response to a request
+type Response struct {
+	// Releases are the results of the releases selected
+	Releases []ReleaseResult `json:"releases,omitempty"`
+
+	// Summary counts the releases selected by status
+	Summary *Summary `json:"summary,omitempty"`
+
+	// Manifest is the result of the manifest of the request, whose manifest with the APIs
+	// mapped is only set by a map request
+	Manifest *common.ManifestResult `json:"manifest,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// ReleaseResult is the result of checking or mapping a release
+type ReleaseResult struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision,omitempty"`
+
+	// Status of the release, one of: clean, pending, mapped, failed
+	Status string `json:"status"`
+
+	// MappedAPIs are the deprecated or removed APIs found, which were or would be mapped
+	MappedAPIs []common.MappedAPI `json:"mappedAPIs,omitempty"`
+
+	// Findings are the resources found using deprecated or removed APIs
+	Findings common.Findings `json:"findings,omitempty"`
+
+	// ManagedBy is the GitOps controller reconciling the release, if any
+	ManagedBy string `json:"managedBy,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Summary counts the releases of a response by status
+type Summary struct {
+	Releases int `json:"releases"`
+	Clean    int `json:"clean"`
+	Pending  int `json:"pending"`
+	Mapped   int `json:"mapped"`
+	Failed   int `json:"failed"`
+}
+
+// Server serves the HTTP API
+type Server struct {
+	options Options
+	logger  common.Logger
+	mux     *http.ServeMux
+
+	// mapMutex serializes the map requests, so that a release is not mapped by concurrent
+	// requests
+	mapMutex sync.Mutex
+}
+
+// New returns the server of the HTTP API with the options
+func New(options Options) *Server {
+	s := &Server{options: options, logger: common.LoggerOrDefault(options.Logger), mux: http.NewServeMux()}
+	s.mux.HandleFunc(CheckPath, func(w http.ResponseWriter, r *http.Request) { s.serve(w, r, false) })
+	s.mux.HandleFunc(MapPath, func(w http.ResponseWriter, r *http.Request) { s.serve(w, r, true) })
+	return s
+}
+
+// ServeHTTP handles a request to the API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.options.Token != "" {
+		authorization := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if token == authorization || subtle.ConstantTimeCompare([]byte(token), []byte(s.options.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeResponse(w, http.StatusUnauthorized, &Response{Error: "unauthorized"})
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// serve checks or maps the releases or the manifest of a request
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, mapAPIs bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeResponse(w, http.StatusMethodNotAllowed, &Response{Error: "method not allowed, use POST"})
+		return
+	}
+	request := new(Request)
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(request); err != nil {
+		writeResponse(w, http.StatusBadRequest, &Response{Error: fmt.Sprintf("invalid request: %s", err)})
+		return
+	}
+	if err := request.validate(); err != nil {
+		writeResponse(w, http.StatusBadRequest, &Response{Error: err.Error()})
+		return
+	}
+
+	var response *Response
+	var err error
+	if request.Manifest != "" {
+		response, err = s.mapManifest(r, request, mapAPIs)
+	} else {
+		if mapAPIs && !request.DryRun {
+			s.mapMutex.Lock()
+			defer s.mapMutex.Unlock()
+		}
+		response, err = s.mapReleases(r, request, mapAPIs)
+	}
+	if err != nil {
+		s.logger.Printf("Failed to serve %s: %s\n", r.URL.Path, err)
+		writeResponse(w, http.StatusInternalServerError, &Response{Error: err.Error()})
+		return
+	}
+	writeResponse(w, http.StatusOK, response)
+}
+
+// validate returns an error if the request is invalid
+func (r *Request) validate() error {
+	if r.Manifest != "" && (len(r.Namespaces) > 0 || len(r.Releases) > 0) {
+		return errors.New("a request sets either a manifest, or the namespaces and releases selected")
+	}
+	if r.Manifest == "" && r.KubeVersion != "" {
+		return errors.New("kubeVersion can only be set with a manifest")
+	}
+	if r.KubeVersion != "" && !semver.IsValid(r.KubeVersion) {
+		return errors.Errorf("invalid kubeVersion '%s', must be a version like v1.25.0", r.KubeVersion)
+	}
+	for _, pattern := range r.Releases {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Errorf("invalid release pattern '%s'", pattern)
+		}
+	}
+	return nil
+}
+
+// selects returns true if the request selects the release of the name
+func (r *Request) selects(releaseName string) bool {
+	if len(r.Releases) == 0 {
+		return true
+	}
+	for _, pattern := range r.Releases {
+		if ok, _ := path.Match(pattern, releaseName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mapManifest checks or maps the manifest of a request
+func (s *Server) mapManifest(r *http.Request, request *Request, mapAPIs bool) (*Response, error) {
+	kubeVersion := request.KubeVersion
+	if kubeVersion == "" {
+		var err error
+		kubeVersion, err = common.GetKubernetesServerVersion(r.Context(), s.options.KubeConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The mapping file is loaded once for the manifest of the request
+	provider := mapping.NewCachedProvider(s.options.MappingProvider)
+	mapper := mapkubeapis.New(mapkubeapis.WithLogger(s.logger), mapkubeapis.WithMappingProvider(provider))
+	result, err := mapper.MapManifests(r.Context(), strings.NewReader(request.Manifest), kubeVersion)
+	if err != nil {
+		return nil, err
+	}
+	if !mapAPIs || request.DryRun {
+		result.Manifest = ""
+	}
+	return &Response{Manifest: result}, nil
+}
+
+// mapReleases checks or maps the releases selected by a request
+func (s *Server) mapReleases(r *http.Request, request *Request, mapAPIs bool) (*Response, error) {
+	releases, err := s.selectReleases(r.Context(), request)
+	if err != nil {
+		return nil, err
+	}
+	// The mapping file is loaded once for the releases of the request
+	provider := mapping.NewCachedProvider(s.options.MappingProvider)
+	if _, err := provider.Mappings(r.Context()); err != nil {
+		return nil, err
+	}
+	opts := []mapkubeapis.Option{
+		mapkubeapis.WithDryRun(request.DryRun),
+		mapkubeapis.WithForce(request.Force),
+		mapkubeapis.WithKubeConfig(s.options.KubeConfig),
+		mapkubeapis.WithLogger(s.logger),
+		mapkubeapis.WithMappingProvider(provider),
+		mapkubeapis.WithReleaseTimeout(s.options.ReleaseTimeout),
+		mapkubeapis.WithStorageDriver(s.options.StorageDriver),
+	}
+	response := &Response{Releases: []ReleaseResult{}, Summary: &Summary{}}
+	for _, rel := range releases {
+		if err := r.Context().Err(); err != nil {
+			return nil, errors.Wrap(err, "request canceled")
+		}
+		mapper := mapkubeapis.New(append(opts, mapkubeapis.WithNamespace(rel.Namespace))...)
+		var result *mapkubeapis.Result
+		if mapAPIs {
+			result, err = mapper.MapRelease(r.Context(), rel.Name)
+		} else {
+			result, err = mapper.CheckRelease(r.Context(), rel.Name)
+		}
+		releaseResult := newReleaseResult(rel, result, err)
+		response.Releases = append(response.Releases, releaseResult)
+		response.Summary.Releases++
+		switch releaseResult.Status {
+		case report.StatusClean:
+			response.Summary.Clean++
+		case report.StatusPending:
+			response.Summary.Pending++
+		case report.StatusMapped:
+			response.Summary.Mapped++
+		case report.StatusFailed:
+			response.Summary.Failed++
+		}
+	}
+	return response, nil
+}
+
+// selectReleases returns the latest version of the releases selected by a request
+func (s *Server) selectReleases(ctx context.Context, request *Request) ([]*release.Release, error) {
+	var releases []*release.Release
+	if len(request.Namespaces) == 0 {
+		all, err := v3.ListReleases(ctx, "", true, s.options.StorageDriver, s.options.KubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		releases = all
+	}
+	for _, namespace := range request.Namespaces {
+		namespaceReleases, err := v3.ListReleases(ctx, namespace, false, s.options.StorageDriver, s.options.KubeConfig)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, namespaceReleases...)
+	}
+	var selected []*release.Release
+	for _, rel := range releases {
+		if request.selects(rel.Name) {
+			selected = append(selected, rel)
+		}
+	}
+	return selected, nil
+}
+
+// newReleaseResult returns the result of a release checked or mapped
+func newReleaseResult(rel *release.Release, result *mapkubeapis.Result, err error) ReleaseResult {
+	releaseResult := ReleaseResult{Name: rel.Name, Namespace: rel.Namespace, Revision: rel.Version, Status: report.StatusClean}
+	switch {
+	case err != nil:
+		releaseResult.Status = report.StatusFailed
+		releaseResult.Error = err.Error()
+		return releaseResult
+	case result.Mapped:
+		releaseResult.Status = report.StatusMapped
+	case len(result.MappedAPIs) > 0:
+		releaseResult.Status = report.StatusPending
+	}
+	releaseResult.Revision = result.Revision
+	releaseResult.MappedAPIs = result.MappedAPIs
+	releaseResult.Findings = result.Findings
+	if result.ManagedBy != nil {
+		releaseResult.ManagedBy = result.ManagedBy.String()
+	}
+	return releaseResult
+}
+
+// writeResponse writes the response as JSON with the status code
+func writeResponse(w http.ResponseWriter, code int, response *Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(response)
+}
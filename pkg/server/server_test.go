@@ -0,0 +1,241 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	"github.com/helm/helm-mapkubeapis/pkg/report"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+// widgetMapping maps the example.com/v1beta1 Widget, setting its size with a CEL transform
+var widgetMapping = &mapping.Metadata{Mappings: []*mapping.Mapping{{
+	DeprecatedAPI:    "apiVersion: example.com/v1beta1\nkind: Widget\n",
+	NewAPI:           "apiVersion: example.com/v1\nkind: Widget\n",
+	RemovedInVersion: "v1.25",
+	Transforms:       []mapping.Transform{{Field: "spec.size", Expression: `object.spec.replicas * 2`}},
+}}}
+
+const widgetManifest = `---
+# Source: widget/templates/widget.yaml
+apiVersion: example.com/v1beta1
+kind: Widget
+metadata:
+  name: widget
+spec:
+  replicas: 2
+`
+
+// staticProvider provides the same mapping data
+type staticProvider struct {
+	mapMetadata *mapping.Metadata
+}
+
+func (p staticProvider) Mappings(ctx context.Context) (*mapping.Metadata, error) {
+	return p.mapMetadata, nil
+}
+
+// newAPIServer returns a test Kubernetes API server which only serves its version, which Helm
+// checks before listing releases
+func newAPIServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"major": "1", "minor": "25", "gitVersion": "v1.25.0"}`))
+	}))
+	t.Cleanup(apiServer.Close)
+	return apiServer
+}
+
+// newTestServer returns a test server of the API with the Widget mapping and the releases of
+// the in-memory release storage, for Kubernetes v1.25.0
+func newTestServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(New(Options{
+		KubeConfig:      common.KubeConfig{APIServer: newAPIServer(t).URL, KubeVersion: "v1.25.0"},
+		MappingProvider: staticProvider{widgetMapping},
+		StorageDriver:   "memory",
+		Token:           token,
+		Logger:          log.New(io.Discard, "", 0),
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// post posts the body to the path of the server, and returns the status code and the decoded
+// response
+func post(t *testing.T, server *httptest.Server, path, token, body string) (int, *Response) {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	response := new(Response)
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, response
+}
+
+func TestServeErrors(t *testing.T) {
+	server := newTestServer(t, "secret")
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		code  int
+		error string
+	}{
+		{name: "without token", body: `{}`, code: http.StatusUnauthorized, error: "unauthorized"},
+		{name: "wrong token", token: "guess", body: `{}`, code: http.StatusUnauthorized, error: "unauthorized"},
+		{name: "invalid JSON", token: "secret", body: `{`, code: http.StatusBadRequest, error: "invalid request"},
+		{name: "unknown field", token: "secret", body: `{"release": "web"}`, code: http.StatusBadRequest, error: `unknown field "release"`},
+		{name: "manifest and releases", token: "secret", body: `{"manifest": "kind: Widget", "releases": ["web"]}`, code: http.StatusBadRequest, error: "either a manifest, or the namespaces and releases"},
+		{name: "kubeVersion without manifest", token: "secret", body: `{"kubeVersion": "v1.25.0"}`, code: http.StatusBadRequest, error: "kubeVersion can only be set with a manifest"},
+		{name: "invalid release pattern", token: "secret", body: `{"releases": ["web-["]}`, code: http.StatusBadRequest, error: "invalid release pattern 'web-['"},
+		{name: "invalid kubeVersion", token: "secret", body: `{"manifest": "kind: Widget", "kubeVersion": "1.25"}`, code: http.StatusBadRequest, error: "invalid kubeVersion '1.25'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, response := post(t, server, CheckPath, tt.token, tt.body)
+			if code != tt.code || !strings.Contains(response.Error, tt.error) {
+				t.Errorf("expected %d %q, got %d %q", tt.code, tt.error, code, response.Error)
+			}
+		})
+	}
+}
+
+func TestServeMethodNotAllowed(t *testing.T) {
+	server := newTestServer(t, "")
+	resp, err := server.Client().Get(server.URL + MapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodPost {
+		t.Errorf("expected %d with Allow POST, got %d with Allow %q", http.StatusMethodNotAllowed, resp.StatusCode, resp.Header.Get("Allow"))
+	}
+}
+
+func TestServeManifest(t *testing.T) {
+	server := newTestServer(t, "")
+	body, err := json.Marshal(Request{Manifest: widgetManifest, KubeVersion: "v1.25.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		path     string
+		manifest string
+	}{
+		{name: "check", path: CheckPath},
+		{name: "map", path: MapPath, manifest: "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: widget\nspec:\n  replicas: 2\n  size: 4\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, response := post(t, server, tt.path, "", string(body))
+			if code != http.StatusOK {
+				t.Fatalf("expected %d, got %d %q", http.StatusOK, code, response.Error)
+			}
+			result := response.Manifest
+			if result == nil || len(result.MappedAPIs) != 1 || result.MappedAPIs[0].Count != 1 {
+				t.Fatalf("expected 1 Widget mapped, got %+v", result)
+			}
+			if !strings.HasSuffix(result.Manifest, tt.manifest) || (tt.manifest == "") != (result.Manifest == "") {
+				t.Errorf("mapped manifest:\n%s\nexpected to end with:\n%s", result.Manifest, tt.manifest)
+			}
+		})
+	}
+}
+
+func TestServeReleases(t *testing.T) {
+	kubeConfig := common.KubeConfig{KubeVersion: "v1.25.0"}
+	cfg, err := v3.GetActionConfig("server-test", "memory", kubeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"web", "api"} {
+		rel := &release.Release{
+			Name:      name,
+			Namespace: "server-test",
+			Version:   1,
+			Info:      &release.Info{Status: release.StatusDeployed},
+			Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: name, Version: "1.0.0"}},
+			Manifest:  widgetManifest,
+		}
+		if err := cfg.Releases.Create(rel); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := newTestServer(t, "")
+
+	code, response := post(t, server, CheckPath, "", `{"namespaces": ["server-test"], "releases": ["w*"]}`)
+	if code != http.StatusOK {
+		t.Fatalf("check: expected %d, got %d %q", http.StatusOK, code, response.Error)
+	}
+	if len(response.Releases) != 1 || response.Releases[0].Name != "web" || response.Releases[0].Status != report.StatusPending {
+		t.Fatalf("check: expected release web pending, got %+v", response.Releases)
+	}
+	if *response.Summary != (Summary{Releases: 1, Pending: 1}) {
+		t.Errorf("check: unexpected summary %+v", *response.Summary)
+	}
+
+	code, response = post(t, server, MapPath, "", `{"namespaces": ["server-test"]}`)
+	if code != http.StatusOK {
+		t.Fatalf("map: expected %d, got %d %q", http.StatusOK, code, response.Error)
+	}
+	if *response.Summary != (Summary{Releases: 2, Mapped: 2}) {
+		t.Fatalf("map: unexpected summary %+v, releases %+v", *response.Summary, response.Releases)
+	}
+	latest, err := cfg.Releases.Last("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Version != 2 || !strings.Contains(latest.Manifest, "apiVersion: example.com/v1\n") || !strings.Contains(latest.Manifest, "size: 4") {
+		t.Errorf("unexpected latest release version %d:\n%s", latest.Version, latest.Manifest)
+	}
+
+	code, response = post(t, server, CheckPath, "", `{"namespaces": ["server-test"]}`)
+	if code != http.StatusOK || *response.Summary != (Summary{Releases: 2, Clean: 2}) {
+		t.Errorf("check after map: expected 2 clean releases, got %d %+v", code, response.Summary)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v2 maps the deprecated or removed Kubernetes APIs of Helm 2 releases, stored by
+// Tiller in the ConfigMaps or Secrets of its namespace.
+package v2
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+)
+
+// StorageOptions are the options of the release storage of Tiller
+type StorageOptions struct {
+	// TillerNamespace is the namespace of Tiller, whose ConfigMaps or Secrets store the
+	// releases; DefaultTillerNamespace if empty
+	TillerNamespace string
+
+	// Storage is the kind of objects Tiller stores the releases in, StorageConfigMaps or
+	// StorageSecrets; StorageConfigMaps if empty
+	Storage string
+}
+
+// MapReleaseWithUnSupportedAPIs checks the latest version of the Helm 2 release for deprecated
+// or removed APIs. If it finds any, it supersedes the latest version and adds a new version
+// with the APIs mapped to supported versions, as for Helm 3 releases, unless in dry-run mode.
+// The release namespace, storage, hooks and validation of the map options are not used.
+func MapReleaseWithUnSupportedAPIs(ctx context.Context, mapOptions common.MapOptions, storageOptions StorageOptions) (*common.ReleaseResult, error) {
+	if mapOptions.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mapOptions.Timeout)
+		defer cancel()
+	}
+	releaseName := mapOptions.ReleaseName
+	logger := common.LoggerOrDefault(mapOptions.Logger)
+	if err := ValidateStorage(storageOptions.Storage); err != nil {
+		return nil, err
+	}
+	clientSet, err := common.ClientSet(mapOptions.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	storage := tillerStorage{clientSet: clientSet, namespace: storageOptions.TillerNamespace, kind: storageOptions.Storage}
+	if storage.namespace == "" {
+		storage.namespace = DefaultTillerNamespace
+	}
+	if storage.kind == "" {
+		storage.kind = StorageConfigMaps
+	}
+
+	logger.Printf("Get Helm 2 release '%s' latest version from the Tiller %s of namespace '%s'.\n", releaseName, storage.kind, storage.namespace)
+	var history []*rspb.Release
+	err = common.Retry(ctx, mapOptions.KubeConfig, func() error {
+		history, err = storage.history(ctx, releaseName)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get Helm 2 release '%s' latest version", releaseName)
+	}
+	if len(history) == 0 {
+		return nil, errors.Errorf("Helm 2 release '%s' not found in the Tiller %s of namespace '%s'", releaseName, storage.kind, storage.namespace)
+	}
+	latest := history[len(history)-1]
+
+	logger.Printf("Check Helm 2 release '%s' in namespace '%s' for deprecated or removed APIs...\n", releaseName, latest.Namespace)
+	manifestResult, err := common.ReplaceManifestUnSupportedAPIs(ctx, latest.Manifest, mapOptions.MappingProvider, mapOptions.KubeConfig, mapOptions.RequireNewAPI, logger)
+	if err != nil {
+		return nil, err
+	}
+	result := &common.ReleaseResult{
+		Name:       latest.Name,
+		Namespace:  latest.Namespace,
+		Revision:   int(latest.Version),
+		MappedAPIs: manifestResult.MappedAPIs,
+		Findings:   manifestResult.Findings,
+		Manifest:   manifestResult.Manifest,
+		Removed:    manifestResult.Removed,
+	}
+	if manifestResult.Manifest == latest.Manifest {
+		logger.Printf("Helm 2 release '%s' has no deprecated or removed APIs.\n", releaseName)
+		return result, nil
+	}
+	objects, err := convert.Objects(manifestResult.Manifest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode the mapped manifest of Helm 2 release '%s'", releaseName)
+	}
+	if len(objects) == 0 && !mapOptions.AllowEmptyRelease {
+		return nil, errors.Errorf("mapping Helm 2 release '%s' would remove all its resources, as their APIs have no replacement; allow empty releases to map it anyway", releaseName)
+	}
+	if mapOptions.DryRun {
+		logger.Printf("Deprecated or removed APIs exist, for Helm 2 release: %s.\n", releaseName)
+		return result, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrapf(err, "mapping of Helm 2 release '%s' interrupted before updating it", releaseName)
+	}
+
+	logger.Printf("Deprecated or removed APIs exist, updating Helm 2 release: %s.\n", releaseName)
+	newRelease, err := updateRelease(storage, latest, manifestResult.Manifest, logger)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to update Helm 2 release '%s'", releaseName)
+	}
+	logger.Printf("Helm 2 release '%s' with deprecated or removed APIs updated successfully to new version.\n", releaseName)
+	result.Revision = int(newRelease.Version)
+	result.Mapped = true
+	return result, nil
+}
+
+// updateRelease supersedes the release version and adds a new version with the modified
+// manifest, and returns the new version. If the new version cannot be added, the status of the
+// release version is restored. The update is completed whatever the context, so that the
+// release is never left without a deployed version.
+func updateRelease(storage tillerStorage, origRelease *rspb.Release, modifiedManifest string, logger common.Logger) (*rspb.Release, error) {
+	ctx := context.Background()
+
+	logger.Printf("Set status of Helm 2 release version '%s' to 'SUPERSEDED'.\n", storageObjectName(origRelease))
+	supersededRelease := proto.Clone(origRelease).(*rspb.Release)
+	supersededRelease.Info.Status.Code = rspb.Status_SUPERSEDED
+	if err := storage.update(ctx, supersededRelease); err != nil {
+		return nil, err
+	}
+
+	newRelease := proto.Clone(origRelease).(*rspb.Release)
+	newRelease.Manifest = modifiedManifest
+	newRelease.Info.Description = common.UpgradeDescription
+	newRelease.Info.LastDeployed = ptypes.TimestampNow()
+	newRelease.Version = origRelease.Version + 1
+	newRelease.Info.Status.Code = rspb.Status_DEPLOYED
+	logger.Printf("Add Helm 2 release version '%s' with updated supported APIs.\n", storageObjectName(newRelease))
+	if err := storage.create(ctx, newRelease); err != nil {
+		logger.Printf("Revert the update of Helm 2 release '%s': %s\n", origRelease.Name, err)
+		if revertErr := storage.update(ctx, origRelease); revertErr != nil {
+			return nil, errors.Wrapf(err, "failed to revert the update, the status of release version '%s' could not be restored: %s", storageObjectName(origRelease), revertErr)
+		}
+		return nil, err
+	}
+	logger.Printf("Helm 2 release version '%s' added successfully.\n", storageObjectName(newRelease))
+	return newRelease, nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+const (
+	// StorageConfigMaps is the Tiller storage of releases in ConfigMaps, the Tiller default
+	StorageConfigMaps = "configmaps"
+
+	// StorageSecrets is the Tiller storage of releases in Secrets
+	StorageSecrets = "secrets"
+
+	// DefaultTillerNamespace is the namespace Tiller is installed in by default
+	DefaultTillerNamespace = "kube-system"
+)
+
+// gzipMagic is the header of gzipped data, which the payloads of releases stored by older
+// Tiller versions lack
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// ValidateStorage returns an error if the Tiller storage is not configmaps or secrets
+func ValidateStorage(storage string) error {
+	switch storage {
+	case "", StorageConfigMaps, StorageSecrets:
+		return nil
+	}
+	return errors.Errorf("unknown Tiller release storage '%s', must be one of: configmaps, secrets", storage)
+}
+
+// tillerStorage reads and writes the Helm 2 releases stored by Tiller in the ConfigMaps or
+// Secrets of its namespace, as the storage drivers of Tiller do. The Helm 2 SDK is built
+// against Kubernetes clients too old to be used with the clients of the plugin, so the
+// storage is implemented here.
+type tillerStorage struct {
+	clientSet kubernetes.Interface
+	namespace string
+	kind      string
+}
+
+// history returns the versions of the release, sorted by version
+func (s tillerStorage) history(ctx context.Context, name string) ([]*rspb.Release, error) {
+	selector := labels.Set{"NAME": name, "OWNER": "TILLER"}.AsSelector().String()
+	var payloads []string
+	if s.kind == StorageSecrets {
+		list, err := s.clientSet.CoreV1().Secrets(s.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list the Secrets of the release")
+		}
+		for _, item := range list.Items {
+			payloads = append(payloads, string(item.Data["release"]))
+		}
+	} else {
+		list, err := s.clientSet.CoreV1().ConfigMaps(s.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list the ConfigMaps of the release")
+		}
+		for _, item := range list.Items {
+			payloads = append(payloads, item.Data["release"])
+		}
+	}
+
+	var releases []*rspb.Release
+	for _, payload := range payloads {
+		rel, err := decodeRelease(payload)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode a version of release '%s'", name)
+		}
+		releases = append(releases, rel)
+	}
+	sort.Slice(releases, func(i, j int) bool { return releases[i].Version < releases[j].Version })
+	return releases, nil
+}
+
+// create stores a new release version
+func (s tillerStorage) create(ctx context.Context, rel *rspb.Release) error {
+	objectMeta, payload, err := s.object(rel, "CREATED_AT")
+	if err != nil {
+		return err
+	}
+	if s.kind == StorageSecrets {
+		_, err = s.clientSet.CoreV1().Secrets(s.namespace).Create(ctx, &v1.Secret{ObjectMeta: objectMeta, Data: map[string][]byte{"release": []byte(payload)}}, metav1.CreateOptions{})
+	} else {
+		_, err = s.clientSet.CoreV1().ConfigMaps(s.namespace).Create(ctx, &v1.ConfigMap{ObjectMeta: objectMeta, Data: map[string]string{"release": payload}}, metav1.CreateOptions{})
+	}
+	return errors.Wrapf(err, "failed to create release version '%s'", storageObjectName(rel))
+}
+
+// update replaces a stored release version
+func (s tillerStorage) update(ctx context.Context, rel *rspb.Release) error {
+	objectMeta, payload, err := s.object(rel, "MODIFIED_AT")
+	if err != nil {
+		return err
+	}
+	if s.kind == StorageSecrets {
+		_, err = s.clientSet.CoreV1().Secrets(s.namespace).Update(ctx, &v1.Secret{ObjectMeta: objectMeta, Data: map[string][]byte{"release": []byte(payload)}}, metav1.UpdateOptions{})
+	} else {
+		_, err = s.clientSet.CoreV1().ConfigMaps(s.namespace).Update(ctx, &v1.ConfigMap{ObjectMeta: objectMeta, Data: map[string]string{"release": payload}}, metav1.UpdateOptions{})
+	}
+	return errors.Wrapf(err, "failed to update release version '%s'", storageObjectName(rel))
+}
+
+// object returns the metadata and payload of the object storing the release version, labeled
+// as by Tiller with the time label set to the current time
+func (s tillerStorage) object(rel *rspb.Release, timeLabel string) (metav1.ObjectMeta, string, error) {
+	payload, err := encodeRelease(rel)
+	if err != nil {
+		return metav1.ObjectMeta{}, "", errors.Wrapf(err, "failed to encode release version '%s'", storageObjectName(rel))
+	}
+	return metav1.ObjectMeta{
+		Name:      storageObjectName(rel),
+		Namespace: s.namespace,
+		Labels: map[string]string{
+			"NAME":    rel.Name,
+			"OWNER":   "TILLER",
+			"STATUS":  rel.Info.Status.Code.String(),
+			"VERSION": strconv.Itoa(int(rel.Version)),
+			timeLabel: strconv.Itoa(int(time.Now().Unix())),
+		},
+	}, payload, nil
+}
+
+// storageObjectName returns the name of the object storing the release version, as set by
+// Tiller
+func storageObjectName(rel *rspb.Release) string {
+	return fmt.Sprintf("%s.v%d", rel.Name, rel.Version)
+}
+
+// encodeRelease encodes a release as Tiller does, i.e. as gzipped protobuf encoded in base64
+func encodeRelease(rel *rspb.Release) (string, error) {
+	data, err := proto.Marshal(rel)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeRelease decodes a release encoded by Tiller, see encodeRelease
+func decodeRelease(payload string) (*rspb.Release, error) {
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if data, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+	var rel rspb.Release
+	if err := proto.Unmarshal(data, &rel); err != nil {
+		return nil, err
+	}
+	return &rel, nil
+}
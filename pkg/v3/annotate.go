@@ -0,0 +1,159 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+)
+
+const (
+	// MappedAPIVersionsAnnotation is the annotation of the storage object of a mapped release
+	// version recording the original and new apiVersion of each resource mapped or removed
+	MappedAPIVersionsAnnotation = "mapkubeapis.helm.sh/mapped-api-versions"
+
+	// ManifestChecksumAnnotation is the annotation of the storage object of a mapped release
+	// version recording the checksum of the manifest written, see ManifestChecksum
+	ManifestChecksumAnnotation = "mapkubeapis.helm.sh/manifest-checksum"
+)
+
+// MappedAPIVersion records the original and new apiVersion of a resource of a mapped release
+type MappedAPIVersion struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+
+	// From is the original apiVersion of the resource
+	From string `json:"from"`
+
+	// To is the new apiVersion of the resource, empty if the resource was removed
+	To string `json:"to,omitempty"`
+}
+
+// ManifestChecksum returns the checksum of a release manifest, in the form sha256:<hex digest>
+func ManifestChecksum(manifest string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(manifest)))
+}
+
+// MappingAnnotations returns the annotations recording the mapping of a release version
+func MappingAnnotations(manifest string, findings common.Findings) (map[string]string, error) {
+	apiVersions := []MappedAPIVersion{}
+	for _, finding := range findings {
+		if finding.Action == common.ActionSkipped {
+			continue
+		}
+		apiVersion := MappedAPIVersion{
+			Kind:      finding.Resource.Kind,
+			Name:      finding.Resource.Name,
+			Namespace: finding.Resource.Namespace,
+			From:      finding.Resource.APIVersion,
+		}
+		if finding.NewAPI != "" {
+			gvk, err := mapping.ParseAPI(finding.NewAPI)
+			if err != nil {
+				return nil, err
+			}
+			apiVersion.To = gvk.GroupVersion().String()
+		}
+		apiVersions = append(apiVersions, apiVersion)
+	}
+	b, err := json.Marshal(apiVersions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the mapped API versions")
+	}
+	return map[string]string{
+		MappedAPIVersionsAnnotation: string(b),
+		ManifestChecksumAnnotation:  ManifestChecksum(manifest),
+	}, nil
+}
+
+// storageObjectAnnotations returns the annotations of the storage object (Secret or ConfigMap)
+// of a release version, or nil if release storage is not a Kubernetes object
+func storageObjectAnnotations(rel *release.Release, cfg *action.Configuration) (map[string]string, error) {
+	meta, err := storageObjectMeta(rel, cfg)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	return meta.Annotations, nil
+}
+
+// storageObjectMeta returns the object metadata of the storage object (Secret or ConfigMap) of
+// a release version, or nil if release storage is not a Kubernetes object
+func storageObjectMeta(rel *release.Release, cfg *action.Configuration) (*metav1.ObjectMeta, error) {
+	kind := storageObjectKind(cfg)
+	if kind == "" {
+		return nil, nil
+	}
+	clientSet, err := cfg.KubernetesClientSet()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Kubernetes client")
+	}
+	name := getStorageObjectName(rel)
+	var meta metav1.ObjectMeta
+	if kind == "Secret" {
+		secret, err := clientSet.CoreV1().Secrets(rel.Namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get %s '%s'", kind, name)
+		}
+		meta = secret.ObjectMeta
+	} else {
+		configMap, err := clientSet.CoreV1().ConfigMaps(rel.Namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get %s '%s'", kind, name)
+		}
+		meta = configMap.ObjectMeta
+	}
+	return &meta, nil
+}
+
+// annotateStorageObject adds annotations to the storage object (Secret or ConfigMap) of a
+// release version. Release storage which is not a Kubernetes object is not annotated.
+func annotateStorageObject(rel *release.Release, annotations map[string]string, cfg *action.Configuration) error {
+	kind := storageObjectKind(cfg)
+	if kind == "" {
+		return nil
+	}
+	clientSet, err := cfg.KubernetesClientSet()
+	if err != nil {
+		return errors.Wrap(err, "failed to get Kubernetes client")
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode annotations")
+	}
+	name := getStorageObjectName(rel)
+	if kind == "Secret" {
+		_, err = clientSet.CoreV1().Secrets(rel.Namespace).Patch(context.Background(), name, types.MergePatchType, patch, metav1.PatchOptions{})
+	} else {
+		_, err = clientSet.CoreV1().ConfigMaps(rel.Namespace).Patch(context.Background(), name, types.MergePatchType, patch, metav1.PatchOptions{})
+	}
+	return errors.Wrapf(err, "failed to annotate %s '%s'", kind, name)
+}
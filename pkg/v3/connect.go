@@ -21,40 +21,126 @@ import (
 	"log"
 	"os"
 
+	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
 
 	common "github.com/helm/helm-mapkubeapis/pkg/common"
 )
 
-var (
-	settings = cli.New()
-)
+const (
+	// sqlDriverName is the HELM_DRIVER value of the SQL storage driver
+	sqlDriverName = "sql"
 
-// GetActionConfig returns action configuration based on Helm env
-func GetActionConfig(namespace string, kubeConfig common.KubeConfig) (*action.Configuration, error) {
-	actionConfig := new(action.Configuration)
+	// sqlConnectionStringEnvVar is the environment variable of the connection string of the
+	// SQL storage driver, as for Helm
+	sqlConnectionStringEnvVar = "HELM_DRIVER_SQL_CONNECTION_STRING"
+)
 
-	// Add kube config settings passed by user
-	settings.KubeConfig = kubeConfig.File
-	settings.KubeContext = kubeConfig.Context
+// GetActionConfig returns action configuration based on Helm env, whose release storage is
+// that of the storage driver, or of the HELM_DRIVER environment variable if empty
+func GetActionConfig(namespace, storageDriver string, kubeConfig common.KubeConfig) (*action.Configuration, error) {
+	settings := newSettings(kubeConfig)
 
 	// check if the namespace is passed by the user. If not get Helm to return the current namespace
 	if namespace == "" {
 		namespace = settings.Namespace()
 	}
 
-	err := actionConfig.Init(settings.RESTClientGetter(), namespace, os.Getenv("HELM_DRIVER"), debug)
+	return initActionConfig(settings, namespace, storageDriver, kubeConfig)
+}
+
+// GetActionConfigAllNamespaces returns action configuration based on Helm env which
+// accesses release storage across all namespaces
+func GetActionConfigAllNamespaces(storageDriver string, kubeConfig common.KubeConfig) (*action.Configuration, error) {
+	return initActionConfig(newSettings(kubeConfig), "", storageDriver, kubeConfig)
+}
+
+// StorageDriver returns the name of the storage driver used for the storage driver passed,
+// i.e. the driver itself, or that of the HELM_DRIVER environment variable if empty, or the
+// secret driver which is the Helm default
+func StorageDriver(storageDriver string) string {
+	if storageDriver == "" {
+		storageDriver = os.Getenv("HELM_DRIVER")
+	}
+	if storageDriver == "" {
+		storageDriver = "secret"
+	}
+	return storageDriver
+}
+
+// ValidateStorageDriver returns an error if the storage driver is not a Helm storage driver
+func ValidateStorageDriver(storageDriver string) error {
+	switch storageDriver {
+	case "", "secret", "secrets", "configmap", "configmaps", "memory", sqlDriverName:
+		return nil
+	}
+	return errors.Errorf("unknown Helm storage driver '%s', must be one of: secret, configmap, memory, sql", storageDriver)
+}
+
+// newSettings returns the Helm env settings with the kube config settings passed by user
+func newSettings(kubeConfig common.KubeConfig) *cli.EnvSettings {
+	return common.HelmSettings(kubeConfig)
+}
+
+func initActionConfig(settings *cli.EnvSettings, namespace, storageDriver string, kubeConfig common.KubeConfig) (*action.Configuration, error) {
+	helmDriver := StorageDriver(storageDriver)
+	// Helm panics on an unknown driver
+	if err := ValidateStorageDriver(helmDriver); err != nil {
+		return nil, err
+	}
+	if helmDriver == sqlDriverName {
+		return initSQLActionConfig(settings, namespace)
+	}
+
+	actionConfig := new(action.Configuration)
+	err := actionConfig.Init(settings.RESTClientGetter(), namespace, helmDriver, debugLog(settings))
 	if err != nil {
 		return nil, err
 	}
+	if helmDriver == "memory" {
+		actionConfig.Releases = storage.Init(newMemoryDriver(namespace))
+		return actionConfig, nil
+	}
+
+	storageLabels, err := newStorageLabels(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := withStorageClients(actionConfig, namespace, storageLabels, kubeConfig.HelmVersion, debugLog(settings)); err != nil {
+		return nil, err
+	}
 
 	return actionConfig, err
 }
 
-func debug(format string, v ...interface{}) {
-	if settings.Debug {
-		format = fmt.Sprintf("[debug] %s\n", format)
-		log.Output(2, fmt.Sprintf(format, v...))
+// initSQLActionConfig returns the action configuration whose release storage is the SQL
+// database of the HELM_DRIVER_SQL_CONNECTION_STRING connection string, e.g. a PostgreSQL
+// database. Helm panics if it fails to connect to the database, so the driver is created here.
+func initSQLActionConfig(settings *cli.EnvSettings, namespace string) (*action.Configuration, error) {
+	connectionString := os.Getenv(sqlConnectionStringEnvVar)
+	if connectionString == "" {
+		return nil, errors.Errorf("%s must be set to use the SQL storage driver", sqlConnectionStringEnvVar)
+	}
+	actionConfig := new(action.Configuration)
+	if err := actionConfig.Init(settings.RESTClientGetter(), namespace, "memory", debugLog(settings)); err != nil {
+		return nil, err
+	}
+	sqlDriver, err := driver.NewSQL(connectionString, debugLog(settings), namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to the SQL release storage")
+	}
+	actionConfig.Releases = storage.Init(sqlDriver)
+	return actionConfig, nil
+}
+
+func debugLog(settings *cli.EnvSettings) action.DebugLog {
+	return func(format string, v ...interface{}) {
+		if settings.Debug {
+			format = fmt.Sprintf("[debug] %s\n", format)
+			log.Output(2, fmt.Sprintf(format, v...))
+		}
 	}
 }
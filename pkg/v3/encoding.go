@@ -0,0 +1,77 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+// gzipMagic is the header of gzipped data, which the payloads of releases stored by older
+// Helm versions lack
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// EncodeRelease encodes a release as the Secret and ConfigMap storage drivers of Helm do, i.e.
+// as gzipped JSON encoded in base64
+func EncodeRelease(rel *release.Release) (string, error) {
+	data, err := json.Marshal(rel)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeRelease decodes a release encoded by the Secret and ConfigMap storage drivers of Helm,
+// see EncodeRelease
+func DecodeRelease(payload string) (*release.Release, error) {
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the base64 release payload")
+	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decompress the release payload")
+		}
+		defer r.Close()
+		if data, err = io.ReadAll(r); err != nil {
+			return nil, errors.Wrap(err, "failed to decompress the release payload")
+		}
+	}
+	var rel release.Release
+	if err := json.Unmarshal(data, &rel); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the release")
+	}
+	return &rel, nil
+}
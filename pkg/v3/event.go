@@ -0,0 +1,108 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+const (
+	// eventReason is the reason set on events recorded when release storage is rewritten
+	eventReason = "MappedKubernetesAPIs"
+
+	// eventComponent is the source component set on recorded events
+	eventComponent = "helm-mapkubeapis"
+
+	// maxEventMessageLength is the maximum length of an event message accepted by the API server
+	maxEventMessageLength = 1024
+)
+
+// recordMappingEvent creates a Kubernetes Event in the release namespace referencing the
+// storage object of the new release version. This allows cluster operators watching events
+// to see that release storage was modified outside of Helm.
+func recordMappingEvent(rel *release.Release, mappedAPIs []common.MappedAPI, cfg *action.Configuration) error {
+	kind := storageObjectKind(cfg)
+	if kind == "" {
+		// Release storage is not a Kubernetes object that an event can reference
+		return nil
+	}
+
+	clientSet, err := cfg.KubernetesClientSet()
+	if err != nil {
+		return errors.Wrap(err, "failed to get Kubernetes client")
+	}
+
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: getStorageObjectName(rel) + ".",
+			Namespace:    rel.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       kind,
+			Name:       getStorageObjectName(rel),
+			Namespace:  rel.Namespace,
+		},
+		Reason:         eventReason,
+		Message:        getMappingEventMessage(rel, mappedAPIs),
+		Type:           v1.EventTypeNormal,
+		Source:         v1.EventSource{Component: eventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	_, err = clientSet.CoreV1().Events(rel.Namespace).Create(context.Background(), event, metav1.CreateOptions{})
+	return err
+}
+
+// storageObjectKind returns the kind of the Kubernetes objects storing the releases, or an
+// empty string if release storage is not a Kubernetes object
+func storageObjectKind(cfg *action.Configuration) string {
+	switch cfg.Releases.Name() {
+	case driver.SecretsDriverName:
+		return "Secret"
+	case driver.ConfigMapsDriverName:
+		return "ConfigMap"
+	}
+	return ""
+}
+
+func getMappingEventMessage(rel *release.Release, mappedAPIs []common.MappedAPI) string {
+	var mapped []string
+	for _, api := range mappedAPIs {
+		mapped = append(mapped, fmt.Sprintf("%s -> %s (%d)", common.FlattenAPI(api.DeprecatedAPI), common.FlattenAPI(api.NewAPI), api.Count))
+	}
+	message := fmt.Sprintf("Release '%s' version %d superseded by version %d with deprecated or removed Kubernetes APIs mapped: %s",
+		rel.Name, rel.Version-1, rel.Version, strings.Join(mapped, ", "))
+	if len(message) > maxEventMessageLength {
+		message = message[:maxEventMessageLength-3] + "..."
+	}
+	return message
+}
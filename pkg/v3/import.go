@@ -0,0 +1,162 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/release"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+// ImportMappedRelease writes the mapped version of the release records of a release, as
+// exported and mapped without access to release storage, back to release storage: the latest
+// version in storage is superseded and a new version with the manifest of the mapped version is
+// added, as when the release is mapped in storage. The records must end with the version added
+// by the mapping, preceded by the version it was mapped from. The import fails if the latest
+// version in storage is no longer the version mapped from, i.e. the release advanced or was
+// modified since it was exported.
+//
+// The release namespace of the options is that of the records if empty, and the release name is
+// ignored. The pre-map and post-map hooks and the validation of the options are not run.
+func ImportMappedRelease(ctx context.Context, mapOptions common.MapOptions, records []*release.Release) (*common.ReleaseResult, error) {
+	ctx, cancel := releaseContext(ctx, mapOptions)
+	defer cancel()
+	logger := common.LoggerOrDefault(mapOptions.Logger)
+	mapped, from, err := importedVersions(records)
+	if err != nil {
+		return nil, err
+	}
+	releaseName := mapped.Name
+	namespace := mapOptions.ReleaseNamespace
+	if namespace == "" {
+		namespace = mapped.Namespace
+	} else if mapped.Namespace != "" && mapped.Namespace != namespace {
+		return nil, errors.Errorf("release '%s' of the records is in namespace '%s', not '%s'", releaseName, mapped.Namespace, namespace)
+	}
+	cfg, err := GetActionConfig(namespace, mapOptions.StorageDriver, mapOptions.KubeConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
+	}
+	if mapOptions.Lock && !mapOptions.DryRun {
+		lock, err := acquireReleaseLock(releaseName, namespace, cfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to lock release '%s'", releaseName)
+		}
+		defer func() {
+			if err := lock.Release(); err != nil {
+				logger.Printf("Warning: failed to unlock release '%s': %s\n", releaseName, err)
+			}
+		}()
+	}
+
+	logger.Printf("Get release '%s' latest version.\n", releaseName)
+	storage := releaseStorage(ctx, mapOptions, cfg)
+	latest, err := getLatestRelease(releaseName, storage)
+	if err != nil {
+		if mapOptions.Storage == nil {
+			err = releaseNotFound(err, mapOptions.StorageDriver)
+		}
+		return nil, errors.Wrapf(err, "failed to get release '%s' latest version", releaseName)
+	}
+	if latest.Version != from.Version {
+		return nil, errors.Errorf("release '%s' advanced since it was exported, its latest version is %d, not the version %d mapped from", releaseName, latest.Version, from.Version)
+	}
+	if latest.Manifest != from.Manifest {
+		return nil, errors.Errorf("release version '%s' was modified since it was exported, its manifest differs from that of the records", getReleaseVersionName(latest))
+	}
+
+	// The mapped version is checked against the mapping of the latest version, so that the
+	// mapping is recorded as for releases mapped in storage
+	logger.Printf("Check release '%s' in namespace '%s' for deprecated or removed APIs...\n", releaseName, latest.Namespace)
+	manifestResult, err := common.ReplaceManifestUnSupportedAPIs(ctx, latest.Manifest, mapOptions.MappingProvider, mapOptions.KubeConfig, mapOptions.RequireNewAPI, logger)
+	if err != nil {
+		return nil, err
+	}
+	if manifestResult.Manifest != mapped.Manifest {
+		logger.Printf("Warning: the manifest of release version '%s' of the records differs from the mapping of release version '%s', e.g. it was edited after it was mapped.\n",
+			getReleaseVersionName(mapped), getReleaseVersionName(latest))
+		manifestResult = &common.ManifestResult{Manifest: mapped.Manifest}
+	}
+	result := newReleaseResult(latest, manifestResult)
+	if mapOptions.Storage == nil {
+		importedRelease := copyRelease(latest)
+		importedRelease.Manifest = mapped.Manifest
+		importedRelease.Version = latest.Version + 1
+		if err := checkStorageObjectSize(importedRelease, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if mapOptions.DryRun {
+		logger.Printf("Release version '%s' of the records can be imported, for release: %s.\n", getReleaseVersionName(mapped), releaseName)
+		return result, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrapf(err, "import of release '%s' interrupted before updating it", releaseName)
+	}
+
+	logger.Printf("Import release version '%s' of the records, updating release: %s.\n", getReleaseVersionName(mapped), releaseName)
+	importedRelease, err := updateRelease(latest, mapped.Manifest, storage, cfg, logger)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to update release '%s'", releaseName)
+	}
+	logger.Printf("Release '%s' updated successfully to new version.\n", releaseName)
+	result.Revision = importedRelease.Version
+	result.Mapped = true
+	if mapOptions.Storage == nil {
+		if err := recordMappingAnnotations(importedRelease, manifestResult.Findings, cfg); err != nil {
+			logger.Printf("Warning: failed to annotate release '%s': %s\n", releaseName, err)
+		}
+		if err := recordMappingEvent(importedRelease, manifestResult.MappedAPIs, cfg); err != nil {
+			logger.Printf("Warning: failed to record event for release '%s': %s\n", releaseName, err)
+		}
+	}
+	return result, nil
+}
+
+// importedVersions returns the mapped version of the release records, i.e. the latest version,
+// and the version it was mapped from, i.e. the version before it
+func importedVersions(records []*release.Release) (mapped, from *release.Release, err error) {
+	for _, rel := range records {
+		if rel == nil || rel.Info == nil {
+			return nil, nil, errors.New("invalid release record, its info must be set")
+		}
+		if mapped != nil && rel.Name != mapped.Name {
+			return nil, nil, errors.Errorf("the records must be the versions of one release, found releases '%s' and '%s'", mapped.Name, rel.Name)
+		}
+		if mapped == nil || rel.Version > mapped.Version {
+			mapped = rel
+		}
+	}
+	if mapped == nil {
+		return nil, nil, errors.New("no release records to import")
+	}
+	if mapped.Info.Description != common.UpgradeDescription {
+		return nil, nil, errors.Errorf("release version '%s' of the records was not added by the mapping of the release", getReleaseVersionName(mapped))
+	}
+	for _, rel := range records {
+		if rel.Version == mapped.Version-1 {
+			from = rel
+		}
+	}
+	if from == nil {
+		return nil, nil, errors.Errorf("the records have no release version '%s.v%d' that release version '%s' was mapped from", mapped.Name, mapped.Version-1, getReleaseVersionName(mapped))
+	}
+	return mapped, from, nil
+}
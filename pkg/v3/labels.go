@@ -0,0 +1,227 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+	v4 "github.com/helm/helm-mapkubeapis/pkg/v4"
+)
+
+// ownerLabel is the label of the Secrets and ConfigMaps storing releases whose value, "helm"
+// for Helm, the Helm storage drivers select the release storage objects by
+const ownerLabel = "owner"
+
+// ValidateStorageLabels returns an error if the owner label value or the label selector of
+// the release storage objects is not valid
+func ValidateStorageLabels(owner, selector string) error {
+	if errs := validation.IsValidLabelValue(owner); owner != "" && len(errs) > 0 {
+		return errors.Errorf("invalid storage owner '%s': %s", owner, strings.Join(errs, "; "))
+	}
+	if _, err := labels.Parse(selector); err != nil {
+		return errors.Wrapf(err, "invalid storage selector '%s'", selector)
+	}
+	return nil
+}
+
+// storageLabels rewrites the label selectors of the Helm storage drivers, so that releases
+// stored by tools which set another owner label, or additional labels, on the Secrets or
+// ConfigMaps storing them are found. The labels of the equality requirements of the selector,
+// and the owner label, are set on the objects written, so that they are still found by the
+// tool and the next runs.
+type storageLabels struct {
+	owner    string
+	selector labels.Selector
+}
+
+// newStorageLabels returns the storage labels of the kube config settings, or nil if the
+// release storage objects are selected as by Helm
+func newStorageLabels(kubeConfig common.KubeConfig) (*storageLabels, error) {
+	if kubeConfig.StorageOwner == "" && kubeConfig.StorageSelector == "" {
+		return nil, nil
+	}
+	selector, err := labels.Parse(kubeConfig.StorageSelector)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid storage selector '%s'", kubeConfig.StorageSelector)
+	}
+	return &storageLabels{owner: kubeConfig.StorageOwner, selector: selector}, nil
+}
+
+// rewriteSelector returns the label selector of the Helm storage driver with the owner
+// replaced and the requirements of the selector added
+func (l *storageLabels) rewriteSelector(selector string) (string, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return "", err
+	}
+	requirements, _ := parsed.Requirements()
+	rewritten := labels.NewSelector()
+	for _, requirement := range requirements {
+		if requirement.Key() == ownerLabel && l.owner != "" {
+			owner, err := labels.NewRequirement(ownerLabel, selection.Equals, []string{l.owner})
+			if err != nil {
+				return "", err
+			}
+			requirement = *owner
+		}
+		rewritten = rewritten.Add(requirement)
+	}
+	added, _ := l.selector.Requirements()
+	return rewritten.Add(added...).String(), nil
+}
+
+// setLabels sets the owner label and the labels of the equality requirements of the selector
+// on the labels of a release storage object written by the Helm storage driver
+func (l *storageLabels) setLabels(objectLabels map[string]string) map[string]string {
+	if objectLabels == nil {
+		objectLabels = map[string]string{}
+	}
+	if l.owner != "" {
+		objectLabels[ownerLabel] = l.owner
+	}
+	requirements, _ := l.selector.Requirements()
+	for _, requirement := range requirements {
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In:
+			if values := requirement.Values().List(); len(values) == 1 {
+				objectLabels[requirement.Key()] = values[0]
+			}
+		}
+	}
+	return objectLabels
+}
+
+// mergeLabels returns the labels of the object written with the labels of the object
+// replaced which are not set, as the Helm storage drivers replace all the labels on update
+func mergeLabels(objectLabels, current map[string]string) map[string]string {
+	if objectLabels == nil {
+		objectLabels = map[string]string{}
+	}
+	for key, value := range current {
+		if _, ok := objectLabels[key]; !ok {
+			objectLabels[key] = value
+		}
+	}
+	return objectLabels
+}
+
+// withStorageClients replaces the release storage of the action configuration by that of the
+// Helm storage driver, secret or configmap, selecting the release storage objects with the
+// storage labels if any, preserving the Helm 4 release fields for Helm 4, and updating the
+// release storage objects on the condition that they were not modified since they were read
+func withStorageClients(cfg *action.Configuration, namespace string, storageLabels *storageLabels, helmVersion int, log action.DebugLog) error {
+	clientSet, err := cfg.KubernetesClientSet()
+	if err != nil {
+		return errors.Wrap(err, "failed to get Kubernetes client")
+	}
+	switch cfg.Releases.Name() {
+	case driver.SecretsDriverName:
+		secrets := clientSet.CoreV1().Secrets(namespace)
+		if storageLabels != nil {
+			secrets = &labeledSecrets{SecretInterface: secrets, labels: storageLabels}
+		}
+		if helmVersion == 4 {
+			secrets = v4.Secrets(secrets)
+		}
+		d := driver.NewSecrets(&preconditionSecrets{SecretInterface: secrets, namespace: namespace})
+		d.Log = log
+		cfg.Releases = storage.Init(d)
+	case driver.ConfigMapsDriverName:
+		configMaps := clientSet.CoreV1().ConfigMaps(namespace)
+		if storageLabels != nil {
+			configMaps = &labeledConfigMaps{ConfigMapInterface: configMaps, labels: storageLabels}
+		}
+		if helmVersion == 4 {
+			configMaps = v4.ConfigMaps(configMaps)
+		}
+		d := driver.NewConfigMaps(&preconditionConfigMaps{ConfigMapInterface: configMaps, namespace: namespace})
+		d.Log = log
+		cfg.Releases = storage.Init(d)
+	}
+	return nil
+}
+
+// labeledSecrets is the Secret client of the Helm secret storage driver with the label
+// selectors rewritten with the storage labels
+type labeledSecrets struct {
+	corev1.SecretInterface
+	labels *storageLabels
+}
+
+func (s *labeledSecrets) List(ctx context.Context, opts metav1.ListOptions) (*v1.SecretList, error) {
+	selector, err := s.labels.rewriteSelector(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	opts.LabelSelector = selector
+	return s.SecretInterface.List(ctx, opts)
+}
+
+func (s *labeledSecrets) Create(ctx context.Context, secret *v1.Secret, opts metav1.CreateOptions) (*v1.Secret, error) {
+	secret.Labels = s.labels.setLabels(secret.Labels)
+	return s.SecretInterface.Create(ctx, secret, opts)
+}
+
+func (s *labeledSecrets) Update(ctx context.Context, secret *v1.Secret, opts metav1.UpdateOptions) (*v1.Secret, error) {
+	if current, err := s.SecretInterface.Get(ctx, secret.Name, metav1.GetOptions{}); err == nil {
+		secret.Labels = mergeLabels(secret.Labels, current.Labels)
+	}
+	secret.Labels = s.labels.setLabels(secret.Labels)
+	return s.SecretInterface.Update(ctx, secret, opts)
+}
+
+// labeledConfigMaps is the ConfigMap client of the Helm configmap storage driver with the
+// label selectors rewritten with the storage labels
+type labeledConfigMaps struct {
+	corev1.ConfigMapInterface
+	labels *storageLabels
+}
+
+func (c *labeledConfigMaps) List(ctx context.Context, opts metav1.ListOptions) (*v1.ConfigMapList, error) {
+	selector, err := c.labels.rewriteSelector(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	opts.LabelSelector = selector
+	return c.ConfigMapInterface.List(ctx, opts)
+}
+
+func (c *labeledConfigMaps) Create(ctx context.Context, configMap *v1.ConfigMap, opts metav1.CreateOptions) (*v1.ConfigMap, error) {
+	configMap.Labels = c.labels.setLabels(configMap.Labels)
+	return c.ConfigMapInterface.Create(ctx, configMap, opts)
+}
+
+func (c *labeledConfigMaps) Update(ctx context.Context, configMap *v1.ConfigMap, opts metav1.UpdateOptions) (*v1.ConfigMap, error) {
+	if current, err := c.ConfigMapInterface.Get(ctx, configMap.Name, metav1.GetOptions{}); err == nil {
+		configMap.Labels = mergeLabels(configMap.Labels, current.Labels)
+	}
+	configMap.Labels = c.labels.setLabels(configMap.Labels)
+	return c.ConfigMapInterface.Update(ctx, configMap, opts)
+}
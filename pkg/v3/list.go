@@ -0,0 +1,153 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+// ListReleases returns the latest version of the releases in the namespace, or in all
+// namespaces if allNamespaces is set, stored with the storage driver, or that of HELM_DRIVER
+// if empty
+func ListReleases(ctx context.Context, namespace string, allNamespaces bool, storageDriver string, kubeConfig common.KubeConfig) ([]*release.Release, error) {
+	var cfg *action.Configuration
+	var err error
+	if allNamespaces {
+		cfg, err = GetActionConfigAllNamespaces(storageDriver, kubeConfig)
+	} else {
+		cfg, err = GetActionConfig(namespace, storageDriver, kubeConfig)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
+	}
+
+	list := action.NewList(cfg)
+	list.AllNamespaces = allNamespaces
+	var releases []*release.Release
+	err = common.Retry(ctx, kubeConfig, func() error {
+		releases, err = list.Run()
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list releases")
+	}
+	return releases, nil
+}
+
+// GetLatestRelease returns the latest version of the release in the namespace, stored with the
+// storage driver, or that of HELM_DRIVER if empty. If the namespace is empty, the release is
+// looked up in the namespace it is found in, see findReleaseNamespace.
+func GetLatestRelease(ctx context.Context, releaseName, namespace, storageDriver string, kubeConfig common.KubeConfig) (*release.Release, error) {
+	if namespace == "" {
+		var err error
+		if namespace, err = findReleaseNamespace(ctx, releaseName, storageDriver, kubeConfig, nil); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := GetActionConfig(namespace, storageDriver, kubeConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
+	}
+
+	rel, err := getLatestRelease(releaseName, common.RetryStorage(ctx, cfg.Releases, kubeConfig))
+	if err != nil {
+		return nil, errors.Wrapf(releaseNotFound(err, storageDriver), "failed to get release '%s' latest version", releaseName)
+	}
+	return rel, nil
+}
+
+// GetReleaseHistory returns all the versions of the release in the namespace, stored with the
+// storage driver, or that of HELM_DRIVER if empty, sorted by version. If the namespace is
+// empty, the release is looked up in the namespace it is found in, see findReleaseNamespace.
+func GetReleaseHistory(ctx context.Context, releaseName, namespace, storageDriver string, kubeConfig common.KubeConfig) ([]*release.Release, error) {
+	if namespace == "" {
+		var err error
+		if namespace, err = findReleaseNamespace(ctx, releaseName, storageDriver, kubeConfig, nil); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := GetActionConfig(namespace, storageDriver, kubeConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
+	}
+
+	var history []*release.Release
+	err = common.Retry(ctx, kubeConfig, func() error {
+		history, err = cfg.Releases.History(releaseName)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(releaseNotFound(err, storageDriver), "failed to get release '%s' history", releaseName)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Version < history[j].Version })
+	return history, nil
+}
+
+// findReleaseNamespace returns the namespace of the release, found by searching the release
+// storage of all namespaces for the release name. It fails if releases of that name exist in
+// several namespaces. It returns an empty namespace, i.e. the current namespace, if the release
+// is not found or the release storage of all namespaces cannot be listed.
+func findReleaseNamespace(ctx context.Context, releaseName, storageDriver string, kubeConfig common.KubeConfig, logger common.Logger) (string, error) {
+	logger = common.LoggerOrDefault(logger)
+	cfg, err := GetActionConfigAllNamespaces(storageDriver, kubeConfig)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get Helm action configuration")
+	}
+	var history []*release.Release
+	err = common.Retry(ctx, kubeConfig, func() error {
+		history, err = cfg.Releases.History(releaseName)
+		return err
+	})
+	switch {
+	case errors.Is(err, driver.ErrReleaseNotFound):
+		return "", nil
+	case apierrors.IsForbidden(err):
+		logger.Printf("Warning: release '%s' is looked up in the current namespace, as the releases of all namespaces cannot be listed: %s\n", releaseName, err)
+		return "", nil
+	case err != nil:
+		return "", errors.Wrapf(err, "failed to find the namespace of release '%s'", releaseName)
+	}
+
+	found := map[string]bool{}
+	var namespaces []string
+	for _, rel := range history {
+		if !found[rel.Namespace] {
+			found[rel.Namespace] = true
+			namespaces = append(namespaces, rel.Namespace)
+		}
+	}
+	switch len(namespaces) {
+	case 0:
+		return "", nil
+	case 1:
+		logger.Printf("Release '%s' found in namespace '%s'.\n", releaseName, namespaces[0])
+		return namespaces[0], nil
+	}
+	sort.Strings(namespaces)
+	return "", errors.Errorf("release '%s' exists in several namespaces: %s; set the namespace of the release", releaseName, strings.Join(namespaces, ", "))
+}
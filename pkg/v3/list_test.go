@@ -0,0 +1,54 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/release"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+)
+
+func TestFindReleaseNamespaceAmbiguous(t *testing.T) {
+	kubeConfig := common.KubeConfig{KubeVersion: "v1.25.0"}
+	for _, namespace := range []string{"ns-b", "ns-a"} {
+		cfg, err := GetActionConfig(namespace, "memory", kubeConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel := &release.Release{Name: "web", Namespace: namespace, Version: 1, Info: &release.Info{Status: release.StatusDeployed}}
+		if err := cfg.Releases.Create(rel); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := findReleaseNamespace(context.Background(), "web", "memory", kubeConfig, nil)
+	if err == nil {
+		t.Fatal("expected an error for a release name found in several namespaces")
+	}
+	if want := "release 'web' exists in several namespaces: ns-a, ns-b"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not contain %q", err, want)
+	}
+
+	namespace, err := findReleaseNamespace(context.Background(), "api", "memory", kubeConfig, nil)
+	if err != nil || namespace != "" {
+		t.Errorf("expected no namespace and no error for a release not found, got %q, %v", namespace, err)
+	}
+}
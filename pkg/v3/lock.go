@@ -0,0 +1,155 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+
+	"helm.sh/helm/v3/pkg/action"
+)
+
+const (
+	// leasePrefix is the prefix of the names of the leases locking releases
+	leasePrefix = "mapkubeapis."
+
+	// leaseDuration is the duration of a lease locking a release, which is renewed every
+	// third of the duration while the release is mapped
+	leaseDuration = 60 * time.Second
+)
+
+// releaseLock is a coordination.k8s.io Lease held in the release namespace while a release is
+// mapped, so that concurrent runs do not map the same release simultaneously
+type releaseLock struct {
+	client   coordinationclient.LeaseInterface
+	name     string
+	identity string
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// acquireReleaseLock acquires the lease locking a release, and renews it until it is released.
+// It fails if the lease is held by another run and has not expired.
+func acquireReleaseLock(releaseName, namespace string, cfg *action.Configuration) (*releaseLock, error) {
+	clientSet, err := cfg.KubernetesClientSet()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Kubernetes client")
+	}
+	hostname, _ := os.Hostname()
+	l := &releaseLock{
+		client:   clientSet.CoordinationV1().Leases(namespace),
+		name:     leasePrefix + releaseName,
+		identity: fmt.Sprintf("%s_%s", hostname, uuid.NewUUID()),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := l.acquire(); err != nil {
+		return nil, err
+	}
+	go l.renew()
+	return l, nil
+}
+
+// acquire creates the lease, or takes it over if it has expired
+func (l *releaseLock) acquire() error {
+	ctx := context.Background()
+	now := metav1.NewMicroTime(time.Now())
+	durationSeconds := int32(leaseDuration.Seconds())
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: l.name},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &l.identity,
+			LeaseDurationSeconds: &durationSeconds,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	}
+	_, err := l.client.Create(ctx, lease, metav1.CreateOptions{})
+	if !apierrors.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "failed to create lease '%s'", l.name)
+	}
+
+	existing, err := l.client.Get(ctx, l.name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get lease '%s'", l.name)
+	}
+	if holder := existing.Spec.HolderIdentity; holder != nil && *holder != "" && !leaseExpired(existing) {
+		return errors.Errorf("release is being mapped by '%s', which holds lease '%s'", *holder, l.name)
+	}
+	existing.Spec = lease.Spec
+	// The update fails with a conflict if another run took over the lease meanwhile
+	if _, err := l.client.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrapf(err, "failed to take over expired lease '%s'", l.name)
+	}
+	return nil
+}
+
+// renew renews the lease every third of its duration until the lock is released
+func (l *releaseLock) renew() {
+	defer close(l.done)
+	ticker := time.NewTicker(leaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			lease, err := l.client.Get(context.Background(), l.name, metav1.GetOptions{})
+			if err != nil || lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.identity {
+				continue
+			}
+			now := metav1.NewMicroTime(time.Now())
+			lease.Spec.RenewTime = &now
+			l.client.Update(context.Background(), lease, metav1.UpdateOptions{})
+		}
+	}
+}
+
+// Release stops renewing the lease and deletes it, if it is still held
+func (l *releaseLock) Release() error {
+	close(l.stop)
+	<-l.done
+	lease, err := l.client.Get(context.Background(), l.name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get lease '%s'", l.name)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.identity {
+		return errors.Errorf("lease '%s' was taken over by another run", l.name)
+	}
+	err = l.client.Delete(context.Background(), l.name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &lease.UID, ResourceVersion: &lease.ResourceVersion},
+	})
+	return errors.Wrapf(err, "failed to delete lease '%s'", l.name)
+}
+
+// leaseExpired returns true if a lease was not renewed within its duration
+func leaseExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return time.Now().After(expiry)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"sync"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// memory is the in-memory release storage of the memory storage driver, shared by the action
+// configurations of the process, so that a release stored through one is found through the
+// others, e.g. when a release found across all namespaces is then read in its namespace
+var memory = struct {
+	sync.Mutex
+	driver *driver.Memory
+}{driver: driver.NewMemory()}
+
+// memoryDriver is the storage driver of a namespace of the shared in-memory release storage, or
+// of all namespaces if the namespace is empty
+type memoryDriver struct {
+	namespace string
+}
+
+// newMemoryDriver returns the storage driver of the namespace of the shared in-memory release
+// storage
+func newMemoryDriver(namespace string) *memoryDriver {
+	return &memoryDriver{namespace: namespace}
+}
+
+// use runs fn with the shared in-memory release storage set to the namespace of the driver
+func (d *memoryDriver) use(fn func(mem *driver.Memory)) {
+	memory.Lock()
+	defer memory.Unlock()
+	memory.driver.SetNamespace(d.namespace)
+	fn(memory.driver)
+}
+
+// Name returns the name of the driver
+func (d *memoryDriver) Name() string {
+	return driver.MemoryDriverName
+}
+
+// Get returns the release of the key
+func (d *memoryDriver) Get(key string) (rls *release.Release, err error) {
+	d.use(func(mem *driver.Memory) { rls, err = mem.Get(key) })
+	return rls, err
+}
+
+// List returns the releases which match the filter
+func (d *memoryDriver) List(filter func(*release.Release) bool) (releases []*release.Release, err error) {
+	d.use(func(mem *driver.Memory) { releases, err = mem.List(filter) })
+	return releases, err
+}
+
+// Query returns the releases which match the labels
+func (d *memoryDriver) Query(labels map[string]string) (releases []*release.Release, err error) {
+	d.use(func(mem *driver.Memory) { releases, err = mem.Query(labels) })
+	return releases, err
+}
+
+// Create stores a release
+func (d *memoryDriver) Create(key string, rls *release.Release) (err error) {
+	d.use(func(mem *driver.Memory) { err = mem.Create(key, rls) })
+	return err
+}
+
+// Update updates a stored release
+func (d *memoryDriver) Update(key string, rls *release.Release) (err error) {
+	d.use(func(mem *driver.Memory) { err = mem.Update(key, rls) })
+	return err
+}
+
+// Delete deletes the release of the key
+func (d *memoryDriver) Delete(key string) (rls *release.Release, err error) {
+	d.use(func(mem *driver.Memory) { rls, err = mem.Delete(key) })
+	return rls, err
+}
@@ -0,0 +1,221 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+	v4 "github.com/helm/helm-mapkubeapis/pkg/v4"
+)
+
+// releasePayloadKey is the data key of the release payload of a Secret or ConfigMap storing a
+// release version
+const releasePayloadKey = "release"
+
+// MapStorageObjectWithUnSupportedAPIs maps the deprecated or removed APIs of the release version
+// stored in the Secret or ConfigMap of the name, in the release namespace of the options,
+// without loading the release through Helm release storage. It is meant for the repair of
+// releases whose storage is too damaged for Helm to load their latest version.
+//
+// Unlike MapReleaseWithUnSupportedAPIs, no new release version is added: the payload of the
+// object is replaced in place, with the version, status and labels of the release version kept.
+// The release name of the options is ignored.
+func MapStorageObjectWithUnSupportedAPIs(ctx context.Context, mapOptions common.MapOptions, objectName string) (*common.ReleaseResult, error) {
+	ctx, cancel := releaseContext(ctx, mapOptions)
+	defer cancel()
+	logger := common.LoggerOrDefault(mapOptions.Logger)
+	namespace := mapOptions.ReleaseNamespace
+	if namespace == "" {
+		namespace = newSettings(mapOptions.KubeConfig).Namespace()
+	}
+	cfg, err := GetActionConfig(namespace, mapOptions.StorageDriver, mapOptions.KubeConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
+	}
+	kind := storageObjectKind(cfg)
+	if kind == "" {
+		return nil, errors.Errorf("storage objects can only be mapped with the secret and configmap storage drivers, not '%s'", StorageDriver(mapOptions.StorageDriver))
+	}
+	clientSet, err := cfg.KubernetesClientSet()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Kubernetes client")
+	}
+	object := storageObject{clientSet: clientSet, kind: kind, namespace: namespace, name: objectName}
+
+	logger.Printf("Get release payload of %s '%s' in namespace '%s'.\n", kind, objectName, namespace)
+	var payload string
+	err = common.Retry(ctx, mapOptions.KubeConfig, func() error {
+		payload, err = object.get(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	rel, err := DecodeRelease(payload)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode the release payload of %s '%s'", kind, objectName)
+	}
+	if name := getStorageObjectName(rel); name != objectName {
+		logger.Printf("Warning: the release payload of %s '%s' is that of release version '%s', stored as '%s' by Helm.\n", kind, objectName, getReleaseVersionName(rel), name)
+	}
+	if rel.Namespace == "" {
+		rel.Namespace = namespace
+	}
+
+	logger.Printf("Check release version '%s' for deprecated or removed APIs...\n", getReleaseVersionName(rel))
+	manifestResult, err := common.ReplaceManifestUnSupportedAPIs(ctx, rel.Manifest, mapOptions.MappingProvider, mapOptions.KubeConfig, mapOptions.RequireNewAPI, logger)
+	if err != nil {
+		return nil, err
+	}
+	result := newReleaseResult(rel, manifestResult)
+	if manifestResult.Manifest == rel.Manifest {
+		logger.Printf("Release version '%s' has no deprecated or removed APIs.\n", getReleaseVersionName(rel))
+		return result, nil
+	}
+
+	objects, err := convert.Objects(manifestResult.Manifest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode the mapped manifest of release version '%s'", getReleaseVersionName(rel))
+	}
+	if len(objects) == 0 && !mapOptions.AllowEmptyRelease {
+		return nil, errors.Errorf("mapping release version '%s' would remove all its resources, as their APIs have no replacement; allow empty releases to map it anyway", getReleaseVersionName(rel))
+	}
+	if mapOptions.Validate != nil {
+		logger.Printf("Validate release version '%s' with its APIs mapped.\n", getReleaseVersionName(rel))
+		if err := mapOptions.Validate(ctx, result); err != nil {
+			return nil, errors.Wrapf(err, "release version '%s' with its APIs mapped failed validation", getReleaseVersionName(rel))
+		}
+	}
+
+	mappedRelease := copyRelease(rel)
+	mappedRelease.Manifest = manifestResult.Manifest
+	mappedPayload, err := EncodeRelease(mappedRelease)
+	if err == nil {
+		mappedPayload, err = v4.PreservePayloadFields(mappedPayload, payload)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode release version '%s'", getReleaseVersionName(rel))
+	}
+	if len(mappedPayload) > maxStorageObjectDataSize {
+		return nil, errors.Errorf("release version '%s' would be %d bytes once encoded, over the %d bytes limit of a %s",
+			getReleaseVersionName(rel), len(mappedPayload), maxStorageObjectDataSize, kind)
+	}
+	if mapOptions.DryRun {
+		logger.Printf("Deprecated or removed APIs exist, for release version: %s.\n", getReleaseVersionName(rel))
+		return result, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrapf(err, "mapping of %s '%s' interrupted before updating it", kind, objectName)
+	}
+
+	annotations, err := MappingAnnotations(mappedRelease.Manifest, manifestResult.Findings)
+	if err != nil {
+		return nil, err
+	}
+	logger.Printf("Deprecated or removed APIs exist, replace the release payload of %s '%s'.\n", kind, objectName)
+	if err := object.update(ctx, payload, mappedPayload, annotations); err != nil {
+		return nil, err
+	}
+	logger.Printf("Release payload of %s '%s' replaced successfully.\n", kind, objectName)
+	result.Mapped = true
+	return result, nil
+}
+
+// storageObject is the Secret or ConfigMap storing a release version
+type storageObject struct {
+	clientSet kubernetes.Interface
+	kind      string
+	namespace string
+	name      string
+}
+
+// get returns the release payload of the storage object
+func (o storageObject) get(ctx context.Context) (string, error) {
+	var payload string
+	var found bool
+	switch o.kind {
+	case "Secret":
+		secret, err := o.clientSet.CoreV1().Secrets(o.namespace).Get(ctx, o.name, metav1.GetOptions{})
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to get Secret '%s'", o.name)
+		}
+		var data []byte
+		data, found = secret.Data[releasePayloadKey]
+		payload = string(data)
+	default:
+		configMap, err := o.clientSet.CoreV1().ConfigMaps(o.namespace).Get(ctx, o.name, metav1.GetOptions{})
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to get ConfigMap '%s'", o.name)
+		}
+		payload, found = configMap.Data[releasePayloadKey]
+	}
+	if !found {
+		return "", errors.Errorf("%s '%s' has no release payload", o.kind, o.name)
+	}
+	return payload, nil
+}
+
+// update replaces the release payload of the storage object, and adds the annotations. It fails
+// if the payload is no longer the payload read, i.e. the object was modified concurrently.
+func (o storageObject) update(ctx context.Context, payload, mappedPayload string, annotations map[string]string) error {
+	switch o.kind {
+	case "Secret":
+		secrets := o.clientSet.CoreV1().Secrets(o.namespace)
+		secret, err := secrets.Get(ctx, o.name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get Secret '%s'", o.name)
+		}
+		if string(secret.Data[releasePayloadKey]) != payload {
+			return errors.Errorf("Secret '%s' was modified concurrently", o.name)
+		}
+		secret.Data[releasePayloadKey] = []byte(mappedPayload)
+		secret.Annotations = withAnnotations(secret.ObjectMeta, annotations)
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		return errors.Wrapf(err, "failed to update Secret '%s'", o.name)
+	default:
+		configMaps := o.clientSet.CoreV1().ConfigMaps(o.namespace)
+		configMap, err := configMaps.Get(ctx, o.name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get ConfigMap '%s'", o.name)
+		}
+		if configMap.Data[releasePayloadKey] != payload {
+			return errors.Errorf("ConfigMap '%s' was modified concurrently", o.name)
+		}
+		configMap.Data[releasePayloadKey] = mappedPayload
+		configMap.Annotations = withAnnotations(configMap.ObjectMeta, annotations)
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		return errors.Wrapf(err, "failed to update ConfigMap '%s'", o.name)
+	}
+}
+
+// withAnnotations returns the annotations of the object with the annotations added
+func withAnnotations(meta metav1.ObjectMeta, annotations map[string]string) map[string]string {
+	merged := meta.Annotations
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	for key, value := range annotations {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,67 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	common "github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	v4 "github.com/helm/helm-mapkubeapis/pkg/v4"
+)
+
+// MapReleasePayload maps the deprecated or removed APIs of the release version of a release
+// payload, encoded as by the Secret and ConfigMap storage drivers of Helm, to supported APIs for
+// the Kubernetes version, without cluster access nor Helm release storage. It returns the
+// payload of the release version with its APIs mapped, or the payload unchanged if it has no
+// deprecated or removed APIs, in which case the result is not mapped.
+func MapReleasePayload(ctx context.Context, payload string, provider mapping.MappingProvider, kubeVersion string, logger common.Logger) (string, *common.ReleaseResult, error) {
+	logger = common.LoggerOrDefault(logger)
+	rel, err := DecodeRelease(strings.TrimSpace(payload))
+	if err != nil {
+		return "", nil, err
+	}
+	logger.Printf("Check release version '%s' for deprecated or removed APIs...\n", getReleaseVersionName(rel))
+	manifestResult, err := common.MapManifests(ctx, strings.NewReader(rel.Manifest), provider, kubeVersion, logger)
+	if err != nil {
+		return "", nil, err
+	}
+	result := newReleaseResult(rel, manifestResult)
+	if manifestResult.Manifest == rel.Manifest {
+		logger.Printf("Release version '%s' has no deprecated or removed APIs.\n", getReleaseVersionName(rel))
+		return payload, result, nil
+	}
+
+	mappedRelease := copyRelease(rel)
+	mappedRelease.Manifest = manifestResult.Manifest
+	mappedPayload, err := EncodeRelease(mappedRelease)
+	if err == nil {
+		mappedPayload, err = v4.PreservePayloadFields(mappedPayload, strings.TrimSpace(payload))
+	}
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "failed to encode release version '%s'", getReleaseVersionName(rel))
+	}
+	if len(mappedPayload) > maxStorageObjectDataSize {
+		return "", nil, errors.Errorf("release version '%s' would be %d bytes once encoded, over the %d bytes limit of a Secret or ConfigMap",
+			getReleaseVersionName(rel), len(mappedPayload), maxStorageObjectDataSize)
+	}
+	result.Mapped = true
+	return mappedPayload, result, nil
+}
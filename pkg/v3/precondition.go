@@ -0,0 +1,136 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// resourceVersions records the resource versions of the release storage objects read by the
+// Helm storage driver, which does not set them on the objects it updates, so that an update
+// only succeeds if the object was not modified since it was last read. A release version
+// modified concurrently, e.g. by a helm upgrade, after it was read and compared fails the update
+// with a conflict instead of being overwritten. The resource versions are recorded by namespace
+// and name, as the release storage of all namespaces has objects of the same name.
+type resourceVersions struct {
+	mu       sync.Mutex
+	versions map[string]string
+}
+
+// record records the resource version of an object read from the namespace of the client
+func (r *resourceVersions) record(namespace string, meta metav1.ObjectMeta) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.versions == nil {
+		r.versions = map[string]string{}
+	}
+	r.versions[objectKey(namespace, meta)] = meta.ResourceVersion
+}
+
+// precondition sets the resource version last read on an object updated without one
+func (r *resourceVersions) precondition(namespace string, meta *metav1.ObjectMeta) {
+	if meta.ResourceVersion != "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	meta.ResourceVersion = r.versions[objectKey(namespace, *meta)]
+}
+
+// objectKey returns the namespace and name of an object, in the namespace of the client if the
+// object has none, as the objects written by the Helm storage drivers
+func objectKey(namespace string, meta metav1.ObjectMeta) string {
+	if meta.Namespace != "" {
+		namespace = meta.Namespace
+	}
+	return namespace + "/" + meta.Name
+}
+
+// preconditionSecrets is the Secret client of the Helm secret storage driver whose updates are
+// conditional on the resource version of the Secret last read
+type preconditionSecrets struct {
+	corev1.SecretInterface
+	namespace string
+	versions  resourceVersions
+}
+
+func (s *preconditionSecrets) Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1.Secret, error) {
+	secret, err := s.SecretInterface.Get(ctx, name, opts)
+	if err == nil {
+		s.versions.record(s.namespace, secret.ObjectMeta)
+	}
+	return secret, err
+}
+
+func (s *preconditionSecrets) List(ctx context.Context, opts metav1.ListOptions) (*v1.SecretList, error) {
+	list, err := s.SecretInterface.List(ctx, opts)
+	if err == nil {
+		for i := range list.Items {
+			s.versions.record(s.namespace, list.Items[i].ObjectMeta)
+		}
+	}
+	return list, err
+}
+
+func (s *preconditionSecrets) Update(ctx context.Context, secret *v1.Secret, opts metav1.UpdateOptions) (*v1.Secret, error) {
+	s.versions.precondition(s.namespace, &secret.ObjectMeta)
+	updated, err := s.SecretInterface.Update(ctx, secret, opts)
+	if err == nil {
+		s.versions.record(s.namespace, updated.ObjectMeta)
+	}
+	return updated, err
+}
+
+// preconditionConfigMaps is the ConfigMap client of the Helm configmap storage driver whose
+// updates are conditional on the resource version of the ConfigMap last read
+type preconditionConfigMaps struct {
+	corev1.ConfigMapInterface
+	namespace string
+	versions  resourceVersions
+}
+
+func (c *preconditionConfigMaps) Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1.ConfigMap, error) {
+	configMap, err := c.ConfigMapInterface.Get(ctx, name, opts)
+	if err == nil {
+		c.versions.record(c.namespace, configMap.ObjectMeta)
+	}
+	return configMap, err
+}
+
+func (c *preconditionConfigMaps) List(ctx context.Context, opts metav1.ListOptions) (*v1.ConfigMapList, error) {
+	list, err := c.ConfigMapInterface.List(ctx, opts)
+	if err == nil {
+		for i := range list.Items {
+			c.versions.record(c.namespace, list.Items[i].ObjectMeta)
+		}
+	}
+	return list, err
+}
+
+func (c *preconditionConfigMaps) Update(ctx context.Context, configMap *v1.ConfigMap, opts metav1.UpdateOptions) (*v1.ConfigMap, error) {
+	c.versions.precondition(c.namespace, &configMap.ObjectMeta)
+	updated, err := c.ConfigMapInterface.Update(ctx, configMap, opts)
+	if err == nil {
+		c.versions.record(c.namespace, updated.ObjectMeta)
+	}
+	return updated, err
+}
@@ -0,0 +1,104 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"context"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPreconditionSecretsConflict(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	secrets := clientSet.CoreV1().Secrets("default")
+	// The fake client does not check resource versions as the API server does: an update with a
+	// resource version fails if it is not that of the object, and one without succeeds
+	clientSet.PrependReactor("update", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		secret := action.(k8stesting.UpdateAction).GetObject().(*v1.Secret)
+		current, err := clientSet.Tracker().Get(v1.SchemeGroupVersion.WithResource("secrets"), "default", secret.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		if secret.ResourceVersion != "" && secret.ResourceVersion != current.(*v1.Secret).ResourceVersion {
+			return true, nil, apierrors.NewConflict(v1.Resource("secrets"), secret.Name, nil)
+		}
+		secret.ResourceVersion += "1"
+		return true, secret, clientSet.Tracker().Update(v1.SchemeGroupVersion.WithResource("secrets"), secret, "default")
+	})
+	storage := driver.NewSecrets(&preconditionSecrets{SecretInterface: secrets, namespace: "default"})
+
+	rel := &release.Release{Name: "web", Namespace: "default", Version: 1, Manifest: "kind: Service\n", Info: &release.Info{Status: release.StatusDeployed}}
+	if err := storage.Create("sh.helm.release.v1.web.v1", rel); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Get("sh.helm.release.v1.web.v1"); err != nil {
+		t.Fatal(err)
+	}
+	rel.Info.Status = release.StatusSuperseded
+	if err := storage.Update("sh.helm.release.v1.web.v1", rel); err != nil {
+		t.Fatalf("expected the update of the release version read to succeed, got: %s", err)
+	}
+
+	// A concurrent writer modifies the release version after it is read
+	if _, err := storage.Get("sh.helm.release.v1.web.v1"); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := secrets.Get(context.Background(), "sh.helm.release.v1.web.v1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secrets.Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	rel.Info.Status = release.StatusDeployed
+	if err := storage.Update("sh.helm.release.v1.web.v1", rel); !isConflict(err) {
+		t.Fatalf("expected the update of the release version modified concurrently to conflict, got: %v", err)
+	}
+}
+
+func TestResourceVersionsByNamespace(t *testing.T) {
+	var versions resourceVersions
+	// The release storage of all namespaces lists objects of the same name
+	versions.record("", metav1.ObjectMeta{Namespace: "team-a", Name: "sh.helm.release.v1.web.v1", ResourceVersion: "10"})
+	versions.record("", metav1.ObjectMeta{Namespace: "team-b", Name: "sh.helm.release.v1.web.v1", ResourceVersion: "20"})
+	versions.record("team-b", metav1.ObjectMeta{Name: "sh.helm.release.v1.api.v1", ResourceVersion: "30"})
+
+	for _, tt := range []struct {
+		namespace string
+		meta      metav1.ObjectMeta
+		want      string
+	}{
+		{"team-a", metav1.ObjectMeta{Name: "sh.helm.release.v1.web.v1"}, "10"},
+		{"team-b", metav1.ObjectMeta{Name: "sh.helm.release.v1.web.v1"}, "20"},
+		{"", metav1.ObjectMeta{Namespace: "team-b", Name: "sh.helm.release.v1.api.v1"}, "30"},
+		{"team-a", metav1.ObjectMeta{Name: "sh.helm.release.v1.api.v1"}, ""},
+	} {
+		meta := tt.meta
+		versions.precondition(tt.namespace, &meta)
+		if meta.ResourceVersion != tt.want {
+			t.Errorf("expected resource version %q of '%s/%s', got %q", tt.want, tt.namespace, tt.meta.Name, meta.ResourceVersion)
+		}
+	}
+}
@@ -17,86 +17,503 @@ limitations under the License.
 package v3
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	common "github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
 )
 
+const (
+	// conflictRetries is the number of times the mapping of a release is retried when the
+	// release is modified concurrently
+	conflictRetries = 3
+
+	// conflictBackoff is the delay before the first retry, doubled on each retry
+	conflictBackoff = time.Second
+)
+
+// errReleaseConflict is returned when a release is modified concurrently while it is mapped
+var errReleaseConflict = errors.New("release was modified concurrently")
+
 // MapReleaseWithUnSupportedAPIs checks the latest release version for any deprecated or removed APIs in its metadata
-// If it finds any, it will create a new release version with the APIs mapped to the supported versions
-func MapReleaseWithUnSupportedAPIs(mapOptions common.MapOptions) error {
-	cfg, err := GetActionConfig(mapOptions.ReleaseNamespace, mapOptions.KubeConfig)
+// If it finds any, it will create a new release version with the APIs mapped to the supported versions.
+// If the release is modified concurrently, e.g. by a Helm upgrade, the mapping is retried with
+// the latest release version.
+//
+// The context, bounded by the timeout of the options, is checked before the release is checked
+// and before it is updated. Once the update of the release started, it is completed or
+// reverted whatever the context, so that the release is never left superseded but not replaced.
+func MapReleaseWithUnSupportedAPIs(ctx context.Context, mapOptions common.MapOptions) (*common.ReleaseResult, error) {
+	ctx, cancel := releaseContext(ctx, mapOptions)
+	defer cancel()
+	mapOptions, err := withReleaseNamespace(ctx, mapOptions)
 	if err != nil {
-		return errors.Wrap(err, "failed to get Helm action configuration")
+		return nil, err
+	}
+	cfg, err := GetActionConfig(mapOptions.ReleaseNamespace, mapOptions.StorageDriver, mapOptions.KubeConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
 	}
 
+	if mapOptions.Lock && !mapOptions.DryRun {
+		namespace := mapOptions.ReleaseNamespace
+		if namespace == "" {
+			namespace = newSettings(mapOptions.KubeConfig).Namespace()
+		}
+		lock, err := acquireReleaseLock(mapOptions.ReleaseName, namespace, cfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to lock release '%s'", mapOptions.ReleaseName)
+		}
+		defer func() {
+			if err := lock.Release(); err != nil {
+				common.LoggerOrDefault(mapOptions.Logger).Printf("Warning: failed to unlock release '%s': %s\n", mapOptions.ReleaseName, err)
+			}
+		}()
+	}
+
+	backoff := conflictBackoff
+	for attempt := 0; ; attempt++ {
+		result, err := mapRelease(ctx, mapOptions, cfg)
+		if !errors.Is(err, errReleaseConflict) || attempt == conflictRetries {
+			return result, err
+		}
+		common.LoggerOrDefault(mapOptions.Logger).Printf("Release '%s' was modified concurrently, retry mapping it in %s: %s\n", mapOptions.ReleaseName, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "mapping of release '%s' interrupted", mapOptions.ReleaseName)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// mapRelease checks the latest release version for deprecated or removed APIs, and creates a
+// new release version with the APIs mapped if it finds any
+func mapRelease(ctx context.Context, mapOptions common.MapOptions, cfg *action.Configuration) (*common.ReleaseResult, error) {
 	var releaseName = mapOptions.ReleaseName
-	log.Printf("Get release '%s' latest version.\n", releaseName)
-	releaseToMap, err := getLatestRelease(releaseName, cfg)
+	var logger = common.LoggerOrDefault(mapOptions.Logger)
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrapf(err, "mapping of release '%s' interrupted", releaseName)
+	}
+	releaseToMap, manifestResult, err := checkRelease(ctx, mapOptions, cfg)
 	if err != nil {
-		return errors.Wrapf(err, "failed to get release '%s' latest version", mapOptions.ReleaseName)
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrapf(err, "mapping of release '%s' interrupted", releaseName)
+	}
+	result := newReleaseResult(releaseToMap, manifestResult)
+	modifiedManifest := manifestResult.Manifest
+	if modifiedManifest == releaseToMap.Manifest {
+		return result, nil
 	}
 
-	log.Printf("Check release '%s' for deprecated or removed APIs...\n", releaseName)
-	var origManifest = releaseToMap.Manifest
-	modifiedManifest, err := common.ReplaceManifestUnSupportedAPIs(origManifest, mapOptions.MapFile, mapOptions.KubeConfig)
+	// An empty release breaks the next Helm operations, e.g. the upgrade of the release finds
+	// no resources to update
+	objects, err := convert.Objects(modifiedManifest)
 	if err != nil {
-		return err
+		return nil, errors.Wrapf(err, "failed to decode the mapped manifest of release '%s'", releaseName)
+	}
+	if len(objects) == 0 {
+		if !mapOptions.AllowEmptyRelease {
+			return nil, errors.Errorf("mapping release '%s' would remove all its resources, as their APIs have no replacement; allow empty releases to map it anyway", releaseName)
+		}
+		logger.Printf("Warning: mapping release '%s' removes all its resources, as their APIs have no replacement.\n", releaseName)
+	}
+
+	// A GitOps controller reconciling the release reverts it to its source, where the APIs
+	// must be mapped instead
+	if mapOptions.Storage == nil {
+		result.ManagedBy = findManagedBy(ctx, releaseToMap, mapOptions, cfg, logger)
+		switch {
+		case result.ManagedBy == nil:
+		case mapOptions.Force:
+			logger.Printf("Warning: release '%s' is managed by %s, which reconciles the release from its source; the update is forced, map the deprecated or removed APIs in its source too.\n", releaseName, result.ManagedBy)
+		case mapOptions.DryRun:
+			logger.Printf("Warning: release '%s' is managed by %s, which reconciles the release from its source; the update would be skipped unless forced, map the deprecated or removed APIs in its source instead.\n", releaseName, result.ManagedBy)
+		default:
+			logger.Printf("Warning: release '%s' is managed by %s, which reconciles the release from its source; the update is skipped, map the deprecated or removed APIs in its source instead, or force the update.\n", releaseName, result.ManagedBy)
+			return result, nil
+		}
 	}
-	log.Printf("Finished checking release '%s' for deprecated or removed APIs.\n", releaseName)
-	if modifiedManifest == origManifest {
-		log.Printf("Release '%s' has no deprecated or removed APIs.\n", releaseName)
-		return nil
+
+	if mapOptions.Validate != nil {
+		logger.Printf("Validate release '%s' with its APIs mapped.\n", releaseName)
+		if err := mapOptions.Validate(ctx, result); err != nil {
+			return nil, errors.Wrapf(err, "release '%s' with its APIs mapped failed validation", releaseName)
+		}
+	}
+
+	if mapOptions.Storage == nil {
+		mappedRelease := copyRelease(releaseToMap)
+		mappedRelease.Manifest = modifiedManifest
+		mappedRelease.Version = releaseToMap.Version + 1
+		if err := checkStorageObjectSize(mappedRelease, cfg); err != nil {
+			return nil, err
+		}
 	}
 
 	if mapOptions.DryRun {
-		log.Printf("Deprecated or removed APIs exist, for release: %s.\n", releaseName)
+		logger.Printf("Deprecated or removed APIs exist, for release: %s.\n", releaseName)
 	} else {
-		log.Printf("Deprecated or removed APIs exist, updating release: %s.\n", releaseName)
-		if err := updateRelease(releaseToMap, modifiedManifest, cfg); err != nil {
-			return errors.Wrapf(err, "failed to update release '%s'", releaseName)
+		if mapOptions.PreMapHook != nil {
+			logger.Printf("Run pre-map hook for release: %s.\n", releaseName)
+			if err := mapOptions.PreMapHook(ctx, result); errors.Is(err, common.ErrSkipUpdate) {
+				logger.Printf("Pre-map hook skipped the update of release '%s': %s\n", releaseName, err)
+				return result, nil
+			} else if err != nil {
+				return nil, errors.Wrapf(err, "pre-map hook aborted the update of release '%s'", releaseName)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrapf(err, "mapping of release '%s' interrupted before updating it", releaseName)
+		}
+		logger.Printf("Deprecated or removed APIs exist, updating release: %s.\n", releaseName)
+		mappedRelease, err := updateRelease(releaseToMap, modifiedManifest, releaseStorage(ctx, mapOptions, cfg), cfg, logger)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to update release '%s'", releaseName)
+		}
+		logger.Printf("Release '%s' with deprecated or removed APIs updated successfully to new version.\n", releaseName)
+		result.Revision = mappedRelease.Version
+		result.Mapped = true
+		if mapOptions.Storage == nil {
+			if err := recordMappingAnnotations(mappedRelease, manifestResult.Findings, cfg); err != nil {
+				logger.Printf("Warning: failed to annotate release '%s': %s\n", releaseName, err)
+			}
+			if err := recordMappingEvent(mappedRelease, manifestResult.MappedAPIs, cfg); err != nil {
+				logger.Printf("Warning: failed to record event for release '%s': %s\n", releaseName, err)
+			}
 		}
-		log.Printf("Release '%s' with deprecated or removed APIs updated successfully to new version.\n", releaseName)
+		if mapOptions.PostMapHook != nil {
+			logger.Printf("Run post-map hook for release: %s.\n", releaseName)
+			if err := mapOptions.PostMapHook(ctx, result); err != nil {
+				return result, errors.Wrapf(err, "release '%s' was updated but the post-map hook failed", releaseName)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// CheckReleaseWithUnSupportedAPIs checks the latest release version for any deprecated or removed APIs in its metadata.
+// It never modifies release storage.
+func CheckReleaseWithUnSupportedAPIs(ctx context.Context, mapOptions common.MapOptions) (*common.ReleaseResult, error) {
+	ctx, cancel := releaseContext(ctx, mapOptions)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrapf(err, "check of release '%s' interrupted", mapOptions.ReleaseName)
+	}
+	mapOptions, err := withReleaseNamespace(ctx, mapOptions)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := GetActionConfig(mapOptions.ReleaseNamespace, mapOptions.StorageDriver, mapOptions.KubeConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Helm action configuration")
+	}
+
+	releaseToCheck, manifestResult, err := checkRelease(ctx, mapOptions, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrapf(err, "check of release '%s' interrupted", mapOptions.ReleaseName)
+	}
+	if len(manifestResult.MappedAPIs) > 0 {
+		common.LoggerOrDefault(mapOptions.Logger).Printf("Deprecated or removed APIs exist, for release: %s.\n", mapOptions.ReleaseName)
+	}
+	return newReleaseResult(releaseToCheck, manifestResult), nil
+}
+
+// withReleaseNamespace returns the map options with the namespace of the release found in the
+// release storage of all namespaces if no namespace is set, see findReleaseNamespace
+func withReleaseNamespace(ctx context.Context, mapOptions common.MapOptions) (common.MapOptions, error) {
+	if mapOptions.ReleaseNamespace != "" || mapOptions.Storage != nil {
+		return mapOptions, nil
+	}
+	namespace, err := findReleaseNamespace(ctx, mapOptions.ReleaseName, mapOptions.StorageDriver, mapOptions.KubeConfig, mapOptions.Logger)
+	if err != nil {
+		return mapOptions, err
+	}
+	mapOptions.ReleaseNamespace = namespace
+	return mapOptions, nil
+}
+
+// releaseContext returns the context bounded by the timeout of the options, if any
+func releaseContext(ctx context.Context, mapOptions common.MapOptions) (context.Context, context.CancelFunc) {
+	if mapOptions.Timeout > 0 {
+		return context.WithTimeout(ctx, mapOptions.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func newReleaseResult(rel *release.Release, manifestResult *common.ManifestResult) *common.ReleaseResult {
+	return &common.ReleaseResult{
+		Name:       rel.Name,
+		Namespace:  rel.Namespace,
+		Revision:   rel.Version,
+		MappedAPIs: manifestResult.MappedAPIs,
+		Findings:   manifestResult.Findings,
+		Manifest:   manifestResult.Manifest,
+		Removed:    manifestResult.Removed,
+	}
+}
+
+// checkRelease gets the latest release version and returns it with the result of mapping its
+// manifest to supported APIs
+func checkRelease(ctx context.Context, mapOptions common.MapOptions, cfg *action.Configuration) (*release.Release, *common.ManifestResult, error) {
+	var releaseName = mapOptions.ReleaseName
+	var logger = common.LoggerOrDefault(mapOptions.Logger)
+	logger.Printf("Get release '%s' latest version.\n", releaseName)
+	releaseToMap, err := getLatestRelease(releaseName, releaseStorage(ctx, mapOptions, cfg))
+	if err != nil {
+		if mapOptions.Storage == nil {
+			err = releaseNotFound(err, mapOptions.StorageDriver)
+		}
+		return nil, nil, errors.Wrapf(err, "failed to get release '%s' latest version", releaseName)
 	}
 
+	if mapOptions.Storage == nil {
+		if err := verifyManifestChecksum(releaseToMap, cfg); err != nil {
+			logger.Printf("Warning: %s\n", err)
+		}
+	}
+
+	logger.Printf("Check release '%s' in namespace '%s' for deprecated or removed APIs...\n", releaseName, releaseToMap.Namespace)
+	var origManifest = releaseToMap.Manifest
+	manifestResult, err := common.ReplaceManifestUnSupportedAPIs(ctx, origManifest, mapOptions.MappingProvider, mapOptions.KubeConfig, mapOptions.RequireNewAPI, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mapOptions.CheckLiveObjects && len(manifestResult.Removed) > 0 {
+		if err := checkLiveObjects(mapOptions, releaseToMap.Namespace, manifestResult, logger); err != nil {
+			return nil, nil, err
+		}
+	}
+	logger.Printf("Finished checking release '%s' for deprecated or removed APIs.\n", releaseName)
+	if manifestResult.Manifest == origManifest {
+		logger.Printf("Release '%s' has no deprecated or removed APIs.\n", releaseName)
+	}
+	return releaseToMap, manifestResult, nil
+}
+
+// findManagedBy returns the GitOps controller reconciling a release, or nil if none is found.
+// A failure to look it up, e.g. as the live objects cannot be read, is logged as a warning.
+func findManagedBy(ctx context.Context, rel *release.Release, mapOptions common.MapOptions, cfg *action.Configuration, logger common.Logger) *common.ManagedBy {
+	var managedBy *common.ManagedBy
+	meta, err := storageObjectMeta(rel, cfg)
+	if err == nil {
+		// A nil metadata is not passed as a non-nil interface
+		var storageObject metav1.Object
+		if meta != nil {
+			storageObject = meta
+		}
+		managedBy, err = common.FindManagedBy(ctx, mapOptions.KubeConfig, storageObject, rel.Name, rel.Namespace, rel.Manifest)
+	}
+	if err != nil {
+		logger.Printf("Warning: failed to check whether release '%s' is managed by a GitOps controller: %s\n", rel.Name, err)
+	}
+	return managedBy
+}
+
+// recordMappingAnnotations annotates the storage object of the new release version with the
+// record of the mapping, for downstream tooling explaining the drift between the chart sources
+// and release storage
+func recordMappingAnnotations(rel *release.Release, findings common.Findings, cfg *action.Configuration) error {
+	annotations, err := MappingAnnotations(rel.Manifest, findings)
+	if err != nil {
+		return err
+	}
+	return annotateStorageObject(rel, annotations, cfg)
+}
+
+// verifyManifestChecksum returns an error if the release version was mapped by the plugin and
+// its manifest was modified since, i.e. it does not match the checksum recorded on its storage
+// object
+func verifyManifestChecksum(rel *release.Release, cfg *action.Configuration) error {
+	annotations, err := storageObjectAnnotations(rel, cfg)
+	if err != nil {
+		return errors.Wrapf(err, "failed to verify the manifest checksum of release version '%s'", getReleaseVersionName(rel))
+	}
+	checksum, ok := annotations[ManifestChecksumAnnotation]
+	if ok && checksum != ManifestChecksum(rel.Manifest) {
+		return errors.Errorf("the manifest of release version '%s' was modified after it was mapped, it does not match its checksum %s", getReleaseVersionName(rel), checksum)
+	}
 	return nil
 }
 
-func updateRelease(origRelease *release.Release, modifiedManifest string, cfg *action.Configuration) error {
-	// Update current release version to be superseded
-	log.Printf("Set status of release version '%s' to 'superseded'.\n", getReleaseVersionName(origRelease))
-	origRelease.Info.Status = release.StatusSuperseded
-	if err := cfg.Releases.Update(origRelease); err != nil {
-		return errors.Wrapf(err, "failed to update release version '%s': %s", getReleaseVersionName(origRelease))
+// checkLiveObjects warns about the resources removed from the release manifest whose live objects
+// still exist in the cluster, as Helm no longer manages them and does not delete them when the
+// release is uninstalled
+func checkLiveObjects(mapOptions common.MapOptions, namespace string, manifestResult *common.ManifestResult, logger common.Logger) error {
+	live, err := common.FindLiveObjects(mapOptions.KubeConfig, manifestResult.Removed, namespace)
+	if err != nil {
+		return errors.Wrap(err, "failed to check the live objects of the removed resources")
+	}
+	for _, resource := range live {
+		warning := "the live object still exists in the cluster and will be orphaned, as Helm no longer manages it; delete it manually if it is no longer needed"
+		logger.Printf("Warning: %s '%s' is removed from the release manifest: %s\n", resource.Kind, resource.Name, warning)
+		for i, finding := range manifestResult.Findings {
+			if finding.Action == common.ActionRemoved && finding.Resource.Kind == resource.Kind && finding.Resource.Name == resource.Name {
+				manifestResult.Findings[i].Warnings = append(manifestResult.Findings[i].Warnings, warning)
+			}
+		}
+	}
+	return nil
+}
+
+// updateRelease supersedes the release version and adds a new version with the modified
+// manifest, and returns the new version. The release version passed is not modified. If the new
+// version cannot be added or verified, the update is reverted, so that the release is never left
+// without a deployed version. An error wrapping errReleaseConflict is returned if the release is
+// modified concurrently.
+//
+// The latest release version is read again and compared with the release version passed, which
+// detects the updates of the release since it was checked. With the secret and configmap storage
+// drivers, the release version is then superseded on the condition that its storage object was
+// not modified since it was read again, see resourceVersions, and the new version is only added
+// if no version of that number exists, so a concurrent update is never overwritten. The other
+// storage drivers have no such condition: an update of the release version between the read and
+// the write, e.g. by a helm upgrade, may be overwritten, while the new version of a concurrent
+// upgrade still fails the creation of the new version.
+func updateRelease(origRelease *release.Release, modifiedManifest string, storage common.ReleaseStorage, cfg *action.Configuration, logger common.Logger) (*release.Release, error) {
+	// Re-fetch the release right before writing, so that a concurrent update of the release
+	// since it was checked is not overwritten
+	latest, err := storage.Last(origRelease.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get release '%s' latest version", origRelease.Name)
+	}
+	if latest.Version != origRelease.Version || latest.Info.Status != origRelease.Info.Status || latest.Manifest != origRelease.Manifest {
+		return nil, errors.Wrapf(errReleaseConflict, "release version '%s' is no longer the latest unchanged version", getReleaseVersionName(origRelease))
+	}
+
+	// Update a copy of the current release version to be superseded
+	logger.Printf("Set status of release version '%s' to 'superseded'.\n", getReleaseVersionName(origRelease))
+	supersededRelease := copyRelease(origRelease)
+	supersededRelease.Info.Status = release.StatusSuperseded
+	if err := storage.Update(supersededRelease); err != nil {
+		if isConflict(err) {
+			return nil, errors.Wrapf(errReleaseConflict, "failed to update release version '%s': %s", getReleaseVersionName(origRelease), err)
+		}
+		return nil, errors.Wrapf(err, "failed to update release version '%s'", getReleaseVersionName(origRelease))
 	}
-	log.Printf("Release version '%s' updated successfully.\n", getReleaseVersionName(origRelease))
+	logger.Printf("Release version '%s' updated successfully.\n", getReleaseVersionName(origRelease))
 
-	// Using a shallow copy of current release version to update the object with the modification
-	// and then store this new version
-	var newRelease = origRelease
+	newRelease := copyRelease(origRelease)
 	newRelease.Manifest = modifiedManifest
 	newRelease.Info.Description = common.UpgradeDescription
 	newRelease.Info.LastDeployed = cfg.Now()
 	newRelease.Version = origRelease.Version + 1
 	newRelease.Info.Status = release.StatusDeployed
-	log.Printf("Add release version '%s' with updated supported APIs.\n", getReleaseVersionName(origRelease))
-	if err := cfg.Releases.Create(newRelease); err != nil {
-		return errors.Wrapf(err, "failed to create new release version '%s': %s", getReleaseVersionName(origRelease))
+	logger.Printf("Add release version '%s' with updated supported APIs.\n", getReleaseVersionName(newRelease))
+	if err := storage.Create(newRelease); err != nil {
+		if isConflict(err) {
+			err = errors.Wrapf(errReleaseConflict, "failed to create new release version '%s': %s", getReleaseVersionName(newRelease), err)
+		} else {
+			err = errors.Wrapf(err, "failed to create new release version '%s'", getReleaseVersionName(newRelease))
+		}
+		return nil, revertUpdate(origRelease, nil, storage, logger, err)
+	}
+	logger.Printf("Release version '%s' added successfully.\n", getReleaseVersionName(newRelease))
+
+	if err := verifyRelease(newRelease, storage); err != nil {
+		return nil, revertUpdate(origRelease, newRelease, storage, logger, err)
+	}
+	logger.Printf("Release version '%s' verified successfully.\n", getReleaseVersionName(newRelease))
+	return newRelease, nil
+}
+
+// revertUpdate reverts a failed update of a release: the new release version is deleted if it
+// was added, and the status of the original version is restored, unless a newer version was
+// added concurrently. It returns the error of the update, including the error of the revert if
+// it failed.
+func revertUpdate(origRelease, newRelease *release.Release, storage common.ReleaseStorage, logger common.Logger, updateErr error) error {
+	logger.Printf("Revert the update of release '%s': %s\n", origRelease.Name, updateErr)
+	if newRelease != nil {
+		if _, err := storage.Delete(newRelease.Name, newRelease.Version); err != nil {
+			return errors.Wrapf(updateErr, "failed to revert the update, release version '%s' could not be deleted: %s", getReleaseVersionName(newRelease), err)
+		}
+	}
+	if latest, err := storage.Last(origRelease.Name); err == nil && latest.Version > origRelease.Version {
+		logger.Printf("Release version '%s' was added concurrently, the status of release version '%s' is not restored.\n", getReleaseVersionName(latest), getReleaseVersionName(origRelease))
+		return updateErr
+	}
+	if err := storage.Update(origRelease); err != nil {
+		return errors.Wrapf(updateErr, "failed to revert the update, the status of release version '%s' could not be restored: %s", getReleaseVersionName(origRelease), err)
+	}
+	logger.Printf("Update of release '%s' reverted, release version '%s' restored.\n", origRelease.Name, getReleaseVersionName(origRelease))
+	return updateErr
+}
+
+// isConflict returns true if a release storage error is caused by a concurrent modification
+func isConflict(err error) bool {
+	return errors.Is(err, driver.ErrReleaseExists) || apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+}
+
+// copyRelease returns a copy of a release version whose fields and info can be modified
+// without modifying the release version. The chart, config and hooks are shared.
+func copyRelease(rel *release.Release) *release.Release {
+	copied := *rel
+	if rel.Info != nil {
+		info := *rel.Info
+		copied.Info = &info
+	}
+	return &copied
+}
+
+// verifyRelease reads a release version back from storage and verifies that its manifest
+// matches the manifest written and still decodes, so that an encoding or truncation issue of
+// the storage driver is detected before the next Helm operation on the release fails
+func verifyRelease(written *release.Release, storage common.ReleaseStorage) error {
+	stored, err := storage.Get(written.Name, written.Version)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read back release version '%s'", getReleaseVersionName(written))
+	}
+	if stored.Manifest != written.Manifest {
+		return errors.Errorf("release version '%s' read back from storage does not match the manifest written", getReleaseVersionName(written))
+	}
+	if _, err := convert.Objects(stored.Manifest); err != nil {
+		return errors.Wrapf(err, "release version '%s' read back from storage has an invalid manifest", getReleaseVersionName(written))
 	}
-	log.Printf("Release version '%s' added successfully.\n", getReleaseVersionName(origRelease))
 	return nil
 }
 
-func getLatestRelease(releaseName string, cfg *action.Configuration) (*release.Release, error) {
-	return cfg.Releases.Last(releaseName)
+func getLatestRelease(releaseName string, storage common.ReleaseStorage) (*release.Release, error) {
+	return storage.Last(releaseName)
+}
+
+// releaseStorage returns the release storage of the map options, or the Helm release storage
+// of the action configuration if none is set, with its operations retried on transient errors
+func releaseStorage(ctx context.Context, mapOptions common.MapOptions, cfg *action.Configuration) common.ReleaseStorage {
+	if mapOptions.Storage != nil {
+		return common.RetryStorage(ctx, mapOptions.Storage, mapOptions.KubeConfig)
+	}
+	return common.RetryStorage(ctx, cfg.Releases, mapOptions.KubeConfig)
+}
+
+// releaseNotFound returns an error naming the storage driver if the error is that the release
+// was not found, as the release may be stored with another driver, or the error otherwise
+func releaseNotFound(err error, storageDriver string) error {
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return errors.Wrapf(err, "release not found with the '%s' storage driver, it may be stored with another driver", StorageDriver(storageDriver))
+	}
+	return err
 }
 
 func getReleaseVersionName(rel *release.Release) string {
 	return fmt.Sprintf("%s.v%d", rel.Name, rel.Version)
 }
+
+func getStorageObjectName(rel *release.Release) string {
+	return fmt.Sprintf("sh.helm.release.v1.%s.v%d", rel.Name, rel.Version)
+}
@@ -0,0 +1,45 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v3
+
+import (
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+// maxStorageObjectDataSize is the maximum size of the data of a Secret or ConfigMap
+const maxStorageObjectDataSize = 1 << 20
+
+// checkStorageObjectSize fails if the release, once encoded as Helm does, exceeds the size
+// limit of the Secret or ConfigMap storing it. The API server would reject the new release
+// version only after the current one was superseded, so the size is checked beforehand.
+func checkStorageObjectSize(rel *release.Release, cfg *action.Configuration) error {
+	kind := storageObjectKind(cfg)
+	if kind == "" {
+		return nil
+	}
+	payload, err := EncodeRelease(rel)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode release '%s'", rel.Name)
+	}
+	if size := len(payload); size > maxStorageObjectDataSize {
+		return errors.Errorf("release version '%s' would be %d bytes once encoded, over the %d bytes limit of a %s",
+			getReleaseVersionName(rel), size, maxStorageObjectDataSize, kind)
+	}
+	return nil
+}
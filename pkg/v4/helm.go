@@ -0,0 +1,74 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v4 keeps the releases managed by Helm 4 intact when they are mapped.
+//
+// Helm 4 stores releases in the same Secrets, ConfigMaps and SQL tables as Helm 3, with the
+// same release schema, so they are checked and mapped by the Helm 3 implementation of package
+// v3. The Helm 4 SDK requires Go and Kubernetes client versions the plugin is not built with,
+// so the few fields Helm 4 adds to the release schema are handled here, on the stored payloads,
+// rather than through the Helm 4 release types.
+package v4
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+)
+
+// detectTimeout bounds the run of the Helm client detecting its version
+const detectTimeout = 10 * time.Second
+
+// ValidateHelmVersion returns an error if the Helm major version is not 3 or 4, or 0 for the
+// version to be detected
+func ValidateHelmVersion(version int) error {
+	switch version {
+	case 0, 3, 4:
+		return nil
+	}
+	return errors.Errorf("unsupported Helm version %d, must be one of: 3, 4", version)
+}
+
+// DetectHelmVersion returns the major version of the Helm client of the environment, that
+// of HELM_BIN when run by the Helm plugin framework or else helm of the PATH, or 0 if there
+// is no Helm client or its version cannot be determined.
+func DetectHelmVersion() int {
+	helmBin := os.Getenv("HELM_BIN")
+	if helmBin == "" {
+		helmBin = "helm"
+	}
+	if _, err := exec.LookPath(helmBin); err != nil {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), detectTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, helmBin, "version", "--template", "{{.Version}}").Output()
+	if err != nil {
+		return 0
+	}
+	switch semver.Major(strings.TrimSpace(string(out))) {
+	case "v3":
+		return 3
+	case "v4":
+		return 4
+	}
+	return 0
+}
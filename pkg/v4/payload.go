@@ -0,0 +1,120 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v4
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+)
+
+// releaseFields are the fields of the Helm 4 release schema unknown to the Helm 3 release
+// types, which are dropped when a release is decoded and encoded again by Helm 3 code
+var releaseFields = []string{
+	// apply_method records whether the release was applied client-side or server-side
+	"apply_method",
+}
+
+// gzipMagic is the header of gzipped data, which the payloads of releases stored by older
+// Helm versions lack
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// PreservePayloadFields returns the release payload, as stored by the Helm storage drivers,
+// with the Helm 4 release fields of the payload it was derived from added where missing. The
+// payload is returned unchanged if there are none.
+func PreservePayloadFields(payload, from string) (string, error) {
+	if from == "" {
+		return payload, nil
+	}
+	fromRelease, err := decodePayload(from)
+	if err != nil {
+		return "", err
+	}
+	var preserved map[string]json.RawMessage
+	for _, field := range releaseFields {
+		if value, ok := fromRelease[field]; ok {
+			if preserved == nil {
+				preserved = map[string]json.RawMessage{}
+			}
+			preserved[field] = value
+		}
+	}
+	if preserved == nil {
+		return payload, nil
+	}
+
+	rel, err := decodePayload(payload)
+	if err != nil {
+		return "", err
+	}
+	added := false
+	for field, value := range preserved {
+		if _, ok := rel[field]; !ok {
+			rel[field] = value
+			added = true
+		}
+	}
+	if !added {
+		return payload, nil
+	}
+	return encodePayload(rel)
+}
+
+// decodePayload decodes a release payload, gzipped JSON encoded in base64, into its fields
+func decodePayload(payload string) (map[string]json.RawMessage, error) {
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if data, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+	var rel map[string]json.RawMessage
+	if err := json.Unmarshal(data, &rel); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+// encodePayload encodes the fields of a release as the Helm storage drivers do
+func encodePayload(rel map[string]json.RawMessage) (string, error) {
+	data, err := json.Marshal(rel)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
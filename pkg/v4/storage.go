@@ -0,0 +1,134 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v4
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// releasePayloadKey is the data key of the release payload of a Secret or ConfigMap storing a
+// release version
+const releasePayloadKey = "release"
+
+// Secrets returns the Secret client of the Helm secret storage driver which preserves the
+// Helm 4 release fields of the release versions written: those of the release version replaced
+// on update, and those of the previous release version on create.
+func Secrets(client corev1.SecretInterface) corev1.SecretInterface {
+	return &releaseSecrets{SecretInterface: client}
+}
+
+// ConfigMaps returns the ConfigMap client of the Helm configmap storage driver which preserves
+// the Helm 4 release fields of the release versions written, see Secrets
+func ConfigMaps(client corev1.ConfigMapInterface) corev1.ConfigMapInterface {
+	return &releaseConfigMaps{ConfigMapInterface: client}
+}
+
+// previousVersionName returns the name of the object storing the release version before that
+// of the object labeled by the Helm storage driver, or "" if there is none
+func previousVersionName(labels map[string]string) string {
+	version, err := strconv.Atoi(labels["version"])
+	if err != nil || version <= 1 || labels["name"] == "" {
+		return ""
+	}
+	return fmt.Sprintf("sh.helm.release.v1.%s.v%d", labels["name"], version-1)
+}
+
+type releaseSecrets struct {
+	corev1.SecretInterface
+}
+
+func (s *releaseSecrets) Create(ctx context.Context, secret *v1.Secret, opts metav1.CreateOptions) (*v1.Secret, error) {
+	if err := s.preserve(ctx, secret, previousVersionName(secret.Labels)); err != nil {
+		return nil, err
+	}
+	return s.SecretInterface.Create(ctx, secret, opts)
+}
+
+func (s *releaseSecrets) Update(ctx context.Context, secret *v1.Secret, opts metav1.UpdateOptions) (*v1.Secret, error) {
+	if err := s.preserve(ctx, secret, secret.Name); err != nil {
+		return nil, err
+	}
+	return s.SecretInterface.Update(ctx, secret, opts)
+}
+
+// preserve adds the Helm 4 release fields of the release version stored in the Secret of the
+// name to the release payload of the Secret written
+func (s *releaseSecrets) preserve(ctx context.Context, secret *v1.Secret, name string) error {
+	payload, ok := secret.Data[releasePayloadKey]
+	if name == "" || !ok {
+		return nil
+	}
+	from, err := s.SecretInterface.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to get Secret '%s'", name)
+	}
+	preserved, err := PreservePayloadFields(string(payload), string(from.Data[releasePayloadKey]))
+	if err != nil {
+		return errors.Wrapf(err, "failed to preserve the Helm 4 release fields of Secret '%s'", name)
+	}
+	secret.Data[releasePayloadKey] = []byte(preserved)
+	return nil
+}
+
+type releaseConfigMaps struct {
+	corev1.ConfigMapInterface
+}
+
+func (c *releaseConfigMaps) Create(ctx context.Context, configMap *v1.ConfigMap, opts metav1.CreateOptions) (*v1.ConfigMap, error) {
+	if err := c.preserve(ctx, configMap, previousVersionName(configMap.Labels)); err != nil {
+		return nil, err
+	}
+	return c.ConfigMapInterface.Create(ctx, configMap, opts)
+}
+
+func (c *releaseConfigMaps) Update(ctx context.Context, configMap *v1.ConfigMap, opts metav1.UpdateOptions) (*v1.ConfigMap, error) {
+	if err := c.preserve(ctx, configMap, configMap.Name); err != nil {
+		return nil, err
+	}
+	return c.ConfigMapInterface.Update(ctx, configMap, opts)
+}
+
+// preserve adds the Helm 4 release fields of the release version stored in the ConfigMap of
+// the name to the release payload of the ConfigMap written
+func (c *releaseConfigMaps) preserve(ctx context.Context, configMap *v1.ConfigMap, name string) error {
+	payload, ok := configMap.Data[releasePayloadKey]
+	if name == "" || !ok {
+		return nil
+	}
+	from, err := c.ConfigMapInterface.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to get ConfigMap '%s'", name)
+	}
+	preserved, err := PreservePayloadFields(payload, from.Data[releasePayloadKey])
+	if err != nil {
+		return errors.Wrapf(err, "failed to preserve the Helm 4 release fields of ConfigMap '%s'", name)
+	}
+	configMap.Data[releasePayloadKey] = preserved
+	return nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+	"golang.org/x/mod/semver"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+)
+
+// DefaultSchemaLocation is the location of the JSON schemas of the Kubernetes resources
+// generated from the OpenAPI specification of each Kubernetes version
+const DefaultSchemaLocation = "https://raw.githubusercontent.com/yannh/kubernetes-json-schema/master/{{ .KubernetesVersion }}-standalone-strict/{{ .Kind }}{{ .KindSuffix }}.json"
+
+// schemaParams are the parameters of a schema location template
+type schemaParams struct {
+	// KubernetesVersion is the Kubernetes version, e.g. v1.25.0
+	KubernetesVersion string
+
+	// Kind is the kind of the resource in lower case
+	Kind string
+
+	// KindSuffix is the first component of the API group and the version of the resource,
+	// e.g. -networking-v1, or the version for the core group, e.g. -v1
+	KindSuffix string
+
+	// Group is the API group of the resource
+	Group string
+
+	// Version is the API version of the resource
+	Version string
+}
+
+// SchemaValidator validates resources against the JSON schemas of a Kubernetes version,
+// without cluster access
+type SchemaValidator struct {
+	location    *template.Template
+	kubeVersion string
+	client      *http.Client
+	schemas     map[string]*gojsonschema.Schema
+}
+
+// NewSchemaValidator returns a validator of the resources of a Kubernetes version, e.g. v1.25,
+// against the JSON schemas at the location. The location is a template of the URL or path of
+// the schema of a resource, with the parameters KubernetesVersion, Kind, KindSuffix, Group and
+// Version. DefaultSchemaLocation is used if the location is empty.
+func NewSchemaValidator(location, kubeVersion string) (*SchemaValidator, error) {
+	if location == "" {
+		location = DefaultSchemaLocation
+	}
+	tmpl, err := template.New("location").Parse(location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid schema location: %s", location)
+	}
+	if !strings.HasPrefix(kubeVersion, "v") {
+		kubeVersion = "v" + kubeVersion
+	}
+	if !semver.IsValid(kubeVersion) {
+		return nil, errors.Errorf("Invalid Kubernetes version: %s", kubeVersion)
+	}
+	// The schemas are published for the patch versions, e.g. v1.25.0
+	kubeVersion = strings.SplitN(semver.Canonical(kubeVersion), "+", 2)[0]
+	return &SchemaValidator{
+		location:    tmpl,
+		kubeVersion: kubeVersion,
+		client:      http.DefaultClient,
+		schemas:     map[string]*gojsonschema.Schema{},
+	}, nil
+}
+
+// Validate validates the resources of a manifest. Resources whose schema is not found at the
+// location, e.g. custom resources, are not validated.
+func (v *SchemaValidator) Validate(manifest string) error {
+	objects, err := convert.Objects(manifest)
+	if err != nil {
+		return err
+	}
+	var failures []Failure
+	for _, obj := range objects {
+		resource := convert.ObjectResource(obj)
+		s, err := v.schema(resource)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			continue
+		}
+		result, err := s.Validate(gojsonschema.NewGoLoader(obj))
+		if err != nil {
+			return errors.Wrapf(err, "failed to validate %s '%s'", resource.Kind, resource.Name)
+		}
+		if !result.Valid() {
+			messages := make([]string, len(result.Errors()))
+			for i, e := range result.Errors() {
+				messages[i] = e.String()
+			}
+			failures = append(failures, Failure{Resource: resource, Message: strings.Join(messages, ", ")})
+		}
+	}
+	if len(failures) > 0 {
+		return &Error{Failures: failures}
+	}
+	return nil
+}
+
+// Hook returns a validation hook which validates the release manifest
+func (v *SchemaValidator) Hook() common.Hook {
+	return func(_ context.Context, result *common.ReleaseResult) error {
+		return v.Validate(result.Manifest)
+	}
+}
+
+// schema returns the schema of a resource, or nil if it is not found
+func (v *SchemaValidator) schema(resource convert.Resource) (*gojsonschema.Schema, error) {
+	gv, err := schema.ParseGroupVersion(resource.APIVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid API version of %s '%s'", resource.Kind, resource.Name)
+	}
+	params := schemaParams{
+		KubernetesVersion: v.kubeVersion,
+		Kind:              strings.ToLower(resource.Kind),
+		KindSuffix:        "-" + gv.Version,
+		Group:             gv.Group,
+		Version:           gv.Version,
+	}
+	if gv.Group != "" {
+		params.KindSuffix = "-" + strings.Split(gv.Group, ".")[0] + "-" + gv.Version
+	}
+	var location bytes.Buffer
+	if err := v.location.Execute(&location, params); err != nil {
+		return nil, errors.Wrap(err, "failed to render schema location")
+	}
+	if s, ok := v.schemas[location.String()]; ok {
+		return s, nil
+	}
+
+	b, err := v.read(location.String())
+	if err != nil {
+		return nil, err
+	}
+	var s *gojsonschema.Schema
+	if b != nil {
+		if s, err = gojsonschema.NewSchema(gojsonschema.NewBytesLoader(b)); err != nil {
+			return nil, errors.Wrapf(err, "failed to load schema: %s", location.String())
+		}
+	}
+	v.schemas[location.String()] = s
+	return s, nil
+}
+
+// read returns the content of a schema URL or file, or nil if it does not exist
+func (v *SchemaValidator) read(location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		b, err := os.ReadFile(location)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return b, errors.Wrapf(err, "failed to read schema: %s", location)
+	}
+	resp, err := v.client.Get(location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download schema: %s", location)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, errors.Errorf("failed to download schema %s: %s", location, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	return b, errors.Wrapf(err, "failed to download schema: %s", location)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validate
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+)
+
+// fieldManager is the field manager of the server-side dry-run requests
+const fieldManager = "helm-mapkubeapis"
+
+// ServerDryRun returns a validation hook which submits each resource of the release manifest to
+// the API server as a server-side apply in dry-run mode, so that the resources are validated
+// against the schemas and the admission chain of the cluster without being persisted
+func ServerDryRun(kubeConfig common.KubeConfig) common.Hook {
+	return func(ctx context.Context, result *common.ReleaseResult) error {
+		getter := common.RESTClientGetter(kubeConfig)
+		restConfig, err := getter.ToRESTConfig()
+		if err != nil {
+			return errors.Wrap(err, "failed to get Kubernetes client configuration")
+		}
+		mapper, err := getter.ToRESTMapper()
+		if err != nil {
+			return errors.Wrap(err, "failed to get Kubernetes REST mapper")
+		}
+		client, err := dynamic.NewForConfig(restConfig)
+		if err != nil {
+			return errors.Wrap(err, "failed to create Kubernetes client")
+		}
+
+		objects, err := convert.Objects(result.Manifest)
+		if err != nil {
+			return err
+		}
+		var failures []Failure
+		for _, obj := range objects {
+			if err := serverDryRun(ctx, client, mapper, obj, result.Namespace); err != nil {
+				failures = append(failures, Failure{Resource: convert.ObjectResource(obj), Message: err.Error()})
+			}
+		}
+		if len(failures) > 0 {
+			return &Error{Failures: failures}
+		}
+		return nil
+	}
+}
+
+// serverDryRun applies a resource in dry-run mode. Namespaced resources without namespace are
+// applied in the release namespace.
+func serverDryRun(ctx context.Context, client dynamic.Interface, mapper meta.RESTMapper, obj map[string]interface{}, namespace string) error {
+	u := &unstructured.Unstructured{Object: obj}
+	gvk := u.GroupVersionKind()
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return errors.Wrap(err, "the API is not served by the cluster")
+	}
+	var resource dynamic.ResourceInterface = client.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if u.GetNamespace() == "" {
+			u.SetNamespace(namespace)
+		}
+		resource = client.Resource(mapping.Resource).Namespace(u.GetNamespace())
+	}
+	data, err := json.Marshal(u.Object)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode resource")
+	}
+	force := true
+	_, err = resource.Patch(ctx, u.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		DryRun:       []string{metav1.DryRunAll},
+		FieldManager: fieldManager,
+		Force:        &force,
+	})
+	return err
+}
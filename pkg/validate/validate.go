@@ -0,0 +1,49 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package validate validates release manifests with their APIs mapped before the releases
+// are updated.
+package validate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/helm/helm-mapkubeapis/pkg/convert"
+)
+
+// Failure describes a resource which failed validation
+type Failure struct {
+	// Resource which failed validation
+	Resource convert.Resource `json:"resource"`
+
+	// Message describing why the resource failed validation
+	Message string `json:"message"`
+}
+
+// Error is the error of a validation, listing the resources which failed validation
+type Error struct {
+	Failures []Failure
+}
+
+// Error returns the failures of the validation
+func (e *Error) Error() string {
+	failures := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		failures[i] = fmt.Sprintf("%s '%s': %s", f.Resource.Kind, f.Resource.Name, f.Message)
+	}
+	return fmt.Sprintf("%d resources failed validation: %s", len(e.Failures), strings.Join(failures, "; "))
+}
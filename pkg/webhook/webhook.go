@@ -0,0 +1,201 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook implements the mutating admission webhook mapping the deprecated or removed
+// Kubernetes APIs of Helm releases as their release versions are written to release storage.
+package webhook
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/helm/helm-mapkubeapis/pkg/common"
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+const (
+	// Path is the path the webhook is served at
+	Path = "/mutate"
+
+	// releaseSecretType is the type of the Secrets storing Helm release versions
+	releaseSecretType = "helm.sh/release.v1"
+
+	// maxRequestSize bounds the size of an admission review, over the 1 MiB limit of the
+	// objects with the encoding overhead
+	maxRequestSize = 3 * 1024 * 1024
+)
+
+// Handler is the mutating admission webhook mapping the deprecated or removed APIs of the
+// release versions stored in the Secrets and ConfigMaps written by Helm, e.g. when a chart with
+// deprecated APIs is installed or upgraded during a migration window. The resources applied by
+// Helm are not mutated, as an admission webhook cannot change the API version of an object.
+//
+// The webhook never denies a request: a release version which cannot be mapped is stored
+// unchanged, and the failure is logged.
+type Handler struct {
+	provider    mapping.MappingProvider
+	kubeVersion string
+	logger      common.Logger
+}
+
+// NewHandler returns the webhook mapping the APIs of the release versions to supported APIs
+// for the Kubernetes version, with the mapping data of the provider
+func NewHandler(provider mapping.MappingProvider, kubeVersion string, logger common.Logger) *Handler {
+	return &Handler{provider: provider, kubeVersion: kubeVersion, logger: common.LoggerOrDefault(logger)}
+}
+
+// ServeHTTP handles an admission.k8s.io/v1 AdmissionReview request
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, "failed to read the request", http.StatusBadRequest)
+		return
+	}
+	review := new(admissionv1.AdmissionReview)
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(w, "invalid AdmissionReview request", http.StatusBadRequest)
+		return
+	}
+
+	response := h.admit(r, review.Request)
+	response.UID = review.Request.UID
+	review.Response = response
+	review.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		h.logger.Printf("Warning: failed to write the admission response: %s\n", err)
+	}
+}
+
+// admit returns the response to an admission request, with the patch mapping the release
+// version of the storage object if it has deprecated or removed APIs
+func (h *Handler) admit(r *http.Request, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{Allowed: true}
+	if request.Operation != admissionv1.Create && request.Operation != admissionv1.Update {
+		return response
+	}
+	object, err := decodeStorageObject(request)
+	if err != nil {
+		h.logger.Printf("Warning: failed to decode %s '%s/%s': %s\n", request.Kind.Kind, request.Namespace, request.Name, err)
+		return response
+	}
+	if object == nil {
+		return response
+	}
+
+	mappedPayload, result, err := v3.MapReleasePayload(r.Context(), object.payload, h.provider, h.kubeVersion, h.logger)
+	if err != nil {
+		h.logger.Printf("Warning: failed to map %s '%s/%s', stored unchanged: %s\n", request.Kind.Kind, request.Namespace, object.meta.Name, err)
+		return response
+	}
+	if !result.Mapped {
+		return response
+	}
+	annotations, err := v3.MappingAnnotations(result.Manifest, result.Findings)
+	if err != nil {
+		h.logger.Printf("Warning: failed to map %s '%s/%s', stored unchanged: %s\n", request.Kind.Kind, request.Namespace, object.meta.Name, err)
+		return response
+	}
+	patch, err := json.Marshal(object.patch(mappedPayload, annotations))
+	if err != nil {
+		h.logger.Printf("Warning: failed to encode the patch of %s '%s/%s': %s\n", request.Kind.Kind, request.Namespace, object.meta.Name, err)
+		return response
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	response.Patch = patch
+	response.PatchType = &patchType
+	response.Warnings = []string{fmt.Sprintf("helm-mapkubeapis mapped %d deprecated or removed APIs of release '%s' version %d",
+		len(result.MappedAPIs), result.Name, result.Revision)}
+	h.logger.Printf("Mapped the deprecated or removed APIs of %s '%s/%s' (%s).\n", request.Kind.Kind, request.Namespace, object.meta.Name, request.UID)
+	return response
+}
+
+// storageObject is the Secret or ConfigMap of a release version
+type storageObject struct {
+	secret  bool
+	meta    metav1.ObjectMeta
+	payload string
+}
+
+// decodeStorageObject returns the storage object of the admission request, or nil if the
+// object is not a Secret or ConfigMap storing a Helm release version
+func decodeStorageObject(request *admissionv1.AdmissionRequest) (*storageObject, error) {
+	switch {
+	case request.Kind.Group == "" && request.Kind.Kind == "Secret":
+		secret := new(corev1.Secret)
+		if err := json.Unmarshal(request.Object.Raw, secret); err != nil {
+			return nil, err
+		}
+		payload, ok := secret.Data["release"]
+		if secret.Type != releaseSecretType || !ok {
+			return nil, nil
+		}
+		return &storageObject{secret: true, meta: secret.ObjectMeta, payload: string(payload)}, nil
+	case request.Kind.Group == "" && request.Kind.Kind == "ConfigMap":
+		configMap := new(corev1.ConfigMap)
+		if err := json.Unmarshal(request.Object.Raw, configMap); err != nil {
+			return nil, err
+		}
+		payload, ok := configMap.Data["release"]
+		if configMap.Labels["owner"] != "helm" || !ok {
+			return nil, nil
+		}
+		return &storageObject{meta: configMap.ObjectMeta, payload: payload}, nil
+	}
+	return nil, nil
+}
+
+// patchOperation is an operation of a JSON patch
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// patch returns the JSON patch replacing the release payload of the storage object and adding
+// the annotations
+func (o *storageObject) patch(payload string, annotations map[string]string) []patchOperation {
+	value := payload
+	if o.secret {
+		value = base64.StdEncoding.EncodeToString([]byte(payload))
+	}
+	ops := []patchOperation{{Op: "replace", Path: "/data/release", Value: value}}
+	if o.meta.Annotations == nil {
+		return append(ops, patchOperation{Op: "add", Path: "/metadata/annotations", Value: annotations})
+	}
+	for key, value := range annotations {
+		ops = append(ops, patchOperation{Op: "add", Path: "/metadata/annotations/" + escapePointer(key), Value: value})
+	}
+	return ops
+}
+
+// escapePointer escapes a key as a token of a JSON pointer
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
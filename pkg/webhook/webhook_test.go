@@ -0,0 +1,206 @@
+/*
+Copyright
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/helm/helm-mapkubeapis/pkg/mapping"
+	v3 "github.com/helm/helm-mapkubeapis/pkg/v3"
+)
+
+const ingressManifest = `---
+# Source: web/templates/ingress.yaml
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  backend:
+    serviceName: web
+    servicePort: 80
+`
+
+// releasePayload returns the payload of the version of the web release with the manifest
+func releasePayload(t *testing.T, manifest string) string {
+	t.Helper()
+	payload, err := v3.EncodeRelease(&release.Release{
+		Name:      "web",
+		Namespace: "default",
+		Version:   2,
+		Info:      &release.Info{Status: release.StatusDeployed},
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "web", Version: "1.0.0"}},
+		Manifest:  manifest,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// releaseSecret returns the admission request of the Secret storing the release payload
+func releaseSecret(t *testing.T, operation admissionv1.Operation, secretType corev1.SecretType, payload string) *admissionv1.AdmissionRequest {
+	t.Helper()
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "sh.helm.release.v1.web.v2", Namespace: "default", Labels: map[string]string{"owner": "helm"}},
+		Type:       secretType,
+		Data:       map[string][]byte{"release": []byte(payload)},
+	}
+	raw, err := json.Marshal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &admissionv1.AdmissionRequest{
+		UID:       types.UID("uid"),
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Secret"},
+		Namespace: "default",
+		Name:      secret.Name,
+		Operation: operation,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+// review posts the admission review of the request to the webhook, and returns the response
+func review(t *testing.T, server *httptest.Server, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	t.Helper()
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  request,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Post(server.URL+Path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	result := new(admissionv1.AdmissionReview)
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Response == nil || result.Response.UID != request.UID || !result.Response.Allowed {
+		t.Fatalf("expected the request to be allowed, got %+v", result.Response)
+	}
+	return result.Response
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.Handle(Path, NewHandler(&mapping.EmbeddedProvider{}, "v1.25.0", log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebhookMapsReleaseSecret(t *testing.T) {
+	server := newTestServer(t)
+	response := review(t, server, releaseSecret(t, admissionv1.Create, releaseSecretType, releasePayload(t, ingressManifest)))
+	if response.PatchType == nil || *response.PatchType != admissionv1.PatchTypeJSONPatch {
+		t.Fatalf("expected a JSON patch, got %+v", response)
+	}
+	var patch []patchOperation
+	if err := json.Unmarshal(response.Patch, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if len(patch) != 2 || patch[0].Path != "/data/release" || patch[1].Path != "/metadata/annotations" {
+		t.Fatalf("unexpected patch %+v", patch)
+	}
+	payload, err := base64.StdEncoding.DecodeString(patch[0].Value.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := v3.DecodeRelease(string(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rel.Manifest, "apiVersion: networking.k8s.io/v1\n") || strings.Contains(rel.Manifest, "serviceName") {
+		t.Errorf("unexpected mapped manifest:\n%s", rel.Manifest)
+	}
+	if len(response.Warnings) != 1 || !strings.Contains(response.Warnings[0], "mapped 1 deprecated or removed APIs of release 'web' version 2") {
+		t.Errorf("unexpected warnings %q", response.Warnings)
+	}
+}
+
+func TestWebhookAllowsUnchanged(t *testing.T) {
+	server := newTestServer(t)
+	tests := []struct {
+		name    string
+		request *admissionv1.AdmissionRequest
+	}{
+		{name: "delete", request: releaseSecret(t, admissionv1.Delete, releaseSecretType, releasePayload(t, ingressManifest))},
+		{name: "other secret type", request: releaseSecret(t, admissionv1.Create, corev1.SecretTypeOpaque, releasePayload(t, ingressManifest))},
+		{name: "supported APIs", request: releaseSecret(t, admissionv1.Create, releaseSecretType, releasePayload(t, "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n"))},
+		{name: "invalid payload", request: releaseSecret(t, admissionv1.Update, releaseSecretType, "not a release")},
+		{name: "invalid object", request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("uid"),
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: []byte(`{"data": []}`)},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if response := review(t, server, tt.request); response.Patch != nil {
+				t.Errorf("expected no patch, got %s", response.Patch)
+			}
+		})
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	server := newTestServer(t)
+	resp, err := server.Client().Get(server.URL + Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: expected %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+	for _, body := range []string{`{`, `{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview"}`} {
+		resp, err := server.Client().Post(server.URL+Path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected %d, got %d", body, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}